@@ -59,6 +59,12 @@ var (
 	BlockchainTrustPubkeyStr = "02aecd90febe163da3c4ac5bb711d9a87b2950d11413541acc9bda17fbda47954e"
 	BlockchainTrustSeckeyStr = ""
 
+	// PinnedPeersStr comma separated address=pubkey pairs of trust nodes
+	PinnedPeersStr = ""
+
+	// SnapshotHashStr hash of the block a snapshot must start from
+	SnapshotHashStr = ""
+
 	TrustPubkeyListStr = "02aecd90febe163da3c4ac5bb711d9a87b2950d11413541acc9bda17fbda47954e,02d15bf28c4ed2c39b35b2be2f8bcde1318e2b3b65fe2a676db39b520bee9bfe86,02e99a1338841e8b1f192337d2c6157045faa0cfe3b8a02210283aed7f5ad6880d"
 
 	// BlockchainSeckeyFile encrypted seckey file
@@ -68,6 +74,12 @@ var (
 	GenesisTimestamp uint64 = 1426562704
 	// GenesisCoinVolume represents the coin capacity
 	GenesisCoinVolume uint64 = 300e12
+	// BlockInterval seconds of a block slot
+	BlockInterval int64 = 10
+	// EpochInterval seconds of an epoch
+	EpochInterval int64 = 86400
+	// ProducerNum number of trust nodes elected by votes to produce blocks in an epoch
+	ProducerNum = 21
 
 	// DefaultConnections the default trust node addresses
 	DefaultConnections = []string{
@@ -112,6 +124,10 @@ type Config struct {
 	OutgoingConnectionsRate time.Duration
 	// PeerlistSize represents the maximum number of peers that the pex would maintain
 	PeerlistSize int
+	// Misbehaviour score at which a peer is banned
+	PeerBanScore int
+	// How long a misbehaving peer is banned for
+	PeerBanDuration time.Duration
 	// Wallet Address Version
 	//AddressVersion string
 	// Remote web interface
@@ -159,6 +175,11 @@ type Config struct {
 	GenesisSignature cipher.Sig
 	GenesisTimestamp uint64
 	GenesisAddress   cipher.Address
+	// Slot and epoch length, in seconds
+	BlockInterval int64
+	EpochInterval int64
+	// Number of elected block producers of an epoch
+	ProducerNum int
 
 	BlockchainPubkey cipher.PubKey
 	BlockchainSeckey cipher.SecKey
@@ -168,6 +189,20 @@ type Config struct {
 	TrustPubkeyList       []cipher.PubKey
 
 	AgreeNum int
+	// Encrypt and authenticate peer connections
+	EncryptConnections bool
+	// Trust keys the peers at these addresses must prove on encrypted connections
+	PinnedPeers map[string]cipher.PubKey
+	// Snapshot file a new node starts the blockchain from
+	SnapshotPath string
+	// Hash of the block the snapshot must start from
+	SnapshotHash cipher.SHA256
+	// Maximum total size of the unconfirmed txns, in bytes, 0 is unlimited
+	UnconfirmedMaxSize int
+	// Minimum fee per kB of an unconfirmed txn, in coin hours
+	UnconfirmedMinFeePerKB uint64
+	// Replace the unconfirmed txns double spent by a txn paying a higher fee
+	ReplaceByFee bool
 	/* Developer options */
 
 	// Enable cpu profiling
@@ -238,16 +273,28 @@ func (c *Config) register() {
 	flag.StringVar(&GenesisAddressStr, "genesis-address", GenesisAddressStr, "genesis address")
 	flag.StringVar(&GenesisSignatureStr, "genesis-signature", GenesisSignatureStr, "genesis block signature")
 	flag.Uint64Var(&c.GenesisTimestamp, "genesis-timestamp", c.GenesisTimestamp, "genesis block timestamp")
+	flag.Int64Var(&c.BlockInterval, "block-interval", c.BlockInterval, "seconds of a block slot (custom genesis only)")
+	flag.Int64Var(&c.EpochInterval, "epoch-interval", c.EpochInterval, "seconds of an epoch, a multiple of the block interval (custom genesis only)")
+	flag.IntVar(&c.ProducerNum, "producer-num", c.ProducerNum, "number of trust nodes elected by votes to produce blocks in an epoch")
 
 	flag.StringVar(&BlockchainTrustPubkeyStr, "trust-public-key", BlockchainTrustPubkeyStr, "public key of the trust node")
 	flag.StringVar(&BlockchainTrustSeckeyStr, "trust-secret-key", BlockchainTrustSeckeyStr, "secret key, set for trust node")
 	flag.StringVar(&TrustAddressStr, "trust-address", TrustAddressStr, "trust node address")
 	flag.StringVar(&TrustPubkeyListStr, "trust-pubkey-list", TrustPubkeyListStr, "trust pubkey list")
-	flag.IntVar(&c.AgreeNum, "agreeNum", c.AgreeNum, "agree num for pbft")
+	flag.IntVar(&c.AgreeNum, "agreeNum", c.AgreeNum, "number of trust nodes that must agree on a block, 0 derives it from the validators (testnet only)")
+	flag.BoolVar(&c.EncryptConnections, "encrypt-connections", c.EncryptConnections, "encrypt and authenticate peer connections, all peers must enable it")
+	flag.StringVar(&PinnedPeersStr, "pinned-peers", PinnedPeersStr, "comma separated address=pubkey pairs of trust nodes that must prove their key on encrypted connections")
+	flag.StringVar(&c.SnapshotPath, "snapshot", c.SnapshotPath, "snapshot file exported by a trust node to start a new blockchain from, the blocks below it are back-filled")
+	flag.StringVar(&SnapshotHashStr, "snapshot-hash", SnapshotHashStr, "hash of the block the snapshot must start from, required with -snapshot")
+	flag.IntVar(&c.UnconfirmedMaxSize, "unconfirmed-max-size", c.UnconfirmedMaxSize, "maximum total size of the unconfirmed txns in bytes, the txns paying the lowest fee are evicted, 0 is unlimited")
+	flag.Uint64Var(&c.UnconfirmedMinFeePerKB, "min-relay-fee", c.UnconfirmedMinFeePerKB, "minimum fee per kB in coin hours of the txns accepted to the unconfirmed pool")
+	flag.BoolVar(&c.ReplaceByFee, "replace-by-fee", c.ReplaceByFee, "replace the unconfirmed txns spending the inputs of a new txn which pays a higher fee")
 
 	flag.StringVar(&c.WalletDirectory, "wallet-dir", c.WalletDirectory, "location of the wallet files. Defaults to ~/.samos/wallet/")
 	flag.IntVar(&c.MaxOutgoingConnections, "max-outgoing-connections", c.MaxOutgoingConnections, "The maximum outgoing connections allowed")
 	flag.IntVar(&c.PeerlistSize, "peerlist-size", c.PeerlistSize, "The peer list size")
+	flag.IntVar(&c.PeerBanScore, "peer-ban-score", c.PeerBanScore, "Misbehaviour score at which a peer is banned, 0 never bans")
+	flag.DurationVar(&c.PeerBanDuration, "peer-ban-duration", c.PeerBanDuration, "How long a misbehaving peer is banned for")
 	flag.DurationVar(&c.OutgoingConnectionsRate, "connection-rate", c.OutgoingConnectionsRate, "How often to make an outgoing connection")
 	flag.BoolVar(&c.LocalhostOnly, "localhost-only", c.LocalhostOnly, "Run on localhost and only connect to localhost peers")
 	flag.BoolVar(&c.Arbitrating, "arbitrating", c.Arbitrating, "Run node in arbitrating mode")
@@ -285,6 +332,10 @@ var devConfig = Config{
 	// How often to make outgoing connections, in seconds
 	OutgoingConnectionsRate: time.Second * 5,
 	PeerlistSize:            65535,
+	PeerBanScore:            100,
+	PeerBanDuration:         time.Hour * 24,
+	UnconfirmedMaxSize:      visor.DefaultUnconfirmedMaxSize,
+	UnconfirmedMinFeePerKB:  visor.DefaultUnconfirmedMinFeePerKB,
 	// Wallet Address Version
 	//AddressVersion: "test",
 	// Remote web interface
@@ -331,10 +382,14 @@ var devConfig = Config{
 	BlockchainTrustSeckey: cipher.SecKey{},
 	TrustPubkeyList:       []cipher.PubKey{},
 	AgreeNum:              0,
+	PinnedPeers:           make(map[string]cipher.PubKey),
 
 	GenesisAddress:   cipher.Address{},
 	GenesisTimestamp: GenesisTimestamp,
 	GenesisSignature: cipher.Sig{},
+	BlockInterval:    BlockInterval,
+	EpochInterval:    EpochInterval,
+	ProducerNum:      ProducerNum,
 
 	/* Developer options */
 
@@ -373,12 +428,19 @@ func applyConfigMode() {
 
 // Parse prepare the config
 func (c *Config) Parse() {
+	mainnetGenesisSignatureStr := GenesisSignatureStr
 	c.register()
 	flag.Parse()
 	if help {
 		flag.Usage()
 		os.Exit(0)
 	}
+
+	// the intervals are parameters of the chain, the mainnet blocks are made in the default ones
+	if GenesisSignatureStr == mainnetGenesisSignatureStr &&
+		(c.BlockInterval != BlockInterval || c.EpochInterval != EpochInterval) {
+		log.Panicf("-block-interval and -epoch-interval can only be changed with a custom genesis block")
+	}
 	//if c.RunMaster == true && BlockchainSeckeyStr == "" {
 	//	if BlockchainSeckeyFile == "" {
 	//		logger.Error("master-secret-file must not empty")
@@ -456,6 +518,23 @@ func (c *Config) postProcess() {
 		}
 	}
 
+	if SnapshotHashStr != "" {
+		c.SnapshotHash, err = cipher.SHA256FromHex(SnapshotHashStr)
+		panicIfError(err, "Invalid snapshot hash")
+	}
+
+	if PinnedPeersStr != "" {
+		for _, pair := range strings.Split(PinnedPeersStr, ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				log.Panicf("Invalid pinned peer %s", pair)
+			}
+			pubkey, err := cipher.PubKeyFromHex(kv[1])
+			panicIfError(err, "Invalid pinned peer Pubkey")
+			c.PinnedPeers[kv[0]] = pubkey
+		}
+	}
+
 	c.DataDirectory, err = file.InitDataDir(c.DataDirectory)
 	panicIfError(err, "Invalid DataDirectory")
 
@@ -613,6 +692,8 @@ func configureDaemon(c *Config) daemon.Config {
 	dc.Pex.DataDirectory = c.DataDirectory
 	dc.Pex.Disabled = c.DisablePEX
 	dc.Pex.Max = c.PeerlistSize
+	dc.Pex.BanScore = c.PeerBanScore
+	dc.Pex.BanDuration = c.PeerBanDuration
 	dc.Pex.DownloadPeerList = c.DownloadPeerList
 	dc.Pex.PeerListURL = c.PeerListURL
 	dc.Daemon.DisableOutgoingConnections = c.DisableOutgoingConnections
@@ -624,6 +705,8 @@ func configureDaemon(c *Config) daemon.Config {
 	dc.Daemon.OutgoingMax = c.MaxOutgoingConnections
 	dc.Daemon.DataDirectory = c.DataDirectory
 	dc.Daemon.LogPings = !c.DisablePingPong
+	dc.Pool.Encrypt = c.EncryptConnections
+	dc.Pool.PinnedKeys = c.PinnedPeers
 
 	if c.OutgoingConnectionsRate == 0 {
 		c.OutgoingConnectionsRate = time.Millisecond
@@ -638,10 +721,18 @@ func configureDaemon(c *Config) daemon.Config {
 	dc.Visor.Config.BlockchainTrustSeckey = c.BlockchainTrustSeckey
 	dc.Visor.Config.TrustPubkeyList = c.TrustPubkeyList
 	dc.Visor.Config.AgreeNum = c.AgreeNum
+	dc.Visor.Config.SnapshotPath = c.SnapshotPath
+	dc.Visor.Config.SnapshotHash = c.SnapshotHash
+	dc.Visor.Config.UnconfirmedMaxSize = c.UnconfirmedMaxSize
+	dc.Visor.Config.UnconfirmedMinFeePerKB = c.UnconfirmedMinFeePerKB
+	dc.Visor.Config.UnconfirmedReplaceByFee = c.ReplaceByFee
 
 	dc.Visor.Config.GenesisAddress = c.GenesisAddress
 	dc.Visor.Config.GenesisSignature = c.GenesisSignature
 	dc.Visor.Config.GenesisTimestamp = c.GenesisTimestamp
+	dc.Visor.Config.BlockInterval = c.BlockInterval
+	dc.Visor.Config.EpochInterval = c.EpochInterval
+	dc.Visor.Config.ProducerNum = c.ProducerNum
 	dc.Visor.Config.GenesisCoinVolume = GenesisCoinVolume
 	dc.Visor.Config.DBPath = c.DBPath
 	dc.Visor.Config.DBReadOnly = c.DBReadOnly
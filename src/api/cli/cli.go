@@ -227,6 +227,7 @@ func NewApp(cfg Config) *App {
 		sendCmd(),
 		statusCmd(),
 		transactionCmd(),
+		validatorsCmd(),
 		verifyAddressCmd(),
 		versionCmd(),
 		walletBalanceCmd(cfg),
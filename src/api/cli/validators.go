@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+
+	gcli "github.com/urfave/cli"
+)
+
+func validatorsCmd() gcli.Command {
+	name := "validators"
+	return gcli.Command{
+		Name:         name,
+		Usage:        "Displays the slots the trust nodes produced and missed in an epoch",
+		ArgsUsage:    "[epoch]",
+		Description:  "The epoch is a unix time in the epoch, the epoch of the last block is shown by default",
+		OnUsageError: onCommandUsageError(name),
+		Action:       getValidators,
+	}
+}
+
+func getValidators(c *gcli.Context) error {
+	rpcClient := RPCClientFromContext(c)
+
+	var epoch int64
+	if e := c.Args().First(); e != "" {
+		var err error
+		epoch, err = strconv.ParseInt(e, 10, 64)
+		if err != nil || epoch <= 0 {
+			return fmt.Errorf("invalid epoch, %s", e)
+		}
+	}
+
+	stats, err := rpcClient.GetValidatorStats(epoch)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(stats)
+}
@@ -139,6 +139,21 @@ func (c *Client) GetLastBlocks(n uint64) (*visor.ReadableBlocks, error) {
 	return &blocks, nil
 }
 
+// GetValidatorStats returns the slots the validators produced and missed in the epoch
+// containing ts, ts 0 selects the epoch of the head block
+func (c *Client) GetValidatorStats(ts int64) (*visor.ReadableValidatorStats, error) {
+	var params interface{}
+	if ts != 0 {
+		params = []int64{ts}
+	}
+	stats := visor.ReadableValidatorStats{}
+	if err := c.Do(&stats, "get_validators", params); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
 // Do send request to web
 func Do(req *Request, rpcAddress string) (*Response, error) {
 	d, err := json.Marshal(req)
@@ -10,6 +10,7 @@ import (
 
 	"github.com/samoslab/samos/src/cipher"
 	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/daemon"
 	"github.com/samoslab/samos/src/testutil"
 	"github.com/samoslab/samos/src/util/logging"
 	"github.com/samoslab/samos/src/visor"
@@ -134,6 +135,7 @@ func testClientGetStatus(t *testing.T, c *Client, s *WebRPC, gw *fakeGateway) {
 		BlockNum:           455,
 		LastBlockHash:      "",
 		TimeSinceLastBlock: "18446744072232256374s",
+		Connections:        []*daemon.Connection{},
 	}, status)
 }
 
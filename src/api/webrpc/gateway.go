@@ -20,4 +20,6 @@ type Gatewayer interface {
 	InjectBroadcastTransaction(tx coin.Transaction) error
 	GetAddrUxOuts(addr []cipher.Address) ([]*historydb.UxOut, error)
 	GetTimeNow() uint64
+	GetValidatorStats(ts int64) (*visor.ReadableValidatorStats, error)
+	GetConnections() *daemon.Connections
 }
@@ -107,6 +107,24 @@ func (m *GatewayerMock) GetBlocksInDepth(p0 []uint64) (*visor.ReadableBlocks, er
 
 }
 
+// GetConnections mocked method
+func (m *GatewayerMock) GetConnections() *daemon.Connections {
+
+	ret := m.Called()
+
+	var r0 *daemon.Connections
+	switch res := ret.Get(0).(type) {
+	case nil:
+	case *daemon.Connections:
+		r0 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	return r0
+
+}
+
 // GetLastBlocks mocked method
 func (m *GatewayerMock) GetLastBlocks(p0 uint64) (*visor.ReadableBlocks, error) {
 
@@ -206,6 +224,33 @@ func (m *GatewayerMock) GetUnspentOutputs(p0 ...daemon.OutputsFilter) (*visor.Re
 
 }
 
+// GetValidatorStats mocked method
+func (m *GatewayerMock) GetValidatorStats(p0 int64) (*visor.ReadableValidatorStats, error) {
+
+	ret := m.Called(p0)
+
+	var r0 *visor.ReadableValidatorStats
+	switch res := ret.Get(0).(type) {
+	case nil:
+	case *visor.ReadableValidatorStats:
+		r0 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	var r1 error
+	switch res := ret.Get(1).(type) {
+	case nil:
+	case error:
+		r1 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	return r0, r1
+
+}
+
 // InjectBroadcastTransaction mocked method
 func (m *GatewayerMock) InjectBroadcastTransaction(p0 coin.Transaction) error {
 
@@ -2,6 +2,8 @@ package webrpc
 
 import (
 	"fmt"
+
+	"github.com/samoslab/samos/src/daemon"
 )
 
 // StatusResult result struct of get_status
@@ -10,6 +12,8 @@ type StatusResult struct {
 	BlockNum           uint64 `json:"num_of_blocks"`
 	LastBlockHash      string `json:"hash_of_last_block"`
 	TimeSinceLastBlock string `json:"time_since_last_block"`
+	// Connections with their traffic stats
+	Connections []*daemon.Connection `json:"connections"`
 }
 
 func getStatusHandler(req Request, gw Gatewayer) Response {
@@ -26,11 +30,17 @@ func getStatusHandler(req Request, gw Gatewayer) Response {
 		return makeErrorResponse(errCodeInternalError, errMsgInternalError)
 	}
 
+	conns := []*daemon.Connection{}
+	if cs := gw.GetConnections(); cs != nil {
+		conns = cs.Connections
+	}
+
 	b := blocks.Blocks[0]
 	return makeSuccessResponse(req.ID, StatusResult{
 		Running:            true,
 		BlockNum:           b.Head.BkSeq + 1,
 		LastBlockHash:      b.Head.BlockHash,
 		TimeSinceLastBlock: fmt.Sprintf("%vs", gw.GetTimeNow()-b.Head.Time),
+		Connections:        conns,
 	})
 }
@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/samoslab/samos/src/daemon"
 )
 
 var lastBlockStr = `
@@ -63,6 +65,20 @@ func Test_getStatusHandler(t *testing.T) {
 	m := NewGatewayerMock()
 	m.On("GetLastBlocks", uint64(1)).Return(b, nil)
 	m.On("GetTimeNow").Return(uint64(now))
+	conns := &daemon.Connections{
+		Connections: []*daemon.Connection{
+			{
+				ID:     1,
+				Addr:   "127.0.0.1:6000",
+				RTT:    25,
+				Height: 100,
+				Sent: map[string]daemon.MessageStats{
+					"PING": {Messages: 2, Bytes: 16},
+				},
+			},
+		},
+	}
+	m.On("GetConnections").Return(conns)
 
 	type args struct {
 		req Request
@@ -90,6 +106,7 @@ func Test_getStatusHandler(t *testing.T) {
 				BlockNum:           b.Blocks[0].Head.BkSeq + 1,
 				LastBlockHash:      b.Blocks[0].Head.BlockHash,
 				TimeSinceLastBlock: fmt.Sprintf("%vs", uint64(now)-b.Blocks[0].Head.Time),
+				Connections:        conns.Connections,
 			}),
 		},
 		{
@@ -0,0 +1,25 @@
+package webrpc
+
+// request params: [] or [epoch], epoch is a unix time in the epoch and
+// defaults to the epoch of the head block
+func getValidatorsHandler(req Request, gateway Gatewayer) Response {
+	var epoch int64
+	if len(req.Params) > 0 {
+		var params []int64
+		if err := req.DecodeParams(&params); err != nil {
+			return makeErrorResponse(errCodeInvalidParams, errMsgInvalidParams)
+		}
+
+		if len(params) != 1 || params[0] <= 0 {
+			return makeErrorResponse(errCodeInvalidParams, errMsgInvalidParams)
+		}
+		epoch = params[0]
+	}
+
+	stats, err := gateway.GetValidatorStats(epoch)
+	if err != nil {
+		logger.Error(err)
+		return makeErrorResponse(errCodeInternalError, errMsgInternalError)
+	}
+	return makeSuccessResponse(req.ID, stats)
+}
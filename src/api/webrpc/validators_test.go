@@ -0,0 +1,73 @@
+package webrpc
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/samoslab/samos/src/visor"
+)
+
+func Test_getValidatorsHandler(t *testing.T) {
+	stats := &visor.ReadableValidatorStats{
+		Epoch: 1523145600,
+		Validators: []visor.ReadableSlotStats{
+			{PubKey: "025d8360bc9439aa94044df96605f7693f50bb35386b37ae5003787d840a98bf43", Produced: 2150, Missed: 7},
+		},
+	}
+	m := NewGatewayerMock()
+	m.On("GetValidatorStats", int64(0)).Return(stats, nil)
+	m.On("GetValidatorStats", int64(1523168680)).Return(stats, nil)
+	m.On("GetValidatorStats", int64(1)).Return(nil, errors.New("get validator stats failed"))
+
+	tests := []struct {
+		name   string
+		params []byte
+		want   Response
+	}{
+		{
+			"head epoch",
+			nil,
+			makeSuccessResponse("1", stats),
+		},
+		{
+			"epoch",
+			[]byte("[1523168680]"),
+			makeSuccessResponse("1", stats),
+		},
+		{
+			"invalid params: epoch value",
+			[]byte(`["abc"]`),
+			makeErrorResponse(errCodeInvalidParams, errMsgInvalidParams),
+		},
+		{
+			"invalid params: negative epoch",
+			[]byte("[-1]"),
+			makeErrorResponse(errCodeInvalidParams, errMsgInvalidParams),
+		},
+		{
+			"invalid params: more than one param",
+			[]byte("[1,2]"),
+			makeErrorResponse(errCodeInvalidParams, errMsgInvalidParams),
+		},
+		{
+			"gateway error",
+			[]byte("[1]"),
+			makeErrorResponse(errCodeInternalError, errMsgInternalError),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := Request{
+				ID:      "1",
+				Jsonrpc: jsonRPC,
+				Method:  "get_validators",
+				Params:  tt.params,
+			}
+			got := getValidatorsHandler(req, m)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -204,6 +204,8 @@ func (rpc *WebRPC) initHandlers() error {
 		"inject_transaction": injectTransactionHandler,
 		// get address affected uxouts
 		"get_address_uxouts": getAddrUxOutsHandler,
+		// get produced and missed slots of validators
+		"get_validators": getValidatorsHandler,
 	}
 
 	// register handlers
@@ -111,6 +111,14 @@ func (fg fakeGateway) GetTimeNow() uint64 {
 	return 0
 }
 
+func (fg fakeGateway) GetValidatorStats(ts int64) (*visor.ReadableValidatorStats, error) {
+	return nil, nil
+}
+
+func (fg fakeGateway) GetConnections() *daemon.Connections {
+	return nil
+}
+
 func Test_rpcHandler_HandlerFunc(t *testing.T) {
 	rpc := setupWebRPC(t)
 	rpc.HandleFunc("get_status", getStatusHandler)
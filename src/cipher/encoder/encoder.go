@@ -22,6 +22,7 @@ import (
 	"log"
 	"math"
 	"reflect"
+	"strings"
 )
 
 /*
@@ -32,6 +33,22 @@ Todo:
 
 // TODO: constant length byte arrays must not be prefixed
 
+// omitEmpty returns true if the field is tagged `enc:",omitempty"`. An empty slice, map
+// or string in such a field is not encoded. It must be the last field of the struct,
+// the decoder leaves it empty when the buffer ends before it
+func omitEmpty(f reflect.StructField) bool {
+	return strings.HasSuffix(f.Tag.Get("enc"), ",omitempty")
+}
+
+// isEmpty returns true if the slice, map or string has no elements
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String:
+		return v.Len() == 0
+	}
+	return false
+}
+
 // EncodeInt encodes int
 func EncodeInt(b []byte, data interface{}) {
 	//var b [8]byte
@@ -434,6 +451,9 @@ func datasizeWrite(v reflect.Value) (int, error) {
 		sum := 0
 		for i, n := 0, t.NumField(); i < n; i++ {
 			f := t.Field(i)
+			if omitEmpty(f) && isEmpty(v.Field(i)) {
+				continue
+			}
 			if f.Tag.Get("enc") != "-" {
 				s, err := datasizeWrite(v.Field(i))
 				if err != nil {
@@ -665,6 +685,9 @@ func (d *decoder) value(v reflect.Value) error {
 		for i := 0; i < v.NumField(); i++ {
 			fv := v.Field(i)
 			ff := t.Field(i)
+			if omitEmpty(ff) && len(d.buf) == 0 {
+				continue
+			}
 			if ff.Tag.Get("enc") != "-" {
 				if fv.CanSet() && ff.Name != "_" {
 					if err := d.value(fv); err != nil {
@@ -794,6 +817,9 @@ func (d *decoder) dchk(v reflect.Value) int {
 		for i := 0; i < v.NumField(); i++ {
 			fv := v.Field(i)
 			ff := t.Field(i)
+			if omitEmpty(ff) && len(d.buf) == 0 {
+				continue
+			}
 			if ff.Tag.Get("enc") != "-" {
 				if fv.CanSet() && ff.Name != "_" {
 					//c += d.adv(d.dchk(fv))
@@ -874,6 +900,9 @@ func (e *encoder) value(v reflect.Value) {
 			// see comment for corresponding code in decoder.value()
 			v := v.Field(i)
 			f := t.Field(i)
+			if omitEmpty(f) && isEmpty(v) {
+				continue
+			}
 			if f.Tag.Get("enc") != "-" {
 				if v.CanSet() || f.Name != "_" {
 					e.value(v)
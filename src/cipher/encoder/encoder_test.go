@@ -552,3 +552,43 @@ func TestByteArray(t *testing.T) {
 	}
 
 }
+
+type TestStructOmitEmpty struct {
+	X     int32
+	Extra []byte `enc:",omitempty"`
+}
+
+type TestStructWithoutOmitted struct {
+	X int32
+}
+
+func TestOmitEmpty(t *testing.T) {
+	empty := TestStructOmitEmpty{X: 7}
+	b := Serialize(empty)
+	if !bytes.Equal(b, Serialize(TestStructWithoutOmitted{X: 7})) {
+		t.Fatalf("empty omitempty field is encoded: %x", b)
+	}
+
+	// the layout without the field decodes
+	var got TestStructOmitEmpty
+	n, err := DeserializeRawToValue(b, reflect.ValueOf(&got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(b) || got.X != 7 || got.Extra != nil {
+		t.Fatalf("decoded %d bytes to %+v", n, got)
+	}
+
+	full := TestStructOmitEmpty{X: 7, Extra: []byte{1, 2, 3}}
+	b = Serialize(full)
+	if len(b) != 4+4+3 {
+		t.Fatalf("encoded %d bytes", len(b))
+	}
+	got = TestStructOmitEmpty{}
+	if err := DeserializeRaw(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(full, got) {
+		t.Fatalf("decoded %+v", got)
+	}
+}
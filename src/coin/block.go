@@ -65,7 +65,37 @@ func (psb PendingSignedBlock) ToSignedBlock() SignedBlock {
 
 // VerifySignature verifies that the block is signed by pubkey
 func (b SignedBlock) VerifySignature(pubkeys []cipher.PubKey) error {
-	pubkeyRec, err := cipher.PubKeyFromSig(b.Sig, b.Block.HashHeader()) //recovered pubkey
+	return b.SignedHeader().VerifySignature(pubkeys)
+}
+
+// SignedHeader returns the header of the block with its signature
+func (b SignedBlock) SignedHeader() SignedBlockHeader {
+	return SignedBlockHeader{
+		Header: b.Head,
+		Sig:    b.Sig,
+	}
+}
+
+// SignedBlockHeader a block header with the signature of its block. The signature
+// covers the header only, so the header can be verified without the body.
+type SignedBlockHeader struct {
+	Header BlockHeader
+	Sig    cipher.Sig
+}
+
+// Hash returns the hash of the header, the same as the hash of its block
+func (h SignedBlockHeader) Hash() cipher.SHA256 {
+	return h.Header.Hash()
+}
+
+// Seq returns the seq of the header
+func (h SignedBlockHeader) Seq() uint64 {
+	return h.Header.BkSeq
+}
+
+// VerifySignature verifies that the header is signed by one of the pubkeys
+func (h SignedBlockHeader) VerifySignature(pubkeys []cipher.PubKey) error {
+	pubkeyRec, err := cipher.PubKeyFromSig(h.Sig, h.Header.Hash()) //recovered pubkey
 	if err != nil {
 		return errors.New("Invalid sig: PubKey recovery failed")
 	}
@@ -79,7 +109,18 @@ func (b SignedBlock) VerifySignature(pubkeys []cipher.PubKey) error {
 		return errors.New("not trust public key")
 	}
 
-	return cipher.VerifySignature(pubkeyRec, b.Sig, b.Block.HashHeader())
+	return cipher.VerifySignature(pubkeyRec, h.Sig, h.Header.Hash())
+}
+
+// ToSignedBlock returns the signed block of the header and body
+func (h SignedBlockHeader) ToSignedBlock(body BlockBody) SignedBlock {
+	return SignedBlock{
+		Block: Block{
+			Head: h.Header,
+			Body: body,
+		},
+		Sig: h.Sig,
+	}
 }
 
 // NewBlock creates new block.
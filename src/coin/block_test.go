@@ -95,6 +95,31 @@ func TestBlockHashBody(t *testing.T) {
 	assert.Equal(t, b.HashBody(), b.Body.Hash())
 }
 
+func TestSignedBlockHeader(t *testing.T) {
+	b, err := makeNewBlock(testutil.RandSHA256(t))
+	require.NoError(t, err)
+
+	pubkey, seckey := cipher.GenerateKeyPair()
+	otherPubkey, _ := cipher.GenerateKeyPair()
+	sb := SignedBlock{
+		Block: *b,
+		Sig:   cipher.SignHash(b.HashHeader(), seckey),
+	}
+
+	h := sb.SignedHeader()
+	require.Equal(t, b.HashHeader(), h.Hash())
+	require.Equal(t, b.Seq(), h.Seq())
+	require.NoError(t, h.VerifySignature([]cipher.PubKey{otherPubkey, pubkey}))
+	require.Error(t, h.VerifySignature([]cipher.PubKey{otherPubkey}))
+	require.NoError(t, sb.VerifySignature([]cipher.PubKey{pubkey}))
+
+	require.Equal(t, sb, h.ToSignedBlock(b.Body))
+
+	// a tampered header is rejected
+	h.Header.Fee++
+	require.Error(t, h.VerifySignature([]cipher.PubKey{pubkey}))
+}
+
 func TestNewGenesisBlock(t *testing.T) {
 	gb, err := NewGenesisBlock(genAddress, _genCoins, _genTime)
 	require.NoError(t, err)
@@ -18,6 +18,19 @@ var (
 	DebugLevel2 = true
 )
 
+const (
+	// TxnTypeNormal is a plain coin transfer
+	TxnTypeNormal uint8 = 0x00
+	// TxnTypeAddValidator proposes adding a trust node to the validator set
+	TxnTypeAddValidator uint8 = 0x01
+	// TxnTypeRemoveValidator proposes removing a trust node from the validator set
+	TxnTypeRemoveValidator uint8 = 0x02
+	// TxnTypeVote votes for a candidate trust key with the coins sent back to the voter
+	TxnTypeVote uint8 = 0x03
+	// TxnTypeEvidence commits the evidence of a trust node signing two conflicting blocks
+	TxnTypeEvidence uint8 = 0x04
+)
+
 /*
 Transaction with N inputs, M ouputs is
 - 32 bytes constant
@@ -35,13 +48,21 @@ Sigs is the array of signatures
 
 The inner hash is SHA256 hash of the serialization of Input and Output array
 The outer hash is the hash of the whole transaction serialization
+
+Typed transactions (Type != TxnTypeNormal) may carry extra signatures after
+the input signatures. Their meaning depends on the type, e.g. the approvals
+of trust nodes for a validator change. The extra signatures sign hashes derived
+from the typed hash of Input, Output and Type, and the inner hash of a typed
+transaction covers the type and the extra signatures, so they can't be changed
+or stripped without invalidating the input signatures. The extra signatures are
+pushed before the inputs are signed.
 */
 
 // Transaction transaction struct
 type Transaction struct {
 	Length    uint32        //length prefix
 	Type      uint8         //transaction type
-	InnerHash cipher.SHA256 //inner hash SHA256 of In[],Out[]
+	InnerHash cipher.SHA256 //inner hash SHA256 of In[],Out[], and Type and extra Sigs[] if typed
 
 	Sigs []cipher.Sig        //list of signatures, 64+1 bytes each
 	In   []cipher.SHA256     //ouputs being spent
@@ -74,7 +95,10 @@ func (txn *Transaction) Verify() error {
 	}
 
 	// Check signature index fields
-	if len(txn.Sigs) != len(txn.In) {
+	if len(txn.Sigs) < len(txn.In) {
+		return errors.New("Invalid number of signatures")
+	}
+	if len(txn.Sigs) != len(txn.In) && txn.Type == TxnTypeNormal {
 		return errors.New("Invalid number of signatures")
 	}
 	if len(txn.Sigs) >= math.MaxUint16 {
@@ -90,7 +114,7 @@ func (txn *Transaction) Verify() error {
 		return errors.New("Duplicate spend")
 	}
 
-	if txn.Type != 0 {
+	if !IsValidTxnType(txn.Type) {
 		return errors.New("transaction type invalid")
 	}
 
@@ -114,7 +138,7 @@ func (txn *Transaction) Verify() error {
 	}
 
 	// Validate signature
-	for i, sig := range txn.Sigs {
+	for i, sig := range txn.InputSigs() {
 		hash := cipher.AddSHA256(txn.InnerHash, txn.In[i])
 		if err := cipher.VerifySignedHash(sig, hash); err != nil {
 			return err
@@ -148,7 +172,7 @@ func (txn Transaction) VerifyInput(uxIn UxArray) error {
 		if len(txn.In) != len(uxIn) {
 			logger.Panic("tx.In != uxIn")
 		}
-		if len(txn.In) != len(txn.Sigs) {
+		if len(txn.In) > len(txn.Sigs) || (txn.Type == TxnTypeNormal && len(txn.In) != len(txn.Sigs)) {
 			logger.Panic("tx.In != tx.Sigs")
 		}
 		if txn.InnerHash != txn.HashInner() {
@@ -203,11 +227,12 @@ func (txn *Transaction) PushOutput(dst cipher.Address, coins, hours uint64) {
 	txn.Out = append(txn.Out, to)
 }
 
-// SignInputs signs all inputs in the transaction
+// SignInputs signs all inputs in the transaction. The signatures pushed to a
+// typed transaction before are its extra signatures, they are kept after the
+// input signatures
 func (txn *Transaction) SignInputs(keys []cipher.SecKey) {
-	txn.InnerHash = txn.HashInner() // update hash
-
-	if len(txn.Sigs) != 0 {
+	extraSigs := txn.Sigs
+	if len(extraSigs) != 0 && txn.Type == TxnTypeNormal {
 		logger.Panic("Transaction has been signed")
 	}
 	if len(keys) != len(txn.In) {
@@ -220,13 +245,14 @@ func (txn *Transaction) SignInputs(keys []cipher.SecKey) {
 		logger.Panic("No keys")
 	}
 
-	sigs := make([]cipher.Sig, len(txn.In))
-	innerHash := txn.HashInner()
+	txn.InnerHash = txn.hashInner(extraSigs) // update hash
+
+	sigs := make([]cipher.Sig, len(txn.In), len(txn.In)+len(extraSigs))
 	for i, k := range keys {
-		h := cipher.AddSHA256(innerHash, txn.In[i]) // hash to sign
+		h := cipher.AddSHA256(txn.InnerHash, txn.In[i]) // hash to sign
 		sigs[i] = cipher.SignHash(h, k)
 	}
-	txn.Sigs = sigs
+	txn.Sigs = append(sigs, extraSigs...)
 }
 
 // Size returns the encoded byte size of the transaction
@@ -261,14 +287,67 @@ func (txn *Transaction) TxIDHex() string {
 func (txn *Transaction) UpdateHeader() {
 	s := txn.Size()
 	txn.Length = uint32(s)
-	txn.Type = byte(0x00)
 	txn.InnerHash = txn.HashInner()
 }
 
-// HashInner hashes only the Transaction Inputs & Outputs
+// IsValidTxnType returns true if the type byte is a known transaction type
+func IsValidTxnType(t uint8) bool {
+	switch t {
+	case TxnTypeNormal, TxnTypeAddValidator, TxnTypeRemoveValidator, TxnTypeVote, TxnTypeEvidence:
+		return true
+	default:
+		return false
+	}
+}
+
+// InputSigs returns the signatures that authorize spending the inputs
+func (txn *Transaction) InputSigs() []cipher.Sig {
+	if len(txn.Sigs) < len(txn.In) {
+		return txn.Sigs
+	}
+	return txn.Sigs[:len(txn.In)]
+}
+
+// ExtraSigs returns the type specific signatures appended after the input signatures
+func (txn *Transaction) ExtraSigs() []cipher.Sig {
+	if len(txn.Sigs) <= len(txn.In) {
+		return nil
+	}
+	return txn.Sigs[len(txn.In):]
+}
+
+// PushExtraSig appends a type specific signature and updates the length prefix,
+// it must be called before the inputs are signed
+func (txn *Transaction) PushExtraSig(sig cipher.Sig) {
+	if txn.Type == TxnTypeNormal {
+		logger.Panic("Normal transactions can't carry extra signatures")
+	}
+	txn.Sigs = append(txn.Sigs, sig)
+	txn.Length = uint32(txn.Size())
+}
+
+// HashTyped hashes the Transaction Inputs & Outputs and the type,
+// the extra signatures of a typed transaction sign hashes derived from it
+func (txn *Transaction) HashTyped() cipher.SHA256 {
+	b1 := encoder.Serialize(txn.In)
+	b2 := encoder.Serialize(txn.Out)
+	b3 := append(b1, b2...)
+	return cipher.SumSHA256(append(b3, txn.Type))
+}
+
+// HashInner hashes only the Transaction Inputs & Outputs,
+// and the type and the extra signatures of a typed transaction
 // This is what is signed
 // Client hashes the inner hash with hash of output being spent and signs it with private key
 func (txn *Transaction) HashInner() cipher.SHA256 {
+	return txn.hashInner(txn.ExtraSigs())
+}
+
+func (txn *Transaction) hashInner(extraSigs []cipher.Sig) cipher.SHA256 {
+	if txn.Type != TxnTypeNormal {
+		return cipher.AddSHA256(txn.HashTyped(), cipher.SumSHA256(encoder.Serialize(extraSigs)))
+	}
+
 	b1 := encoder.Serialize(txn.In)
 	b2 := encoder.Serialize(txn.Out)
 	b3 := append(b1, b2...)
@@ -366,6 +445,24 @@ func (txns Transactions) TruncateBytesTo(size int) Transactions {
 	return txns
 }
 
+// FillBytesTo returns the transactions whose total size is less than or equal to size,
+// taken in order. Unlike TruncateBytesTo, a transaction that does not fit is skipped
+// so that smaller transactions after it can fill the remaining space.
+func (txns Transactions) FillBytesTo(size int) Transactions {
+	var filled Transactions
+	total := 0
+	for i := range txns {
+		pending := txns[i].Size()
+		if pending+total > size {
+			continue
+		}
+
+		filled = append(filled, txns[i])
+		total += pending
+	}
+	return filled
+}
+
 // SortableTransactions allows sorting transactions by fee & hash
 type SortableTransactions struct {
 	Txns   Transactions
@@ -401,18 +498,9 @@ func NewSortableTransactions(txns Transactions, feeCalc FeeCalculator) SortableT
 
 		size, hash := txns[i].SizeHash()
 
-		// Calculate fee priority based on fee per kb
-		feeKB, err := multUint64(fee, 1024)
-
-		// If the fee * 1024 would exceed math.MaxUint64, set it to math.MaxUint64 so that
-		// this transaction can still be processed
-		if err != nil {
-			feeKB = math.MaxUint64
-		}
-
 		newTxns[j] = txns[i]
 		hashes[j] = hash
-		fees[j] = feeKB / uint64(size)
+		fees[j] = FeePerKB(fee, size)
 		j++
 	}
 	return SortableTransactions{
@@ -422,6 +510,23 @@ func NewSortableTransactions(txns Transactions, feeCalc FeeCalculator) SortableT
 	}
 }
 
+// FeePerKB returns the fee priority of a transaction of size bytes paying fee coin hours
+func FeePerKB(fee uint64, size int) uint64 {
+	if size <= 0 {
+		return 0
+	}
+
+	feeKB, err := multUint64(fee, 1024)
+
+	// If the fee * 1024 would exceed math.MaxUint64, set it to math.MaxUint64 so that
+	// this transaction can still be processed
+	if err != nil {
+		feeKB = math.MaxUint64
+	}
+
+	return feeKB / uint64(size)
+}
+
 // Sort sorts by tx fee, and then by hash if fee equal
 func (txns SortableTransactions) Sort() {
 	sort.Sort(txns)
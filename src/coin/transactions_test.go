@@ -204,6 +204,70 @@ func TestTransactionVerifyInput(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestTransactionExtraSigs(t *testing.T) {
+	// Normal transactions can't carry extra signatures
+	tx := makeTransaction(t)
+	require.Len(t, tx.InputSigs(), 1)
+	require.Nil(t, tx.ExtraSigs())
+	p, s := cipher.GenerateKeyPair()
+	require.Panics(t, func() { tx.PushExtraSig(cipher.SignHash(tx.InnerHash, s)) })
+	tx.Sigs = append(tx.Sigs, cipher.SignHash(tx.InnerHash, s))
+	tx.UpdateHeader()
+	testutil.RequireError(t, tx.Verify(), "Invalid number of signatures")
+
+	// Typed transactions push the extra signatures before the inputs are signed
+	ux, us := makeUxOutWithSecret(t)
+	tx = Transaction{Type: TxnTypeAddValidator}
+	tx.PushInput(ux.Hash())
+	tx.PushOutput(makeAddress(), 1e6, 50)
+	sig := cipher.SignHash(tx.HashTyped(), s)
+	tx.PushExtraSig(sig)
+	tx.SignInputs([]cipher.SecKey{us})
+	tx.UpdateHeader()
+	require.Equal(t, TxnTypeAddValidator, tx.Type)
+	require.NoError(t, tx.Verify())
+	require.NoError(t, tx.VerifyInput(UxArray{ux}))
+	require.Equal(t, []cipher.Sig{sig}, tx.ExtraSigs())
+	require.Len(t, tx.InputSigs(), 1)
+	pk, err := cipher.PubKeyFromSig(tx.ExtraSigs()[0], tx.HashTyped())
+	require.NoError(t, err)
+	require.Equal(t, p, pk)
+
+	// The inner hash of normal transactions covers only the inputs and outputs
+	normal := copyTransaction(tx)
+	normal.Type = TxnTypeNormal
+	b := append(encoder.Serialize(normal.In), encoder.Serialize(normal.Out)...)
+	require.Equal(t, cipher.SumSHA256(b), normal.HashInner())
+
+	// Setting the type back to normal and stripping the extra signatures invalidates the transaction
+	stripped := copyTransaction(tx)
+	stripped.Type = TxnTypeNormal
+	stripped.Sigs = stripped.InputSigs()
+	stripped.Length = uint32(stripped.Size())
+	testutil.RequireError(t, stripped.Verify(), "Invalid header hash")
+	stripped.InnerHash = stripped.HashInner()
+	testutil.RequireError(t, stripped.VerifyInput(UxArray{ux}), "Signature not valid for output being spent")
+
+	// Dropping an extra signature invalidates the transaction
+	dropped := copyTransaction(tx)
+	dropped.Sigs = dropped.InputSigs()
+	dropped.Length = uint32(dropped.Size())
+	testutil.RequireError(t, dropped.Verify(), "Invalid header hash")
+	dropped.InnerHash = dropped.HashInner()
+	testutil.RequireError(t, dropped.VerifyInput(UxArray{ux}), "Signature not valid for output being spent")
+
+	// Retagging a normal transaction invalidates it
+	retagged := makeTransaction(t)
+	retagged.Type = TxnTypeEvidence
+	testutil.RequireError(t, retagged.Verify(), "Invalid header hash")
+
+	// Unknown types are rejected
+	tx = makeTransaction(t)
+	tx.Type = 0xff
+	tx.UpdateHeader()
+	testutil.RequireError(t, tx.Verify(), "transaction type invalid")
+}
+
 func TestTransactionPushInput(t *testing.T) {
 	tx := &Transaction{}
 	ux := makeUxOut(t)
@@ -459,6 +523,29 @@ func TestTransactionsTruncateBytesTo(t *testing.T) {
 	require.Equal(t, txns2.Size(), trunc)
 }
 
+func TestTransactionsFillBytesTo(t *testing.T) {
+	txns := makeTransactions(t, 4)
+	// make the second transaction bigger than two of the others
+	for i := 0; i < 10; i++ {
+		txns[1].PushOutput(makeAddress(), 1e6, 50)
+	}
+	txns[1].UpdateHeader()
+	require.True(t, txns[1].Size() > txns[0].Size()*2)
+
+	// the bigger transaction is skipped, the next ones fill the space
+	size := txns[0].Size() * 3
+	txns2 := txns.FillBytesTo(size)
+	require.Equal(t, Transactions{txns[0], txns[2], txns[3]}, txns2)
+	require.Equal(t, size, txns2.Size())
+
+	size = txns[0].Size() + txns[1].Size()
+	txns2 = txns.FillBytesTo(size)
+	require.Equal(t, Transactions{txns[0], txns[1]}, txns2)
+
+	require.Equal(t, txns, txns.FillBytesTo(txns.Size()))
+	require.Empty(t, txns.FillBytesTo(txns[0].Size()-1))
+}
+
 func TestVerifyTransactionCoinsSpending(t *testing.T) {
 	// Input coins overflow
 	// Insufficient coins
@@ -865,6 +952,14 @@ func TestTransactionsFees(t *testing.T) {
 	testutil.RequireError(t, err, "Transactions fee totals overflow")
 }
 
+func TestFeePerKB(t *testing.T) {
+	require.Equal(t, uint64(1024), FeePerKB(100, 100))
+	require.Equal(t, uint64(3), FeePerKB(1, 300))
+	require.Equal(t, uint64(0), FeePerKB(0, 300))
+	require.Equal(t, uint64(0), FeePerKB(10, 0))
+	require.Equal(t, uint64(math.MaxUint64/300), FeePerKB(math.MaxUint64/2, 300))
+}
+
 func TestSortTransactions(t *testing.T) {
 	n := 6
 	var txns Transactions
@@ -8,11 +8,6 @@ import (
 	"github.com/samoslab/samos/src/coin"
 )
 
-const (
-	blockInterval = int64(10)
-	epochInterval = int64(86400)
-)
-
 var (
 	ErrInvalidTimestamp      = errors.New("invalid timestamp")
 	ErrWaitForPrevBlock      = errors.New("wait for last block arrived")
@@ -20,6 +15,7 @@ var (
 	ErrBlockAlreadyCreated   = errors.New("block already created in the slot")
 	ErrInvalidBlockValidator = errors.New("invalid block validator")
 	ErrInvalidMintBlockTime  = errors.New("invalid time to mint the block")
+	ErrUnknownEpochSeed      = errors.New("the seed of the epoch is unknown")
 )
 
 // Dpos consensus alg
@@ -28,13 +24,18 @@ type Dpos struct {
 	mu          sync.RWMutex
 	stop        chan bool
 	dposContext *DposContext
+	intervals   Intervals
 	lastSlot    uint32
+	// the epoch of the latest block and the hash of the last block before it
+	seedEpoch int64
+	seed      cipher.SHA256
 }
 
 // NewDpos create dpos instance
 func NewDpos(signer cipher.PubKey) *Dpos {
 	return &Dpos{
 		dposContext: NewDposContext(),
+		intervals:   DefaultIntervals(),
 		signer:      signer,
 		lastSlot:    0,
 	}
@@ -42,22 +43,176 @@ func NewDpos(signer cipher.PubKey) *Dpos {
 
 // SetTrustNode set trust nodes for block creator
 func (d *Dpos) SetTrustNode(trusts []cipher.PubKey) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	return d.dposContext.SetValidators(trusts)
 }
 
+// SetIntervals set the block and epoch intervals of the chain
+func (d *Dpos) SetIntervals(iv Intervals) error {
+	if err := iv.Verify(); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.intervals = iv
+	return nil
+}
+
+// Intervals returns the block and epoch intervals of the chain
+func (d *Dpos) Intervals() Intervals {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.intervals
+}
+
+// SetEpochSeed records the hash of the last block before the epoch, it seeds the
+// producer order of the epoch
+func (d *Dpos) SetEpochSeed(epoch int64, seed cipher.SHA256) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.seedEpoch = epoch
+	d.seed = seed
+}
+
+// seedOf returns the seed of the epoch containing slot for a block built on parent,
+// which is the hash of the last block before the epoch
+func (d *Dpos) seedOf(parent *coin.SignedBlock, slot int64) (cipher.SHA256, error) {
+	epoch := d.intervals.EpochStart(slot)
+	if d.intervals.PrevSlot(int64(parent.Time())) < epoch {
+		return parent.HashHeader(), nil
+	}
+	if d.seedEpoch == epoch {
+		return d.seed, nil
+	}
+	return cipher.SHA256{}, ErrUnknownEpochSeed
+}
+
+// epochContext returns the epoch context of the slot for a block built on parent
+func (d *Dpos) epochContext(parent *coin.SignedBlock, slot int64) (*EpochContext, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	seed, err := d.seedOf(parent, slot)
+	if err != nil {
+		return nil, err
+	}
+	return NewEpochFromDposContext(*d.dposContext, d.intervals, seed, slot), nil
+}
+
+// AddValidatorChange schedules an executed validator change
+func (d *Dpos) AddValidatorChange(change ValidatorChange) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dposContext.AddValidatorChange(change)
+}
+
+// RemoveValidatorChange unschedules the validator change of a reverted transaction
+func (d *Dpos) RemoveValidatorChange(txid cipher.SHA256) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dposContext.RemoveValidatorChange(txid)
+}
+
+// ValidatorsAt returns the validators active at the timestamp
+func (d *Dpos) ValidatorsAt(ts int64) []cipher.PubKey {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.dposContext.ValidatorsAt(ts)
+}
+
+// AddElection records the producers elected for an epoch
+func (d *Dpos) AddElection(election Election) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dposContext.AddElection(election)
+}
+
+// RemoveElection removes the election of the epoch, when the block holding it is reverted
+func (d *Dpos) RemoveElection(epoch int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dposContext.RemoveElection(epoch)
+}
+
+// ProducersAt returns the block producers in their order at the timestamp
+func (d *Dpos) ProducersAt(ts int64) []cipher.PubKey {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.dposContext.ProducersAt(ts)
+}
+
+// NextEpochExclusion returns the exclusion of the validator from the epoch after
+// the one containing ts, for the double signing proved by the evidence hash
+func (d *Dpos) NextEpochExclusion(evidence cipher.SHA256, pubkey cipher.PubKey, ts int64) Exclusion {
+	iv := d.Intervals()
+	from := iv.NextEpoch(ts)
+	return Exclusion{
+		Evidence: evidence,
+		PubKey:   pubkey,
+		From:     from,
+		To:       from + iv.Epoch,
+	}
+}
+
+// AddExclusion keeps the validator out of the validator set in the range of an executed exclusion
+func (d *Dpos) AddExclusion(exclusion Exclusion) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dposContext.AddExclusion(exclusion)
+}
+
+// RemoveExclusion removes the exclusion for the evidence, when the block committing it is reverted
+func (d *Dpos) RemoveExclusion(evidence cipher.SHA256) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dposContext.RemoveExclusion(evidence)
+}
+
+// HasExclusion returns true if an executed exclusion punishes the evidence
+func (d *Dpos) HasExclusion(evidence cipher.SHA256) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.dposContext.HasExclusion(evidence)
+}
+
+// ResetState replaces the validator changes, elections and exclusions with the executed ones,
+// when the state changed by a rolled back db transaction is reloaded
+func (d *Dpos) ResetState(changes []ValidatorChange, elections []Election, exclusions []Exclusion) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dc := NewDposContext()
+	dc.candidate = d.dposContext.candidate
+	for _, change := range changes {
+		dc.AddValidatorChange(change)
+	}
+	for _, election := range elections {
+		dc.AddElection(election)
+	}
+	for _, exclusion := range exclusions {
+		dc.AddExclusion(exclusion)
+	}
+	d.dposContext = dc
+}
+
+// NextEpoch returns the start time of the epoch after the one containing ts,
+// validator changes are activated there
+func (d *Dpos) NextEpoch(ts int64) int64 {
+	return d.Intervals().NextEpoch(ts)
+}
+
 func (d *Dpos) checkDeadline(lastBlock *coin.SignedBlock, now int64) error {
-	prevSlot := PrevSlot(now)
-	nextSlot := NextSlot(now)
-	if int64(lastBlock.Time()) >= nextSlot {
+	// compare slots rather than times, a block made at the end of a slot
+	// shares its timestamp with the start of the next one
+	iv := d.Intervals()
+	prevSlot := iv.PrevSlot(now)
+	lastSlot := iv.PrevSlot(int64(lastBlock.Time()))
+	if lastSlot > prevSlot {
 		return ErrMintFutureBlock
 	}
-	if int64(lastBlock.Time()) >= prevSlot && int64(lastBlock.Time()) < nextSlot {
+	if lastSlot == prevSlot {
 		return ErrBlockAlreadyCreated
 	}
-	if int64(lastBlock.Time()) < prevSlot {
-		return nil
-	}
-	return ErrWaitForPrevBlock
+	return nil
 }
 
 // CheckValidator check current node create block or not, every node has same chance to
@@ -66,8 +221,12 @@ func (d *Dpos) CheckValidator(lastBlock *coin.SignedBlock, now int64) error {
 	if err := d.checkDeadline(lastBlock, now); err != nil {
 		return err
 	}
-	epochContext := NewEpochFromDposContext(*d.dposContext, now)
-	validator, err := epochContext.LookupValidator(PrevSlot(now))
+	slot := d.PrevSlot(now)
+	epochContext, err := d.epochContext(lastBlock, slot)
+	if err != nil {
+		return err
+	}
+	validator, err := epochContext.LookupValidator(slot)
 	if err != nil {
 		return err
 	}
@@ -78,17 +237,52 @@ func (d *Dpos) CheckValidator(lastBlock *coin.SignedBlock, now int64) error {
 }
 
 // PrevSlot previos slot for create block
-func PrevSlot(now int64) int64 {
-	return int64((now-1)/blockInterval) * blockInterval
+func (d *Dpos) PrevSlot(now int64) int64 {
+	return d.Intervals().PrevSlot(now)
 }
 
 // NextSlot next slot for create block
-func NextSlot(now int64) int64 {
-	return int64((now+blockInterval-1)/blockInterval) * blockInterval
+func (d *Dpos) NextSlot(now int64) int64 {
+	return d.Intervals().NextSlot(now)
 }
 
-// GetValidator returns validator in the timestamp
-func (d *Dpos) GetValidator(timestamp int64) (cipher.PubKey, error) {
-	epochContext := NewEpochFromDposContext(*d.dposContext, timestamp)
-	return epochContext.LookupValidator(PrevSlot(timestamp))
+// GetValidator returns validator of the block at the timestamp built on parent
+func (d *Dpos) GetValidator(parent *coin.SignedBlock, timestamp int64) (cipher.PubKey, error) {
+	slot := d.PrevSlot(timestamp)
+	epochContext, err := d.epochContext(parent, slot)
+	if err != nil {
+		return cipher.PubKey{}, err
+	}
+	return epochContext.LookupValidator(slot)
+}
+
+// SlotProducer the producer of the block slot (Slot, Slot+BlockInterval]
+type SlotProducer struct {
+	Slot     int64
+	Producer cipher.PubKey
+}
+
+// Schedule returns the producers of the next n slots after now for blocks built on parent.
+// The order of an epoch is known once its first block is due, so the schedule ends with the epoch of now
+func (d *Dpos) Schedule(parent *coin.SignedBlock, now int64, n int) ([]SlotProducer, error) {
+	iv := d.Intervals()
+	slot := iv.PrevSlot(now)
+	epochContext, err := d.epochContext(parent, slot)
+	if err != nil {
+		return nil, err
+	}
+
+	end := iv.NextEpoch(slot)
+	schedule := []SlotProducer{}
+	for ; slot < end && len(schedule) < n; slot += iv.Block {
+		producer, err := epochContext.LookupValidator(slot)
+		if err != nil {
+			return nil, err
+		}
+		schedule = append(schedule, SlotProducer{
+			Slot:     slot,
+			Producer: producer,
+		})
+	}
+	return schedule, nil
 }
@@ -2,13 +2,32 @@ package dpos
 
 import (
 	"errors"
+	"sort"
 
 	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/coin"
 )
 
 // DposContext store trust pubkey
 type DposContext struct {
 	candidate []cipher.PubKey
+	// on-chain validator changes, ordered by activation time
+	changes []ValidatorChange
+	// validators kept out of the set for a time range
+	exclusions []Exclusion
+	// producers elected by votes, ordered by epoch
+	elections []Election
+}
+
+// Exclusion keeps the validator out of the validator set in [From, To),
+// Evidence is the hash of the double signing evidence it punishes and TxID
+// the transaction committing it
+type Exclusion struct {
+	Evidence cipher.SHA256
+	TxID     cipher.SHA256
+	PubKey   cipher.PubKey
+	From     int64
+	To       int64
 }
 
 // NewDposContext new instance
@@ -29,3 +48,191 @@ func (dc *DposContext) SetValidators(validators []cipher.PubKey) error {
 	dc.candidate = validators
 	return nil
 }
+
+// AddValidatorChange schedules a validator change, changes already known are ignored
+func (dc *DposContext) AddValidatorChange(change ValidatorChange) {
+	for _, c := range dc.changes {
+		if c.TxID == change.TxID {
+			return
+		}
+	}
+
+	changes := make([]ValidatorChange, len(dc.changes), len(dc.changes)+1)
+	copy(changes, dc.changes)
+	changes = append(changes, change)
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].ActivateAt < changes[j].ActivateAt
+	})
+	dc.changes = changes
+}
+
+// RemoveValidatorChange removes the validator change of the transaction, when its block is reverted
+func (dc *DposContext) RemoveValidatorChange(txid cipher.SHA256) {
+	changes := make([]ValidatorChange, 0, len(dc.changes))
+	for _, c := range dc.changes {
+		if c.TxID != txid {
+			changes = append(changes, c)
+		}
+	}
+	dc.changes = changes
+}
+
+// AddExclusion excludes a validator for the time range, exclusions already known are ignored
+func (dc *DposContext) AddExclusion(exclusion Exclusion) {
+	for _, e := range dc.exclusions {
+		if e == exclusion {
+			return
+		}
+	}
+
+	exclusions := make([]Exclusion, len(dc.exclusions), len(dc.exclusions)+1)
+	copy(exclusions, dc.exclusions)
+	dc.exclusions = append(exclusions, exclusion)
+}
+
+// RemoveExclusion removes the exclusion for the evidence, when the block committing it is reverted
+func (dc *DposContext) RemoveExclusion(evidence cipher.SHA256) {
+	exclusions := make([]Exclusion, 0, len(dc.exclusions))
+	for _, e := range dc.exclusions {
+		if e.Evidence != evidence {
+			exclusions = append(exclusions, e)
+		}
+	}
+	dc.exclusions = exclusions
+}
+
+// HasExclusion returns true if the evidence excluded a validator already
+func (dc *DposContext) HasExclusion(evidence cipher.SHA256) bool {
+	for _, e := range dc.exclusions {
+		if e.Evidence == evidence {
+			return true
+		}
+	}
+	return false
+}
+
+// GetExclusions returns all validator exclusions
+func (dc *DposContext) GetExclusions() []Exclusion {
+	return dc.exclusions
+}
+
+func (dc *DposContext) isExcluded(pubkey cipher.PubKey, ts int64) bool {
+	for _, e := range dc.exclusions {
+		if e.PubKey == pubkey && e.From <= ts && ts < e.To {
+			return true
+		}
+	}
+	return false
+}
+
+// AddElection records the producers elected for an epoch, a later election of the same epoch replaces it
+func (dc *DposContext) AddElection(election Election) {
+	elections := make([]Election, 0, len(dc.elections)+1)
+	for _, e := range dc.elections {
+		if e.Epoch != election.Epoch {
+			elections = append(elections, e)
+		}
+	}
+	elections = append(elections, election)
+	sort.SliceStable(elections, func(i, j int) bool {
+		return elections[i].Epoch < elections[j].Epoch
+	})
+	dc.elections = elections
+}
+
+// RemoveElection removes the election of the epoch, when the block holding it is reverted
+func (dc *DposContext) RemoveElection(epoch int64) {
+	elections := make([]Election, 0, len(dc.elections))
+	for _, e := range dc.elections {
+		if e.Epoch != epoch {
+			elections = append(elections, e)
+		}
+	}
+	dc.elections = elections
+}
+
+// GetElections returns all elections
+func (dc *DposContext) GetElections() []Election {
+	return dc.elections
+}
+
+// ProducersAt returns the block producers in their order at the timestamp. The
+// latest election before ts applies, elected producers that are no longer active
+// validators are skipped. Without an election all active validators produce
+func (dc *DposContext) ProducersAt(ts int64) []cipher.PubKey {
+	validators := dc.ValidatorsAt(ts)
+
+	var election *Election
+	for i := range dc.elections {
+		if dc.elections[i].Epoch > ts {
+			break
+		}
+		election = &dc.elections[i]
+	}
+	if election == nil {
+		return validators
+	}
+
+	producers := make([]cipher.PubKey, 0, len(election.Producers))
+	for _, pk := range election.Producers {
+		if containsPubKey(validators, pk) {
+			producers = append(producers, pk)
+		}
+	}
+	if len(producers) == 0 {
+		return validators
+	}
+	return producers
+}
+
+// GetValidatorChanges returns all scheduled validator changes
+func (dc *DposContext) GetValidatorChanges() []ValidatorChange {
+	return dc.changes
+}
+
+// ValidatorsAt returns the validators active at the timestamp, which are the
+// configured validators with all validator changes activated before ts applied,
+// excluded validators are left out unless that would leave no validator
+func (dc *DposContext) ValidatorsAt(ts int64) []cipher.PubKey {
+	validators := make([]cipher.PubKey, len(dc.candidate))
+	copy(validators, dc.candidate)
+
+	for _, c := range dc.changes {
+		if c.ActivateAt > ts {
+			break
+		}
+
+		idx := -1
+		for i, pk := range validators {
+			if pk == c.PubKey {
+				idx = i
+				break
+			}
+		}
+
+		switch c.Type {
+		case coin.TxnTypeAddValidator:
+			if idx < 0 {
+				validators = append(validators, c.PubKey)
+			}
+		case coin.TxnTypeRemoveValidator:
+			if idx >= 0 {
+				validators = append(validators[:idx], validators[idx+1:]...)
+			}
+		}
+	}
+
+	if len(dc.exclusions) == 0 {
+		return validators
+	}
+	active := make([]cipher.PubKey, 0, len(validators))
+	for _, pk := range validators {
+		if !dc.isExcluded(pk, ts) {
+			active = append(active, pk)
+		}
+	}
+	if len(active) == 0 {
+		return validators
+	}
+	return active
+}
@@ -35,10 +35,11 @@ func TestSlot(t *testing.T) {
 			ns:  12350,
 		},
 	}
+	iv := DefaultIntervals()
 	for _, cs := range testCases {
-		p := PrevSlot(cs.now)
+		p := iv.PrevSlot(cs.now)
 		assert.Equal(t, cs.ps, p)
-		n := NextSlot(cs.now)
+		n := iv.NextSlot(cs.now)
 		assert.Equal(t, cs.ns, n)
 	}
 }
@@ -95,14 +96,23 @@ func TestCheckValidator(t *testing.T) {
 	ts := uint64(12345678)
 	block := oneBlock(ts)
 	now := int64(12345680)
-	pubkey := cipher.MustPubKeyFromHex("03cec5e9f78524a4283868b79cf3a2b406bcd7956cd9b4be325e070a1cb1881563")
-	dpos := NewDpos(pubkey)
 	pubkeys := []string{"03cec5e9f78524a4283868b79cf3a2b406bcd7956cd9b4be325e070a1cb1881563", "02d15bf28c4ed2c39b35b2be2f8bcde1318e2b3b65fe2a676db39b520bee9bfe86", "02e99a1338841e8b1f192337d2c6157045faa0cfe3b8a02210283aed7f5ad6880d"}
 	trusts := []cipher.PubKey{}
 	for _, pk := range pubkeys {
 		trusts = append(trusts, cipher.MustPubKeyFromHex(pk))
 	}
+
+	// slot 12345690 is the 7689th of the epoch starting at 12268800
+	seed := cipher.SumSHA256([]byte("seed"))
+	order := append([]cipher.PubKey{}, trusts...)
+	Shuffle(order, seed)
+	dpos := NewDpos(order[7689%3])
 	dpos.SetTrustNode(trusts)
+
+	// the order of the epoch is unknown until its first block is executed
+	assert.Equal(t, ErrUnknownEpochSeed, dpos.CheckValidator(block, int64(12345691)))
+	dpos.SetEpochSeed(12268800, seed)
+
 	err := dpos.CheckValidator(block, now)
 	assert.Equal(t, ErrBlockAlreadyCreated, err)
 
@@ -114,7 +124,134 @@ func TestCheckValidator(t *testing.T) {
 	err = dpos.CheckValidator(block, now)
 	assert.NoError(t, err)
 
-	pubkeyValidator, err := dpos.GetValidator(int64(ts))
+	pubkeyValidator, err := dpos.GetValidator(oneBlock(ts-10), int64(ts))
+	assert.NoError(t, err)
+	assert.Equal(t, pubkeyValidator, order[7687%3])
+}
+
+func TestNextEpochExclusion(t *testing.T) {
+	validators := []cipher.PubKey{}
+	for i := 0; i < 3; i++ {
+		pk, _ := cipher.GenerateKeyPair()
+		validators = append(validators, pk)
+	}
+	d := NewDpos(validators[0])
+	assert.NoError(t, d.SetTrustNode(validators))
+
+	ts := 3*DefaultEpochInterval + 100
+	evidence := cipher.SumSHA256([]byte("evidence"))
+	exclusion := d.NextEpochExclusion(evidence, validators[1], ts)
+	assert.Equal(t, evidence, exclusion.Evidence)
+	assert.Equal(t, 4*DefaultEpochInterval, exclusion.From)
+	assert.Equal(t, 5*DefaultEpochInterval, exclusion.To)
+	assert.Equal(t, validators, d.ValidatorsAt(exclusion.From))
+
+	d.AddExclusion(exclusion)
+	d.AddExclusion(d.NextEpochExclusion(evidence, validators[1], ts+1))
+	assert.Len(t, d.dposContext.GetExclusions(), 1)
+
+	assert.Equal(t, validators, d.ValidatorsAt(ts))
+	assert.Equal(t, []cipher.PubKey{validators[0], validators[2]}, d.ValidatorsAt(exclusion.From))
+	assert.Equal(t, []cipher.PubKey{validators[0], validators[2]}, d.ValidatorsAt(exclusion.To-1))
+	assert.Equal(t, validators, d.ValidatorsAt(exclusion.To))
+
+	// a validator set is never emptied by exclusions
+	single := NewDpos(validators[0])
+	assert.NoError(t, single.SetTrustNode(validators[:1]))
+	single.AddExclusion(single.NextEpochExclusion(evidence, validators[0], ts))
+	assert.Equal(t, validators[:1], single.ValidatorsAt(exclusion.From))
+}
+
+func TestRevertState(t *testing.T) {
+	validators := []cipher.PubKey{}
+	for i := 0; i < 3; i++ {
+		pk, _ := cipher.GenerateKeyPair()
+		validators = append(validators, pk)
+	}
+	d := NewDpos(validators[0])
+	assert.NoError(t, d.SetTrustNode(validators[:2]))
+
+	epoch := 3 * DefaultEpochInterval
+	change := ValidatorChange{
+		TxID:       cipher.SumSHA256([]byte("change")),
+		Type:       coin.TxnTypeAddValidator,
+		PubKey:     validators[2],
+		ActivateAt: epoch,
+	}
+	election := Election{Epoch: epoch, Producers: []cipher.PubKey{validators[2]}}
+	evidence := cipher.SumSHA256([]byte("evidence"))
+	exclusion := d.NextEpochExclusion(evidence, validators[1], epoch)
+
+	d.AddValidatorChange(change)
+	d.AddElection(election)
+	d.AddExclusion(exclusion)
+	assert.True(t, d.HasExclusion(evidence))
+	assert.Equal(t, validators, d.ValidatorsAt(epoch))
+	assert.Equal(t, []cipher.PubKey{validators[0], validators[2]}, d.ValidatorsAt(exclusion.From))
+	assert.Equal(t, validators[2:], d.ProducersAt(epoch))
+
+	// the state of reverted blocks is removed
+	d.RemoveElection(epoch)
+	assert.Equal(t, validators, d.ProducersAt(epoch))
+	d.RemoveValidatorChange(change.TxID)
+	assert.Equal(t, validators[:2], d.ValidatorsAt(epoch))
+	d.RemoveExclusion(evidence)
+	assert.False(t, d.HasExclusion(evidence))
+	assert.Equal(t, validators[:2], d.ValidatorsAt(exclusion.From))
+
+	// the executed state is reloaded
+	d.ResetState([]ValidatorChange{change}, []Election{election}, []Exclusion{exclusion})
+	assert.True(t, d.HasExclusion(evidence))
+	assert.Equal(t, validators, d.ValidatorsAt(epoch))
+	assert.Equal(t, validators[2:], d.ProducersAt(epoch))
+	d.ResetState(nil, nil, nil)
+	assert.False(t, d.HasExclusion(evidence))
+	assert.Equal(t, validators[:2], d.ValidatorsAt(epoch))
+}
+
+func TestSchedule(t *testing.T) {
+	validators := []cipher.PubKey{}
+	for i := 0; i < 4; i++ {
+		pk, _ := cipher.GenerateKeyPair()
+		validators = append(validators, pk)
+	}
+	d := NewDpos(validators[0])
+	assert.NoError(t, d.SetIntervals(Intervals{Block: 2, Epoch: 12}))
+	assert.NoError(t, d.SetTrustNode(validators))
+
+	// the head is in the previous epoch, its hash seeds the order
+	parent := oneBlock(23)
+	order := append([]cipher.PubKey{}, validators...)
+	Shuffle(order, parent.HashHeader())
+
+	schedule, err := d.Schedule(parent, 25, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []SlotProducer{
+		{Slot: 24, Producer: order[0]},
+		{Slot: 26, Producer: order[1]},
+		{Slot: 28, Producer: order[2]},
+	}, schedule)
+
+	// the schedule ends with the epoch
+	schedule, err = d.Schedule(parent, 31, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []SlotProducer{
+		{Slot: 30, Producer: order[3]},
+		{Slot: 32, Producer: order[0]},
+		{Slot: 34, Producer: order[1]},
+	}, schedule)
+
+	for _, s := range schedule {
+		v, err := d.GetValidator(parent, s.Slot+1)
+		assert.NoError(t, err)
+		assert.Equal(t, s.Producer, v)
+	}
+
+	// another epoch is ordered by another seed
+	_, err = d.Schedule(oneBlock(37), 41, 1)
+	assert.Equal(t, ErrUnknownEpochSeed, err)
+	d.SetEpochSeed(36, parent.HashHeader())
+	schedule, err = d.Schedule(oneBlock(37), 41, 1)
 	assert.NoError(t, err)
-	assert.Equal(t, pubkeyValidator, trusts[1])
+	assert.Equal(t, []SlotProducer{{Slot: 40, Producer: order[2]}}, schedule)
 }
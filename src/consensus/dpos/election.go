@@ -0,0 +1,71 @@
+package dpos
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/samoslab/samos/src/cipher"
+)
+
+// DefaultProducerNum the number of candidates elected to produce blocks in an epoch
+const DefaultProducerNum = 21
+
+// Election the producers elected for the epoch starting at Epoch
+type Election struct {
+	Epoch     int64
+	Producers []cipher.PubKey
+}
+
+// Tally sums the stake of the votes for each candidate, stake returns the coins
+// of an output and false if it is spent
+func Tally(votes []Vote, stake func(uxid cipher.SHA256) (uint64, bool)) map[cipher.PubKey]uint64 {
+	tally := make(map[cipher.PubKey]uint64)
+	for _, v := range votes {
+		for _, uxid := range v.Outputs {
+			if coins, ok := stake(uxid); ok {
+				tally[v.Candidate] += coins
+			}
+		}
+	}
+	return tally
+}
+
+// Elect returns the n candidates with the most votes, candidates with the same votes
+// are ranked by pubkey. Returns nil if no candidate has votes, all candidates then produce
+func Elect(candidates []cipher.PubKey, tally map[cipher.PubKey]uint64, n int) []cipher.PubKey {
+	voted := false
+	for _, c := range candidates {
+		if tally[c] > 0 {
+			voted = true
+			break
+		}
+	}
+	if !voted || n <= 0 {
+		return nil
+	}
+
+	ranked := make([]cipher.PubKey, len(candidates))
+	copy(ranked, candidates)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		vi, vj := tally[ranked[i]], tally[ranked[j]]
+		if vi != vj {
+			return vi > vj
+		}
+		return bytes.Compare(ranked[i][:], ranked[j][:]) < 0
+	})
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
+
+// Shuffle reorders the pubkeys in place with a Fisher-Yates shuffle driven by the hash chain of seed
+func Shuffle(pubkeys []cipher.PubKey, seed cipher.SHA256) {
+	h := seed
+	for i := len(pubkeys) - 1; i > 0; i-- {
+		h = cipher.SumSHA256(h[:])
+		j := int(binary.BigEndian.Uint64(h[:8]) % uint64(i+1))
+		pubkeys[i], pubkeys[j] = pubkeys[j], pubkeys[i]
+	}
+}
@@ -0,0 +1,122 @@
+package dpos
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/coin"
+)
+
+func sortedPubKeys(pubkeys []cipher.PubKey) []cipher.PubKey {
+	sorted := append([]cipher.PubKey{}, pubkeys...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i][:], sorted[j][:]) < 0
+	})
+	return sorted
+}
+
+func TestTally(t *testing.T) {
+	candidates, _ := makeKeys(2)
+	spent := cipher.SumSHA256([]byte("spent"))
+	stakes := map[cipher.SHA256]uint64{
+		cipher.SumSHA256([]byte("a")): 10,
+		cipher.SumSHA256([]byte("b")): 5,
+		cipher.SumSHA256([]byte("c")): 7,
+	}
+	votes := []Vote{
+		{Candidate: candidates[0], Outputs: []cipher.SHA256{cipher.SumSHA256([]byte("a")), spent}},
+		{Candidate: candidates[1], Outputs: []cipher.SHA256{cipher.SumSHA256([]byte("b"))}},
+		{Candidate: candidates[1], Outputs: []cipher.SHA256{cipher.SumSHA256([]byte("c"))}},
+	}
+
+	tally := Tally(votes, func(uxid cipher.SHA256) (uint64, bool) {
+		coins, ok := stakes[uxid]
+		return coins, ok
+	})
+	require.Equal(t, map[cipher.PubKey]uint64{
+		candidates[0]: 10,
+		candidates[1]: 12,
+	}, tally)
+}
+
+func TestElect(t *testing.T) {
+	candidates, _ := makeKeys(5)
+	require.Nil(t, Elect(candidates, map[cipher.PubKey]uint64{}, 3))
+
+	tally := map[cipher.PubKey]uint64{
+		candidates[0]: 1,
+		candidates[2]: 50,
+		candidates[4]: 20,
+	}
+	producers := Elect(candidates, tally, 3)
+	require.Len(t, producers, 3)
+	require.Equal(t, sortedPubKeys([]cipher.PubKey{candidates[0], candidates[2], candidates[4]}), sortedPubKeys(producers))
+
+	// the election is the same on every node
+	require.Equal(t, producers, Elect(candidates, tally, 3))
+
+	// candidates without votes fill the remaining seats ranked by pubkey
+	producers = Elect(candidates, tally, 4)
+	rest := sortedPubKeys([]cipher.PubKey{candidates[1], candidates[3]})
+	require.Equal(t, sortedPubKeys([]cipher.PubKey{candidates[0], candidates[2], candidates[4], rest[0]}), sortedPubKeys(producers))
+
+	// all candidates are elected when there are fewer than seats
+	require.Len(t, Elect(candidates, tally, 21), 5)
+}
+
+func TestShuffle(t *testing.T) {
+	pubkeys, _ := makeKeys(10)
+	a := append([]cipher.PubKey{}, pubkeys...)
+	b := append([]cipher.PubKey{}, pubkeys...)
+	Shuffle(a, cipher.SumSHA256([]byte("1")))
+	Shuffle(b, cipher.SumSHA256([]byte("1")))
+	require.Equal(t, a, b)
+	require.Equal(t, sortedPubKeys(pubkeys), sortedPubKeys(a))
+
+	Shuffle(b, cipher.SumSHA256([]byte("2")))
+	require.NotEqual(t, a, b)
+}
+
+func TestProducersAt(t *testing.T) {
+	validators, _ := makeKeys(4)
+	iv := Intervals{Block: 1, Epoch: 6}
+	d := NewDpos(validators[0])
+	require.NoError(t, d.SetIntervals(iv))
+	require.NoError(t, d.SetTrustNode(validators))
+
+	// without an election every validator produces
+	require.Equal(t, validators, d.ProducersAt(10))
+
+	elected := []cipher.PubKey{validators[3], validators[1]}
+	d.AddElection(Election{Epoch: 12, Producers: elected})
+	require.Equal(t, validators, d.ProducersAt(11))
+	require.Equal(t, elected, d.ProducersAt(12))
+	// the election is kept until the next one
+	require.Equal(t, elected, d.ProducersAt(30))
+
+	parent := oneBlock(11)
+	order := append([]cipher.PubKey{}, elected...)
+	Shuffle(order, parent.HashHeader())
+	for slot := int64(12); slot < 18; slot++ {
+		v, err := d.GetValidator(parent, slot+1)
+		require.NoError(t, err)
+		require.Equal(t, order[(slot%iv.Epoch)%2], v)
+	}
+
+	// removed validators stop producing
+	d.AddValidatorChange(ValidatorChange{
+		TxID:       cipher.SumSHA256([]byte("remove")),
+		Type:       coin.TxnTypeRemoveValidator,
+		PubKey:     validators[1],
+		ActivateAt: 18,
+	})
+	require.Equal(t, []cipher.PubKey{validators[3]}, d.ProducersAt(18))
+
+	d.AddElection(Election{Epoch: 24, Producers: []cipher.PubKey{validators[2]}})
+	require.Equal(t, []cipher.PubKey{validators[2]}, d.ProducersAt(24))
+	require.Len(t, d.dposContext.GetElections(), 2)
+}
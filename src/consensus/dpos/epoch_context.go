@@ -9,38 +9,40 @@ import (
 // EpochContext epoch for time polling
 type EpochContext struct {
 	DposContext DposContext
-	TimeStamp   int64
+	Intervals   Intervals
+	// Seed shuffles the producers of the epoch
+	Seed      cipher.SHA256
+	TimeStamp int64
 }
 
 // NewEpochFromDposContext new instance
-func NewEpochFromDposContext(dc DposContext, ts int64) *EpochContext {
+func NewEpochFromDposContext(dc DposContext, iv Intervals, seed cipher.SHA256, ts int64) *EpochContext {
 	return &EpochContext{
 		DposContext: dc,
+		Intervals:   iv,
+		Seed:        seed,
 		TimeStamp:   ts,
 	}
 }
 
-func calSolt(now int64) (int64, error) {
-	offset := now % epochInterval
-	if offset%blockInterval != 0 {
-		return 0, ErrInvalidMintBlockTime
-	}
-	offset /= blockInterval
-	return offset, nil
+// Producers returns the producers of the slot in the order of the epoch
+func (ec *EpochContext) Producers(now int64) []cipher.PubKey {
+	producers := ec.DposContext.ProducersAt(now)
+	shuffled := make([]cipher.PubKey, len(producers))
+	copy(shuffled, producers)
+	Shuffle(shuffled, ec.Seed)
+	return shuffled
 }
 
 // LookupValidator lookup a valid validator according to time
 func (ec *EpochContext) LookupValidator(now int64) (validator cipher.PubKey, err error) {
 	validator = cipher.PubKey{}
-	offset, err := calSolt(now)
+	offset, err := ec.Intervals.calSolt(now)
 	if err != nil {
 		return cipher.PubKey{}, err
 	}
 
-	validators, err := ec.DposContext.GetValidators()
-	if err != nil {
-		return cipher.PubKey{}, err
-	}
+	validators := ec.Producers(now)
 	validatorSize := len(validators)
 	if validatorSize == 0 {
 		return cipher.PubKey{}, errors.New("failed to lookup validator")
@@ -38,8 +38,9 @@ func TestCalSlot(t *testing.T) {
 			err:  nil,
 		},
 	}
+	iv := DefaultIntervals()
 	for _, cs := range testCases {
-		slot, err := calSolt(PrevSlot(cs.now))
+		slot, err := iv.calSolt(iv.PrevSlot(cs.now))
 		if err != nil {
 			assert.Equal(t, cs.err, err)
 		}
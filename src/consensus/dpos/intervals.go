@@ -0,0 +1,74 @@
+package dpos
+
+import (
+	"errors"
+)
+
+const (
+	// DefaultBlockInterval seconds of a block slot
+	DefaultBlockInterval = int64(10)
+	// DefaultEpochInterval seconds of an epoch, validator changes take effect at epoch boundaries
+	DefaultEpochInterval = int64(86400)
+)
+
+var (
+	ErrInvalidBlockInterval = errors.New("block interval must be positive")
+	ErrInvalidEpochInterval = errors.New("epoch interval must be a positive multiple of the block interval")
+)
+
+// Intervals the slot and epoch lengths in seconds, they are genesis parameters
+// so all nodes of a chain must use the same values
+type Intervals struct {
+	Block int64
+	Epoch int64
+}
+
+// DefaultIntervals returns the intervals of the main chain
+func DefaultIntervals() Intervals {
+	return Intervals{
+		Block: DefaultBlockInterval,
+		Epoch: DefaultEpochInterval,
+	}
+}
+
+// Verify checks the epoch is made of whole slots
+func (iv Intervals) Verify() error {
+	if iv.Block <= 0 {
+		return ErrInvalidBlockInterval
+	}
+	if iv.Epoch <= 0 || iv.Epoch%iv.Block != 0 {
+		return ErrInvalidEpochInterval
+	}
+	return nil
+}
+
+// PrevSlot previos slot for create block
+func (iv Intervals) PrevSlot(now int64) int64 {
+	return int64((now-1)/iv.Block) * iv.Block
+}
+
+// NextSlot next slot for create block
+func (iv Intervals) NextSlot(now int64) int64 {
+	return int64((now+iv.Block-1)/iv.Block) * iv.Block
+}
+
+// EpochStart returns the start time of the epoch containing ts
+func (iv Intervals) EpochStart(ts int64) int64 {
+	return ts / iv.Epoch * iv.Epoch
+}
+
+// NextEpoch returns the start time of the epoch after the one containing ts,
+// validator changes are activated there
+func (iv Intervals) NextEpoch(ts int64) int64 {
+	return (ts/iv.Epoch + 1) * iv.Epoch
+}
+
+// calSolt returns the index of the slot starting at now within its epoch
+func (iv Intervals) calSolt(now int64) (int64, error) {
+	offset := now % iv.Epoch
+	if offset%iv.Block != 0 {
+		return 0, ErrInvalidMintBlockTime
+	}
+	offset /= iv.Block
+	return offset, nil
+}
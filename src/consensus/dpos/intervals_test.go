@@ -0,0 +1,100 @@
+package dpos
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/coin"
+)
+
+func TestIntervalsVerify(t *testing.T) {
+	require.NoError(t, DefaultIntervals().Verify())
+	require.NoError(t, Intervals{Block: 1, Epoch: 6}.Verify())
+	require.Equal(t, ErrInvalidBlockInterval, Intervals{Block: 0, Epoch: 6}.Verify())
+	require.Equal(t, ErrInvalidEpochInterval, Intervals{Block: 2, Epoch: 0}.Verify())
+	require.Equal(t, ErrInvalidEpochInterval, Intervals{Block: 4, Epoch: 6}.Verify())
+
+	d := NewDpos(cipher.PubKey{})
+	require.Equal(t, ErrInvalidEpochInterval, d.SetIntervals(Intervals{Block: 4, Epoch: 6}))
+	require.Equal(t, DefaultIntervals(), d.Intervals())
+}
+
+func TestShortIntervals(t *testing.T) {
+	iv := Intervals{Block: 2, Epoch: 12}
+	require.Equal(t, int64(10), iv.PrevSlot(11))
+	require.Equal(t, int64(10), iv.PrevSlot(12))
+	require.Equal(t, int64(12), iv.NextSlot(11))
+	require.Equal(t, int64(24), iv.NextEpoch(12))
+	require.Equal(t, int64(24), iv.NextEpoch(23))
+
+	slot, err := iv.calSolt(22)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), slot)
+	_, err = iv.calSolt(23)
+	require.Equal(t, ErrInvalidMintBlockTime, err)
+}
+
+// TestEpochRotation runs every slot of two epochs with a 1 second block interval,
+// each validator mints in its turn of the epoch order and a validator added in
+// the first epoch joins the rotation at the next one
+func TestEpochRotation(t *testing.T) {
+	iv := Intervals{Block: 1, Epoch: 6}
+	validators, _ := makeKeys(3)
+	newPk, _ := cipher.GenerateKeyPair()
+	all := append(validators[:3:3], newPk)
+
+	nodes := make(map[cipher.PubKey]*Dpos)
+	for _, pk := range all {
+		d := NewDpos(pk)
+		require.NoError(t, d.SetIntervals(iv))
+		require.NoError(t, d.SetTrustNode(validators))
+		nodes[pk] = d
+	}
+
+	start := int64(600)
+	change := ValidatorChange{
+		TxID:       cipher.SumSHA256([]byte("add")),
+		Type:       coin.TxnTypeAddValidator,
+		PubKey:     newPk,
+		ActivateAt: nodes[newPk].NextEpoch(start),
+	}
+	for _, d := range nodes {
+		d.AddValidatorChange(change)
+	}
+
+	last := oneBlock(uint64(start - 1))
+	seed := last.HashHeader()
+	for slot := start; slot < start+2*iv.Epoch; slot += iv.Block {
+		now := slot + 1
+		if slot%iv.Epoch == 0 {
+			seed = last.HashHeader()
+		}
+		var producers []cipher.PubKey
+		for pk, d := range nodes {
+			if d.CheckValidator(last, now) == nil {
+				producers = append(producers, pk)
+			}
+		}
+		require.Len(t, producers, 1, "slot %d", slot)
+
+		active := validators
+		if slot >= change.ActivateAt {
+			active = all
+		}
+		order := append([]cipher.PubKey{}, active...)
+		Shuffle(order, seed)
+		expect := order[(slot%iv.Epoch/iv.Block)%int64(len(order))]
+		require.Equal(t, expect, producers[0], "slot %d", slot)
+
+		// executing the first block of an epoch records the seed of the epoch
+		if slot%iv.Epoch == 0 {
+			for _, d := range nodes {
+				d.SetEpochSeed(slot, last.HashHeader())
+			}
+		}
+		last = oneBlock(uint64(now))
+		require.Equal(t, ErrBlockAlreadyCreated, nodes[expect].CheckValidator(last, now))
+	}
+}
@@ -0,0 +1,18 @@
+package dpos
+
+// MaxFaulty returns the number of byzantine validators f that n validators tolerate, n >= 3f+1
+func MaxFaulty(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	return (n - 1) / 3
+}
+
+// Quorum returns the number of validators that must agree so that any two quorums
+// share an honest validator, this is 2f+1 when n = 3f+1
+func Quorum(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	return (n+MaxFaulty(n))/2 + 1
+}
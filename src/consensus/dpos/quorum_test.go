@@ -0,0 +1,35 @@
+package dpos
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuorum(t *testing.T) {
+	cases := []struct {
+		n      int
+		faulty int
+		quorum int
+	}{
+		{0, 0, 0},
+		{1, 0, 1},
+		{2, 0, 2},
+		{3, 0, 2},
+		{4, 1, 3},
+		{5, 1, 4},
+		{6, 1, 4},
+		{7, 2, 5},
+		{10, 3, 7},
+		{21, 6, 14},
+	}
+
+	for _, tc := range cases {
+		require.Equal(t, tc.faulty, MaxFaulty(tc.n), "n=%d", tc.n)
+		require.Equal(t, tc.quorum, Quorum(tc.n), "n=%d", tc.n)
+		if tc.n > 0 {
+			// two quorums overlap in more than f validators
+			require.True(t, 2*tc.quorum-tc.n > tc.faulty, "n=%d", tc.n)
+		}
+	}
+}
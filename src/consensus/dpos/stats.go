@@ -0,0 +1,118 @@
+package dpos
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/coin"
+)
+
+// SlotStats the slots a validator produced and missed in the epoch starting at Epoch
+type SlotStats struct {
+	Epoch     int64
+	Validator cipher.PubKey
+	Produced  uint64
+	Missed    uint64
+}
+
+// Add adds the slots of other to the stats
+func (s *SlotStats) Add(other SlotStats) {
+	s.Produced += other.Produced
+	s.Missed += other.Missed
+}
+
+// Sub subtracts the slots of other from the stats
+func (s *SlotStats) Sub(other SlotStats) {
+	if s.Produced > other.Produced {
+		s.Produced -= other.Produced
+	} else {
+		s.Produced = 0
+	}
+	if s.Missed > other.Missed {
+		s.Missed -= other.Missed
+	} else {
+		s.Missed = 0
+	}
+}
+
+type statsKey struct {
+	epoch     int64
+	validator cipher.PubKey
+}
+
+// CountSlots returns the slots settled by a block made at blockTime on parent: the slot
+// of the block is produced and the slots between the parent's and the block's are missed.
+// The stats are ordered by epoch and validator
+func (d *Dpos) CountSlots(parent *coin.SignedBlock, blockTime int64) ([]SlotStats, error) {
+	if err := d.checkDeadline(parent, blockTime); err != nil {
+		return nil, err
+	}
+	iv := d.Intervals()
+	last := iv.PrevSlot(blockTime)
+	from := iv.PrevSlot(int64(parent.Time())) + iv.Block
+
+	counts := make(map[statsKey]*SlotStats)
+	add := func(epoch int64, validator cipher.PubKey, produced, missed uint64) {
+		k := statsKey{epoch, validator}
+		s, ok := counts[k]
+		if !ok {
+			s = &SlotStats{Epoch: epoch, Validator: validator}
+			counts[k] = s
+		}
+		s.Add(SlotStats{Produced: produced, Missed: missed})
+	}
+
+	// count the missed slots an epoch at a time, the producers take turns in the order of the epoch
+	for start := from; start < last; start = iv.NextEpoch(start) {
+		end := iv.NextEpoch(start) - iv.Block
+		if end > last-iv.Block {
+			end = last - iv.Block
+		}
+
+		epochContext, err := d.epochContext(parent, start)
+		if err != nil {
+			return nil, err
+		}
+		producers := epochContext.Producers(start)
+		n := uint64(len(producers))
+		if n == 0 {
+			return nil, ErrInvalidBlockValidator
+		}
+
+		first, err := iv.calSolt(start)
+		if err != nil {
+			return nil, err
+		}
+		total := uint64((end-start)/iv.Block) + 1
+		for i := uint64(0); i < n && i < total; i++ {
+			missed := total / n
+			if i < total%n {
+				missed++
+			}
+			add(iv.EpochStart(start), producers[(uint64(first)+i)%n], 0, missed)
+		}
+	}
+
+	epochContext, err := d.epochContext(parent, last)
+	if err != nil {
+		return nil, err
+	}
+	producer, err := epochContext.LookupValidator(last)
+	if err != nil {
+		return nil, err
+	}
+	add(iv.EpochStart(last), producer, 1, 0)
+
+	stats := make([]SlotStats, 0, len(counts))
+	for _, s := range counts {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Epoch != stats[j].Epoch {
+			return stats[i].Epoch < stats[j].Epoch
+		}
+		return bytes.Compare(stats[i].Validator[:], stats[j].Validator[:]) < 0
+	})
+	return stats, nil
+}
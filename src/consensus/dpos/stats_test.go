@@ -0,0 +1,78 @@
+package dpos
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/samoslab/samos/src/cipher"
+)
+
+func TestCountSlots(t *testing.T) {
+	validators := []cipher.PubKey{}
+	for i := 0; i < 4; i++ {
+		pk, _ := cipher.GenerateKeyPair()
+		validators = append(validators, pk)
+	}
+	d := NewDpos(validators[0])
+	assert.NoError(t, d.SetIntervals(Intervals{Block: 2, Epoch: 12}))
+	assert.NoError(t, d.SetTrustNode(validators))
+
+	parent := oneBlock(23)
+
+	// count slot by slot
+	expect := func(blockTime int64) map[statsKey]SlotStats {
+		counts := make(map[statsKey]SlotStats)
+		last := d.PrevSlot(blockTime)
+		for slot := d.PrevSlot(23) + 2; slot <= last; slot += 2 {
+			v, err := d.GetValidator(parent, slot+1)
+			assert.NoError(t, err)
+			k := statsKey{d.Intervals().EpochStart(slot), v}
+			s := counts[k]
+			s.Epoch, s.Validator = k.epoch, k.validator
+			if slot == last {
+				s.Produced++
+			} else {
+				s.Missed++
+			}
+			counts[k] = s
+		}
+		return counts
+	}
+
+	cases := []struct {
+		name      string
+		blockTime int64
+	}{
+		{"next slot", 25},
+		{"missed in the epoch", 29},
+		{"missed across epochs", 41},
+		{"missed whole epochs", 75},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			stats, err := d.CountSlots(parent, tc.blockTime)
+			assert.NoError(t, err)
+			assert.NotEmpty(t, stats)
+
+			var produced uint64
+			counts := expect(tc.blockTime)
+			for i, s := range stats {
+				if i > 0 {
+					assert.True(t, stats[i-1].Epoch <= s.Epoch)
+				}
+				assert.Equal(t, counts[statsKey{s.Epoch, s.Validator}], s)
+				produced += s.Produced
+				delete(counts, statsKey{s.Epoch, s.Validator})
+			}
+			assert.Empty(t, counts)
+			assert.Equal(t, uint64(1), produced)
+		})
+	}
+
+	_, err := d.CountSlots(parent, 23)
+	assert.Equal(t, ErrBlockAlreadyCreated, err)
+	_, err = d.CountSlots(parent, 21)
+	assert.Equal(t, ErrMintFutureBlock, err)
+}
@@ -0,0 +1,120 @@
+package dpos
+
+import (
+	"errors"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/coin"
+)
+
+var (
+	ErrNotValidatorChange        = errors.New("not a validator change transaction")
+	ErrValidatorChangeNoSubject  = errors.New("validator change subject not found")
+	ErrValidatorAlreadyExists    = errors.New("validator already exists")
+	ErrValidatorNotExists        = errors.New("validator does not exist")
+	ErrValidatorChangeNoQuorum   = errors.New("validator change is not approved by a quorum of trust nodes")
+	ErrValidatorChangeBadApprove = errors.New("validator change approved by unknown or duplicate trust node")
+)
+
+// ValidatorChange is an executed proposal to add or remove a trust node,
+// the change takes effect at ActivateAt
+type ValidatorChange struct {
+	TxID       cipher.SHA256
+	Type       uint8
+	PubKey     cipher.PubKey
+	ActivateAt int64
+}
+
+// IsValidatorChange returns true if the transaction proposes a validator change
+func IsValidatorChange(txn coin.Transaction) bool {
+	return txn.Type == coin.TxnTypeAddValidator || txn.Type == coin.TxnTypeRemoveValidator
+}
+
+// ValidatorChangeHash returns the hash which trust nodes sign to approve the change of subject
+func ValidatorChangeHash(txn coin.Transaction, subject cipher.PubKey) cipher.SHA256 {
+	b := append([]byte{txn.Type}, subject[:]...)
+	return cipher.AddSHA256(txn.HashTyped(), cipher.SumSHA256(b))
+}
+
+// ValidatorProofHash returns the hash a new validator signs to prove possession of its key
+func ValidatorProofHash(txn coin.Transaction) cipher.SHA256 {
+	return cipher.AddSHA256(txn.HashTyped(), cipher.SumSHA256([]byte{txn.Type}))
+}
+
+// ParseValidatorChange verifies a validator change transaction against the current
+// validators and returns the proposed change.
+// For an addition the first extra signature is the new validator's proof of possession,
+// signed over ValidatorProofHash, and the rest are approvals. For a removal all extra
+// signatures are approvals. Approvals sign ValidatorChangeHash, at least quorum
+// distinct current validators must approve.
+func ParseValidatorChange(txn coin.Transaction, validators []cipher.PubKey, quorum int) (ValidatorChange, error) {
+	if !IsValidatorChange(txn) {
+		return ValidatorChange{}, ErrNotValidatorChange
+	}
+
+	sigs := txn.ExtraSigs()
+	if len(sigs) == 0 {
+		return ValidatorChange{}, ErrValidatorChangeNoSubject
+	}
+
+	var subject cipher.PubKey
+	switch txn.Type {
+	case coin.TxnTypeAddValidator:
+		pk, err := cipher.PubKeyFromSig(sigs[0], ValidatorProofHash(txn))
+		if err != nil {
+			return ValidatorChange{}, ErrValidatorChangeNoSubject
+		}
+		if containsPubKey(validators, pk) {
+			return ValidatorChange{}, ErrValidatorAlreadyExists
+		}
+		subject = pk
+		sigs = sigs[1:]
+	case coin.TxnTypeRemoveValidator:
+		// The subject of a removal is an existing validator, find the one whose
+		// change hash recovers the first approval to a current validator
+		found := false
+		for _, v := range validators {
+			pk, err := cipher.PubKeyFromSig(sigs[0], ValidatorChangeHash(txn, v))
+			if err == nil && containsPubKey(validators, pk) {
+				subject = v
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ValidatorChange{}, ErrValidatorNotExists
+		}
+	}
+
+	hash := ValidatorChangeHash(txn, subject)
+	approved := make(map[cipher.PubKey]struct{}, len(sigs))
+	for _, sig := range sigs {
+		pk, err := cipher.PubKeyFromSig(sig, hash)
+		if err != nil {
+			return ValidatorChange{}, err
+		}
+		if _, ok := approved[pk]; ok || !containsPubKey(validators, pk) {
+			return ValidatorChange{}, ErrValidatorChangeBadApprove
+		}
+		approved[pk] = struct{}{}
+	}
+
+	if quorum <= 0 || len(approved) < quorum {
+		return ValidatorChange{}, ErrValidatorChangeNoQuorum
+	}
+
+	return ValidatorChange{
+		TxID:   txn.Hash(),
+		Type:   txn.Type,
+		PubKey: subject,
+	}, nil
+}
+
+func containsPubKey(pubkeys []cipher.PubKey, pubkey cipher.PubKey) bool {
+	for _, pk := range pubkeys {
+		if pk == pubkey {
+			return true
+		}
+	}
+	return false
+}
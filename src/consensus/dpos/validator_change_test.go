@@ -0,0 +1,168 @@
+package dpos
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/coin"
+)
+
+func makeKeys(n int) ([]cipher.PubKey, []cipher.SecKey) {
+	pubkeys := make([]cipher.PubKey, n)
+	seckeys := make([]cipher.SecKey, n)
+	for i := 0; i < n; i++ {
+		pubkeys[i], seckeys[i] = cipher.GenerateKeyPair()
+	}
+	return pubkeys, seckeys
+}
+
+// makeValidatorChangeTxn returns the transaction with the signer of its input,
+// the extra signatures are pushed before it is signed
+func makeValidatorChangeTxn(t *testing.T, txnType uint8) (coin.Transaction, func(coin.Transaction) coin.Transaction) {
+	ux := coin.UxOut{
+		Body: coin.UxBody{
+			SrcTransaction: cipher.SumSHA256([]byte("src")),
+			Coins:          10e6,
+			Hours:          100,
+		},
+	}
+	p, s := cipher.GenerateKeyPair()
+	ux.Body.Address = cipher.AddressFromPubKey(p)
+
+	txn := coin.Transaction{Type: txnType}
+	txn.PushInput(ux.Hash())
+	txn.PushOutput(ux.Body.Address, 10e6, 50)
+	return txn, func(txn coin.Transaction) coin.Transaction {
+		txn.SignInputs([]cipher.SecKey{s})
+		txn.UpdateHeader()
+		return txn
+	}
+}
+
+func TestParseValidatorChangeAdd(t *testing.T) {
+	validators, seckeys := makeKeys(4)
+	newPk, newSk := cipher.GenerateKeyPair()
+
+	txn, sign := makeValidatorChangeTxn(t, coin.TxnTypeAddValidator)
+
+	// Without the proof of possession of the new key
+	_, err := ParseValidatorChange(sign(txn), validators, 3)
+	require.Equal(t, ErrValidatorChangeNoSubject, err)
+
+	txn.PushExtraSig(cipher.SignHash(ValidatorProofHash(txn), newSk))
+	hash := ValidatorChangeHash(txn, newPk)
+
+	// Not enough approvals
+	txn.PushExtraSig(cipher.SignHash(hash, seckeys[0]))
+	txn.PushExtraSig(cipher.SignHash(hash, seckeys[1]))
+	signed := sign(txn)
+	require.NoError(t, signed.Verify())
+	_, err = ParseValidatorChange(signed, validators, 3)
+	require.Equal(t, ErrValidatorChangeNoQuorum, err)
+
+	// Duplicate approval
+	dup := txn
+	dup.Sigs = append([]cipher.Sig{}, txn.Sigs...)
+	dup.PushExtraSig(cipher.SignHash(hash, seckeys[1]))
+	_, err = ParseValidatorChange(sign(dup), validators, 3)
+	require.Equal(t, ErrValidatorChangeBadApprove, err)
+
+	// Approval by an unknown key
+	_, sk := cipher.GenerateKeyPair()
+	unknown := txn
+	unknown.Sigs = append([]cipher.Sig{}, txn.Sigs...)
+	unknown.PushExtraSig(cipher.SignHash(hash, sk))
+	_, err = ParseValidatorChange(sign(unknown), validators, 3)
+	require.Equal(t, ErrValidatorChangeBadApprove, err)
+
+	txn.PushExtraSig(cipher.SignHash(hash, seckeys[2]))
+	txn = sign(txn)
+	require.NoError(t, txn.Verify())
+	change, err := ParseValidatorChange(txn, validators, 3)
+	require.NoError(t, err)
+	require.Equal(t, newPk, change.PubKey)
+	require.Equal(t, coin.TxnTypeAddValidator, change.Type)
+	require.Equal(t, txn.Hash(), change.TxID)
+
+	// Adding an existing validator is rejected
+	_, err = ParseValidatorChange(txn, append(validators, newPk), 3)
+	require.Equal(t, ErrValidatorAlreadyExists, err)
+}
+
+func TestParseValidatorChangeRemove(t *testing.T) {
+	validators, seckeys := makeKeys(4)
+
+	txn, sign := makeValidatorChangeTxn(t, coin.TxnTypeRemoveValidator)
+	hash := ValidatorChangeHash(txn, validators[3])
+	for _, sk := range seckeys[:3] {
+		txn.PushExtraSig(cipher.SignHash(hash, sk))
+	}
+	txn = sign(txn)
+	require.NoError(t, txn.Verify())
+
+	change, err := ParseValidatorChange(txn, validators, 3)
+	require.NoError(t, err)
+	require.Equal(t, validators[3], change.PubKey)
+	require.Equal(t, coin.TxnTypeRemoveValidator, change.Type)
+
+	// The subject must be a current validator
+	_, err = ParseValidatorChange(txn, validators[:3], 3)
+	require.Equal(t, ErrValidatorNotExists, err)
+
+	normal, sign := makeValidatorChangeTxn(t, coin.TxnTypeNormal)
+	_, err = ParseValidatorChange(sign(normal), validators, 3)
+	require.Equal(t, ErrNotValidatorChange, err)
+}
+
+func TestValidatorsAt(t *testing.T) {
+	validators, _ := makeKeys(3)
+	newPk, _ := cipher.GenerateKeyPair()
+
+	d := NewDpos(validators[0])
+	d.SetTrustNode(validators)
+
+	now := int64(86400*10 + 50)
+	activateAt := d.NextEpoch(now)
+	require.Equal(t, int64(86400*11), activateAt)
+
+	d.AddValidatorChange(ValidatorChange{
+		TxID:       cipher.SumSHA256([]byte("add")),
+		Type:       coin.TxnTypeAddValidator,
+		PubKey:     newPk,
+		ActivateAt: activateAt,
+	})
+	d.AddValidatorChange(ValidatorChange{
+		TxID:       cipher.SumSHA256([]byte("remove")),
+		Type:       coin.TxnTypeRemoveValidator,
+		PubKey:     validators[1],
+		ActivateAt: activateAt + DefaultEpochInterval,
+	})
+	// Known changes are ignored
+	d.AddValidatorChange(ValidatorChange{
+		TxID:       cipher.SumSHA256([]byte("add")),
+		Type:       coin.TxnTypeAddValidator,
+		PubKey:     newPk,
+		ActivateAt: activateAt,
+	})
+
+	require.Equal(t, validators, d.ValidatorsAt(now))
+	require.Equal(t, validators, d.ValidatorsAt(activateAt-1))
+	require.Equal(t, append(validators[:3:3], newPk), d.ValidatorsAt(activateAt))
+	require.Equal(t, []cipher.PubKey{validators[0], validators[2], newPk}, d.ValidatorsAt(activateAt+DefaultEpochInterval))
+
+	// The configured validators are not modified
+	require.Len(t, validators, 3)
+
+	// The new validator is scheduled in the following epoch
+	parent := oneBlock(uint64(activateAt - 5))
+	order := d.ValidatorsAt(activateAt)
+	Shuffle(order, parent.HashHeader())
+	for i, pk := range order {
+		v, err := d.GetValidator(parent, activateAt+int64(i)*DefaultBlockInterval+1)
+		require.NoError(t, err)
+		require.Equal(t, pk, v)
+	}
+	require.Contains(t, order, newPk)
+}
@@ -0,0 +1,92 @@
+package dpos
+
+import (
+	"errors"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/coin"
+)
+
+var (
+	ErrNotVote         = errors.New("not a vote transaction")
+	ErrVoteNoCandidate = errors.New("vote is not signed for a candidate trust node by the owner of an output")
+)
+
+// Vote is an executed vote of a coin holder for a candidate trust key. The vote
+// weighs the coins of Outputs, which the transaction sent back to the voter, for
+// as long as they stay unspent
+type Vote struct {
+	TxID      cipher.SHA256
+	Candidate cipher.PubKey
+	Voter     cipher.Address
+	Outputs   []cipher.SHA256
+}
+
+// IsVote returns true if the transaction is a vote
+func IsVote(txn coin.Transaction) bool {
+	return txn.Type == coin.TxnTypeVote
+}
+
+// VoteHash returns the hash the voter signs to vote for the candidate
+func VoteHash(txn coin.Transaction, candidate cipher.PubKey) cipher.SHA256 {
+	b := append([]byte{coin.TxnTypeVote}, candidate[:]...)
+	return cipher.AddSHA256(txn.HashTyped(), cipher.SumSHA256(b))
+}
+
+// PushVote turns the transaction into a vote of the owner of voter for the candidate,
+// the outputs of the transaction to the voter's address are the stake of the vote.
+// The inputs are signed after
+func PushVote(txn *coin.Transaction, candidate cipher.PubKey, voter cipher.SecKey) {
+	txn.Type = coin.TxnTypeVote
+	txn.PushExtraSig(cipher.SignHash(VoteHash(*txn, candidate), voter))
+}
+
+// ParseVote verifies a vote transaction against the candidates and returns the vote.
+// The single extra signature signs VoteHash of the candidate, the candidate is the
+// one whose hash recovers a pubkey owning an output of the transaction, a vote
+// without outputs to the voter has no stake and is rejected
+func ParseVote(txn coin.Transaction, candidates []cipher.PubKey) (Vote, error) {
+	if !IsVote(txn) {
+		return Vote{}, ErrNotVote
+	}
+
+	sigs := txn.ExtraSigs()
+	if len(sigs) != 1 {
+		return Vote{}, ErrVoteNoCandidate
+	}
+
+	txid := txn.Hash()
+	for _, c := range candidates {
+		pk, err := cipher.PubKeyFromSig(sigs[0], VoteHash(txn, c))
+		if err != nil {
+			continue
+		}
+		voter := cipher.AddressFromPubKey(pk)
+
+		var outputs []cipher.SHA256
+		for _, o := range txn.Out {
+			if o.Address != voter {
+				continue
+			}
+			body := coin.UxBody{
+				SrcTransaction: txid,
+				Address:        o.Address,
+				Coins:          o.Coins,
+				Hours:          o.Hours,
+			}
+			outputs = append(outputs, body.Hash())
+		}
+		if len(outputs) == 0 {
+			continue
+		}
+
+		return Vote{
+			TxID:      txid,
+			Candidate: c,
+			Voter:     voter,
+			Outputs:   outputs,
+		}, nil
+	}
+
+	return Vote{}, ErrVoteNoCandidate
+}
@@ -0,0 +1,55 @@
+package dpos
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/coin"
+)
+
+func makeVoteTxn(t *testing.T, voter, signer cipher.SecKey, candidate cipher.PubKey) coin.Transaction {
+	txn, sign := makeValidatorChangeTxn(t, coin.TxnTypeNormal)
+	txn.Out = nil
+	txn.PushOutput(cipher.AddressFromSecKey(voter), 7e6, 30)
+	other, _ := cipher.GenerateKeyPair()
+	txn.PushOutput(cipher.AddressFromPubKey(other), 3e6, 20)
+	PushVote(&txn, candidate, signer)
+	return sign(txn)
+}
+
+func TestParseVote(t *testing.T) {
+	candidates, _ := makeKeys(3)
+	_, voter := cipher.GenerateKeyPair()
+
+	txn := makeVoteTxn(t, voter, voter, candidates[1])
+	require.Equal(t, coin.TxnTypeVote, txn.Type)
+	require.NoError(t, txn.Verify())
+
+	vote, err := ParseVote(txn, candidates)
+	require.NoError(t, err)
+	require.Equal(t, txn.Hash(), vote.TxID)
+	require.Equal(t, candidates[1], vote.Candidate)
+	require.Equal(t, cipher.AddressFromSecKey(voter), vote.Voter)
+
+	// only the output to the voter is staked, and it is the unspent the block creates
+	b := coin.BlockHeader{BkSeq: 1}
+	ux, err := coin.CreateUnspent(b, txn, 0)
+	require.NoError(t, err)
+	require.Equal(t, []cipher.SHA256{ux.Hash()}, vote.Outputs)
+
+	// the candidate must be a trust node
+	_, err = ParseVote(txn, []cipher.PubKey{candidates[0], candidates[2]})
+	require.Equal(t, ErrVoteNoCandidate, err)
+
+	// a vote without coins to the voter has no stake
+	_, stranger := cipher.GenerateKeyPair()
+	txn = makeVoteTxn(t, voter, stranger, candidates[0])
+	_, err = ParseVote(txn, candidates)
+	require.Equal(t, ErrVoteNoCandidate, err)
+
+	normal, sign := makeValidatorChangeTxn(t, coin.TxnTypeNormal)
+	_, err = ParseVote(sign(normal), candidates)
+	require.Equal(t, ErrNotVote, err)
+}
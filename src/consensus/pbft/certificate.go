@@ -0,0 +1,81 @@
+package pbft
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/samoslab/samos/src/cipher"
+)
+
+var (
+	// ErrDuplicateCommit the certificate has two commits of the same validator
+	ErrDuplicateCommit = errors.New("duplicate commit in certificate")
+)
+
+// CommitSig the signature of a validator committing a block in a view, commits
+// carried over a view change keep the view they were signed in
+type CommitSig struct {
+	View uint64
+	Sig  cipher.Sig
+}
+
+// Certificate the commits that made a block final
+type Certificate struct {
+	Hash    cipher.SHA256
+	Commits []CommitSig
+}
+
+// Signers recovers the validators that committed the block
+func (c Certificate) Signers() ([]cipher.PubKey, error) {
+	signers := make([]cipher.PubKey, 0, len(c.Commits))
+	seen := make(map[cipher.PubKey]struct{}, len(c.Commits))
+	for _, cs := range c.Commits {
+		pk, err := cipher.PubKeyFromSig(cs.Sig, CommitHash(c.Hash, cs.View))
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[pk]; ok {
+			return nil, ErrDuplicateCommit
+		}
+		seen[pk] = struct{}{}
+		signers = append(signers, pk)
+	}
+	return signers, nil
+}
+
+// Verify checks at least quorum of the validators committed the block
+func (c Certificate) Verify(validators []cipher.PubKey, quorum int) error {
+	signers, err := c.Signers()
+	if err != nil {
+		return err
+	}
+
+	valid := make(map[cipher.PubKey]struct{}, len(validators))
+	for _, v := range validators {
+		valid[v] = struct{}{}
+	}
+	for _, pk := range signers {
+		if _, ok := valid[pk]; !ok {
+			return fmt.Errorf("commit is signed by %s, which is not a validator", pk.Hex())
+		}
+	}
+
+	if quorum <= 0 || len(signers) < quorum {
+		return fmt.Errorf("certificate has %d commits, %d are required", len(signers), quorum)
+	}
+	return nil
+}
+
+// GetCertificate returns the commits collected for the block hash
+func (p *PBFT) GetCertificate(hash cipher.SHA256) (Certificate, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	commits, ok := p.CommitSigs[hash]
+	if !ok {
+		return Certificate{}, errors.New("the hash not exists")
+	}
+	return Certificate{
+		Hash:    hash,
+		Commits: append([]CommitSig{}, commits...),
+	}, nil
+}
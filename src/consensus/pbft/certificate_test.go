@@ -0,0 +1,52 @@
+package pbft
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/util/utc"
+)
+
+func TestCertificate(t *testing.T) {
+	p := NewPBFT()
+	sb := makePendingBlock(1, uint64(utc.UnixNow()))
+	hash := sb.HashHeader()
+
+	_, err := p.GetCertificate(hash)
+	require.Error(t, err)
+	require.NoError(t, p.AddSignedBlock(sb))
+
+	validators := []cipher.PubKey{}
+	var commits []CommitSig
+	for i := 0; i < 4; i++ {
+		pk, sk := cipher.GenerateKeyPair()
+		validators = append(validators, pk)
+		if i == 3 {
+			break
+		}
+		cs := CommitSig{View: uint64(i % 2), Sig: cipher.SignHash(CommitHash(hash, uint64(i%2)), sk)}
+		commits = append(commits, cs)
+		require.NoError(t, p.AddCommit(hash, pk, cs))
+	}
+
+	cert, err := p.GetCertificate(hash)
+	require.NoError(t, err)
+	require.Equal(t, Certificate{Hash: hash, Commits: commits}, cert)
+
+	signers, err := cert.Signers()
+	require.NoError(t, err)
+	require.Equal(t, validators[:3], signers)
+
+	require.NoError(t, cert.Verify(validators, 3))
+	require.EqualError(t, cert.Verify(validators, 4), "certificate has 3 commits, 4 are required")
+	require.Error(t, cert.Verify(validators[1:], 2))
+
+	// a commit signed for another view recovers another key
+	forged := Certificate{Hash: hash, Commits: []CommitSig{{View: 1, Sig: commits[0].Sig}}}
+	require.Error(t, forged.Verify(validators, 1))
+
+	dup := Certificate{Hash: hash, Commits: []CommitSig{commits[0], commits[0]}}
+	require.Equal(t, ErrDuplicateCommit, dup.Verify(validators, 2))
+}
@@ -0,0 +1,255 @@
+package pbft
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/cipher/encoder"
+	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/consensus/dpos"
+)
+
+const (
+	// EvidenceSlot the producer signed two blocks in the same slot
+	EvidenceSlot = uint8(0x01)
+	// EvidenceSeq the producer signed a block conflicting with an executed block of the same
+	// seq and slot, a producer proposing the seq again in a later slot after a view change is honest
+	EvidenceSeq = uint8(0x02)
+	// EvidencePrepare the validator prepared two blocks of the same seq in the same view,
+	// prepare signatures do not cover the view so only the node that saw both can tell
+	EvidencePrepare = uint8(0x03)
+)
+
+var (
+	// ErrInvalidEvidence the evidence does not prove double signing
+	ErrInvalidEvidence = errors.New("invalid evidence")
+	// ErrNotEvidence the transaction does not commit evidence
+	ErrNotEvidence = errors.New("not an evidence transaction")
+)
+
+// Evidence two conflicting signatures of a trust key
+type Evidence struct {
+	Type    uint8
+	PubKey  cipher.PubKey
+	View    uint64
+	HeaderA coin.BlockHeader
+	SigA    cipher.Sig
+	HeaderB coin.BlockHeader
+	SigB    cipher.Sig
+}
+
+// NewEvidence creates evidence of the pubkey signing both headers, the
+// headers are ordered by hash so the same pair always makes the same evidence
+func NewEvidence(typ uint8, pubkey cipher.PubKey, view uint64, a coin.BlockHeader, sigA cipher.Sig, b coin.BlockHeader, sigB cipher.Sig) Evidence {
+	ha, hb := a.Hash(), b.Hash()
+	if bytes.Compare(ha[:], hb[:]) > 0 {
+		a, b = b, a
+		sigA, sigB = sigB, sigA
+	}
+	return Evidence{
+		Type:    typ,
+		PubKey:  pubkey,
+		View:    view,
+		HeaderA: a,
+		SigA:    sigA,
+		HeaderB: b,
+		SigB:    sigB,
+	}
+}
+
+// Hash returns the hash identifying the evidence
+func (e Evidence) Hash() cipher.SHA256 {
+	return cipher.SumSHA256(encoder.Serialize(e))
+}
+
+// Time returns the time of the latest conflicting block
+func (e Evidence) Time() int64 {
+	if e.HeaderA.Time > e.HeaderB.Time {
+		return int64(e.HeaderA.Time)
+	}
+	return int64(e.HeaderB.Time)
+}
+
+// Verify checks both signatures are made by PubKey for different blocks that conflict,
+// the slots of the blocks are derived from the chain intervals
+func (e Evidence) Verify(iv dpos.Intervals) error {
+	ha, hb := e.HeaderA.Hash(), e.HeaderB.Hash()
+	if ha == hb {
+		return ErrInvalidEvidence
+	}
+
+	sameSlot := iv.PrevSlot(int64(e.HeaderA.Time)) == iv.PrevSlot(int64(e.HeaderB.Time))
+	sameSeq := e.HeaderA.BkSeq == e.HeaderB.BkSeq
+	switch e.Type {
+	case EvidenceSlot:
+		if !sameSlot {
+			return ErrInvalidEvidence
+		}
+	case EvidenceSeq:
+		if !sameSeq || !sameSlot {
+			return ErrInvalidEvidence
+		}
+	case EvidencePrepare:
+		if !sameSeq {
+			return ErrInvalidEvidence
+		}
+	default:
+		return ErrInvalidEvidence
+	}
+
+	if err := cipher.VerifySignature(e.PubKey, e.SigA, ha); err != nil {
+		return err
+	}
+	return cipher.VerifySignature(e.PubKey, e.SigB, hb)
+}
+
+// evidenceBody is the evidence carried by a transaction besides the two signatures,
+// the pubkey is recovered from them
+type evidenceBody struct {
+	Type    uint8
+	View    uint64
+	HeaderA coin.BlockHeader
+	HeaderB coin.BlockHeader
+}
+
+var evidenceBodySize = len(encoder.Serialize(evidenceBody{}))
+
+// IsEvidence returns true if the transaction commits double signing evidence
+func IsEvidence(txn coin.Transaction) bool {
+	return txn.Type == coin.TxnTypeEvidence
+}
+
+// PushEvidence turns the transaction into a commitment of the evidence, the inputs are signed
+// after. The extra signatures are the two conflicting signatures followed by the rest of the
+// evidence, packed in signature sized chunks. Prepare evidence can not be verified by others
+func PushEvidence(txn *coin.Transaction, e Evidence) error {
+	if e.Type == EvidencePrepare {
+		return ErrInvalidEvidence
+	}
+
+	txn.Type = coin.TxnTypeEvidence
+	txn.PushExtraSig(e.SigA)
+	txn.PushExtraSig(e.SigB)
+	b := encoder.Serialize(evidenceBody{
+		Type:    e.Type,
+		View:    e.View,
+		HeaderA: e.HeaderA,
+		HeaderB: e.HeaderB,
+	})
+	for len(b) > 0 {
+		var chunk cipher.Sig
+		n := copy(chunk[:], b)
+		b = b[n:]
+		txn.PushExtraSig(chunk)
+	}
+	return nil
+}
+
+// ParseEvidence returns the evidence committed by the transaction, verified against the chain intervals
+func ParseEvidence(txn coin.Transaction, iv dpos.Intervals) (Evidence, error) {
+	if !IsEvidence(txn) {
+		return Evidence{}, ErrNotEvidence
+	}
+
+	sigs := txn.ExtraSigs()
+	chunks := (evidenceBodySize + len(cipher.Sig{}) - 1) / len(cipher.Sig{})
+	if len(sigs) != 2+chunks {
+		return Evidence{}, ErrInvalidEvidence
+	}
+
+	b := make([]byte, 0, chunks*len(cipher.Sig{}))
+	for _, sig := range sigs[2:] {
+		b = append(b, sig[:]...)
+	}
+	// the padding of the last chunk is zero so the transaction is not malleable
+	for _, c := range b[evidenceBodySize:] {
+		if c != 0 {
+			return Evidence{}, ErrInvalidEvidence
+		}
+	}
+
+	var body evidenceBody
+	if err := encoder.DeserializeRaw(b[:evidenceBodySize], &body); err != nil {
+		return Evidence{}, ErrInvalidEvidence
+	}
+	if body.Type == EvidencePrepare {
+		return Evidence{}, ErrInvalidEvidence
+	}
+
+	pubkey, err := cipher.PubKeyFromSig(sigs[0], body.HeaderA.Hash())
+	if err != nil {
+		return Evidence{}, ErrInvalidEvidence
+	}
+	e := NewEvidence(body.Type, pubkey, body.View, body.HeaderA, sigs[0], body.HeaderB, sigs[1])
+	if err := e.Verify(iv); err != nil {
+		return Evidence{}, err
+	}
+	return e, nil
+}
+
+type prepareKey struct {
+	seq    uint64
+	view   uint64
+	pubkey cipher.PubKey
+}
+
+type prepareVote struct {
+	header coin.BlockHeader
+	sig    cipher.Sig
+}
+
+// FindConflict returns evidence if a pending block was signed by the producer of sb in the same slot
+func (p *PBFT) FindConflict(sb coin.SignedBlock, iv dpos.Intervals) (*Evidence, error) {
+	bh := sb.HashHeader()
+	producer, err := cipher.PubKeyFromSig(sb.Sig, bh)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for hash, pending := range p.PendingBlocks {
+		if hash == bh || iv.PrevSlot(int64(pending.Time())) != iv.PrevSlot(int64(sb.Time())) {
+			continue
+		}
+		pk, err := cipher.PubKeyFromSig(pending.Sig, hash)
+		if err != nil || pk != producer {
+			continue
+		}
+		e := NewEvidence(EvidenceSlot, producer, 0, pending.Block.Head, pending.Sig, sb.Block.Head, sb.Sig)
+		return &e, nil
+	}
+	return nil, nil
+}
+
+// RecordPrepare records the prepare signature of pubkey for the pending block hash,
+// returns evidence if pubkey prepared another block of the same seq in the same view
+func (p *PBFT) RecordPrepare(hash cipher.SHA256, pubkey cipher.PubKey, sig cipher.Sig) (*Evidence, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	sb, ok := p.PendingBlocks[hash]
+	if !ok {
+		return nil, errors.New("the hash not exists")
+	}
+
+	key := prepareKey{
+		seq:    sb.Seq(),
+		view:   p.BlockView[hash],
+		pubkey: pubkey,
+	}
+	prev, ok := p.prepares[key]
+	if !ok {
+		p.prepares[key] = prepareVote{
+			header: sb.Block.Head,
+			sig:    sig,
+		}
+		return nil, nil
+	}
+	if prev.header.Hash() == hash {
+		return nil, nil
+	}
+
+	e := NewEvidence(EvidencePrepare, pubkey, key.view, prev.header, prev.sig, sb.Block.Head, sig)
+	return &e, nil
+}
@@ -0,0 +1,173 @@
+package pbft
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/consensus/dpos"
+	"github.com/samoslab/samos/src/util/utc"
+)
+
+func signBlock(seq, when uint64, sk cipher.SecKey) coin.SignedBlock {
+	b := coin.Block{Head: coin.BlockHeader{BkSeq: seq, Time: when}}
+	return coin.SignedBlock{Block: b, Sig: cipher.SignHash(b.HashHeader(), sk)}
+}
+
+func TestEvidenceVerify(t *testing.T) {
+	pk, sk := cipher.GenerateKeyPair()
+	_, sk2 := cipher.GenerateKeyPair()
+	a := signBlock(5, 1001, sk)
+	b := signBlock(6, 1005, sk)
+
+	e := NewEvidence(EvidenceSlot, pk, 0, a.Block.Head, a.Sig, b.Block.Head, b.Sig)
+	require.NoError(t, e.Verify(dpos.DefaultIntervals()))
+	require.Equal(t, int64(1005), e.Time())
+
+	// the same pair makes the same evidence
+	require.Equal(t, e, NewEvidence(EvidenceSlot, pk, 0, b.Block.Head, b.Sig, a.Block.Head, a.Sig))
+
+	// different seq in the same slot is not seq evidence
+	e.Type = EvidenceSeq
+	require.Equal(t, ErrInvalidEvidence, e.Verify(dpos.DefaultIntervals()))
+
+	// blocks in different slots
+	c := signBlock(6, 1011, sk)
+	e = NewEvidence(EvidenceSlot, pk, 0, a.Block.Head, a.Sig, c.Block.Head, c.Sig)
+	require.Equal(t, ErrInvalidEvidence, e.Verify(dpos.DefaultIntervals()))
+
+	// the same seq in the same slot
+	f := signBlock(5, 1003, sk)
+	e = NewEvidence(EvidenceSeq, pk, 0, a.Block.Head, a.Sig, f.Block.Head, f.Sig)
+	require.NoError(t, e.Verify(dpos.DefaultIntervals()))
+
+	// the same seq proposed again in a later slot after a view change
+	g := signBlock(5, 1021, sk)
+	e = NewEvidence(EvidenceSeq, pk, 0, a.Block.Head, a.Sig, g.Block.Head, g.Sig)
+	require.Equal(t, ErrInvalidEvidence, e.Verify(dpos.DefaultIntervals()))
+
+	// signed by someone else
+	d := signBlock(5, 1003, sk2)
+	e = NewEvidence(EvidenceSeq, pk, 0, a.Block.Head, a.Sig, d.Block.Head, d.Sig)
+	require.Error(t, e.Verify(dpos.DefaultIntervals()))
+
+	// the same block twice
+	e = NewEvidence(EvidenceSeq, pk, 0, a.Block.Head, a.Sig, a.Block.Head, a.Sig)
+	require.Equal(t, ErrInvalidEvidence, e.Verify(dpos.DefaultIntervals()))
+}
+
+func TestPushEvidence(t *testing.T) {
+	pk, sk := cipher.GenerateKeyPair()
+	a := signBlock(5, 1001, sk)
+	b := signBlock(5, 1005, sk)
+	e := NewEvidence(EvidenceSeq, pk, 0, a.Block.Head, a.Sig, b.Block.Head, b.Sig)
+
+	makeTxn := func() coin.Transaction {
+		txn := coin.Transaction{}
+		txn.PushInput(cipher.SumSHA256([]byte("in")))
+		txn.PushOutput(cipher.AddressFromPubKey(pk), 1e6, 10)
+		return txn
+	}
+	sign := func(txn *coin.Transaction) {
+		txn.SignInputs([]cipher.SecKey{sk})
+		txn.UpdateHeader()
+	}
+
+	txn := makeTxn()
+	sign(&txn)
+	_, err := ParseEvidence(txn, dpos.DefaultIntervals())
+	require.Equal(t, ErrNotEvidence, err)
+
+	txn = makeTxn()
+	require.NoError(t, PushEvidence(&txn, e))
+	sign(&txn)
+	require.NoError(t, txn.Verify())
+	require.True(t, IsEvidence(txn))
+	parsed, err := ParseEvidence(txn, dpos.DefaultIntervals())
+	require.NoError(t, err)
+	require.Equal(t, e, parsed)
+
+	// the padding of the last chunk must be zero
+	padded := makeTxn()
+	require.NoError(t, PushEvidence(&padded, e))
+	padded.Sigs[len(padded.Sigs)-1][len(cipher.Sig{})-1] = 1
+	sign(&padded)
+	_, err = ParseEvidence(padded, dpos.DefaultIntervals())
+	require.Equal(t, ErrInvalidEvidence, err)
+
+	// blocks in different slots
+	c := signBlock(5, 1021, sk)
+	invalid := makeTxn()
+	require.NoError(t, PushEvidence(&invalid, NewEvidence(EvidenceSeq, pk, 0, a.Block.Head, a.Sig, c.Block.Head, c.Sig)))
+	sign(&invalid)
+	_, err = ParseEvidence(invalid, dpos.DefaultIntervals())
+	require.Equal(t, ErrInvalidEvidence, err)
+
+	// prepare evidence is only known to the node that saw both prepares
+	prepare := makeTxn()
+	require.Equal(t, ErrInvalidEvidence, PushEvidence(&prepare, NewEvidence(EvidencePrepare, pk, 1, a.Block.Head, a.Sig, b.Block.Head, b.Sig)))
+}
+
+func TestFindConflict(t *testing.T) {
+	// now and now+1 must fall in the same slot
+	now := uint64(dpos.DefaultIntervals().NextSlot(utc.UnixNow()) + 1)
+	pk, sk := cipher.GenerateKeyPair()
+	_, sk2 := cipher.GenerateKeyPair()
+	p := NewPBFT()
+	a := signBlock(1, now, sk)
+	require.NoError(t, p.AddSignedBlock(a))
+
+	e, err := p.FindConflict(a, dpos.DefaultIntervals())
+	require.NoError(t, err)
+	require.Nil(t, e)
+
+	// another producer
+	e, err = p.FindConflict(signBlock(1, now, sk2), dpos.DefaultIntervals())
+	require.NoError(t, err)
+	require.Nil(t, e)
+
+	b := signBlock(1, now+1, sk)
+	e, err = p.FindConflict(b, dpos.DefaultIntervals())
+	require.NoError(t, err)
+	require.NotNil(t, e)
+	require.Equal(t, EvidenceSlot, e.Type)
+	require.Equal(t, pk, e.PubKey)
+	require.NoError(t, e.Verify(dpos.DefaultIntervals()))
+}
+
+func TestRecordPrepare(t *testing.T) {
+	now := utc.UnixNow()
+	pk, sk := cipher.GenerateKeyPair()
+	p := NewPBFT()
+
+	a := signBlock(1, uint64(now), sk)
+	require.NoError(t, p.AddSignedBlock(a))
+	e, err := p.RecordPrepare(a.HashHeader(), pk, cipher.SignHash(a.HashHeader(), sk))
+	require.NoError(t, err)
+	require.Nil(t, e)
+	e, err = p.RecordPrepare(a.HashHeader(), pk, cipher.SignHash(a.HashHeader(), sk))
+	require.NoError(t, err)
+	require.Nil(t, e)
+
+	// the block expired and another block of the same seq is proposed in the same view
+	require.NoError(t, p.DeleteHash(a.HashHeader()))
+	b := signBlock(1, uint64(now+1), sk)
+	require.NoError(t, p.AddSignedBlock(b))
+	e, err = p.RecordPrepare(b.HashHeader(), pk, cipher.SignHash(b.HashHeader(), sk))
+	require.NoError(t, err)
+	require.NotNil(t, e)
+	require.Equal(t, EvidencePrepare, e.Type)
+	require.NoError(t, e.Verify(dpos.DefaultIntervals()))
+
+	// preparing a block of the same seq in a new view is allowed
+	_, err = p.AddViewChange(NewViewChange(1, 1, cipher.SHA256{}, sk), pk)
+	require.NoError(t, err)
+	require.NoError(t, p.ChangeView(1, now+2))
+	c := signBlock(1, uint64(now+2), sk)
+	require.NoError(t, p.AddSignedBlock(c))
+	e, err = p.RecordPrepare(c.HashHeader(), pk, cipher.SignHash(c.HashHeader(), sk))
+	require.NoError(t, err)
+	require.Nil(t, e)
+}
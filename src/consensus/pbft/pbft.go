@@ -2,22 +2,50 @@ package pbft
 
 import (
 	"errors"
-	"fmt"
 	"sync"
 
 	"github.com/samoslab/samos/src/cipher"
 	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/util/logging"
 	"github.com/samoslab/samos/src/util/utc"
 )
 
+var logger = logging.MustGetLogger("pbft")
+
 // PBFT pending block data
 type PBFT struct {
 	Status        int
 	BlockNum      int
 	PendingBlocks map[cipher.SHA256]coin.SignedBlock
 	PreparedInfos map[cipher.SHA256][]cipher.PubKey
+	CommitInfos   map[cipher.SHA256][]cipher.PubKey
+	CommitSigs    map[cipher.SHA256][]CommitSig
 	BlockTime     map[cipher.SHA256]int64
-	mutex         sync.Mutex
+	BlockView     map[cipher.SHA256]uint64
+	// View the view number of the height being agreed on, reset when a block is executed
+	View        uint64
+	viewStart   int64
+	viewChanges map[uint64]map[cipher.PubKey]ViewChange
+	prepares    map[prepareKey]prepareVote
+	journal     Journal
+	clock       utc.Clock
+	mutex       sync.Mutex
+}
+
+// PendingState the state of a pending block that is kept across restarts
+type PendingState struct {
+	Block      coin.SignedBlock
+	Prepared   []cipher.PubKey
+	Commits    []cipher.PubKey
+	CommitSigs []CommitSig
+	View       uint64
+	CreatedAt  int64
+}
+
+// Journal persists pending block state
+type Journal interface {
+	Put(hash cipher.SHA256, state PendingState) error
+	Delete(hash cipher.SHA256) error
 }
 
 // NewPBFT new pbft
@@ -28,22 +56,101 @@ func NewPBFT() *PBFT {
 		PendingBlocks: make(map[cipher.SHA256]coin.SignedBlock, 1),
 		BlockTime:     make(map[cipher.SHA256]int64, 1),
 		PreparedInfos: make(map[cipher.SHA256][]cipher.PubKey, 1),
+		CommitInfos:   make(map[cipher.SHA256][]cipher.PubKey, 1),
+		CommitSigs:    make(map[cipher.SHA256][]CommitSig, 1),
+		BlockView:     make(map[cipher.SHA256]uint64, 1),
+		viewStart:     utc.UnixNow(),
+		viewChanges:   make(map[uint64]map[cipher.PubKey]ViewChange),
+		prepares:      make(map[prepareKey]prepareVote),
 	}
 }
 
+// SetJournal sets the journal that every pending block state change is written to
+func (p *PBFT) SetJournal(journal Journal) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.journal = journal
+}
+
+// SetClock sets the clock the pending block times and the view start are read from,
+// the utc clock if nil. The view start is reset to the time of the new clock
+func (p *PBFT) SetClock(clock utc.Clock) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.clock = clock
+	p.viewStart = p.now()
+}
+
+func (p *PBFT) now() int64 {
+	return utc.NowFrom(p.clock).Unix()
+}
+
+// Restore replays journaled pending block states
+func (p *PBFT) Restore(states []PendingState) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, st := range states {
+		bh := st.Block.HashHeader()
+		if _, ok := p.PendingBlocks[bh]; ok {
+			continue
+		}
+		p.PendingBlocks[bh] = st.Block
+		p.PreparedInfos[bh] = st.Prepared
+		p.CommitInfos[bh] = st.Commits
+		p.CommitSigs[bh] = st.CommitSigs
+		p.BlockTime[bh] = st.CreatedAt
+		p.BlockView[bh] = st.View
+		p.BlockNum++
+		if st.View > p.View {
+			p.View = st.View
+		}
+	}
+}
+
+func (p *PBFT) journalPut(hash cipher.SHA256) error {
+	if p.journal == nil {
+		return nil
+	}
+	return p.journal.Put(hash, PendingState{
+		Block:      p.PendingBlocks[hash],
+		Prepared:   p.PreparedInfos[hash],
+		Commits:    p.CommitInfos[hash],
+		CommitSigs: p.CommitSigs[hash],
+		View:       p.BlockView[hash],
+		CreatedAt:  p.BlockTime[hash],
+	})
+}
+
+func (p *PBFT) removeHash(hash cipher.SHA256) error {
+	delete(p.PendingBlocks, hash)
+	delete(p.PreparedInfos, hash)
+	delete(p.CommitInfos, hash)
+	delete(p.CommitSigs, hash)
+	delete(p.BlockTime, hash)
+	delete(p.BlockView, hash)
+	p.BlockNum--
+	return p.journalDelete(hash)
+}
+
+func (p *PBFT) journalDelete(hash cipher.SHA256) error {
+	if p.journal == nil {
+		return nil
+	}
+	return p.journal.Delete(hash)
+}
+
 // RemoveUnconfirmBlock remove pending block if it unconfirmed in 120 seconds
 func (p *PBFT) RemoveUnconfirmBlock() {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
-	now := utc.UnixNow()
+	now := p.now()
 	for hash := range p.PendingBlocks {
 		createdTime, _ := p.BlockTime[hash]
 		if now-createdTime > 120 {
-			fmt.Printf("delete block hash %s because it is not confirmed in 120s\n", hash.Hex())
-			delete(p.PendingBlocks, hash)
-			delete(p.BlockTime, hash)
-			delete(p.PreparedInfos, hash)
-			p.BlockNum--
+			logger.Infof("delete block hash %s because it is not confirmed in 120s", hash.Hex())
+			if err := p.removeHash(hash); err != nil {
+				logger.Errorf("delete block hash %s from journal failed: %v", hash.Hex(), err)
+			}
 		}
 	}
 }
@@ -78,12 +185,7 @@ func (p *PBFT) DeleteHash(hash cipher.SHA256) error {
 		return errors.New("block hash not exists")
 	}
 
-	delete(p.PendingBlocks, hash)
-	delete(p.PreparedInfos, hash)
-	delete(p.BlockTime, hash)
-	p.BlockNum--
-
-	return nil
+	return p.removeHash(hash)
 }
 
 // WaitingConfirmedBlockHash block hash that waiting other validator message
@@ -109,14 +211,24 @@ func (p *PBFT) AddSignedBlock(sb coin.SignedBlock) error {
 	if p.BlockNum >= 1 {
 		return errors.New("has unconfirmed block, this block cannot added")
 	}
+	if p.View > 0 && int64(sb.Time()) < p.viewStart {
+		return errors.New("the block is proposed before the view change")
+	}
 	pubkeyRec, err := cipher.PubKeyFromSig(sb.Sig, bh) //recovered pubkey
 	if err != nil {
 		return errors.New("Invalid sig: PubKey recovery failed")
 	}
 	p.PendingBlocks[bh] = sb
 	p.PreparedInfos[bh] = []cipher.PubKey{pubkeyRec}
-	p.BlockTime[bh] = utc.UnixNow()
+	p.CommitInfos[bh] = []cipher.PubKey{}
+	p.CommitSigs[bh] = []CommitSig{}
+	p.BlockTime[bh] = p.now()
+	p.BlockView[bh] = p.View
 	p.BlockNum++
+	if err := p.journalPut(bh); err != nil {
+		p.removeHash(bh)
+		return err
+	}
 	return nil
 }
 
@@ -160,8 +272,11 @@ func (p *PBFT) AddValidator(hash cipher.SHA256, pubkey cipher.PubKey) error {
 			return errors.New("the pubkey already exists")
 		}
 	}
-	validators = append(validators, pubkey)
-	p.PreparedInfos[hash] = validators
+	p.PreparedInfos[hash] = append(validators, pubkey)
+	if err := p.journalPut(hash); err != nil {
+		p.PreparedInfos[hash] = validators
+		return err
+	}
 	return nil
 }
 
@@ -175,3 +290,61 @@ func (p *PBFT) ValidatorNumber(hash cipher.SHA256) (int, error) {
 	}
 	return len(validators), nil
 }
+
+// AddCommit records the commit of a validator for the block hash with its signature
+func (p *PBFT) AddCommit(hash cipher.SHA256, pubkey cipher.PubKey, cs CommitSig) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	commits, ok := p.CommitInfos[hash]
+	if !ok {
+		return errors.New("this block hash not added into commit infos")
+	}
+	for _, pk := range commits {
+		if pk == pubkey {
+			return errors.New("the pubkey already committed")
+		}
+	}
+	sigs := p.CommitSigs[hash]
+	p.CommitInfos[hash] = append(commits, pubkey)
+	p.CommitSigs[hash] = append(sigs, cs)
+	if err := p.journalPut(hash); err != nil {
+		p.CommitInfos[hash] = commits
+		p.CommitSigs[hash] = sigs
+		return err
+	}
+	return nil
+}
+
+// CheckCommitExists check pubkey has committed the block hash
+func (p *PBFT) CheckCommitExists(hash cipher.SHA256, pubkey cipher.PubKey) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, pk := range p.CommitInfos[hash] {
+		if pk == pubkey {
+			return nil
+		}
+	}
+	return errors.New("not exists")
+}
+
+// CommitNumber the number of commits for the block hash
+func (p *PBFT) CommitNumber(hash cipher.SHA256) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	commits, ok := p.CommitInfos[hash]
+	if !ok {
+		return 0, errors.New("the hash not exists")
+	}
+	return len(commits), nil
+}
+
+// GetBlockView returns the view the block hash was proposed in
+func (p *PBFT) GetBlockView(hash cipher.SHA256) (uint64, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	view, ok := p.BlockView[hash]
+	if !ok {
+		return 0, errors.New("the hash not exists")
+	}
+	return view, nil
+}
@@ -3,9 +3,11 @@ package pbft
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/samoslab/samos/src/cipher"
 	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/util/utc"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -107,3 +109,88 @@ func TestPbft(t *testing.T) {
 	err = pbft.DeleteHash(hash)
 	assert.Nil(t, err)
 }
+
+type memJournal map[cipher.SHA256]PendingState
+
+func (mj memJournal) Put(hash cipher.SHA256, state PendingState) error {
+	mj[hash] = state
+	return nil
+}
+
+func (mj memJournal) Delete(hash cipher.SHA256) error {
+	delete(mj, hash)
+	return nil
+}
+
+func (mj memJournal) states() []PendingState {
+	states := []PendingState{}
+	for _, st := range mj {
+		states = append(states, st)
+	}
+	return states
+}
+
+func TestPbftJournalRestore(t *testing.T) {
+	journal := memJournal{}
+	p := NewPBFT()
+	p.SetJournal(journal)
+
+	seckey := cipher.MustSecKeyFromHex("4f36d5784d96a5b0e29d6876dd4eba422a2d92a29e81c67487fff7c403fa105b")
+	pubkey := cipher.MustPubKeyFromHex("025d8360bc9439aa94044df96605f7693f50bb35386b37ae5003787d840a98bf43")
+	pubkey1 := cipher.MustPubKeyFromHex("02d15bf28c4ed2c39b35b2be2f8bcde1318e2b3b65fe2a676db39b520bee9bfe86")
+
+	block, err := makeNewBlock(cipher.SumSHA256([]byte("abcd1234")))
+	assert.NoError(t, err)
+	sb := coin.SignedBlock{
+		Block: *block,
+		Sig:   cipher.SignHash(block.HashHeader(), seckey),
+	}
+	hash := sb.HashHeader()
+
+	assert.NoError(t, p.AddSignedBlock(sb))
+	assert.NoError(t, p.AddValidator(hash, pubkey1))
+	assert.Len(t, journal, 1)
+	assert.Equal(t, []cipher.PubKey{pubkey, pubkey1}, journal[hash].Prepared)
+
+	// a restarted node continues the round with the prepare votes it had
+	restarted := NewPBFT()
+	restarted.Restore(journal.states())
+	restarted.SetJournal(journal)
+	assert.Equal(t, 1, restarted.BlockNum)
+	assert.Equal(t, p.BlockTime[hash], restarted.BlockTime[hash])
+	num, err := restarted.ValidatorNumber(hash)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, num)
+	got, err := restarted.GetSignedBlock(hash)
+	assert.NoError(t, err)
+	assert.Equal(t, sb, got)
+	assert.Equal(t, errors.New("has unconfirmed block, this block cannot added"), restarted.AddSignedBlock(coin.SignedBlock{}))
+
+	assert.NoError(t, restarted.DeleteHash(hash))
+	assert.Len(t, journal, 0)
+}
+
+func TestPbftRemoveUnconfirmBlock(t *testing.T) {
+	clock := utc.NewMockClock(time.Unix(1000, 0))
+	p := NewPBFT()
+	p.SetClock(clock)
+
+	seckey := cipher.MustSecKeyFromHex("4f36d5784d96a5b0e29d6876dd4eba422a2d92a29e81c67487fff7c403fa105b")
+	block, err := makeNewBlock(cipher.SumSHA256([]byte("abcd1234")))
+	assert.NoError(t, err)
+	sb := coin.SignedBlock{
+		Block: *block,
+		Sig:   cipher.SignHash(block.HashHeader(), seckey),
+	}
+	assert.NoError(t, p.AddSignedBlock(sb))
+	assert.Equal(t, int64(1000), p.BlockTime[sb.HashHeader()])
+
+	clock.Advance(120 * time.Second)
+	p.RemoveUnconfirmBlock()
+	assert.Equal(t, 1, p.BlockNum)
+
+	clock.Advance(time.Second)
+	p.RemoveUnconfirmBlock()
+	assert.Equal(t, 0, p.BlockNum)
+	assert.Empty(t, p.PendingBlocks)
+}
@@ -0,0 +1,183 @@
+package pbft
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/cipher/encoder"
+)
+
+// viewChangeTimeout seconds a pending block waits to be committed before
+// validators vote to abandon the view it was proposed in
+const viewChangeTimeout = int64(30)
+
+// ViewChange the vote of a validator to abandon the current view of height Seq,
+// Prepared is the block hash the validator committed in the old view if any
+type ViewChange struct {
+	Seq      uint64
+	View     uint64
+	Prepared cipher.SHA256
+	Sig      cipher.Sig
+}
+
+// NewViewChange creates a signed ViewChange
+func NewViewChange(seq, view uint64, prepared cipher.SHA256, secKey cipher.SecKey) ViewChange {
+	vc := ViewChange{
+		Seq:      seq,
+		View:     view,
+		Prepared: prepared,
+	}
+	vc.Sig = cipher.SignHash(vc.Hash(), secKey)
+	return vc
+}
+
+// Hash returns the hash signed by the voter
+func (vc ViewChange) Hash() cipher.SHA256 {
+	return cipher.SumSHA256(encoder.Serialize(struct {
+		Seq      uint64
+		View     uint64
+		Prepared cipher.SHA256
+	}{vc.Seq, vc.View, vc.Prepared}))
+}
+
+// Signer recovers the pubkey of the voter
+func (vc ViewChange) Signer() (cipher.PubKey, error) {
+	return cipher.PubKeyFromSig(vc.Sig, vc.Hash())
+}
+
+// CommitHash returns the hash validators sign to commit the block hash in view
+func CommitHash(hash cipher.SHA256, view uint64) cipher.SHA256 {
+	return cipher.AddSHA256(hash, cipher.SumSHA256([]byte(fmt.Sprintf("commit:%d", view))))
+}
+
+// GetView returns the current view
+func (p *PBFT) GetView() uint64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.View
+}
+
+// NeedViewChange returns the view the validator should vote for at now, the current view
+// is abandoned when its pending block is not committed within viewChangeTimeout
+func (p *PBFT) NeedViewChange(now int64, pubkey cipher.PubKey) (uint64, cipher.SHA256, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var prepared cipher.SHA256
+	stuck := false
+	for hash, created := range p.BlockTime {
+		if p.BlockView[hash] == p.View && now-created > viewChangeTimeout {
+			stuck = true
+		}
+		for _, pk := range p.CommitInfos[hash] {
+			if pk == pubkey {
+				prepared = hash
+			}
+		}
+	}
+	if !stuck {
+		return 0, cipher.SHA256{}, false
+	}
+
+	view := p.View + 1
+	if _, ok := p.viewChanges[view][pubkey]; ok {
+		return 0, cipher.SHA256{}, false
+	}
+	return view, prepared, true
+}
+
+// AddViewChange records the view change vote of signer, returns the number of votes for the view
+func (p *PBFT) AddViewChange(vc ViewChange, signer cipher.PubKey) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if vc.View <= p.View {
+		return 0, errors.New("view change for stale view")
+	}
+	votes, ok := p.viewChanges[vc.View]
+	if !ok {
+		votes = make(map[cipher.PubKey]ViewChange)
+		p.viewChanges[vc.View] = votes
+	}
+	if _, ok := votes[signer]; ok {
+		return len(votes), errors.New("the pubkey already voted")
+	}
+	votes[signer] = vc
+	return len(votes), nil
+}
+
+// ViewChanges returns the view change votes for the view
+func (p *PBFT) ViewChanges(view uint64) []ViewChange {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	vcs := []ViewChange{}
+	for _, vc := range p.viewChanges[view] {
+		vcs = append(vcs, vc)
+	}
+	return vcs
+}
+
+// ChangeView moves to the view, pending blocks that no voter committed are dropped
+// so that the producer of a later slot can propose
+func (p *PBFT) ChangeView(view uint64, now int64) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if view <= p.View {
+		return errors.New("view change for stale view")
+	}
+
+	prepared := make(map[cipher.SHA256]struct{})
+	for _, vc := range p.viewChanges[view] {
+		prepared[vc.Prepared] = struct{}{}
+	}
+
+	var err error
+	for hash := range p.PendingBlocks {
+		if _, ok := prepared[hash]; ok {
+			p.BlockView[hash] = view
+			p.BlockTime[hash] = now
+			if e := p.journalPut(hash); e != nil {
+				err = e
+			}
+			continue
+		}
+		logger.Infof("delete block hash %s because view %d is abandoned", hash.Hex(), p.View)
+		if e := p.removeHash(hash); e != nil {
+			err = e
+		}
+	}
+
+	p.View = view
+	p.viewStart = now
+	for v := range p.viewChanges {
+		if v <= view {
+			delete(p.viewChanges, v)
+		}
+	}
+	return err
+}
+
+// NewHeight resets the view after the block of seq is executed
+func (p *PBFT) NewHeight(seq uint64, now int64) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var err error
+	for hash, block := range p.PendingBlocks {
+		if block.Seq() <= seq {
+			if e := p.removeHash(hash); e != nil {
+				err = e
+			}
+		}
+	}
+
+	p.View = 0
+	p.viewStart = now
+	p.viewChanges = make(map[uint64]map[cipher.PubKey]ViewChange)
+	for key := range p.prepares {
+		if key.seq <= seq {
+			delete(p.prepares, key)
+		}
+	}
+	return err
+}
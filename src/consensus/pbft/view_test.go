@@ -0,0 +1,151 @@
+package pbft
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/util/utc"
+)
+
+func makePendingBlock(seq, when uint64) coin.SignedBlock {
+	_, sk := cipher.GenerateKeyPair()
+	b := coin.Block{Head: coin.BlockHeader{BkSeq: seq, Time: when}}
+	return coin.SignedBlock{Block: b, Sig: cipher.SignHash(b.HashHeader(), sk)}
+}
+
+func TestCommit(t *testing.T) {
+	p := NewPBFT()
+	sb := makePendingBlock(1, uint64(utc.UnixNow()))
+	hash := sb.HashHeader()
+	pk, sk := cipher.GenerateKeyPair()
+	cs := CommitSig{View: 0, Sig: cipher.SignHash(CommitHash(hash, 0), sk)}
+
+	require.Error(t, p.AddCommit(hash, pk, cs))
+	require.NoError(t, p.AddSignedBlock(sb))
+
+	num, err := p.CommitNumber(hash)
+	require.NoError(t, err)
+	require.Equal(t, 0, num)
+
+	require.NoError(t, p.AddCommit(hash, pk, cs))
+	require.EqualError(t, p.AddCommit(hash, pk, cs), "the pubkey already committed")
+	require.NoError(t, p.CheckCommitExists(hash, pk))
+
+	num, err = p.CommitNumber(hash)
+	require.NoError(t, err)
+	require.Equal(t, 1, num)
+
+	view, err := p.GetBlockView(hash)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), view)
+
+	require.NotEqual(t, CommitHash(hash, 0), CommitHash(hash, 1))
+}
+
+func TestViewChange(t *testing.T) {
+	now := utc.UnixNow()
+	p := NewPBFT()
+	sb := makePendingBlock(1, uint64(now))
+	require.NoError(t, p.AddSignedBlock(sb))
+
+	pk1, sk1 := cipher.GenerateKeyPair()
+	pk2, sk2 := cipher.GenerateKeyPair()
+
+	// the pending block is not stuck yet
+	_, _, ok := p.NeedViewChange(now, pk1)
+	require.False(t, ok)
+
+	timeout := now + viewChangeTimeout + 1
+	view, prepared, ok := p.NeedViewChange(timeout, pk1)
+	require.True(t, ok)
+	require.Equal(t, uint64(1), view)
+	require.Equal(t, cipher.SHA256{}, prepared)
+
+	vc1 := NewViewChange(1, view, prepared, sk1)
+	signer, err := vc1.Signer()
+	require.NoError(t, err)
+	require.Equal(t, pk1, signer)
+
+	num, err := p.AddViewChange(vc1, pk1)
+	require.NoError(t, err)
+	require.Equal(t, 1, num)
+	_, err = p.AddViewChange(vc1, pk1)
+	require.EqualError(t, err, "the pubkey already voted")
+
+	// pk1 voted already
+	_, _, ok = p.NeedViewChange(timeout, pk1)
+	require.False(t, ok)
+
+	num, err = p.AddViewChange(NewViewChange(1, view, cipher.SHA256{}, sk2), pk2)
+	require.NoError(t, err)
+	require.Equal(t, 2, num)
+	require.Len(t, p.ViewChanges(view), 2)
+
+	// nobody committed the pending block, it is dropped
+	require.NoError(t, p.ChangeView(view, timeout))
+	require.Equal(t, uint64(1), p.GetView())
+	require.Empty(t, p.WaitingConfirmedBlockHash())
+	require.Equal(t, 0, p.BlockNum)
+	require.Empty(t, p.ViewChanges(view))
+
+	require.EqualError(t, p.ChangeView(view, timeout), "view change for stale view")
+	_, err = p.AddViewChange(vc1, pk1)
+	require.EqualError(t, err, "view change for stale view")
+
+	// the leader of the abandoned view can not propose again
+	require.EqualError(t, p.AddSignedBlock(sb), "the block is proposed before the view change")
+
+	sb2 := makePendingBlock(1, uint64(timeout))
+	require.NoError(t, p.AddSignedBlock(sb2))
+	view, err = p.GetBlockView(sb2.HashHeader())
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), view)
+
+	// executing the block starts the next height in view 0
+	require.NoError(t, p.NewHeight(1, timeout))
+	require.Equal(t, uint64(0), p.GetView())
+	require.Empty(t, p.WaitingConfirmedBlockHash())
+}
+
+func TestViewChangeKeepsPrepared(t *testing.T) {
+	now := utc.UnixNow()
+	journal := memJournal{}
+	p := NewPBFT()
+	p.SetJournal(journal)
+	sb := makePendingBlock(1, uint64(now))
+	hash := sb.HashHeader()
+	require.NoError(t, p.AddSignedBlock(sb))
+
+	pk1, sk1 := cipher.GenerateKeyPair()
+	cs := CommitSig{View: 0, Sig: cipher.SignHash(CommitHash(hash, 0), sk1)}
+	require.NoError(t, p.AddCommit(hash, pk1, cs))
+
+	timeout := now + viewChangeTimeout + 1
+	view, prepared, ok := p.NeedViewChange(timeout, pk1)
+	require.True(t, ok)
+	require.Equal(t, hash, prepared)
+
+	_, err := p.AddViewChange(NewViewChange(1, view, prepared, sk1), pk1)
+	require.NoError(t, err)
+	require.NoError(t, p.ChangeView(view, timeout))
+
+	// the block committed by a voter is carried into the new view
+	require.Equal(t, []cipher.SHA256{hash}, p.WaitingConfirmedBlockHash())
+	view, err = p.GetBlockView(hash)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), view)
+	require.Equal(t, uint64(1), journal[hash].View)
+	require.Equal(t, []cipher.PubKey{pk1}, journal[hash].Commits)
+
+	// the carried commit keeps the view it was signed in
+	cert, err := p.GetCertificate(hash)
+	require.NoError(t, err)
+	require.Equal(t, []CommitSig{cs}, cert.Commits)
+	require.NoError(t, cert.Verify([]cipher.PubKey{pk1}, 1))
+
+	_, _, ok = p.NeedViewChange(timeout, pk1)
+	require.False(t, ok)
+}
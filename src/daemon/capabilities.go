@@ -0,0 +1,83 @@
+package daemon
+
+import (
+	"strings"
+
+	"github.com/samoslab/samos/src/daemon/gnet"
+)
+
+// Capabilities is a bitfield of the optional messages a node handles. It is
+// advertised in the IntroductionMessage, and a message is only sent to the
+// peers that advertised its capability, so nodes of different versions can
+// share a network
+type Capabilities uint64
+
+const (
+	// CapCommit handles the pbft commit messages
+	CapCommit Capabilities = 1 << iota
+	// CapViewChange handles the view change and new view messages
+	CapViewChange
+	// CapEvidence handles the double signing evidence messages
+	CapEvidence
+	// CapCertificate handles the commit certificate messages
+	CapCertificate
+	// CapHeaders handles the headers-first block sync messages
+	CapHeaders
+	// CapCompactBlocks handles the compact block relay messages
+	CapCompactBlocks
+)
+
+// DefaultCapabilities the capabilities of this version
+const DefaultCapabilities = CapCommit | CapViewChange | CapEvidence | CapCertificate | CapHeaders | CapCompactBlocks
+
+var capabilityNames = []struct {
+	c    Capabilities
+	name string
+}{
+	{CapCommit, "commit"},
+	{CapViewChange, "view_change"},
+	{CapEvidence, "evidence"},
+	{CapCertificate, "certificate"},
+	{CapHeaders, "headers"},
+	{CapCompactBlocks, "compact_blocks"},
+}
+
+// Has returns true if all the capabilities of c are set
+func (cs Capabilities) Has(c Capabilities) bool {
+	return cs&c == c
+}
+
+// Names returns the names of the known capabilities that are set
+func (cs Capabilities) Names() []string {
+	names := []string{}
+	for _, cn := range capabilityNames {
+		if cs.Has(cn.c) {
+			names = append(names, cn.name)
+		}
+	}
+	return names
+}
+
+func (cs Capabilities) String() string {
+	return strings.Join(cs.Names(), ",")
+}
+
+// requiredCapabilities returns the capabilities a peer needs to handle the message
+func requiredCapabilities(msg gnet.Message) Capabilities {
+	switch msg.(type) {
+	case *CommitMessage:
+		return CapCommit
+	case *ViewChangeMessage, *NewViewMessage:
+		return CapViewChange
+	case *EvidenceMessage:
+		return CapEvidence
+	case *CertificateMessage:
+		return CapCertificate
+	case *GetHeadersMessage, *GetBodiesMessage:
+		return CapHeaders
+	case *CompactBlockMessage, *GetBlockTxnsMessage:
+		return CapCompactBlocks
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,81 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/cipher/encoder"
+	"github.com/samoslab/samos/src/consensus/pbft"
+	"github.com/samoslab/samos/src/daemon/gnet"
+)
+
+func TestCapabilities(t *testing.T) {
+	cs := CapCommit | CapCertificate
+	require.True(t, cs.Has(CapCommit))
+	require.True(t, cs.Has(CapCommit|CapCertificate))
+	require.False(t, cs.Has(CapCommit|CapViewChange))
+	require.True(t, cs.Has(0))
+	require.Equal(t, []string{"commit", "certificate"}, cs.Names())
+	require.Equal(t, "commit,certificate", cs.String())
+	require.Equal(t, "", Capabilities(0).String())
+
+	require.True(t, DefaultCapabilities.Has(CapCommit|CapViewChange|CapEvidence|CapCertificate|CapHeaders|CapCompactBlocks))
+}
+
+func TestRequiredCapabilities(t *testing.T) {
+	tt := []struct {
+		name string
+		msg  gnet.Message
+		caps Capabilities
+	}{
+		{"commit", &CommitMessage{}, CapCommit},
+		{"view change", &ViewChangeMessage{}, CapViewChange},
+		{"new view", NewNewViewMessage(1, nil), CapViewChange},
+		{"evidence", NewEvidenceMessage(pbft.Evidence{}), CapEvidence},
+		{"certificate", NewCertificateMessage(pbft.Certificate{}), CapCertificate},
+		{"headers", NewGetHeadersMessage(1, 10), CapHeaders},
+		{"bodies", NewGetBodiesMessage(nil), CapHeaders},
+		{"compact block", &CompactBlockMessage{}, CapCompactBlocks},
+		{"block txns", NewGetBlockTxnsMessage(cipher.SHA256{}, nil), CapCompactBlocks},
+		{"blocks", NewGetBlocksMessage(1, 10), 0},
+		{"ping", &PingMessage{}, 0},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.caps, requiredCapabilities(tc.msg))
+		})
+	}
+}
+
+func TestIntroductionMessageExtra(t *testing.T) {
+	intro := NewIntroductionMessage(1, 3, 6677, 4, DefaultCapabilities)
+	// a later version appends its fields to Extra
+	intro.Extra = append(intro.Extra, 1, 2, 3)
+
+	var decoded IntroductionMessage
+	require.NoError(t, encoder.DeserializeRaw(encoder.Serialize(*intro), &decoded))
+	extra, ok, err := decoded.Extension()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, IntroductionExtra{AgreeNum: 4, Capabilities: uint64(DefaultCapabilities)}, extra)
+
+	// a version 2 introduction has no Extra
+	v2 := struct {
+		Mirror  uint32
+		Port    uint16
+		Version int32
+	}{Mirror: 1, Port: 6677, Version: 2}
+	decoded = IntroductionMessage{}
+	require.NoError(t, encoder.DeserializeRaw(encoder.Serialize(v2), &decoded))
+	require.Equal(t, int32(2), decoded.Version)
+	_, ok, err = decoded.Extension()
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	decoded.Extra = []byte{1}
+	_, _, err = decoded.Extension()
+	require.Error(t, err)
+}
@@ -0,0 +1,563 @@
+// Package cluster runs several daemons in one process for consensus tests.
+//
+// Every node is a trust node producing blocks. The nodes talk over loopback
+// through a forwarding link per pair of nodes, which the test can cut or slow
+// down. The visors of the nodes share a mock clock, so slots and view changes
+// only move when the test advances it.
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/consensus/dpos"
+	"github.com/samoslab/samos/src/daemon"
+	"github.com/samoslab/samos/src/util/utc"
+	"github.com/samoslab/samos/src/visor"
+)
+
+var (
+	// ErrNodeStopped the node is not running
+	ErrNodeStopped = errors.New("node is not running")
+	// ErrNodeRunning the node is already running
+	ErrNodeRunning = errors.New("node is already running")
+	// ErrWaitTimeout the condition was not met in time
+	ErrWaitTimeout = errors.New("wait timeout")
+)
+
+// Config cluster config
+type Config struct {
+	// Number of nodes
+	Nodes int
+	// Time of the genesis block, the clock starts right after it
+	GenesisTime time.Time
+	// Coins in the genesis block
+	GenesisCoins uint64
+	// Length of a block slot, in seconds
+	BlockInterval int64
+	// Length of an epoch, in seconds
+	EpochInterval int64
+	// Directory where the node dbs and peer lists are kept, a temp dir if empty
+	DataDirectory string
+}
+
+// NewConfig returns a Config with defaults set
+func NewConfig() Config {
+	return Config{
+		Nodes:         4,
+		GenesisTime:   time.Unix(1500000000, 0),
+		GenesisCoins:  300e12,
+		BlockInterval: dpos.DefaultBlockInterval,
+		EpochInterval: dpos.DefaultEpochInterval,
+	}
+}
+
+// Node a daemon of the cluster
+type Node struct {
+	Index  int
+	PubKey cipher.PubKey
+	SecKey cipher.SecKey
+	// Daemon of the node, nil when the node is stopped
+	Daemon *daemon.Daemon
+
+	config daemon.Config
+	peers  []string
+	done   chan error
+}
+
+// Cluster runs the nodes and controls the clock and the links between them
+type Cluster struct {
+	Config Config
+	Clock  *utc.MockClock
+	Nodes  []*Node
+	// GenesisSecKey owns all the coins of the genesis block
+	GenesisSecKey cipher.SecKey
+
+	genesis coin.SignedBlock
+	links   map[[2]int]*link
+	tmpDir  string
+	mutex   sync.Mutex
+}
+
+// New creates the cluster, nodes are started by Start
+func New(c Config) (*Cluster, error) {
+	if c.Nodes <= 0 {
+		return nil, errors.New("cluster needs at least one node")
+	}
+
+	cl := &Cluster{
+		Config: c,
+		Clock:  utc.NewMockClock(c.GenesisTime.Add(time.Second)),
+		links:  make(map[[2]int]*link),
+	}
+
+	if c.DataDirectory == "" {
+		dir, err := ioutil.TempDir("", "samos-cluster")
+		if err != nil {
+			return nil, err
+		}
+		cl.tmpDir = dir
+		cl.Config.DataDirectory = dir
+	}
+
+	if err := cl.setup(); err != nil {
+		cl.Shutdown()
+		return nil, err
+	}
+
+	return cl, nil
+}
+
+func (cl *Cluster) setup() error {
+	c := cl.Config
+	seeds := cipher.GenerateDeterministicKeyPairs([]byte("samos cluster"), c.Nodes+1)
+	cl.GenesisSecKey = seeds[c.Nodes]
+
+	trustKeys := make([]cipher.PubKey, c.Nodes)
+	ports := make([]int, c.Nodes)
+	for i := 0; i < c.Nodes; i++ {
+		trustKeys[i] = cipher.PubKeyFromSecKey(seeds[i])
+		port, err := freePort()
+		if err != nil {
+			return err
+		}
+		ports[i] = port
+	}
+
+	genesisAddr := cipher.AddressFromSecKey(cl.GenesisSecKey)
+	gb, err := coin.NewGenesisBlock(genesisAddr, c.GenesisCoins, uint64(c.GenesisTime.Unix()))
+	if err != nil {
+		return err
+	}
+	cl.genesis = coin.SignedBlock{
+		Block: *gb,
+		Sig:   cipher.SignHash(gb.HashHeader(), seeds[0]),
+	}
+
+	for i := 0; i < c.Nodes; i++ {
+		n := &Node{
+			Index:  i,
+			PubKey: trustKeys[i],
+			SecKey: seeds[i],
+		}
+
+		// every pair is connected once, by the node with the lower index
+		for j := i + 1; j < c.Nodes; j++ {
+			l, err := newLink(fmt.Sprintf("127.0.0.1:%d", ports[j]))
+			if err != nil {
+				return err
+			}
+			cl.links[[2]int{i, j}] = l
+			n.peers = append(n.peers, l.Addr())
+		}
+
+		dir := filepath.Join(c.DataDirectory, strconv.Itoa(i))
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+
+		n.config = cl.nodeConfig(i, ports[i], dir, seeds[i], trustKeys)
+		cl.Nodes = append(cl.Nodes, n)
+	}
+
+	return nil
+}
+
+func (cl *Cluster) nodeConfig(i, port int, dir string, sec cipher.SecKey, trustKeys []cipher.PubKey) daemon.Config {
+	dc := daemon.NewConfig()
+	dc.Daemon.Address = "127.0.0.1"
+	dc.Daemon.Port = port
+	dc.Daemon.LocalhostOnly = true
+	dc.Daemon.DataDirectory = dir
+	dc.Daemon.OutgoingRate = 100 * time.Millisecond
+	dc.Daemon.PrivateRate = 100 * time.Millisecond
+	dc.Daemon.IPCountsMax = cl.Config.Nodes * 2
+	dc.Daemon.LogPings = false
+
+	dc.Pex.DataDirectory = dir
+	dc.Pex.Disabled = true
+
+	vc := &dc.Visor.Config
+	vc.Clock = cl.Clock
+	vc.IsMaster = true
+	// the first node is the genesis node
+	vc.BlockchainPubkey = trustKeys[0]
+	if i == 0 {
+		vc.BlockchainSeckey = sec
+	}
+	vc.BlockchainTrustPubkey = trustKeys[i]
+	vc.BlockchainTrustSeckey = sec
+	vc.TrustPubkeyList = trustKeys
+	vc.BlockCreationInterval = 1
+	vc.BlockInterval = cl.Config.BlockInterval
+	vc.EpochInterval = cl.Config.EpochInterval
+	vc.GenesisAddress = cipher.AddressFromSecKey(cl.GenesisSecKey)
+	vc.GenesisSignature = cl.genesis.Sig
+	vc.GenesisTimestamp = cl.genesis.Time()
+	vc.GenesisCoinVolume = cl.Config.GenesisCoins
+	vc.DBPath = filepath.Join(dir, "data.db")
+	dc.Visor.PrepareRequestRate = time.Second
+
+	return dc
+}
+
+func freePort() (int, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
+
+// Start starts all the stopped nodes
+func (cl *Cluster) Start() error {
+	for i := range cl.Nodes {
+		if cl.Nodes[i].Daemon != nil {
+			continue
+		}
+		if err := cl.StartNode(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartNode starts the node i, a killed node restarts from its db
+func (cl *Cluster) StartNode(i int) error {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	n := cl.Nodes[i]
+	if n.Daemon != nil {
+		return ErrNodeRunning
+	}
+
+	db, err := visor.OpenDB(n.config.Visor.Config.DBPath, false)
+	if err != nil {
+		return err
+	}
+
+	d, err := daemon.NewDaemon(n.config, db, n.peers)
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	n.Daemon = d
+	n.done = make(chan error, 1)
+	go func() {
+		n.done <- d.Run()
+	}()
+	return nil
+}
+
+// Kill stops the node i, its db is kept so it can be started again
+func (cl *Cluster) Kill(i int) error {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	n := cl.Nodes[i]
+	if n.Daemon == nil {
+		return ErrNodeStopped
+	}
+
+	n.Daemon.Shutdown()
+	err := <-n.done
+	n.Daemon = nil
+	return err
+}
+
+// Shutdown kills all nodes and closes the links
+func (cl *Cluster) Shutdown() {
+	for i, n := range cl.Nodes {
+		if n.Daemon != nil {
+			cl.Kill(i)
+		}
+	}
+	for _, l := range cl.links {
+		l.close()
+	}
+	if cl.tmpDir != "" {
+		os.RemoveAll(cl.tmpDir)
+	}
+}
+
+func (cl *Cluster) link(a, b int) *link {
+	if a > b {
+		a, b = b, a
+	}
+	return cl.links[[2]int{a, b}]
+}
+
+// Partition cuts the links between nodes of different groups, nodes
+// in no group are cut from all others
+func (cl *Cluster) Partition(groups ...[]int) {
+	group := make(map[int]int)
+	for g, nodes := range groups {
+		for _, i := range nodes {
+			group[i] = g + 1
+		}
+	}
+	for k, l := range cl.links {
+		ga, gb := group[k[0]], group[k[1]]
+		l.setCut(ga == 0 || ga != gb)
+	}
+}
+
+// Cut cuts the link between node a and b, other links are kept
+func (cl *Cluster) Cut(a, b int) {
+	if l := cl.link(a, b); l != nil {
+		l.setCut(true)
+	}
+}
+
+// Heal restores all links and removes their delays
+func (cl *Cluster) Heal() {
+	for _, l := range cl.links {
+		l.setCut(false)
+		l.setDelay(0)
+	}
+}
+
+// Delay delays the traffic between node a and b by d in both directions
+func (cl *Cluster) Delay(a, b int, d time.Duration) {
+	if l := cl.link(a, b); l != nil {
+		l.setDelay(d)
+	}
+}
+
+// Advance moves the clock of all nodes forward
+func (cl *Cluster) Advance(d time.Duration) {
+	cl.Clock.Advance(d)
+}
+
+// AdvanceSlots moves the clock forward by n block slots
+func (cl *Cluster) AdvanceSlots(n int) {
+	cl.Clock.Advance(time.Duration(int64(n)*cl.Config.BlockInterval) * time.Second)
+}
+
+// WaitFor polls cond until it returns true or the timeout expires
+func WaitFor(timeout time.Duration, cond func() bool) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if cond() {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return ErrWaitTimeout
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// HeadSeq returns the head seq of the node i
+func (cl *Cluster) HeadSeq(i int) (uint64, error) {
+	d := cl.Nodes[i].Daemon
+	if d == nil {
+		return 0, ErrNodeStopped
+	}
+	m, err := d.Gateway.GetBlockchainMetadata()
+	if err != nil {
+		return 0, err
+	}
+	return m.Head.BkSeq, nil
+}
+
+// WaitHeadSeq waits until all the given nodes reach seq
+func (cl *Cluster) WaitHeadSeq(seq uint64, timeout time.Duration, nodes ...int) error {
+	return WaitFor(timeout, func() bool {
+		for _, i := range nodes {
+			s, err := cl.HeadSeq(i)
+			if err != nil || s < seq {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// BlockHash returns the hash of the block seq in the chain of node i
+func (cl *Cluster) BlockHash(i int, seq uint64) (cipher.SHA256, error) {
+	d := cl.Nodes[i].Daemon
+	if d == nil {
+		return cipher.SHA256{}, ErrNodeStopped
+	}
+	sb, err := d.Visor.GetSignedBlock(seq)
+	if err != nil {
+		return cipher.SHA256{}, err
+	}
+	if sb == nil {
+		return cipher.SHA256{}, fmt.Errorf("node %d has no block %d", i, seq)
+	}
+	return sb.HashHeader(), nil
+}
+
+// Producer returns the index of the node scheduled for the current slot, as node i sees it
+func (cl *Cluster) Producer(i int) (int, error) {
+	d := cl.Nodes[i].Daemon
+	if d == nil {
+		return 0, ErrNodeStopped
+	}
+	schedule, err := d.Gateway.GetProducerSchedule(1)
+	if err != nil {
+		return 0, err
+	}
+	if len(schedule) == 0 {
+		return 0, errors.New("no producer is scheduled")
+	}
+	for _, n := range cl.Nodes {
+		if n.PubKey.Hex() == schedule[0].Producer {
+			return n.Index, nil
+		}
+	}
+	return 0, fmt.Errorf("producer %s is not a node of the cluster", schedule[0].Producer)
+}
+
+// Pending returns the hashes of the blocks node i waits to be committed
+func (cl *Cluster) Pending(i int) ([]cipher.SHA256, error) {
+	d := cl.Nodes[i].Daemon
+	if d == nil {
+		return nil, ErrNodeStopped
+	}
+	return d.Visor.V.GetPendingHash(), nil
+}
+
+// View returns the pbft view of node i
+func (cl *Cluster) View(i int) (uint64, error) {
+	d := cl.Nodes[i].Daemon
+	if d == nil {
+		return 0, ErrNodeStopped
+	}
+	return d.Visor.V.GetView(), nil
+}
+
+// Connections returns the number of connections of the node i
+func (cl *Cluster) Connections(i int) (int, error) {
+	d := cl.Nodes[i].Daemon
+	if d == nil {
+		return 0, ErrNodeStopped
+	}
+	return d.Pool.Pool.Size()
+}
+
+// WaitConnected waits until all running nodes are connected to the running nodes
+// they have links to
+func (cl *Cluster) WaitConnected(timeout time.Duration) error {
+	return WaitFor(timeout, func() bool {
+		for i, n := range cl.Nodes {
+			if n.Daemon == nil {
+				continue
+			}
+			want := 0
+			for j, m := range cl.Nodes {
+				if i != j && m.Daemon != nil && !cl.link(i, j).isCut() {
+					want++
+				}
+			}
+			got, err := cl.Connections(i)
+			if err != nil || got < want {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Spend injects at node i a transaction sending coins from the genesis
+// address to addr, the change returns to the genesis address. The previous
+// spend must be executed before spending again
+func (cl *Cluster) Spend(i int, addr cipher.Address, coins uint64) (*coin.Transaction, error) {
+	d := cl.Nodes[i].Daemon
+	if d == nil {
+		return nil, ErrNodeStopped
+	}
+
+	genesisAddr := cipher.AddressFromSecKey(cl.GenesisSecKey)
+	uxs := d.Gateway.GetUnspent().GetUnspentsOfAddrs([]cipher.Address{genesisAddr})[genesisAddr]
+	if len(uxs) == 0 {
+		return nil, errors.New("genesis address has no unspent output")
+	}
+
+	txn := coin.Transaction{}
+	var totalCoins, totalHours uint64
+	keys := make([]cipher.SecKey, len(uxs))
+	for k, ux := range uxs {
+		txn.PushInput(ux.Hash())
+		totalCoins += ux.Body.Coins
+		totalHours += ux.Body.Hours
+		keys[k] = cl.GenesisSecKey
+	}
+	if coins > totalCoins {
+		return nil, fmt.Errorf("genesis address has only %d coins", totalCoins)
+	}
+
+	// half of the coin hours are burned as fee
+	txn.PushOutput(addr, coins, totalHours/4)
+	if totalCoins > coins {
+		txn.PushOutput(genesisAddr, totalCoins-coins, totalHours/4)
+	}
+	txn.SignInputs(keys)
+	txn.UpdateHeader()
+
+	if err := d.Gateway.InjectBroadcastTransaction(txn); err != nil {
+		return nil, err
+	}
+	return &txn, nil
+}
+
+// Equivocate makes the producer of the block seq in the chain of node i sign a second
+// block for the same slot and sends it to the peers of the producer. Returns the index
+// of the producer
+func (cl *Cluster) Equivocate(i int, seq uint64) (int, error) {
+	d := cl.Nodes[i].Daemon
+	if d == nil {
+		return 0, ErrNodeStopped
+	}
+	sb, err := d.Visor.GetSignedBlock(seq)
+	if err != nil {
+		return 0, err
+	}
+	if sb == nil {
+		return 0, fmt.Errorf("node %d has no block %d", i, seq)
+	}
+
+	pubkey, err := cipher.PubKeyFromSig(sb.Sig, sb.HashHeader())
+	if err != nil {
+		return 0, err
+	}
+	var producer *Node
+	for _, n := range cl.Nodes {
+		if n.PubKey == pubkey {
+			producer = n
+		}
+	}
+	if producer == nil {
+		return 0, fmt.Errorf("producer %s is not a node of the cluster", pubkey.Hex())
+	}
+	if producer.Daemon == nil {
+		return 0, ErrNodeStopped
+	}
+
+	b := sb.Block
+	b.Head.Fee++
+	psb := coin.PendingSignedBlock{
+		Block:   b,
+		Sig:     cipher.SignHash(b.HashHeader(), producer.SecKey),
+		Pending: true,
+	}
+	m := daemon.NewGivePendingBlockMessage([]coin.PendingSignedBlock{psb})
+	if err := producer.Daemon.Pool.Pool.BroadcastMessage(m); err != nil {
+		return 0, err
+	}
+	return producer.Index, nil
+}
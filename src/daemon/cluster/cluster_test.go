@@ -0,0 +1,320 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/consensus/dpos"
+	"github.com/samoslab/samos/src/daemon"
+	"github.com/samoslab/samos/src/testutil"
+)
+
+const waitTimeout = 20 * time.Second
+
+func newTestCluster(t *testing.T, n int) *Cluster {
+	c := NewConfig()
+	c.Nodes = n
+	cl, err := New(c)
+	require.NoError(t, err)
+	require.NoError(t, cl.Start())
+	require.NoError(t, cl.WaitConnected(waitTimeout))
+	return cl
+}
+
+// produceBlock spends at node i and advances the clock slot by slot until all nodes reach seq
+func produceBlock(t *testing.T, cl *Cluster, i int, seq uint64, nodes ...int) {
+	_, err := cl.Spend(i, testutil.MakeAddress(), 1e6)
+	require.NoError(t, err)
+
+	for s := 0; s < 3*cl.Config.Nodes; s++ {
+		cl.AdvanceSlots(1)
+		if cl.WaitHeadSeq(seq, 3*time.Second, nodes...) == nil {
+			return
+		}
+	}
+	t.Fatalf("nodes %v did not reach seq %d", nodes, seq)
+}
+
+func TestClusterProduceBlock(t *testing.T) {
+	cl := newTestCluster(t, 3)
+	defer cl.Shutdown()
+
+	for i := range cl.Nodes {
+		seq, err := cl.HeadSeq(i)
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), seq)
+		require.Equal(t, cl.Clock.Now().UTC(), cl.Nodes[i].Daemon.Visor.V.Now())
+	}
+
+	produceBlock(t, cl, 1, 1, 0, 1, 2)
+}
+
+func TestClusterKillRestart(t *testing.T) {
+	cl := newTestCluster(t, 3)
+	defer cl.Shutdown()
+
+	require.NoError(t, cl.Kill(2))
+	require.Equal(t, ErrNodeStopped, cl.Kill(2))
+	_, err := cl.HeadSeq(2)
+	require.Equal(t, ErrNodeStopped, err)
+
+	require.NoError(t, cl.StartNode(2))
+	require.Equal(t, ErrNodeRunning, cl.StartNode(2))
+	require.NoError(t, cl.WaitConnected(waitTimeout))
+
+	produceBlock(t, cl, 0, 1, 0, 1, 2)
+}
+
+func TestClusterPartition(t *testing.T) {
+	cl := newTestCluster(t, 3)
+	defer cl.Shutdown()
+
+	cl.Partition([]int{0, 1}, []int{2})
+	require.NoError(t, WaitFor(waitTimeout, func() bool {
+		n, err := cl.Connections(2)
+		return err == nil && n == 0
+	}))
+
+	cl.Heal()
+	require.NoError(t, cl.WaitConnected(waitTimeout))
+	n, err := cl.Connections(2)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	// a slow link still delivers the block
+	cl.Delay(0, 2, 50*time.Millisecond)
+	produceBlock(t, cl, 0, 1, 0, 1, 2)
+}
+
+func TestClusterCatchUp(t *testing.T) {
+	tt := []struct {
+		name         string
+		capabilities daemon.Capabilities
+	}{
+		{"headers first", daemon.DefaultCapabilities},
+		{"blocks", daemon.DefaultCapabilities &^ daemon.CapHeaders},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			cl := newTestCluster(t, 3)
+			defer cl.Shutdown()
+
+			require.NoError(t, cl.Kill(2))
+			produceBlock(t, cl, 0, 1, 0, 1)
+			produceBlock(t, cl, 1, 2, 0, 1)
+			produceBlock(t, cl, 0, 3, 0, 1)
+
+			// the restarted node downloads the blocks it missed, one per response
+			n := cl.Nodes[2]
+			n.config.Daemon.Capabilities = tc.capabilities
+			n.config.Visor.HeadersResponseCount = 1
+			n.config.Visor.BlocksResponseCount = 1
+			require.NoError(t, cl.StartNode(2))
+			require.NoError(t, cl.WaitConnected(waitTimeout))
+			require.NoError(t, cl.WaitHeadSeq(3, waitTimeout, 2))
+		})
+	}
+}
+
+// waitPending waits until the nodes have a pending block, returns the pending hash of the first one
+func waitPending(t *testing.T, cl *Cluster, nodes ...int) cipher.SHA256 {
+	var hash cipher.SHA256
+	require.NoError(t, WaitFor(waitTimeout, func() bool {
+		for _, i := range nodes {
+			hashes, err := cl.Pending(i)
+			if err != nil || len(hashes) == 0 {
+				return false
+			}
+			if i == nodes[0] {
+				hash = hashes[0]
+			}
+		}
+		return true
+	}))
+	return hash
+}
+
+func TestClusterViewChange(t *testing.T) {
+	cl := newTestCluster(t, 4)
+	defer cl.Shutdown()
+
+	// the producer of the slot is the only link between the other nodes, so they
+	// see its block and its prepare but not enough prepares to commit it
+	cl.AdvanceSlots(1)
+	p, err := cl.Producer(0)
+	require.NoError(t, err)
+	for a := range cl.Nodes {
+		for b := a + 1; b < len(cl.Nodes); b++ {
+			if a != p && b != p {
+				cl.Cut(a, b)
+			}
+		}
+	}
+	require.NoError(t, WaitFor(waitTimeout, func() bool {
+		for i := range cl.Nodes {
+			n, err := cl.Connections(i)
+			if err != nil || (i == p && n != 3) || (i != p && n != 1) {
+				return false
+			}
+		}
+		return true
+	}))
+
+	_, err = cl.Spend(p, testutil.MakeAddress(), 1e6)
+	require.NoError(t, err)
+	hash := waitPending(t, cl, 0, 1, 2, 3)
+
+	time.Sleep(time.Second)
+	for i := range cl.Nodes {
+		seq, err := cl.HeadSeq(i)
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), seq)
+	}
+
+	// the block is not committed in time, the nodes move to the next view through
+	// the producer and keep the block the producer committed
+	cl.Advance(time.Minute)
+	require.NoError(t, WaitFor(waitTimeout, func() bool {
+		for i := range cl.Nodes {
+			view, err := cl.View(i)
+			if err != nil || view != 1 {
+				return false
+			}
+		}
+		return true
+	}))
+	for i := range cl.Nodes {
+		hashes, err := cl.Pending(i)
+		require.NoError(t, err)
+		require.Equal(t, []cipher.SHA256{hash}, hashes)
+	}
+
+	// once the nodes hear each other the block is committed in the new view
+	cl.Heal()
+	require.NoError(t, cl.WaitConnected(waitTimeout))
+	require.NoError(t, cl.WaitHeadSeq(1, waitTimeout, 0, 1, 2, 3))
+	for i := range cl.Nodes {
+		h, err := cl.BlockHash(i, 1)
+		require.NoError(t, err)
+		require.Equal(t, hash, h)
+		view, err := cl.View(i)
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), view)
+	}
+
+	produceBlock(t, cl, p, 2, 0, 1, 2, 3)
+}
+
+func TestClusterFork(t *testing.T) {
+	cl := newTestCluster(t, 4)
+	defer cl.Shutdown()
+
+	cl.Partition([]int{0, 1, 2}, []int{3})
+	require.NoError(t, WaitFor(waitTimeout, func() bool {
+		n, err := cl.Connections(3)
+		return err == nil && n == 0
+	}))
+
+	// both sides spend the same genesis output, the isolated node proposes
+	// its spend in its slot but can not commit it alone
+	_, err := cl.Spend(3, testutil.MakeAddress(), 1e6)
+	require.Error(t, err)
+	require.Len(t, cl.Nodes[3].Daemon.Visor.UnconfirmedTxns(), 1)
+	_, err = cl.Spend(0, testutil.MakeAddress(), 2e6)
+	require.NoError(t, err)
+
+	var forked cipher.SHA256
+	for s := 0; s < 3*cl.Config.Nodes; s++ {
+		cl.AdvanceSlots(1)
+		err := WaitFor(3*time.Second, func() bool {
+			if hashes, err := cl.Pending(3); err == nil && len(hashes) > 0 {
+				forked = hashes[0]
+			}
+			return forked != cipher.SHA256{} && cl.WaitHeadSeq(1, 0, 0, 1, 2) == nil
+		})
+		if err == nil {
+			break
+		}
+	}
+	require.NotEqual(t, cipher.SHA256{}, forked, "the isolated node did not propose a block")
+
+	seq, err := cl.HeadSeq(3)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), seq)
+	hash, err := cl.BlockHash(0, 1)
+	require.NoError(t, err)
+	require.NotEqual(t, forked, hash)
+
+	// the isolated node drops its proposal and follows the committed chain
+	cl.Heal()
+	require.NoError(t, cl.WaitConnected(waitTimeout))
+	require.NoError(t, cl.WaitHeadSeq(1, waitTimeout, 3))
+	h, err := cl.BlockHash(3, 1)
+	require.NoError(t, err)
+	require.Equal(t, hash, h)
+	hashes, err := cl.Pending(3)
+	require.NoError(t, err)
+	require.Empty(t, hashes)
+
+	produceBlock(t, cl, 3, 2, 0, 1, 2, 3)
+}
+
+func TestClusterEquivocationExclusion(t *testing.T) {
+	cl := newTestCluster(t, 3)
+	defer cl.Shutdown()
+
+	// fund the trust node addresses, they pay the fee of the evidence transactions
+	for i := range cl.Nodes {
+		_, err := cl.Spend(0, cipher.AddressFromSecKey(cl.Nodes[i].SecKey), 1e12)
+		require.NoError(t, err)
+		seq := uint64(i + 1)
+		for s := 0; s < 3*len(cl.Nodes) && cl.WaitHeadSeq(seq, 3*time.Second, 0, 1, 2) != nil; s++ {
+			cl.AdvanceSlots(1)
+		}
+		require.NoError(t, cl.WaitHeadSeq(seq, waitTimeout, 0, 1, 2))
+	}
+
+	cheater, err := cl.Equivocate(0, 3)
+	require.NoError(t, err)
+
+	// the nodes detecting the double signing commit the evidence in a block
+	iv := dpos.Intervals{Block: cl.Config.BlockInterval, Epoch: cl.Config.EpochInterval}
+	var excludedFrom int64
+	var evidenceSeq uint64
+	for s := 0; s < 3*len(cl.Nodes) && excludedFrom == 0; s++ {
+		cl.AdvanceSlots(1)
+		_ = WaitFor(3*time.Second, func() bool {
+			head, err := cl.HeadSeq(0)
+			if err != nil {
+				return false
+			}
+			for seq := uint64(4); seq <= head; seq++ {
+				sb, err := cl.Nodes[0].Daemon.Visor.GetSignedBlock(seq)
+				if err != nil || sb == nil {
+					return false
+				}
+				for _, txn := range sb.Body.Transactions {
+					if txn.Type == coin.TxnTypeEvidence {
+						excludedFrom = iv.NextEpoch(int64(sb.Time()))
+						evidenceSeq = seq
+						return true
+					}
+				}
+			}
+			return false
+		})
+	}
+	require.NotZero(t, excludedFrom, "evidence was not committed")
+	require.NoError(t, cl.WaitHeadSeq(evidenceSeq, waitTimeout, 0, 1, 2))
+
+	for i := range cl.Nodes {
+		v := cl.Nodes[i].Daemon.Visor.V
+		require.Contains(t, v.TrustNodesAt(excludedFrom-1), cl.Nodes[cheater].PubKey)
+		require.NotContains(t, v.TrustNodesAt(excludedFrom), cl.Nodes[cheater].PubKey)
+	}
+}
@@ -0,0 +1,145 @@
+package cluster
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// link forwards the connections between two nodes, so the cluster can cut or
+// slow down the traffic between them
+type link struct {
+	mutex  sync.Mutex
+	ln     net.Listener
+	target string
+	cut    bool
+	delay  time.Duration
+	conns  map[net.Conn]struct{}
+	wg     sync.WaitGroup
+}
+
+func newLink(target string) (*link, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	l := &link{
+		ln:     ln,
+		target: target,
+		conns:  make(map[net.Conn]struct{}),
+	}
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		l.run()
+	}()
+	return l, nil
+}
+
+// Addr returns the address the dialing node connects to
+func (l *link) Addr() string {
+	return l.ln.Addr().String()
+}
+
+func (l *link) run() {
+	for {
+		in, err := l.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		if l.isCut() {
+			in.Close()
+			continue
+		}
+
+		out, err := net.Dial("tcp", l.target)
+		if err != nil {
+			in.Close()
+			continue
+		}
+
+		l.mutex.Lock()
+		l.conns[in] = struct{}{}
+		l.conns[out] = struct{}{}
+		l.mutex.Unlock()
+
+		l.wg.Add(2)
+		go func() {
+			defer l.wg.Done()
+			l.forward(out, in)
+		}()
+		go func() {
+			defer l.wg.Done()
+			l.forward(in, out)
+		}()
+	}
+}
+
+// forward copies src to dst until either is closed, delaying every chunk
+func (l *link) forward(dst, src net.Conn) {
+	defer l.drop(dst, src)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if d := l.getDelay(); d > 0 {
+				time.Sleep(d)
+			}
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (l *link) drop(conns ...net.Conn) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, c := range conns {
+		c.Close()
+		delete(l.conns, c)
+	}
+}
+
+func (l *link) isCut() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.cut
+}
+
+func (l *link) getDelay() time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.delay
+}
+
+// setCut cuts the link and closes its connections, or restores it
+func (l *link) setCut(cut bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.cut = cut
+	if !cut {
+		return
+	}
+	for c := range l.conns {
+		c.Close()
+		delete(l.conns, c)
+	}
+}
+
+func (l *link) setDelay(d time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.delay = d
+}
+
+// close stops the link and waits its connections to be closed
+func (l *link) close() {
+	l.ln.Close()
+	l.setCut(true)
+	l.wg.Wait()
+}
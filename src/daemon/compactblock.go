@@ -0,0 +1,409 @@
+package daemon
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/daemon/gnet"
+)
+
+var (
+	// ErrInvalidBlockTxns the transactions don't match the missing transactions of the compact block
+	ErrInvalidBlockTxns = errors.New("Invalid compact block transactions")
+	// ErrCompactBlockMismatch the rebuilt block does not match the BodyHash of its header
+	ErrCompactBlockMismatch = errors.New("Rebuilt compact block does not match its header")
+)
+
+// ShortTxID returns the short id of a transaction in a compact block. The
+// block hash salts it, so transactions colliding in one block don't collide
+// in the next one
+func ShortTxID(blockHash, txnHash cipher.SHA256) uint64 {
+	h := cipher.AddSHA256(blockHash, txnHash)
+	return binary.LittleEndian.Uint64(h[:8])
+}
+
+// ShortTxIDs returns the short ids of the transactions of the block
+func ShortTxIDs(b coin.SignedBlock) []uint64 {
+	hash := b.HashHeader()
+	ids := make([]uint64, len(b.Body.Transactions))
+	for i, txn := range b.Body.Transactions {
+		ids[i] = ShortTxID(hash, txn.Hash())
+	}
+	return ids
+}
+
+// CompactBlocks keeps the compact blocks waiting for their missing
+// transactions. It is only used from the daemon loop.
+type CompactBlocks struct {
+	blocks map[cipher.SHA256]*compactBlock
+}
+
+type compactBlock struct {
+	header coin.SignedBlockHeader
+	// transactions of the block, nil where missing
+	txns []*coin.Transaction
+	// indexes of the missing transactions
+	missing []uint64
+	// peer the missing transactions are requested from
+	addr string
+}
+
+// NewCompactBlocks creates CompactBlocks instance
+func NewCompactBlocks() *CompactBlocks {
+	return &CompactBlocks{
+		blocks: make(map[cipher.SHA256]*compactBlock),
+	}
+}
+
+// Len returns the number of compact blocks waiting for transactions
+func (cb *CompactBlocks) Len() int {
+	return len(cb.blocks)
+}
+
+// Has returns true if the block is waiting for transactions
+func (cb *CompactBlocks) Has(hash cipher.SHA256) bool {
+	_, ok := cb.blocks[hash]
+	return ok
+}
+
+// Rebuild rebuilds the block of the short ids from the known transactions.
+// Returns the block if no transaction is missing, otherwise the block waits
+// for the missing transactions requested from addr, whose indexes are returned.
+// A short id matching several known transactions counts as missing.
+func (cb *CompactBlocks) Rebuild(addr string, header coin.SignedBlockHeader, ids []uint64,
+	known coin.Transactions) (*coin.SignedBlock, []uint64, error) {
+	hash := header.Hash()
+
+	byID := make(map[uint64]*coin.Transaction, len(known))
+	collided := make(map[uint64]struct{})
+	for i := range known {
+		txnHash := known[i].Hash()
+		id := ShortTxID(hash, txnHash)
+		if txn, ok := byID[id]; ok && txn.Hash() != txnHash {
+			collided[id] = struct{}{}
+		}
+		byID[id] = &known[i]
+	}
+
+	txns := make([]*coin.Transaction, len(ids))
+	var missing []uint64
+	for i, id := range ids {
+		if _, ok := collided[id]; ok {
+			missing = append(missing, uint64(i))
+			continue
+		}
+
+		txn, ok := byID[id]
+		if !ok {
+			missing = append(missing, uint64(i))
+			continue
+		}
+		txns[i] = txn
+	}
+
+	if len(missing) > 0 {
+		cb.blocks[hash] = &compactBlock{
+			header:  header,
+			txns:    txns,
+			missing: missing,
+			addr:    addr,
+		}
+		return nil, missing, nil
+	}
+
+	b, err := toSignedBlock(header, txns)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, nil, nil
+}
+
+// AddTxns fills in the missing transactions of the block, which must come from
+// the peer they were requested from. The block is removed whether it could be
+// rebuilt or not.
+func (cb *CompactBlocks) AddTxns(addr string, hash cipher.SHA256, txns coin.Transactions) (*coin.SignedBlock, error) {
+	c, ok := cb.blocks[hash]
+	if !ok || c.addr != addr {
+		return nil, nil
+	}
+	delete(cb.blocks, hash)
+
+	if len(txns) != len(c.missing) {
+		return nil, ErrInvalidBlockTxns
+	}
+
+	for i, idx := range c.missing {
+		txn := txns[i]
+		c.txns[idx] = &txn
+	}
+
+	return toSignedBlock(c.header, c.txns)
+}
+
+// Prune drops the blocks that are not above the head block
+func (cb *CompactBlocks) Prune(headSeq uint64) {
+	for hash, c := range cb.blocks {
+		if c.header.Seq() <= headSeq {
+			delete(cb.blocks, hash)
+		}
+	}
+}
+
+// RemovePeer drops the blocks waiting for transactions from the peer
+func (cb *CompactBlocks) RemovePeer(addr string) {
+	for hash, c := range cb.blocks {
+		if c.addr == addr {
+			delete(cb.blocks, hash)
+		}
+	}
+}
+
+func toSignedBlock(header coin.SignedBlockHeader, txns []*coin.Transaction) (*coin.SignedBlock, error) {
+	body := coin.BlockBody{
+		Transactions: make(coin.Transactions, len(txns)),
+	}
+	for i, txn := range txns {
+		body.Transactions[i] = *txn
+	}
+
+	if body.Hash() != header.Header.BodyHash {
+		return nil, ErrCompactBlockMismatch
+	}
+
+	b := header.ToSignedBlock(body)
+	return &b, nil
+}
+
+// broadcastBlock sends the block to all connections, the peers that support
+// compact blocks get its header and short transaction ids only, since they
+// have most of its transactions in their unconfirmed pool already
+func (dm *Daemon) broadcastBlock(b coin.SignedBlock) error {
+	if dm.Visor.Config.DisableNetworking {
+		return nil
+	}
+
+	m := NewGiveBlocksMessage([]coin.SignedBlock{b})
+	if !dm.Config.Capabilities.Has(CapCompactBlocks) {
+		return dm.Pool.Pool.BroadcastMessage(m)
+	}
+
+	if err := dm.Pool.BroadcastMessage(NewCompactBlockMessage(b)); err != nil {
+		logger.Debugf("Broadcast CompactBlockMessage failed: %v", err)
+	}
+	return dm.Pool.BroadcastMessageWithout(m, CapCompactBlocks)
+}
+
+// executeBlock executes the block received from addr and announces our new head
+func (dm *Daemon) executeBlock(addr string, b coin.SignedBlock) {
+	if err := dm.Visor.ExecuteSignedBlock(b); err != nil {
+		logger.Critical().Errorf("Failed to execute compact block %d: %v", b.Seq(), err)
+		// the header was verified, fall back to downloading the whole block
+		if err := dm.Visor.RequestBlocksFromAddr(dm.Pool, addr); err != nil {
+			logger.Errorf("Request blocks from %s failed: %v", addr, err)
+		}
+		return
+	}
+	logger.Critical().Infof("Added new block %d", b.Seq())
+
+	m := NewAnnounceBlocksMessage(dm.Visor.HeadBkSeq())
+	if err := dm.Pool.Pool.BroadcastMessage(m); err != nil {
+		logger.Debugf("Broadcast AnnounceBlocksMessage failed: %v", err)
+	}
+}
+
+// CompactBlockMessage announces a new block with the short ids of its
+// transactions instead of the transactions
+type CompactBlockMessage struct {
+	Header   coin.SignedBlockHeader
+	ShortIDs []uint64
+	c        *gnet.MessageContext `enc:"-"`
+}
+
+// NewCompactBlockMessage creates CompactBlockMessage
+func NewCompactBlockMessage(b coin.SignedBlock) *CompactBlockMessage {
+	return &CompactBlockMessage{
+		Header:   b.SignedHeader(),
+		ShortIDs: ShortTxIDs(b),
+	}
+}
+
+// Handle handles message
+func (cbm *CompactBlockMessage) Handle(mc *gnet.MessageContext, daemon interface{}) error {
+	cbm.c = mc
+	return daemon.(*Daemon).recordMessageEvent(cbm, mc)
+}
+
+// Process rebuilds the block from the unconfirmed pool, and requests the
+// transactions missing from it
+func (cbm *CompactBlockMessage) Process(d *Daemon) {
+	if d.Visor.Config.DisableNetworking {
+		return
+	}
+
+	head, ok := d.Visor.HeadBlockHeader()
+	if !ok {
+		return
+	}
+
+	addr := cbm.c.Addr
+	seq := cbm.Header.Seq()
+	hash := cbm.Header.Hash()
+	d.compactBlocks.Prune(head.BkSeq)
+	if seq <= head.BkSeq || d.compactBlocks.Has(hash) {
+		return
+	}
+
+	d.Visor.RecordBlockchainHeight(addr, seq)
+
+	// we are behind, sync the blocks in between
+	if seq > head.BkSeq+1 {
+		if d.headersFirst(addr) {
+			d.requestHeaders(addr)
+		} else if err := d.Visor.RequestBlocksFromAddr(d.Pool, addr); err != nil {
+			logger.Errorf("Request blocks from %s failed: %v", addr, err)
+		}
+		return
+	}
+
+	if cbm.Header.Header.PrevHash != head.Hash() {
+		logger.Infof("Compact block %d from %s does not follow our head block", seq, addr)
+		return
+	}
+
+	if err := d.Visor.VerifyBlockHeader(cbm.Header); err != nil {
+		logger.Errorf("Received invalid compact block from %s: %v", addr, err)
+		if err := d.Pool.Pool.Disconnect(addr, ErrDisconnectInvalidBlock); err != nil {
+			logger.Errorf("Disconnect %s failed: %v", addr, err)
+		}
+		return
+	}
+
+	b, missing, err := d.compactBlocks.Rebuild(addr, cbm.Header, cbm.ShortIDs, d.Visor.UnconfirmedTxns())
+	if err != nil {
+		// a short id matched the wrong transaction
+		logger.Infof("Rebuild compact block %d failed: %v", seq, err)
+		if err := d.Visor.RequestBlocksFromAddr(d.Pool, addr); err != nil {
+			logger.Errorf("Request blocks from %s failed: %v", addr, err)
+		}
+		return
+	}
+
+	if b != nil {
+		d.executeBlock(addr, *b)
+		return
+	}
+
+	logger.Debugf("Compact block %d is missing %d of %d transactions", seq, len(missing), len(cbm.ShortIDs))
+	if err := d.Pool.Pool.SendMessage(addr, NewGetBlockTxnsMessage(hash, missing)); err != nil {
+		logger.Errorf("Send GetBlockTxnsMessage to %s failed: %v", addr, err)
+		d.compactBlocks.RemovePeer(addr)
+	}
+}
+
+// GetBlockTxnsMessage asks for the transactions of the block at the indexes
+type GetBlockTxnsMessage struct {
+	Hash    cipher.SHA256
+	Indexes []uint64
+	c       *gnet.MessageContext `enc:"-"`
+}
+
+// NewGetBlockTxnsMessage creates GetBlockTxnsMessage
+func NewGetBlockTxnsMessage(hash cipher.SHA256, indexes []uint64) *GetBlockTxnsMessage {
+	return &GetBlockTxnsMessage{
+		Hash:    hash,
+		Indexes: indexes,
+	}
+}
+
+// Handle handles message
+func (gbm *GetBlockTxnsMessage) Handle(mc *gnet.MessageContext, daemon interface{}) error {
+	gbm.c = mc
+	return daemon.(*Daemon).recordMessageEvent(gbm, mc)
+}
+
+// Process sends the requested transactions of the block
+func (gbm *GetBlockTxnsMessage) Process(d *Daemon) {
+	if d.Visor.Config.DisableNetworking {
+		return
+	}
+
+	b, err := d.Visor.GetSignedBlockByHash(gbm.Hash)
+	if err != nil || b == nil {
+		logger.Infof("Get block %s requested by %s failed: %v", gbm.Hash.Hex(), gbm.c.Addr, err)
+		return
+	}
+
+	txns := make(coin.Transactions, 0, len(gbm.Indexes))
+	for _, i := range gbm.Indexes {
+		if i >= uint64(len(b.Body.Transactions)) {
+			logger.Infof("%s requested transaction %d of block %d with %d transactions",
+				gbm.c.Addr, i, b.Seq(), len(b.Body.Transactions))
+			return
+		}
+		txns = append(txns, b.Body.Transactions[i])
+	}
+
+	if err := d.Pool.Pool.SendMessage(gbm.c.Addr, NewGiveBlockTxnsMessage(gbm.Hash, txns)); err != nil {
+		logger.Errorf("Send GiveBlockTxnsMessage to %s failed: %v", gbm.c.Addr, err)
+	}
+}
+
+// GiveBlockTxnsMessage sent in response to GetBlockTxnsMessage
+type GiveBlockTxnsMessage struct {
+	Hash cipher.SHA256
+	Txns coin.Transactions
+	c    *gnet.MessageContext `enc:"-"`
+}
+
+// NewGiveBlockTxnsMessage creates GiveBlockTxnsMessage
+func NewGiveBlockTxnsMessage(hash cipher.SHA256, txns coin.Transactions) *GiveBlockTxnsMessage {
+	return &GiveBlockTxnsMessage{
+		Hash: hash,
+		Txns: txns,
+	}
+}
+
+// Handle handles message
+func (gbm *GiveBlockTxnsMessage) Handle(mc *gnet.MessageContext, daemon interface{}) error {
+	gbm.c = mc
+	return daemon.(*Daemon).recordMessageEvent(gbm, mc)
+}
+
+// Process completes the compact block with the transactions and executes it
+func (gbm *GiveBlockTxnsMessage) Process(d *Daemon) {
+	if d.Visor.Config.DisableNetworking {
+		return
+	}
+
+	addr := gbm.c.Addr
+	b, err := d.compactBlocks.AddTxns(addr, gbm.Hash, gbm.Txns)
+	switch err {
+	case nil:
+	case ErrInvalidBlockTxns:
+		logger.Errorf("Received invalid compact block transactions from %s", addr)
+		if err := d.Pool.Pool.Disconnect(addr, ErrDisconnectInvalidBlock); err != nil {
+			logger.Errorf("Disconnect %s failed: %v", addr, err)
+		}
+		return
+	default:
+		// either a short id matched the wrong transaction of our pool or
+		// the peer sent the wrong ones, download the whole block
+		logger.Infof("Rebuild compact block %s failed: %v", gbm.Hash.Hex(), err)
+		if err := d.Visor.RequestBlocksFromAddr(d.Pool, addr); err != nil {
+			logger.Errorf("Request blocks from %s failed: %v", addr, err)
+		}
+		return
+	}
+
+	if b == nil {
+		return
+	}
+
+	if b.Seq() != d.Visor.HeadBkSeq()+1 {
+		return
+	}
+
+	d.executeBlock(addr, *b)
+}
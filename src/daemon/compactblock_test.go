@@ -0,0 +1,102 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/coin"
+)
+
+func TestShortTxID(t *testing.T) {
+	_, seckey := cipher.GenerateKeyPair()
+	blocks := makeHeaderChain(t, coin.BlockHeader{Time: 100, BkSeq: 5}, 2, seckey)
+
+	txn := blocks[0].Body.Transactions[0]
+	ids := ShortTxIDs(blocks[0])
+	require.Equal(t, []uint64{ShortTxID(blocks[0].HashHeader(), txn.Hash())}, ids)
+	// salted by the block hash
+	require.NotEqual(t, ids[0], ShortTxID(blocks[1].HashHeader(), txn.Hash()))
+}
+
+func TestCompactBlocksRebuild(t *testing.T) {
+	_, seckey := cipher.GenerateKeyPair()
+	head := coin.BlockHeader{Time: 100, BkSeq: 5}
+	b := makeHeaderChain(t, head, 1, seckey)[0]
+	other := makeHeaderChain(t, head, 1, seckey)[0]
+	b.Body.Transactions = append(b.Body.Transactions, other.Body.Transactions...)
+	b.Body.Transactions = append(b.Body.Transactions, makeHeaderChain(t, head, 1, seckey)[0].Body.Transactions...)
+	b.Head.BodyHash = b.Body.Hash()
+	b.Sig = cipher.SignHash(b.HashHeader(), seckey)
+	txns := b.Body.Transactions
+	hash := b.HashHeader()
+	ids := ShortTxIDs(b)
+
+	t.Run("all known", func(t *testing.T) {
+		cb := NewCompactBlocks()
+		// the same transaction twice is not a collision
+		known := coin.Transactions{txns[2], txns[0], txns[1], other.Body.Transactions[0]}
+		rb, missing, err := cb.Rebuild("a", b.SignedHeader(), ids, known)
+		require.NoError(t, err)
+		require.Empty(t, missing)
+		require.Equal(t, b, *rb)
+		require.Equal(t, 0, cb.Len())
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		cb := NewCompactBlocks()
+		rb, missing, err := cb.Rebuild("a", b.SignedHeader(), ids, coin.Transactions{txns[1]})
+		require.NoError(t, err)
+		require.Nil(t, rb)
+		require.Equal(t, []uint64{0, 2}, missing)
+		require.True(t, cb.Has(hash))
+
+		// only the peer the transactions were requested from
+		rb, err = cb.AddTxns("b", hash, coin.Transactions{txns[0], txns[2]})
+		require.NoError(t, err)
+		require.Nil(t, rb)
+
+		rb, err = cb.AddTxns("a", hash, coin.Transactions{txns[0], txns[2]})
+		require.NoError(t, err)
+		require.Equal(t, b, *rb)
+		require.Equal(t, 0, cb.Len())
+	})
+
+	t.Run("wrong number of txns", func(t *testing.T) {
+		cb := NewCompactBlocks()
+		_, _, err := cb.Rebuild("a", b.SignedHeader(), ids, nil)
+		require.NoError(t, err)
+
+		_, err = cb.AddTxns("a", hash, coin.Transactions{txns[0]})
+		require.Equal(t, ErrInvalidBlockTxns, err)
+		require.Equal(t, 0, cb.Len())
+	})
+
+	t.Run("wrong txns", func(t *testing.T) {
+		cb := NewCompactBlocks()
+		_, _, err := cb.Rebuild("a", b.SignedHeader(), ids, coin.Transactions{txns[1]})
+		require.NoError(t, err)
+
+		_, err = cb.AddTxns("a", hash, coin.Transactions{txns[2], txns[0]})
+		require.Equal(t, ErrCompactBlockMismatch, err)
+	})
+
+	t.Run("prune", func(t *testing.T) {
+		cb := NewCompactBlocks()
+		_, _, err := cb.Rebuild("a", b.SignedHeader(), ids, nil)
+		require.NoError(t, err)
+
+		cb.Prune(head.BkSeq)
+		require.True(t, cb.Has(hash))
+		cb.Prune(b.Seq())
+		require.False(t, cb.Has(hash))
+
+		_, _, err = cb.Rebuild("a", b.SignedHeader(), ids, nil)
+		require.NoError(t, err)
+		cb.RemovePeer("b")
+		require.True(t, cb.Has(hash))
+		cb.RemovePeer("a")
+		require.False(t, cb.Has(hash))
+	})
+}
@@ -3,6 +3,7 @@ package daemon
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"reflect"
 	"runtime/debug"
 	"sync"
@@ -16,7 +17,6 @@ import (
 	"github.com/samoslab/samos/src/util/elapse"
 	"github.com/samoslab/samos/src/util/iputil"
 	"github.com/samoslab/samos/src/util/logging"
-	"github.com/samoslab/samos/src/util/utc"
 )
 
 /*
@@ -30,6 +30,8 @@ Todo
 var (
 	// ErrDisconnectReasons invalid version
 	ErrDisconnectInvalidVersion gnet.DisconnectReason = errors.New("Invalid version")
+	// ErrDisconnectInvalidAgreeNum different agree num
+	ErrDisconnectInvalidAgreeNum gnet.DisconnectReason = errors.New("Invalid agree num")
 	// ErrDisconnectIntroductionTimeout timeout
 	ErrDisconnectIntroductionTimeout gnet.DisconnectReason = errors.New("Version timeout")
 	// ErrDisconnectVersionSendFailed version send failed
@@ -46,11 +48,26 @@ var (
 	ErrDisconnectNoIntroduction gnet.DisconnectReason = errors.New("First message was not an Introduction")
 	// ErrDisconnectIPLimitReached ip limit reached
 	ErrDisconnectIPLimitReached gnet.DisconnectReason = errors.New("Maximum number of connections for this IP was reached")
+	// ErrDisconnectInvalidBlock sent a block that can't be executed on our chain
+	ErrDisconnectInvalidBlock gnet.DisconnectReason = errors.New("Invalid block")
+	// ErrDisconnectGetBlocksSpam repeated the same GetBlocksMessage too often
+	ErrDisconnectGetBlocksSpam gnet.DisconnectReason = errors.New("Too many repeated block requests")
 	// ErrDisconnectOtherError this is returned when a seemingly impossible error is encountered
 	// e.g. net.Conn.Addr() returns an invalid ip:port
 	ErrDisconnectOtherError gnet.DisconnectReason = errors.New("Incomprehensible error")
 
 	logger = logging.MustGetLogger("daemon")
+
+	// misbehaviourScores the scores added to the misbehaviour score of a peer
+	// disconnected for these reasons, pex bans the peer once it reaches the ban score
+	misbehaviourScores = map[gnet.DisconnectReason]int{
+		gnet.ErrDisconnectInvalidMessageLength: 50,
+		gnet.ErrDisconnectMalformedMessage:     50,
+		gnet.ErrDisconnectUnknownMessage:       10,
+		ErrDisconnectInvalidBlock:              20,
+		ErrDisconnectGetBlocksSpam:             50,
+		gnet.ErrDisconnectRateLimited:          20,
+	}
 )
 
 const (
@@ -98,6 +115,10 @@ func (cfg *Config) preprocess() Config {
 	}
 	config.Pool.port = config.Daemon.Port
 	config.Pool.address = config.Daemon.Address
+	config.Pool.identityKey = config.Visor.Config.BlockchainTrustSeckey
+	config.Pool.connectionRateLimit = config.Messages.ConnectionRateLimit
+	config.Pool.messageRateLimits = config.Messages.RateLimits()
+	config.Pool.maxRateViolations = config.Messages.MaxRateViolations
 
 	if config.Daemon.DisableNetworking {
 		logger.Info("Networking is disabled")
@@ -123,6 +144,10 @@ func (cfg *Config) preprocess() Config {
 type DaemonConfig struct { // nolint: golint
 	// Application version. TODO -- manage version better
 	Version int32
+	// Lowest version of a peer we stay connected to
+	MinVersion int32
+	// Optional messages we handle, advertised to the peers
+	Capabilities Capabilities
 	// IP Address to serve on. Leave empty for automatic assignment
 	Address string
 	// TCP/UDP port for connections
@@ -154,12 +179,19 @@ type DaemonConfig struct { // nolint: golint
 	LocalhostOnly bool
 	// Log ping and pong messages
 	LogPings bool
+	// How many times a peer may repeat a GetBlocksMessage within
+	// GetBlocksRepeatWindow before it is disconnected, 0 means no limit
+	GetBlocksRepeatMax int
+	// Window the repeated GetBlocksMessages are counted in
+	GetBlocksRepeatWindow time.Duration
 }
 
 // NewDaemonConfig creates daemon config
 func NewDaemonConfig() DaemonConfig {
 	return DaemonConfig{
-		Version:                    2,
+		Version:                    3,
+		MinVersion:                 2,
+		Capabilities:               DefaultCapabilities,
 		Address:                    "",
 		Port:                       6677,
 		OutgoingRate:               time.Second * 5,
@@ -174,6 +206,8 @@ func NewDaemonConfig() DaemonConfig {
 		DisableIncomingConnections: false,
 		LocalhostOnly:              false,
 		LogPings:                   true,
+		GetBlocksRepeatMax:         30,
+		GetBlocksRepeatWindow:      time.Minute,
 	}
 }
 
@@ -202,6 +236,8 @@ type Daemon struct {
 	// connections (one to their listener, and one to our listener)
 	// Maps from addr to mirror value
 	connectionMirrors *ConnectionMirrors
+	// Capabilities advertised by the peer of each connection
+	peerCapabilities *PeerCapabilities
 	// Maps from mirror value to a map of ip (no port)
 	// We use a map of ip as value because multiple peers can have the same
 	// mirror (to avoid attacks enabled by our use of mirrors),
@@ -216,6 +252,14 @@ type Daemon struct {
 	// Tracking connections from the same base IP.  Multiple connections
 	// from the same base IP are allowed but limited.
 	ipCounts *IPCount
+	// Repeated block requests of each connection
+	getBlocksRequests *GetBlocksRequests
+	// Round-trip times of the pings of each connection
+	pingTimes *PingTimes
+	// State of the headers-first block sync
+	headerSync *HeaderSync
+	// Compact blocks waiting for their missing transactions
+	compactBlocks *CompactBlocks
 	// Message handling queue
 	messageEvents chan MessageEvent
 	// quit channel
@@ -247,8 +291,13 @@ func NewDaemon(config Config, db *bolt.DB, defaultConns []string) (*Daemon, erro
 
 		expectingIntroductions: NewExpectIntroductions(),
 		connectionMirrors:      NewConnectionMirrors(),
+		peerCapabilities:       NewPeerCapabilities(),
 		mirrorConnections:      NewMirrorConnections(),
 		ipCounts:               NewIPCount(),
+		getBlocksRequests:      NewGetBlocksRequests(),
+		pingTimes:              NewPingTimes(),
+		headerSync:             NewHeaderSync(),
+		compactBlocks:          NewCompactBlocks(),
 		// TODO -- if there are performance problems from blocking chans,
 		// Its because we are connecting to more things than OutgoingMax
 		// if we have private peers
@@ -369,6 +418,7 @@ func (dm *Daemon) Run() error {
 	unconfirmedRemoveInvalidTicker := time.Tick(dm.Visor.Config.Config.UnconfirmedRemoveInvalidRate)
 	blocksRequestTicker := time.Tick(dm.Visor.Config.BlocksRequestRate)
 	blocksAnnounceTicker := time.Tick(dm.Visor.Config.BlocksAnnounceRate)
+	bodiesRequestTicker := time.Tick(dm.Visor.Config.BodiesRequestRate)
 	TrustNodeRequestTicker := time.Tick(dm.Visor.Config.TrustNodeRequestRate)
 	TrustNodeAnnounceTicker := time.Tick(dm.Visor.Config.TrustNodeAnnounceRate)
 
@@ -513,7 +563,7 @@ loop:
 			elapser.Register("blockCreationTicker.C")
 			if dm.Visor.Config.Config.IsMaster {
 				dm.Visor.v.RemoveUnconfirmBlock()
-				should, err := dm.Visor.InTurnTheNode(utc.UnixNow())
+				should, err := dm.Visor.InTurnTheNode(dm.Visor.v.Now().Unix())
 				if err != nil || !should {
 					logger.Infof("slot not for this node: %v", err)
 					continue
@@ -528,19 +578,13 @@ loop:
 					logger.Errorf("Failed to create block: %v", err)
 					continue
 				}
-				err = CanMakeBlock(dm, sb.HashHeader())
-				if err != nil {
-					continue
-				}
-				err = dm.Visor.broadcastBlock(sb.ToSignedBlock(), dm.Pool)
-				if err != nil {
-					logger.Errorf("broadcast block %s failed", sb.HashHeader())
-					continue
-				}
+				// The block is broadcast once AgreeNum validators committed it,
+				// peers would execute it without pbft otherwise
+				executeCommittedBlock(dm, sb.HashHeader())
 
 				// Not a critical error, but we want it visible in logs
 				head := sb.Block.Head
-				logger.Critical().Infof("Created and Broadcast a new block, version=%d seq=%d time=%d", head.Version, head.BkSeq, head.Time)
+				logger.Critical().Infof("Created and proposed a new block, version=%d seq=%d time=%d", head.Version, head.BkSeq, head.Time)
 			}
 
 		case <-genesisBroadcastTicker.C:
@@ -574,7 +618,13 @@ loop:
 
 		case <-blocksRequestTicker:
 			elapser.Register("blocksRequestTicker")
-			dm.Visor.RequestBlocks(dm.Pool)
+			dm.requestBlocks()
+
+		case <-bodiesRequestTicker:
+			elapser.Register("bodiesRequestTicker")
+			if !dm.Visor.Config.DisableNetworking {
+				dm.requestBodies()
+			}
 
 		case <-blocksAnnounceTicker:
 			elapser.Register("blocksAnnounceTicker")
@@ -590,6 +640,7 @@ loop:
 		case <-PrepareRequestTicker:
 			elapser.Register("PrepareRequestTicker")
 			dm.Visor.RequestPrepare(dm.Pool)
+			dm.Visor.RequestViewChange(dm.Pool)
 
 		case <-AgressNodeNumRequestTicker:
 			elapser.Register("AgreeNodeNumRequestTicker")
@@ -739,7 +790,7 @@ func (dm *Daemon) handleConnectionError(c ConnectionError) {
 func (dm *Daemon) cullInvalidConnections() {
 	// This method only handles the erroneous people from the DHT, but not
 	// malicious nodes
-	now := utc.Now()
+	now := dm.Visor.v.Now()
 	addrs, err := dm.expectingIntroductions.CullInvalidConns(
 		func(addr string, t time.Time) (bool, error) {
 			conned, err := dm.Pool.Pool.IsConnExist(addr)
@@ -847,6 +898,12 @@ func (dm *Daemon) onConnect(e ConnectEvent) {
 		return
 	}
 
+	if dm.Pex.IsBanned(a) {
+		logger.Infof("%s is banned, disconnecting", a)
+		dm.Pool.Pool.Disconnect(a, ErrDisconnectIsBlacklisted)
+		return
+	}
+
 	if dm.ipCountMaxed(a) {
 		logger.Infof("Max connections for %s reached, disconnecting", a)
 		dm.Pool.Pool.Disconnect(a, ErrDisconnectIPLimitReached)
@@ -859,9 +916,10 @@ func (dm *Daemon) onConnect(e ConnectEvent) {
 		dm.outgoingConnections.Add(a)
 	}
 
-	dm.expectingIntroductions.Add(a, utc.Now())
+	dm.expectingIntroductions.Add(a, dm.Visor.v.Now())
 	logger.Debugf("Sending introduction message to %s, mirror:%d", a, dm.Messages.Mirror)
-	m := NewIntroductionMessage(dm.Messages.Mirror, dm.Config.Version, dm.Pool.Pool.Config.Port)
+	m := NewIntroductionMessage(dm.Messages.Mirror, dm.Config.Version, dm.Pool.Pool.Config.Port,
+		int32(dm.Visor.Config.Config.AgreeNum), dm.Config.Capabilities)
 	if err := dm.Pool.Pool.SendMessage(a, m); err != nil {
 		logger.Errorf("Send IntroductionMessage to %s failed: %v", a, err)
 	}
@@ -870,11 +928,39 @@ func (dm *Daemon) onConnect(e ConnectEvent) {
 func (dm *Daemon) onDisconnect(e DisconnectEvent) {
 	logger.Infof("%s disconnected because: %v", e.Addr, e.Reason)
 
+	if score, ok := misbehaviourScores[e.Reason]; ok {
+		dm.misbehave(e.Addr, score)
+	}
+
 	dm.outgoingConnections.Remove(e.Addr)
 	dm.expectingIntroductions.Remove(e.Addr)
 	dm.Visor.RemoveConnection(e.Addr)
 	dm.removeIPCount(e.Addr)
 	dm.removeConnectionMirror(e.Addr)
+	dm.peerCapabilities.Remove(e.Addr)
+	dm.getBlocksRequests.Remove(e.Addr)
+	dm.pingTimes.Remove(e.Addr)
+	dm.headerSync.RemovePeer(e.Addr)
+	dm.compactBlocks.RemovePeer(e.Addr)
+}
+
+// misbehave adds score to the misbehaviour score of the peer of the connection.
+// The listening address is scored if the peer told it, otherwise the address of
+// the connection is. Either way a ban covers every port of the peer's ip.
+func (dm *Daemon) misbehave(addr string, score int) {
+	a := addr
+	if port := dm.GetListenPort(addr); port != 0 {
+		ip, _, err := iputil.SplitAddr(addr)
+		if err != nil {
+			logger.Errorf("misbehave called with invalid addr: %v", err)
+			return
+		}
+		a = fmt.Sprintf("%s:%d", ip, port)
+	}
+
+	if dm.Pex.Misbehave(a, score) {
+		logger.Infof("Banned %s for misbehaving", a)
+	}
 }
 
 // Triggered when an gnet.Connection terminates
@@ -978,6 +1064,8 @@ func (dm *Daemon) handleMessageSendResult(r gnet.SendResult) {
 	switch r.Message.(type) {
 	case SendingTxnsMessage:
 		dm.Visor.SetTxnsAnnounced(r.Message.(SendingTxnsMessage).GetTxns())
+	case *PingMessage:
+		dm.pingTimes.Sent(r.Addr, dm.Visor.v.Now())
 	default:
 	}
 }
@@ -5,8 +5,9 @@ import (
 
 	"github.com/samoslab/samos/src/cipher"
 	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/consensus/dpos"
+	"github.com/samoslab/samos/src/consensus/pbft"
 	"github.com/samoslab/samos/src/daemon/strand"
-	"github.com/samoslab/samos/src/util/utc"
 	"github.com/samoslab/samos/src/visor"
 	"github.com/samoslab/samos/src/wallet"
 
@@ -120,6 +121,24 @@ func (gw *Gateway) GetExchgConnection() []string {
 	return conn
 }
 
+// GetBannedPeers returns the peers banned for misbehaving
+func (gw *Gateway) GetBannedPeers() *BannedPeers {
+	var peers *BannedPeers
+	gw.strand("GetBannedPeers", func() {
+		peers = gw.drpc.GetBannedPeers(gw.d)
+	})
+	return peers
+}
+
+// UnbanPeer lifts the ban of a peer
+func (gw *Gateway) UnbanPeer(addr string) error {
+	var err error
+	gw.strand("UnbanPeer", func() {
+		err = gw.drpc.UnbanPeer(gw.d, addr)
+	})
+	return err
+}
+
 /* Blockchain & Transaction status */
 
 // GetBlockchainProgress returns a *BlockchainProgress
@@ -453,16 +472,18 @@ func (gw *Gateway) GetAddrUxOuts(addresses []cipher.Address) ([]*historydb.UxOut
 
 // GetTimeNow returns the current Unix time
 func (gw *Gateway) GetTimeNow() uint64 {
-	return uint64(utc.UnixNow())
+	return uint64(gw.d.Visor.v.Now().Unix())
 }
 
-// GetAllUnconfirmedTxns returns all unconfirmed transactions
-func (gw *Gateway) GetAllUnconfirmedTxns() []visor.UnconfirmedTxn {
-	var txns []visor.UnconfirmedTxn
+// GetAllUnconfirmedTxns returns all unconfirmed transactions with their fees,
+// in the order they are picked for a block
+func (gw *Gateway) GetAllUnconfirmedTxns() ([]visor.UnconfirmedTxnFee, error) {
+	var txns []visor.UnconfirmedTxnFee
+	var err error
 	gw.strand("GetAllUnconfirmedTxns", func() {
-		txns = gw.v.GetAllUnconfirmedTxns()
+		txns, err = gw.v.GetAllUnconfirmedTxnsByFee()
 	})
-	return txns
+	return txns, err
 }
 
 // GetUnconfirmedTxns returns addresses related unconfirmed transactions
@@ -505,6 +526,15 @@ func (sv spendValidator) HasUnconfirmedSpendTx(addr []cipher.Address) (bool, err
 	return len(aux) > 0, nil
 }
 
+func (sv spendValidator) GetUnconfirmedTxn(hash cipher.SHA256) (*coin.Transaction, bool) {
+	ux, ok := sv.uncfm.Get(hash)
+	if !ok {
+		return nil, false
+	}
+
+	return &ux.Txn, true
+}
+
 // Spend spends coins from given wallet and broadcast it,
 // set password as nil if wallet is not encrypted, otherwise the password must be provied.
 // return transaction or error.
@@ -819,35 +849,38 @@ func (gw *Gateway) GetBuildInfo() visor.BuildInfo {
 	return bi
 }
 
-// GetRichlist returns rich list as desc order.
-func (gw *Gateway) GetRichlist(includeDistribution bool) (visor.Richlist, error) {
-	rbOuts, err := gw.GetUnspentOutputs()
-	if err != nil {
-		return nil, err
-	}
-
-	allAccounts, err := rbOuts.AggregateUnspentOutputs()
-	if err != nil {
-		return nil, err
-	}
-
+// GetRichlist returns the topn richest addresses as desc order, all of them if topn <= 0.
+// The addresses are read from the unspent outputs index ordered by coins
+func (gw *Gateway) GetRichlist(topn int, includeDistribution bool) (visor.Richlist, error) {
 	lockedAddrs := visor.GetLockedDistributionAddresses()
 	addrsMap := make(map[string]struct{}, len(lockedAddrs))
 	for _, a := range lockedAddrs {
 		addrsMap[a] = struct{}{}
 	}
 
-	richlist, err := visor.NewRichlist(allAccounts, addrsMap)
+	var skip []string
+	if !includeDistribution {
+		skip = append(skip, lockedAddrs...)
+		skip = append(skip, visor.GetUnlockedDistributionAddresses()...)
+	}
+
+	var accounts map[string]uint64
+	var err error
+	gw.strand("GetRichlist", func() {
+		accounts, err = gw.v.GetTopAddressCoins(topn, skip)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	if !includeDistribution {
-		unlockedAddrs := visor.GetUnlockedDistributionAddresses()
-		for _, a := range unlockedAddrs {
-			addrsMap[a] = struct{}{}
-		}
-		richlist = richlist.FilterAddresses(addrsMap)
+	richlist, err := visor.NewRichlist(accounts, addrsMap)
+	if err != nil {
+		return nil, err
+	}
+
+	// the addresses tied with the last one are ordered by the richlist
+	if topn > 0 && topn < len(richlist) {
+		richlist = richlist[:topn]
 	}
 
 	return richlist, nil
@@ -855,17 +888,83 @@ func (gw *Gateway) GetRichlist(includeDistribution bool) (visor.Richlist, error)
 
 // GetAddressCount returns count number of unique address with uxouts > 0.
 func (gw *Gateway) GetAddressCount() (uint64, error) {
-	rbOuts, err := gw.GetUnspentOutputs()
-	if err != nil {
-		return 0, err
-	}
+	var n uint64
+	gw.strand("GetAddressCount", func() {
+		n = gw.v.GetAddressCount()
+	})
+	return n, nil
+}
 
-	allAccounts, err := rbOuts.AggregateUnspentOutputs()
-	if err != nil {
-		return 0, err
-	}
+// GetEvidence returns the double signing evidence of trust nodes
+func (gw *Gateway) GetEvidence() ([]visor.ReadableEvidence, error) {
+	var evidences []visor.ReadableEvidence
+	var err error
+	gw.strand("GetEvidence", func() {
+		var es []pbft.Evidence
+		es, err = gw.v.GetEvidence()
+		if err != nil {
+			return
+		}
+
+		evidences = make([]visor.ReadableEvidence, 0, len(es))
+		for _, e := range es {
+			evidences = append(evidences, visor.NewReadableEvidence(e))
+		}
+	})
+
+	return evidences, err
+}
+
+// GetProducerSchedule returns the producers of the next n slots of the current epoch
+func (gw *Gateway) GetProducerSchedule(n int) ([]visor.ReadableSlotProducer, error) {
+	var schedule []visor.ReadableSlotProducer
+	var err error
+	gw.strand("GetProducerSchedule", func() {
+		var sps []dpos.SlotProducer
+		sps, err = gw.v.GetProducerSchedule(n)
+		if err != nil {
+			return
+		}
+
+		schedule = make([]visor.ReadableSlotProducer, 0, len(sps))
+		for _, sp := range sps {
+			schedule = append(schedule, visor.NewReadableSlotProducer(sp))
+		}
+	})
+
+	return schedule, err
+}
+
+// GetValidatorStats returns the slots the validators produced and missed in the epoch
+// containing ts, ts 0 selects the epoch of the head block
+func (gw *Gateway) GetValidatorStats(ts int64) (*visor.ReadableValidatorStats, error) {
+	var stats *visor.ReadableValidatorStats
+	var err error
+	gw.strand("GetValidatorStats", func() {
+		var epoch int64
+		var ss []dpos.SlotStats
+		epoch, ss, err = gw.v.GetValidatorStats(ts)
+		if err != nil {
+			return
+		}
+
+		rs := visor.NewReadableValidatorStats(epoch, ss)
+		stats = &rs
+	})
+
+	return stats, err
+}
+
+// ExportSnapshot returns the state at the finalized block signed by the trust node,
+// new nodes start the blockchain from it
+func (gw *Gateway) ExportSnapshot() (*visor.Snapshot, error) {
+	var s *visor.Snapshot
+	var err error
+	gw.strand("ExportSnapshot", func() {
+		s, err = gw.v.ExportSnapshot()
+	})
 
-	return uint64(len(allAccounts)), nil
+	return s, err
 }
 
 // Health is returned by the /health endpoint
@@ -2,7 +2,6 @@ package gnet
 
 import (
 	"errors"
-	"fmt"
 	"net"
 	"reflect"
 	"time"
@@ -25,23 +24,33 @@ func newSendResult(addr string, m Message, err error) SendResult {
 	}
 }
 
-// Serializes a Message over a net.Conn
-func sendMessage(conn net.Conn, msg Message, timeout time.Duration) error {
+// Serializes a Message over a net.Conn, returns the number of bytes sent
+func sendMessage(conn net.Conn, msg Message, timeout time.Duration) (int, error) {
 	m := EncodeMessage(msg)
-	return sendByteMessage(conn, m, timeout)
+	if err := sendByteMessage(conn, m, timeout); err != nil {
+		return 0, err
+	}
+	return len(m), nil
+}
+
+// messagePrefixOf returns the prefix msg is registered with
+func messagePrefixOf(msg Message) MessagePrefix {
+	return MessageIDMap[reflect.ValueOf(msg).Elem().Type()]
 }
 
 // Event handler that is called after a Connection sends a complete message
 func convertToMessage(id int, msg []byte, debugPrint bool) (Message, error) {
 	msgID := [4]byte{}
 	if len(msg) < len(msgID) {
-		return nil, errors.New("Not enough data to read msg id")
+		logger.Debugf("Not enough data to read msg id from connection %d", id)
+		return nil, ErrDisconnectMalformedMessage
 	}
 	copy(msgID[:], msg[:len(msgID)])
 	msg = msg[len(msgID):]
 	t, succ := MessageIDReverseMap[msgID]
 	if !succ {
-		return nil, fmt.Errorf("Unknown message %s received", string(msgID[:]))
+		logger.Debugf("Unknown message %s received from connection %d", string(msgID[:]), id)
+		return nil, ErrDisconnectUnknownMessage
 	}
 
 	if debugPrint {
@@ -53,10 +62,12 @@ func convertToMessage(id int, msg []byte, debugPrint bool) (Message, error) {
 	//logger.Debugf("Giving %d bytes to the decoder", len(msg))
 	used, err := deserializeMessage(msg, v)
 	if err != nil {
-		return nil, err
+		logger.Debugf("Decode %v from connection %d failed: %v", t, id, err)
+		return nil, ErrDisconnectMalformedMessage
 	}
 	if used != len(msg) {
-		return nil, errors.New("Data buffer was not completely decoded")
+		logger.Debugf("Data buffer of %v from connection %d was not completely decoded", t, id)
+		return nil, ErrDisconnectMalformedMessage
 	}
 
 	m, succ = (v.Interface()).(Message)
@@ -45,8 +45,7 @@ func TestConvertToMessageNoMessageId(t *testing.T) {
 	b := []byte{}
 	m, err := convertToMessage(c.ID, b, testing.Verbose())
 	assert.Nil(t, m)
-	assert.NotNil(t, err)
-	assert.Equal(t, err.Error(), "Not enough data to read msg id")
+	assert.Equal(t, ErrDisconnectMalformedMessage, err)
 }
 
 func TestConvertToMessageUnknownMessage(t *testing.T) {
@@ -55,8 +54,7 @@ func TestConvertToMessageUnknownMessage(t *testing.T) {
 	c := &Connection{}
 	b := MessagePrefix{'C', 'C', 'C', 'C'}
 	m, err := convertToMessage(c.ID, b[:], testing.Verbose())
-	assert.NotNil(t, err)
-	assert.Equal(t, err.Error(), "Unknown message CCCC received")
+	assert.Equal(t, ErrDisconnectUnknownMessage, err)
 	assert.Nil(t, m)
 }
 
@@ -70,15 +68,13 @@ func TestConvertToMessageBadDeserialize(t *testing.T) {
 	// Test with too many bytes
 	b := append(DummyPrefix[:], []byte{0, 1, 1, 1}...)
 	m, err := convertToMessage(c.ID, b, testing.Verbose())
-	assert.NotNil(t, err)
-	assert.Equal(t, err.Error(), "Data buffer was not completely decoded")
+	assert.Equal(t, ErrDisconnectMalformedMessage, err)
 	assert.Nil(t, m)
 
 	// Test with not enough bytes
 	b = append([]byte{}, BytePrefix[:]...)
 	m, err = convertToMessage(c.ID, b, testing.Verbose())
-	assert.NotNil(t, err)
-	assert.Equal(t, err.Error(), "Deserialization failed")
+	assert.Equal(t, ErrDisconnectMalformedMessage, err)
 	assert.Nil(t, m)
 }
 
@@ -166,8 +162,10 @@ func TestSendMessage(t *testing.T) {
 		assert.True(t, bytes.Equal(msg, expect))
 		return nil
 	}
-	err := sendMessage(nil, m, 0)
+	n, err := sendMessage(nil, m, 0)
 	assert.Nil(t, err)
+	assert.Equal(t, 9, n)
+	assert.Equal(t, BytePrefix, messagePrefixOf(m))
 }
 
 /* Helpers */
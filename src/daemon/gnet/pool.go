@@ -12,6 +12,7 @@ import (
 
 	"io"
 
+	"github.com/samoslab/samos/src/cipher"
 	"github.com/samoslab/samos/src/cipher/encoder"
 	"github.com/samoslab/samos/src/daemon/strand"
 
@@ -46,6 +47,8 @@ var (
 	ErrDisconnectUnknownMessage DisconnectReason = errors.New("Unknown message ID")
 	// ErrDisconnectWriteQueueFull write queue is full
 	ErrDisconnectWriteQueueFull DisconnectReason = errors.New("Write queue full")
+	// ErrDisconnectRateLimited sent more messages than the rate limits allow too many times
+	ErrDisconnectRateLimited DisconnectReason = errors.New("Message rate limit exceeded")
 	// ErrDisconnectUnexpectedError  unexpected error
 	ErrDisconnectUnexpectedError DisconnectReason = errors.New("Unexpected error encountered")
 	// ErrConnectionPoolClosed error message indicates the connection pool is closed
@@ -84,6 +87,21 @@ type Config struct {
 	ConnectCallback ConnectCallback
 	// Print debug logs
 	DebugPrint bool
+	// Encrypt and authenticate all traffic, peers must enable it too
+	Encrypt bool
+	// Identity key proved to peers in the encrypted handshake, set for trust nodes
+	IdentityKey cipher.SecKey
+	// Keys that the peers at these addresses must prove when connecting to them
+	PinnedKeys map[string]cipher.PubKey
+	// Timeout for the encrypted handshake. Set to 0 to ignore timeout
+	HandshakeTimeout time.Duration
+	// Limits the messages received from each connection, zero is unlimited
+	ConnectionRateLimit RateLimit
+	// Limits the messages of a prefix received from each connection
+	MessageRateLimits map[MessagePrefix]RateLimit
+	// Messages beyond the rate limits are dropped, the connection is
+	// disconnected once more than MaxRateViolations are dropped
+	MaxRateViolations int
 }
 
 // NewConfig returns a Config with defaults set
@@ -101,6 +119,11 @@ func NewConfig() Config {
 		DisconnectCallback:       nil,
 		ConnectCallback:          nil,
 		DebugPrint:               false,
+		Encrypt:                  false,
+		PinnedKeys:               make(map[string]cipher.PubKey),
+		HandshakeTimeout:         time.Second * 10,
+		MessageRateLimits:        make(map[MessagePrefix]RateLimit),
+		MaxRateViolations:        20,
 	}
 }
 
@@ -109,6 +132,12 @@ const (
 	messageLengthSize = 4
 )
 
+// MessageStats counts the messages of a prefix and their bytes on the wire
+type MessageStats struct {
+	Messages uint64
+	Bytes    uint64
+}
+
 // Connection is stored by the ConnectionPool
 type Connection struct {
 	// Key in ConnectionPool.Pool
@@ -123,22 +152,44 @@ type Connection struct {
 	LastReceived time.Time
 	// Last time a message was sent to the connection
 	LastSent time.Time
+	// When the connection was made
+	ConnectedAt time.Time
+	// Messages sent and received by prefix
+	Sent     map[MessagePrefix]MessageStats
+	Received map[MessagePrefix]MessageStats
 	// Message send queue.
 	WriteQueue chan Message
 	Solicited  bool
+	// Whether the traffic is encrypted
+	Encrypted bool
+	// Identity key the peer proved in the encrypted handshake, empty if none
+	RemoteKey cipher.PubKey
+	// Number of messages dropped by the rate limits
+	RateViolations int
+
+	limiter *rateLimiter
 }
 
 // NewConnection creates a new Connection tied to a ConnectionPool
 func NewConnection(pool *ConnectionPool, id int, conn net.Conn, writeQueueSize int, solicited bool) *Connection {
+	var cfg Config
+	if pool != nil {
+		cfg = pool.Config
+	}
+	now := Now()
 	return &Connection{
 		ID:             id,
 		Conn:           conn,
 		Buffer:         &bytes.Buffer{},
 		ConnectionPool: pool,
-		LastReceived:   Now(),
-		LastSent:       Now(),
+		LastReceived:   now,
+		LastSent:       now,
+		ConnectedAt:    now,
+		Sent:           make(map[MessagePrefix]MessageStats),
+		Received:       make(map[MessagePrefix]MessageStats),
 		WriteQueue:     make(chan Message, writeQueueSize),
 		Solicited:      solicited,
+		limiter:        newRateLimiter(cfg, now),
 	}
 }
 
@@ -152,6 +203,33 @@ func (conn *Connection) String() string {
 	return conn.Addr()
 }
 
+// copy returns a copy of the connection that doesn't share its stats
+func (conn *Connection) copy() Connection {
+	c := *conn
+	c.Sent = copyMessageStats(conn.Sent)
+	c.Received = copyMessageStats(conn.Received)
+	return c
+}
+
+func copyMessageStats(stats map[MessagePrefix]MessageStats) map[MessagePrefix]MessageStats {
+	if stats == nil {
+		return nil
+	}
+	c := make(map[MessagePrefix]MessageStats, len(stats))
+	for k, v := range stats {
+		c[k] = v
+	}
+	return c
+}
+
+// addMessageStats counts a message of prefix with n bytes
+func addMessageStats(stats map[MessagePrefix]MessageStats, prefix MessagePrefix, n int) {
+	ms := stats[prefix]
+	ms.Messages++
+	ms.Bytes += uint64(n)
+	stats[prefix] = ms
+}
+
 // Close close the connection and write queue
 func (conn *Connection) Close() {
 	conn.Conn.Close()
@@ -301,6 +379,10 @@ func (pool *ConnectionPool) NewConnection(conn net.Conn, solicited bool) (*Conne
 		}
 		pool.connID++
 		nc = NewConnection(pool, pool.connID, conn, pool.Config.ConnectionWriteQueueSize, solicited)
+		if sc, ok := conn.(*secureConn); ok {
+			nc.Encrypted = true
+			nc.RemoteKey = sc.remoteKey
+		}
 
 		pool.pool[nc.ID] = nc
 		pool.addresses[a] = nc
@@ -337,6 +419,18 @@ func (pool *ConnectionPool) handleConnection(conn net.Conn, solicited bool) {
 		return
 	}
 
+	if pool.Config.Encrypt {
+		sc, err := handshake(conn, solicited, pool.Config.IdentityKey, pool.Config.PinnedKeys[addr], pool.Config.HandshakeTimeout)
+		if err != nil {
+			logger.Errorf("Handshake with %s failed: %v", addr, err)
+			if err := conn.Close(); err != nil {
+				logger.Errorf("conn.Close() error: %v", err)
+			}
+			return
+		}
+		conn = sc
+	}
+
 	c, err := pool.NewConnection(conn, solicited)
 	if err != nil {
 		logger.Errorf("Create connection failed: %v", err)
@@ -473,7 +567,7 @@ func (pool *ConnectionPool) sendLoop(conn *Connection, timeout time.Duration, qc
 			if m == nil {
 				continue
 			}
-			err := sendMessage(conn.Conn, m, timeout)
+			n, err := sendMessage(conn.Conn, m, timeout)
 			sr := newSendResult(conn.Addr(), m, err)
 			select {
 			case <-qc:
@@ -487,7 +581,7 @@ func (pool *ConnectionPool) sendLoop(conn *Connection, timeout time.Duration, qc
 				return err
 			}
 
-			if err := pool.updateLastSent(conn.Addr(), Now()); err != nil {
+			if err := pool.updateLastSent(conn.Addr(), Now(), messagePrefixOf(m), n); err != nil {
 				return err
 			}
 		}
@@ -560,19 +654,32 @@ func (pool *ConnectionPool) IsConnExist(addr string) (bool, error) {
 	return exist, nil
 }
 
-func (pool *ConnectionPool) updateLastSent(addr string, t time.Time) error {
+// updateLastSent records a message of prefix with n bytes sent at t
+func (pool *ConnectionPool) updateLastSent(addr string, t time.Time, prefix MessagePrefix, n int) error {
 	return pool.strand("updateLastSent", func() error {
 		if conn, ok := pool.addresses[addr]; ok {
 			conn.LastSent = t
+			addMessageStats(conn.Sent, prefix, n)
 		}
 		return nil
 	})
 }
 
-func (pool *ConnectionPool) updateLastRecv(addr string, t time.Time) error {
+func (pool *ConnectionPool) updateRateViolations(addr string, n int) error {
+	return pool.strand("updateRateViolations", func() error {
+		if conn, ok := pool.addresses[addr]; ok {
+			conn.RateViolations = n
+		}
+		return nil
+	})
+}
+
+// updateLastRecv records a message of prefix with n bytes received at t
+func (pool *ConnectionPool) updateLastRecv(addr string, t time.Time, prefix MessagePrefix, n int) error {
 	return pool.strand("updateLastRecv", func() error {
 		if conn, ok := pool.addresses[addr]; ok {
 			conn.LastReceived = t
+			addMessageStats(conn.Received, prefix, n)
 		}
 		return nil
 	})
@@ -584,7 +691,7 @@ func (pool *ConnectionPool) GetConnection(addr string) (*Connection, error) {
 	if err := pool.strand("GetConnection", func() error {
 		if c, ok := pool.addresses[addr]; ok {
 			// copy connection
-			cc := *c
+			cc := c.copy()
 			conn = &cc
 		}
 		return nil
@@ -647,7 +754,7 @@ func (pool *ConnectionPool) GetConnections() ([]Connection, error) {
 	conns := []Connection{}
 	if err := pool.strand("GetConnections", func() error {
 		for _, conn := range pool.pool {
-			conns = append(conns, *conn)
+			conns = append(conns, conn.copy())
 		}
 		return nil
 	}); err != nil {
@@ -687,6 +794,12 @@ func (pool *ConnectionPool) SendMessage(addr string, msg Message) error {
 
 // BroadcastMessage sends a Message to all connections in the Pool.
 func (pool *ConnectionPool) BroadcastMessage(msg Message) error {
+	return pool.BroadcastMessageTo(msg, nil)
+}
+
+// BroadcastMessageTo sends a Message to the connections of the Pool whose address
+// is accepted, to all connections if accept is nil. accept is called in the pool strand
+func (pool *ConnectionPool) BroadcastMessageTo(msg Message, accept func(addr string) bool) error {
 	if pool.Config.DebugPrint {
 		logger.Debugf("Broadcast, Msg Type: %s", reflect.TypeOf(msg))
 	}
@@ -697,7 +810,12 @@ func (pool *ConnectionPool) BroadcastMessage(msg Message) error {
 			return errors.New("Connection pool is empty")
 		}
 
+		sent := 0
 		for _, conn := range pool.pool {
+			if accept != nil && !accept(conn.Addr()) {
+				continue
+			}
+			sent++
 			select {
 			case conn.WriteQueue <- msg:
 			default:
@@ -705,7 +823,7 @@ func (pool *ConnectionPool) BroadcastMessage(msg Message) error {
 				fullWriteQueue = append(fullWriteQueue, conn.Addr())
 			}
 		}
-		if len(fullWriteQueue) == len(pool.pool) {
+		if sent > 0 && len(fullWriteQueue) == sent {
 			return errors.New("There's no available connection in pool")
 		}
 
@@ -727,17 +845,41 @@ func (pool *ConnectionPool) BroadcastMessage(msg Message) error {
 // first return value.  Otherwise, error will be nil and DisconnectReason will
 // be the value returned from the message handler.
 func (pool *ConnectionPool) receiveMessage(c *Connection, msg []byte) error {
+	var prefix MessagePrefix
+	copy(prefix[:], msg)
+	if len(msg) >= messagePrefixLength && !c.limiter.allow(prefix, Now()) {
+		return pool.rateViolation(c, prefix)
+	}
 
 	m, err := convertToMessage(c.ID, msg, pool.Config.DebugPrint)
 	if err != nil {
 		return err
 	}
-	if err := pool.updateLastRecv(c.Addr(), Now()); err != nil {
+	if err := pool.updateLastRecv(c.Addr(), Now(), prefix, len(msg)+messageLengthSize); err != nil {
 		return err
 	}
 	return m.Handle(NewMessageContext(c), pool.messageState)
 }
 
+// rateViolation records a message dropped by the rate limits, the connection is
+// disconnected once it has more than MaxRateViolations
+func (pool *ConnectionPool) rateViolation(c *Connection, prefix MessagePrefix) error {
+	c.limiter.violations++
+	n := c.limiter.violations
+	if err := pool.updateRateViolations(c.Addr(), n); err != nil {
+		return err
+	}
+
+	if n > pool.Config.MaxRateViolations {
+		return ErrDisconnectRateLimited
+	}
+
+	if pool.Config.DebugPrint {
+		logger.Debugf("Dropped %s message from %s over the rate limit", string(prefix[:]), c.Addr())
+	}
+	return nil
+}
+
 // SendPings sends a ping if our last message sent was over pingRate ago
 func (pool *ConnectionPool) SendPings(rate time.Duration, msg Message) error {
 	now := utc.Now()
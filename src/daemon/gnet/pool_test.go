@@ -736,6 +736,59 @@ func TestPoolBroadcastMessage(t *testing.T) {
 	<-q
 }
 
+func TestPoolBroadcastMessageTo(t *testing.T) {
+	resetHandler()
+	EraseMessages()
+	RegisterMessage(BytePrefix, ByteMessage{})
+	VerifyMessages()
+	cfg := newTestConfig()
+	p := NewConnectionPool(cfg, nil)
+
+	q := make(chan struct{})
+	go func() {
+		defer close(q)
+		p.Run()
+	}()
+	wait()
+
+	ready := make(chan struct{})
+	var i int
+	p.Config.ConnectCallback = func(addr string, solicited bool) {
+		i++
+		if i == 2 {
+			ready <- struct{}{}
+		}
+	}
+
+	c1, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	c2, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+
+	<-ready
+
+	m := NewByteMessage(88)
+	err = p.BroadcastMessageTo(m, func(a string) bool {
+		return a == c1.LocalAddr().String()
+	})
+	require.NoError(t, err)
+
+	c1.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = c1.Read(make([]byte, 16))
+	require.NoError(t, err)
+
+	c2.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = c2.Read(make([]byte, 16))
+	require.Error(t, err)
+
+	// accepting no connection is not an error
+	err = p.BroadcastMessageTo(m, func(string) bool { return false })
+	require.NoError(t, err)
+
+	p.Shutdown()
+	<-q
+}
+
 func TestPoolReceiveMessage(t *testing.T) {
 	wait()
 	resetHandler()
@@ -767,7 +820,18 @@ func TestPoolReceiveMessage(t *testing.T) {
 	// Invalid byte message received
 	b = []byte{1}
 	err = p.receiveMessage(c, b)
-	require.Error(t, err)
+	require.Equal(t, ErrDisconnectMalformedMessage, err)
+
+	// Body not completely decoded
+	b = append([]byte{}, BytePrefix[:]...)
+	b = append(b, 7, 8)
+	err = p.receiveMessage(c, b)
+	require.Equal(t, ErrDisconnectMalformedMessage, err)
+
+	// Unknown message id
+	b = []byte{'C', 'C', 'C', 'C'}
+	err = p.receiveMessage(c, b)
+	require.Equal(t, ErrDisconnectUnknownMessage, err)
 
 	// Valid message, but handler returns a DisconnectReason
 	b = make([]byte, 0)
@@ -779,6 +843,89 @@ func TestPoolReceiveMessage(t *testing.T) {
 	<-q
 }
 
+func TestPoolMessageStats(t *testing.T) {
+	wait()
+	resetHandler()
+	EraseMessages()
+	RegisterMessage(BytePrefix, ByteMessage{})
+	VerifyMessages()
+
+	p := NewConnectionPool(newTestConfig(), nil)
+
+	q := make(chan struct{})
+	go func() {
+		defer close(q)
+		p.Run()
+	}()
+	wait()
+
+	c, err := p.NewConnection(NewDummyConn(addr), true)
+	require.NoError(t, err)
+	require.False(t, c.ConnectedAt.IsZero())
+
+	b := append(BytePrefix[:], byte(7))
+	require.NoError(t, p.receiveMessage(c, b))
+	require.NoError(t, p.receiveMessage(c, b))
+	require.NoError(t, p.updateLastSent(addr, Now(), BytePrefix, 9))
+
+	conn, err := p.GetConnection(addr)
+	require.NoError(t, err)
+	require.Equal(t, map[MessagePrefix]MessageStats{
+		BytePrefix: {Messages: 2, Bytes: 18},
+	}, conn.Received)
+	require.Equal(t, map[MessagePrefix]MessageStats{
+		BytePrefix: {Messages: 1, Bytes: 9},
+	}, conn.Sent)
+
+	// the copy does not share the stats
+	conn.Sent[BytePrefix] = MessageStats{}
+	conns, err := p.GetConnections()
+	require.NoError(t, err)
+	require.Len(t, conns, 1)
+	require.Equal(t, MessageStats{Messages: 1, Bytes: 9}, conns[0].Sent[BytePrefix])
+
+	p.Shutdown()
+	<-q
+}
+
+func TestPoolReceiveMessageRateLimit(t *testing.T) {
+	wait()
+	resetHandler()
+	EraseMessages()
+	RegisterMessage(BytePrefix, ByteMessage{})
+	VerifyMessages()
+
+	cfg := newTestConfig()
+	cfg.MessageRateLimits[BytePrefix] = RateLimit{Rate: 0.001, Burst: 2}
+	cfg.MaxRateViolations = 1
+	p := NewConnectionPool(cfg, nil)
+
+	q := make(chan struct{})
+	go func() {
+		defer close(q)
+		p.Run()
+	}()
+	wait()
+
+	c, err := p.NewConnection(NewDummyConn(addr), true)
+	require.NoError(t, err)
+
+	b := append(BytePrefix[:], byte(7))
+	require.NoError(t, p.receiveMessage(c, b))
+	require.NoError(t, p.receiveMessage(c, b))
+
+	// dropped over the limit
+	require.NoError(t, p.receiveMessage(c, b))
+	conn, err := p.GetConnection(addr)
+	require.NoError(t, err)
+	require.Equal(t, 1, conn.RateViolations)
+
+	require.Equal(t, ErrDisconnectRateLimited, p.receiveMessage(c, b))
+
+	p.Shutdown()
+	<-q
+}
+
 // Helpers
 
 func wait() {
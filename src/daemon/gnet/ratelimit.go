@@ -0,0 +1,83 @@
+package gnet
+
+import (
+	"time"
+)
+
+// RateLimit is a token bucket refilled with Rate tokens a second up to Burst tokens,
+// each message received takes a token. A zero Rate is unlimited
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// Unlimited returns true if the limit is not set
+func (rl RateLimit) Unlimited() bool {
+	return rl.Rate <= 0
+}
+
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		limit:  limit,
+		tokens: float64(limit.Burst),
+		last:   now,
+	}
+}
+
+// take takes a token if there is one left after refilling the bucket up to now
+func (b *tokenBucket) take(now time.Time) bool {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.limit.Rate
+		if b.tokens > float64(b.limit.Burst) {
+			b.tokens = float64(b.limit.Burst)
+		}
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rateLimiter limits the messages received from a connection, it is only used by the
+// goroutine receiving the messages of the connection
+type rateLimiter struct {
+	conn       *tokenBucket
+	prefixes   map[MessagePrefix]*tokenBucket
+	violations int
+}
+
+func newRateLimiter(c Config, now time.Time) *rateLimiter {
+	rl := &rateLimiter{
+		prefixes: make(map[MessagePrefix]*tokenBucket),
+	}
+	if !c.ConnectionRateLimit.Unlimited() {
+		rl.conn = newTokenBucket(c.ConnectionRateLimit, now)
+	}
+	for prefix, limit := range c.MessageRateLimits {
+		if !limit.Unlimited() {
+			rl.prefixes[prefix] = newTokenBucket(limit, now)
+		}
+	}
+	return rl
+}
+
+// allow returns true if a message of prefix is within the limits of the connection.
+// A message dropped by the limit of its prefix does not take a token of the connection
+func (rl *rateLimiter) allow(prefix MessagePrefix, now time.Time) bool {
+	if b, ok := rl.prefixes[prefix]; ok && !b.take(now) {
+		return false
+	}
+	if rl.conn != nil && !rl.conn.take(now) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package gnet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := newTokenBucket(RateLimit{Rate: 2, Burst: 3}, now)
+
+	// the burst is available at once
+	for i := 0; i < 3; i++ {
+		require.True(t, b.take(now))
+	}
+	require.False(t, b.take(now))
+
+	// refilled at the rate
+	now = now.Add(500 * time.Millisecond)
+	require.True(t, b.take(now))
+	require.False(t, b.take(now))
+
+	// up to the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		require.True(t, b.take(now))
+	}
+	require.False(t, b.take(now))
+
+	// a clock going back does not refill
+	require.False(t, b.take(now.Add(-time.Minute)))
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	a := MessagePrefixFromString("AAAA")
+	b := MessagePrefixFromString("BBBB")
+
+	cfg := NewConfig()
+	require.True(t, newRateLimiter(cfg, now).allow(a, now))
+
+	cfg.ConnectionRateLimit = RateLimit{Rate: 1, Burst: 3}
+	cfg.MessageRateLimits[a] = RateLimit{Rate: 1, Burst: 1}
+	rl := newRateLimiter(cfg, now)
+
+	require.True(t, rl.allow(a, now))
+	require.False(t, rl.allow(a, now))
+	// dropped by the prefix limit without taking a token of the connection
+	require.True(t, rl.allow(b, now))
+	require.True(t, rl.allow(b, now))
+	require.False(t, rl.allow(b, now))
+
+	now = now.Add(time.Second)
+	require.True(t, rl.allow(a, now))
+	require.False(t, rl.allow(b, now))
+}
@@ -0,0 +1,230 @@
+package gnet
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	scipher "github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/cipher/chacha20poly1305"
+)
+
+const (
+	// Byte size of the length prefix of an encrypted frame
+	frameLengthSize = 4
+	// Max plaintext size of an encrypted frame, larger writes are split
+	maxFramePayload = 64 * 1024
+	// Byte size of the authentication tag of a frame
+	frameTagSize = 16
+	// Byte size of the identity proof, a pubkey followed by a signature
+	identityProofSize = len(scipher.PubKey{}) + len(scipher.Sig{})
+)
+
+var (
+	// ErrDisconnectHandshakeFailed the encrypted handshake could not be completed
+	ErrDisconnectHandshakeFailed DisconnectReason = errors.New("Handshake failed")
+	// ErrDisconnectPinnedKeyMismatch the peer did not prove the key pinned for its address
+	ErrDisconnectPinnedKeyMismatch DisconnectReason = errors.New("Pinned key mismatch")
+	// ErrDisconnectDecryptFailed a frame failed authentication
+	ErrDisconnectDecryptFailed DisconnectReason = errors.New("Decrypt failed")
+
+	// labels of the keys derived for each direction
+	initiatorKeyLabel = []byte("samos gnet initiator")
+	responderKeyLabel = []byte("samos gnet responder")
+)
+
+// secureConn encrypts and authenticates every frame written to the underlying
+// connection with chacha20poly1305. Frames are [length][ciphertext], the nonce is
+// the frame counter of each direction so replayed or reordered frames fail to open.
+// Reads and writes must each come from a single goroutine.
+type secureConn struct {
+	net.Conn
+	send      cipher.AEAD
+	recv      cipher.AEAD
+	sendNonce uint64
+	recvNonce uint64
+	// plaintext of the last frame not yet read
+	pending []byte
+	// identity key the peer proved possession of, empty if none
+	remoteKey scipher.PubKey
+}
+
+func newSecureConn(conn net.Conn, sendKey, recvKey []byte) (*secureConn, error) {
+	send, err := chacha20poly1305.New(sendKey)
+	if err != nil {
+		return nil, err
+	}
+	recv, err := chacha20poly1305.New(recvKey)
+	if err != nil {
+		return nil, err
+	}
+	return &secureConn{
+		Conn: conn,
+		send: send,
+		recv: recv,
+	}, nil
+}
+
+func frameNonce(n uint64) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce, n)
+	return nonce
+}
+
+// Write seals b into one or more frames
+func (sc *secureConn) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		n := len(b)
+		if n > maxFramePayload {
+			n = maxFramePayload
+		}
+		if err := sc.writeFrame(b[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		b = b[n:]
+	}
+	return written, nil
+}
+
+func (sc *secureConn) writeFrame(b []byte) error {
+	frame := make([]byte, frameLengthSize, frameLengthSize+len(b)+frameTagSize)
+	frame = sc.send.Seal(frame, frameNonce(sc.sendNonce), b, nil)
+	binary.LittleEndian.PutUint32(frame, uint32(len(frame)-frameLengthSize))
+	sc.sendNonce++
+	_, err := sc.Conn.Write(frame)
+	return err
+}
+
+// Read returns the plaintext of the received frames
+func (sc *secureConn) Read(b []byte) (int, error) {
+	if len(sc.pending) == 0 {
+		data, err := sc.readFrame()
+		if err != nil {
+			return 0, err
+		}
+		sc.pending = data
+	}
+	n := copy(b, sc.pending)
+	sc.pending = sc.pending[n:]
+	return n, nil
+}
+
+func (sc *secureConn) readFrame() ([]byte, error) {
+	var prefix [frameLengthSize]byte
+	if _, err := io.ReadFull(sc.Conn, prefix[:]); err != nil {
+		return nil, err
+	}
+	length := int(binary.LittleEndian.Uint32(prefix[:]))
+	if length < frameTagSize || length > maxFramePayload+frameTagSize {
+		return nil, ErrDisconnectInvalidMessageLength
+	}
+
+	frame := make([]byte, length)
+	if _, err := io.ReadFull(sc.Conn, frame); err != nil {
+		return nil, err
+	}
+	data, err := sc.recv.Open(frame[:0], frameNonce(sc.recvNonce), frame, nil)
+	if err != nil {
+		return nil, ErrDisconnectDecryptFailed
+	}
+	sc.recvNonce++
+	return data, nil
+}
+
+// handshake establishes an encrypted session over conn. Both sides exchange
+// ephemeral secp256k1 pubkeys and derive one key per direction from the ECDH secret.
+// Then each side sends an encrypted identity proof, signing the session with its
+// identity key if it has one. If pinned is not empty the peer must prove that key.
+func handshake(conn net.Conn, initiator bool, identity scipher.SecKey, pinned scipher.PubKey, timeout time.Duration) (*secureConn, error) {
+	deadline := time.Time{}
+	if timeout != 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	pub, sec := scipher.GenerateKeyPair()
+	if _, err := conn.Write(pub[:]); err != nil {
+		return nil, err
+	}
+	var remote scipher.PubKey
+	if _, err := io.ReadFull(conn, remote[:]); err != nil {
+		return nil, err
+	}
+	if err := remote.Verify(); err != nil || remote == pub {
+		return nil, ErrDisconnectHandshakeFailed
+	}
+
+	// the session is identified by both ephemeral keys, initiator first
+	local, peer := initiatorKeyLabel, responderKeyLabel
+	session := scipher.SumSHA256(append(pub[:], remote[:]...))
+	if !initiator {
+		local, peer = responderKeyLabel, initiatorKeyLabel
+		session = scipher.SumSHA256(append(remote[:], pub[:]...))
+	}
+	shared := scipher.ECDH(remote, sec)
+	sendKey := scipher.SumSHA256(append(append(shared, session[:]...), local...))
+	recvKey := scipher.SumSHA256(append(append(shared, session[:]...), peer...))
+
+	sc, err := newSecureConn(conn, sendKey[:], recvKey[:])
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sc.writeFrame(identityProof(identity, session, local)); err != nil {
+		return nil, err
+	}
+	proof, err := sc.readFrame()
+	if err != nil {
+		return nil, err
+	}
+	sc.remoteKey, err = verifyIdentityProof(proof, session, peer)
+	if err != nil {
+		return nil, err
+	}
+
+	if pinned != (scipher.PubKey{}) && sc.remoteKey != pinned {
+		return nil, ErrDisconnectPinnedKeyMismatch
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// identityProof signs the session with the identity key, empty if there is no identity key.
+// The label binds the signature to the direction so it can not be reflected back.
+func identityProof(identity scipher.SecKey, session scipher.SHA256, label []byte) []byte {
+	if identity == (scipher.SecKey{}) {
+		return []byte{}
+	}
+	pk := scipher.PubKeyFromSecKey(identity)
+	sig := scipher.SignHash(scipher.AddSHA256(session, scipher.SumSHA256(label)), identity)
+	return append(pk[:], sig[:]...)
+}
+
+func verifyIdentityProof(proof []byte, session scipher.SHA256, label []byte) (scipher.PubKey, error) {
+	switch len(proof) {
+	case 0:
+		return scipher.PubKey{}, nil
+	case identityProofSize:
+	default:
+		return scipher.PubKey{}, ErrDisconnectHandshakeFailed
+	}
+
+	pk := scipher.NewPubKey(proof[:len(scipher.PubKey{})])
+	sig := scipher.NewSig(proof[len(scipher.PubKey{}):])
+	hash := scipher.AddSHA256(session, scipher.SumSHA256(label))
+	if err := scipher.VerifySignature(pk, sig, hash); err != nil {
+		return scipher.PubKey{}, fmt.Errorf("invalid identity proof: %v", err)
+	}
+	return pk, nil
+}
@@ -0,0 +1,139 @@
+package gnet
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/samoslab/samos/src/cipher"
+)
+
+type handshakeResult struct {
+	conn *secureConn
+	err  error
+}
+
+// connPair returns the two ends of a loopback tcp connection, the dialer first
+func connPair(t *testing.T) (net.Conn, net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- c
+	}()
+
+	dialed, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	c, ok := <-accepted
+	require.True(t, ok)
+	return dialed, c
+}
+
+func runHandshake(t *testing.T, dialKey, acceptKey cipher.SecKey, pinned cipher.PubKey) (handshakeResult, handshakeResult) {
+	dialed, accepted := connPair(t)
+	dc := make(chan handshakeResult, 1)
+	go func() {
+		sc, err := handshake(dialed, true, dialKey, pinned, time.Second)
+		dc <- handshakeResult{sc, err}
+	}()
+	sc, err := handshake(accepted, false, acceptKey, cipher.PubKey{}, time.Second)
+	return <-dc, handshakeResult{sc, err}
+}
+
+func TestHandshake(t *testing.T) {
+	trustPub, trustSec := cipher.GenerateKeyPair()
+	d, a := runHandshake(t, cipher.SecKey{}, trustSec, trustPub)
+	require.NoError(t, d.err)
+	require.NoError(t, a.err)
+	defer d.conn.Close()
+	defer a.conn.Close()
+
+	require.Equal(t, trustPub, d.conn.remoteKey)
+	require.Equal(t, cipher.PubKey{}, a.conn.remoteKey)
+
+	// writes larger than a frame are split and read back in order
+	msg := bytes.Repeat([]byte("samos"), maxFramePayload/2)
+	go func() {
+		d.conn.Write(msg)
+	}()
+	buf := make([]byte, len(msg))
+	n := 0
+	for n < len(buf) {
+		c, err := a.conn.Read(buf[n:])
+		require.NoError(t, err)
+		n += c
+	}
+	require.Equal(t, msg, buf)
+	// the identity proof and three data frames
+	require.Equal(t, uint64(4), d.conn.sendNonce)
+	require.Equal(t, uint64(4), a.conn.recvNonce)
+}
+
+func TestHandshakePinnedKeyMismatch(t *testing.T) {
+	pinned, _ := cipher.GenerateKeyPair()
+	_, trustSec := cipher.GenerateKeyPair()
+
+	d, a := runHandshake(t, cipher.SecKey{}, trustSec, pinned)
+	require.Equal(t, ErrDisconnectPinnedKeyMismatch, d.err)
+	require.NoError(t, a.err)
+	a.conn.Close()
+
+	// a peer without identity key can not satisfy the pin
+	d, a = runHandshake(t, cipher.SecKey{}, cipher.SecKey{}, pinned)
+	require.Equal(t, ErrDisconnectPinnedKeyMismatch, d.err)
+	require.NoError(t, a.err)
+	a.conn.Close()
+}
+
+func TestSecureConnTampered(t *testing.T) {
+	d, a := runHandshake(t, cipher.SecKey{}, cipher.SecKey{}, cipher.PubKey{})
+	require.NoError(t, d.err)
+	require.NoError(t, a.err)
+	defer d.conn.Close()
+	defer a.conn.Close()
+
+	// flip a bit of the ciphertext on the raw connection
+	frame := make([]byte, frameLengthSize, 64)
+	frame = d.conn.send.Seal(frame, frameNonce(d.conn.sendNonce), []byte("hello"), nil)
+	frame[0] = byte(len(frame) - frameLengthSize)
+	frame[len(frame)-1] ^= 1
+	_, err := d.conn.Conn.Write(frame)
+	require.NoError(t, err)
+
+	_, err = a.conn.Read(make([]byte, 16))
+	require.Equal(t, ErrDisconnectDecryptFailed, err)
+}
+
+func TestVerifyIdentityProof(t *testing.T) {
+	pk, sk := cipher.GenerateKeyPair()
+	session := cipher.SumSHA256([]byte("session"))
+
+	proof := identityProof(sk, session, initiatorKeyLabel)
+	key, err := verifyIdentityProof(proof, session, initiatorKeyLabel)
+	require.NoError(t, err)
+	require.Equal(t, pk, key)
+
+	// the proof can not be reflected back to its signer
+	_, err = verifyIdentityProof(proof, session, responderKeyLabel)
+	require.Error(t, err)
+
+	_, err = verifyIdentityProof(proof, cipher.SumSHA256([]byte("other")), initiatorKeyLabel)
+	require.Error(t, err)
+
+	_, err = verifyIdentityProof(proof[1:], session, initiatorKeyLabel)
+	require.Equal(t, ErrDisconnectHandshakeFailed, err)
+
+	key, err = verifyIdentityProof(identityProof(cipher.SecKey{}, session, initiatorKeyLabel), session, initiatorKeyLabel)
+	require.NoError(t, err)
+	require.Equal(t, cipher.PubKey{}, key)
+}
@@ -0,0 +1,550 @@
+package daemon
+
+import (
+	"errors"
+	"time"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/daemon/gnet"
+)
+
+var (
+	// ErrInvalidHeader the headers don't form a chain, or the header following
+	// our head block is not signed by a trust node
+	ErrInvalidHeader = errors.New("Invalid block header")
+	// ErrInvalidBody the body does not match the BodyHash of its header
+	ErrInvalidBody = errors.New("Invalid block body")
+)
+
+// HeaderSync keeps the state of the headers-first block sync. The headers
+// following our head block are downloaded and verified first, then their
+// bodies are requested from several peers at once and checked against the
+// BodyHash of the headers, so a peer can't make us download junk bodies.
+// It is only used from the daemon loop.
+type HeaderSync struct {
+	// verified headers following the head block, in order
+	headers []*syncHeader
+	// bodies requested from each peer
+	requests map[string]*bodiesRequest
+	// peer that has more headers than fit in MaxPendingHeaders
+	more string
+}
+
+type syncHeader struct {
+	coin.SignedBlockHeader
+	body      *coin.BlockBody
+	requested bool
+}
+
+type bodiesRequest struct {
+	headers []*syncHeader
+	at      time.Time
+}
+
+// NewHeaderSync creates HeaderSync instance
+func NewHeaderSync() *HeaderSync {
+	return &HeaderSync{
+		requests: make(map[string]*bodiesRequest),
+	}
+}
+
+// Len returns the number of headers whose blocks are not executed yet
+func (hs *HeaderSync) Len() int {
+	return len(hs.headers)
+}
+
+// Tip returns the seq of the last header, or of the head block if there is none
+func (hs *HeaderSync) Tip(head coin.BlockHeader) uint64 {
+	if n := len(hs.headers); n > 0 {
+		return hs.headers[n-1].Seq()
+	}
+	return head.BkSeq
+}
+
+// Reset drops all the headers, the outstanding requests are kept so their
+// responses are still accepted
+func (hs *HeaderSync) Reset() {
+	hs.headers = nil
+	hs.more = ""
+}
+
+// prune drops the headers of the blocks executed meanwhile, and all the headers
+// if they no longer extend the head block
+func (hs *HeaderSync) prune(head coin.BlockHeader) {
+	i := 0
+	for i < len(hs.headers) && hs.headers[i].Seq() <= head.BkSeq {
+		i++
+	}
+	hs.headers = hs.headers[i:]
+
+	if len(hs.headers) > 0 && hs.headers[0].Header.PrevHash != head.Hash() {
+		hs.Reset()
+	}
+}
+
+// AddHeaders appends the headers that extend our last header. verify checks the
+// signature of a header, it is reliable for the header following the head block
+// only, since the trust nodes may change in the blocks in between. A header
+// failing it further ahead ends the batch, and is requested again once the
+// blocks before it are executed. Returns the number of headers added.
+func (hs *HeaderSync) AddHeaders(head coin.BlockHeader, headers []coin.SignedBlockHeader,
+	verify func(coin.SignedBlockHeader) error) (int, error) {
+	hs.prune(head)
+
+	prev := head
+	if n := len(hs.headers); n > 0 {
+		prev = hs.headers[n-1].Header
+	}
+
+	for i := 1; i < len(headers); i++ {
+		if headers[i].Seq() != headers[i-1].Seq()+1 || headers[i].Header.PrevHash != headers[i-1].Hash() {
+			return 0, ErrInvalidHeader
+		}
+	}
+
+	var added int
+	for _, h := range headers {
+		if h.Seq() <= prev.BkSeq {
+			continue
+		}
+
+		if h.Seq() != prev.BkSeq+1 || h.Header.PrevHash != prev.Hash() {
+			// the headers are on another fork
+			return added, nil
+		}
+
+		if h.Header.Time <= prev.Time {
+			return added, ErrInvalidHeader
+		}
+
+		if err := verify(h); err != nil {
+			if len(hs.headers) == 0 {
+				return added, ErrInvalidHeader
+			}
+			return added, nil
+		}
+
+		hs.headers = append(hs.headers, &syncHeader{SignedBlockHeader: h})
+		prev = h.Header
+		added++
+	}
+
+	return added, nil
+}
+
+// Expire releases the requests older than timeout, so their bodies are
+// requested from other peers. Returns the peers of the expired requests.
+func (hs *HeaderSync) Expire(now time.Time, timeout time.Duration) []string {
+	var addrs []string
+	for addr, r := range hs.requests {
+		if now.Sub(r.at) >= timeout {
+			hs.release(addr)
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// RemovePeer releases the request of the peer
+func (hs *HeaderSync) RemovePeer(addr string) {
+	hs.release(addr)
+	if hs.more == addr {
+		hs.more = ""
+	}
+}
+
+func (hs *HeaderSync) release(addr string) {
+	r, ok := hs.requests[addr]
+	if !ok {
+		return
+	}
+
+	for _, h := range r.headers {
+		h.requested = false
+	}
+	delete(hs.requests, addr)
+}
+
+// NextBodiesRequest picks up to max of the earliest headers whose bodies are
+// neither downloaded nor requested, and not above the height of the peer.
+// A peer is sent one request at a time. Returns the hashes of the headers.
+func (hs *HeaderSync) NextBodiesRequest(addr string, height uint64, max int, now time.Time) []cipher.SHA256 {
+	if _, ok := hs.requests[addr]; ok {
+		return nil
+	}
+
+	var headers []*syncHeader
+	for _, h := range hs.headers {
+		if len(headers) >= max || h.Seq() > height {
+			break
+		}
+
+		if h.body != nil || h.requested {
+			continue
+		}
+
+		headers = append(headers, h)
+	}
+
+	if len(headers) == 0 {
+		return nil
+	}
+
+	hashes := make([]cipher.SHA256, len(headers))
+	for i, h := range headers {
+		h.requested = true
+		hashes[i] = h.Hash()
+	}
+	hs.requests[addr] = &bodiesRequest{
+		headers: headers,
+		at:      now,
+	}
+
+	return hashes
+}
+
+// AddBodies adds the bodies of the request of the peer, in the order they were
+// requested. A peer may send fewer bodies than requested, the rest are requested
+// again. Bodies that were not requested, e.g. after the request expired, are ignored.
+func (hs *HeaderSync) AddBodies(addr string, bodies []coin.BlockBody) error {
+	r, ok := hs.requests[addr]
+	if !ok {
+		return nil
+	}
+	hs.release(addr)
+
+	if len(bodies) > len(r.headers) {
+		return ErrInvalidBody
+	}
+
+	for i, b := range bodies {
+		h := r.headers[i]
+		if b.Hash() != h.Header.BodyHash {
+			return ErrInvalidBody
+		}
+
+		if h.body == nil {
+			body := b
+			h.body = &body
+		}
+	}
+
+	return nil
+}
+
+// Ready removes and returns the blocks following the head block whose bodies
+// are downloaded
+func (hs *HeaderSync) Ready(head coin.BlockHeader) []coin.SignedBlock {
+	hs.prune(head)
+
+	var blocks []coin.SignedBlock
+	for len(hs.headers) > 0 && hs.headers[0].body != nil {
+		blocks = append(blocks, hs.headers[0].ToSignedBlock(*hs.headers[0].body))
+		hs.headers = hs.headers[1:]
+	}
+	return blocks
+}
+
+// headersFirst returns true if the blocks are synced from the peer headers first
+func (dm *Daemon) headersFirst(addr string) bool {
+	return dm.Config.Capabilities.Has(CapHeaders) && dm.peerCapabilities.Has(addr, CapHeaders)
+}
+
+// requestBlocks asks the peers for the blocks following ours, the peers that
+// support it for the headers, the others for the whole blocks. A chain started
+// from a snapshot asks for the blocks below it too
+func (dm *Daemon) requestBlocks() {
+	if dm.Visor.Config.DisableNetworking {
+		return
+	}
+
+	dm.Visor.RequestBackfill(dm.Pool)
+
+	if !dm.Config.Capabilities.Has(CapHeaders) {
+		dm.Visor.RequestBlocks(dm.Pool)
+		return
+	}
+
+	if head, ok := dm.Visor.HeadBlockHeader(); ok && dm.headerSync.Len() < dm.Visor.Config.MaxPendingHeaders {
+		m := NewGetHeadersMessage(dm.headerSync.Tip(head), dm.Visor.Config.HeadersResponseCount)
+		if err := dm.Pool.BroadcastMessage(m); err != nil {
+			logger.Debugf("Broadcast GetHeadersMessage failed: %v", err)
+		}
+	}
+
+	m := NewGetBlocksMessage(dm.Visor.HeadBkSeq(), dm.Visor.Config.BlocksResponseCount)
+	if err := dm.Pool.BroadcastMessageWithout(m, CapHeaders); err != nil {
+		logger.Debugf("Broadcast GetBlocksMessage failed: %v", err)
+	}
+}
+
+// requestHeaders asks the peer for the headers following our last header,
+// unless there are MaxPendingHeaders headers already
+func (dm *Daemon) requestHeaders(addr string) {
+	head, ok := dm.Visor.HeadBlockHeader()
+	if !ok {
+		return
+	}
+
+	if dm.headerSync.Len() >= dm.Visor.Config.MaxPendingHeaders {
+		dm.headerSync.more = addr
+		return
+	}
+	dm.headerSync.more = ""
+
+	m := NewGetHeadersMessage(dm.headerSync.Tip(head), dm.Visor.Config.HeadersResponseCount)
+	if err := dm.Pool.Pool.SendMessage(addr, m); err != nil {
+		logger.Errorf("Send GetHeadersMessage to %s failed: %v", addr, err)
+	}
+}
+
+// requestBodies requests the bodies of the headers, each peer that has the
+// blocks is asked for a different batch so the bodies download in parallel
+func (dm *Daemon) requestBodies() {
+	now := dm.Visor.v.Now()
+	for _, addr := range dm.headerSync.Expire(now, dm.Visor.Config.BodiesRequestTimeout) {
+		logger.Debugf("%s did not send the requested block bodies in time", addr)
+	}
+
+	if dm.headerSync.Len() == 0 {
+		return
+	}
+
+	for _, p := range dm.Visor.GetPeerBlockchainHeights() {
+		if !dm.headersFirst(p.Address) {
+			continue
+		}
+
+		hashes := dm.headerSync.NextBodiesRequest(p.Address, p.Height, int(dm.Visor.Config.BlocksResponseCount), now)
+		if len(hashes) == 0 {
+			continue
+		}
+
+		if err := dm.Pool.Pool.SendMessage(p.Address, NewGetBodiesMessage(hashes)); err != nil {
+			logger.Errorf("Send GetBodiesMessage to %s failed: %v", p.Address, err)
+			dm.headerSync.RemovePeer(p.Address)
+		}
+	}
+}
+
+// executeSyncedBlocks executes the blocks whose bodies are downloaded
+func (dm *Daemon) executeSyncedBlocks() {
+	head, ok := dm.Visor.HeadBlockHeader()
+	if !ok {
+		return
+	}
+
+	var processed int
+	for _, b := range dm.headerSync.Ready(head) {
+		if err := dm.Visor.ExecuteSignedBlock(b); err != nil {
+			// the header is signed by a trust node, so it's not the fault of the peer
+			logger.Critical().Errorf("Failed to execute synced block %d: %v", b.Seq(), err)
+			dm.headerSync.Reset()
+			break
+		}
+		logger.Critical().Infof("Added new block %d", b.Seq())
+		processed++
+	}
+
+	if processed == 0 {
+		return
+	}
+
+	// Announce our new blocks to peers
+	m := NewAnnounceBlocksMessage(dm.Visor.HeadBkSeq())
+	if err := dm.Pool.Pool.BroadcastMessage(m); err != nil {
+		logger.Debugf("Broadcast AnnounceBlocksMessage failed: %v", err)
+	}
+
+	if dm.headerSync.more != "" {
+		dm.requestHeaders(dm.headerSync.more)
+	}
+}
+
+// GetHeadersMessage asks for the headers of the blocks following LastBlock
+type GetHeadersMessage struct {
+	LastBlock        uint64
+	RequestedHeaders uint64
+	c                *gnet.MessageContext `enc:"-"`
+}
+
+// NewGetHeadersMessage creates GetHeadersMessage
+func NewGetHeadersMessage(lastBlock uint64, requestedHeaders uint64) *GetHeadersMessage {
+	return &GetHeadersMessage{
+		LastBlock:        lastBlock,
+		RequestedHeaders: requestedHeaders,
+	}
+}
+
+// Handle handles message
+func (ghm *GetHeadersMessage) Handle(mc *gnet.MessageContext, daemon interface{}) error {
+	ghm.c = mc
+	return daemon.(*Daemon).recordMessageEvent(ghm, mc)
+}
+
+// Process sends the headers following LastBlock
+func (ghm *GetHeadersMessage) Process(d *Daemon) {
+	if d.Visor.Config.DisableNetworking {
+		return
+	}
+
+	n := ghm.RequestedHeaders
+	if n > d.Visor.Config.HeadersResponseCount {
+		n = d.Visor.Config.HeadersResponseCount
+	}
+
+	blocks, err := d.Visor.GetSignedBlocksSince(ghm.LastBlock, n)
+	if err != nil {
+		logger.Infof("Get signed blocks failed: %v", err)
+		return
+	}
+
+	if len(blocks) == 0 {
+		return
+	}
+
+	headers := make([]coin.SignedBlockHeader, len(blocks))
+	for i, b := range blocks {
+		headers[i] = b.SignedHeader()
+	}
+
+	if err := d.Pool.Pool.SendMessage(ghm.c.Addr, NewGiveHeadersMessage(headers)); err != nil {
+		logger.Errorf("Send GiveHeadersMessage to %s failed: %v", ghm.c.Addr, err)
+	}
+}
+
+// GiveHeadersMessage sent in response to GetHeadersMessage
+type GiveHeadersMessage struct {
+	Headers []coin.SignedBlockHeader
+	c       *gnet.MessageContext `enc:"-"`
+}
+
+// NewGiveHeadersMessage creates GiveHeadersMessage
+func NewGiveHeadersMessage(headers []coin.SignedBlockHeader) *GiveHeadersMessage {
+	return &GiveHeadersMessage{
+		Headers: headers,
+	}
+}
+
+// Handle handles message
+func (ghm *GiveHeadersMessage) Handle(mc *gnet.MessageContext, daemon interface{}) error {
+	ghm.c = mc
+	return daemon.(*Daemon).recordMessageEvent(ghm, mc)
+}
+
+// Process verifies the headers and requests their bodies
+func (ghm *GiveHeadersMessage) Process(d *Daemon) {
+	if d.Visor.Config.DisableNetworking || len(ghm.Headers) == 0 {
+		return
+	}
+
+	head, ok := d.Visor.HeadBlockHeader()
+	if !ok {
+		return
+	}
+
+	addr := ghm.c.Addr
+	// the peer has the blocks of the headers
+	d.Visor.RecordBlockchainHeight(addr, ghm.Headers[len(ghm.Headers)-1].Seq())
+
+	n, err := d.headerSync.AddHeaders(head, ghm.Headers, d.Visor.VerifyBlockHeader)
+	if err != nil {
+		logger.Errorf("Received invalid headers from %s: %v", addr, err)
+		if err := d.Pool.Pool.Disconnect(addr, ErrDisconnectInvalidBlock); err != nil {
+			logger.Errorf("Disconnect %s failed: %v", addr, err)
+		}
+		return
+	}
+	logger.Debugf("Added %d headers from %s, %d pending", n, addr, d.headerSync.Len())
+
+	// a full response means the peer may have more headers
+	if n > 0 && uint64(len(ghm.Headers)) >= d.Visor.Config.HeadersResponseCount {
+		d.requestHeaders(addr)
+	}
+
+	d.requestBodies()
+}
+
+// GetBodiesMessage asks for the bodies of the blocks of the header hashes
+type GetBodiesMessage struct {
+	Hashes []cipher.SHA256
+	c      *gnet.MessageContext `enc:"-"`
+}
+
+// NewGetBodiesMessage creates GetBodiesMessage
+func NewGetBodiesMessage(hashes []cipher.SHA256) *GetBodiesMessage {
+	return &GetBodiesMessage{
+		Hashes: hashes,
+	}
+}
+
+// Handle handles message
+func (gbm *GetBodiesMessage) Handle(mc *gnet.MessageContext, daemon interface{}) error {
+	gbm.c = mc
+	return daemon.(*Daemon).recordMessageEvent(gbm, mc)
+}
+
+// Process sends the bodies of the requested blocks in order, up to the first
+// block we don't have. The response is sent even if empty, so the peer can
+// request the bodies from someone else.
+func (gbm *GetBodiesMessage) Process(d *Daemon) {
+	if d.Visor.Config.DisableNetworking {
+		return
+	}
+
+	hashes := gbm.Hashes
+	if uint64(len(hashes)) > d.Visor.Config.BlocksResponseCount {
+		hashes = hashes[:d.Visor.Config.BlocksResponseCount]
+	}
+
+	bodies := make([]coin.BlockBody, 0, len(hashes))
+	for _, h := range hashes {
+		b, err := d.Visor.GetSignedBlockByHash(h)
+		if err != nil || b == nil {
+			break
+		}
+		bodies = append(bodies, b.Body)
+	}
+
+	if err := d.Pool.Pool.SendMessage(gbm.c.Addr, NewGiveBodiesMessage(bodies)); err != nil {
+		logger.Errorf("Send GiveBodiesMessage to %s failed: %v", gbm.c.Addr, err)
+	}
+}
+
+// GiveBodiesMessage sent in response to GetBodiesMessage
+type GiveBodiesMessage struct {
+	Bodies []coin.BlockBody
+	c      *gnet.MessageContext `enc:"-"`
+}
+
+// NewGiveBodiesMessage creates GiveBodiesMessage
+func NewGiveBodiesMessage(bodies []coin.BlockBody) *GiveBodiesMessage {
+	return &GiveBodiesMessage{
+		Bodies: bodies,
+	}
+}
+
+// Handle handles message
+func (gbm *GiveBodiesMessage) Handle(mc *gnet.MessageContext, daemon interface{}) error {
+	gbm.c = mc
+	return daemon.(*Daemon).recordMessageEvent(gbm, mc)
+}
+
+// Process checks the bodies against their headers and executes the blocks
+func (gbm *GiveBodiesMessage) Process(d *Daemon) {
+	if d.Visor.Config.DisableNetworking {
+		return
+	}
+
+	if err := d.headerSync.AddBodies(gbm.c.Addr, gbm.Bodies); err != nil {
+		logger.Errorf("Received invalid bodies from %s: %v", gbm.c.Addr, err)
+		if err := d.Pool.Pool.Disconnect(gbm.c.Addr, ErrDisconnectInvalidBlock); err != nil {
+			logger.Errorf("Disconnect %s failed: %v", gbm.c.Addr, err)
+		}
+		return
+	}
+
+	d.executeSyncedBlocks()
+	d.requestBodies()
+}
@@ -0,0 +1,178 @@
+package daemon
+
+import (
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/testutil"
+)
+
+// makeHeaderChain makes n signed blocks following prev
+func makeHeaderChain(t *testing.T, prev coin.BlockHeader, n int, seckey cipher.SecKey) []coin.SignedBlock {
+	var blocks []coin.SignedBlock
+	for i := 0; i < n; i++ {
+		body := coin.BlockBody{
+			Transactions: coin.Transactions{{InnerHash: testutil.RandSHA256(t)}},
+		}
+		head := coin.BlockHeader{
+			Time:     prev.Time + 10,
+			BkSeq:    prev.BkSeq + 1,
+			PrevHash: prev.Hash(),
+			BodyHash: body.Hash(),
+		}
+		blocks = append(blocks, coin.SignedBlock{
+			Block: coin.Block{Head: head, Body: body},
+			Sig:   cipher.SignHash(head.Hash(), seckey),
+		})
+		prev = head
+	}
+	return blocks
+}
+
+func signedHeaders(blocks []coin.SignedBlock) []coin.SignedBlockHeader {
+	headers := make([]coin.SignedBlockHeader, len(blocks))
+	for i, b := range blocks {
+		headers[i] = b.SignedHeader()
+	}
+	return headers
+}
+
+func TestHeaderSyncAddHeaders(t *testing.T) {
+	pubkey, seckey := cipher.GenerateKeyPair()
+	_, otherSeckey := cipher.GenerateKeyPair()
+	verify := func(h coin.SignedBlockHeader) error {
+		return h.VerifySignature([]cipher.PubKey{pubkey})
+	}
+
+	head := coin.BlockHeader{Time: 100, BkSeq: 5}
+	chain := signedHeaders(makeHeaderChain(t, head, 4, seckey))
+	fork := signedHeaders(makeHeaderChain(t, coin.BlockHeader{Time: 90, BkSeq: 5}, 2, seckey))
+	forged := signedHeaders(makeHeaderChain(t, head, 2, otherSeckey))
+	forgedTail := append(append([]coin.SignedBlockHeader{}, chain[:2]...),
+		signedHeaders(makeHeaderChain(t, chain[1].Header, 1, otherSeckey))...)
+	reversed := []coin.SignedBlockHeader{chain[1], chain[0]}
+	stale := chain[1]
+	stale.Header.Time = head.Time
+
+	tt := []struct {
+		name    string
+		pending []coin.SignedBlockHeader
+		headers []coin.SignedBlockHeader
+		added   int
+		err     error
+		tip     uint64
+	}{
+		{"extend head", nil, chain, 4, nil, 9},
+		{"extend pending", chain[:2], chain[2:], 2, nil, 9},
+		{"skip known", chain[:2], chain, 2, nil, 9},
+		{"fork", nil, fork, 0, nil, 5},
+		{"not a chain", nil, reversed, 0, ErrInvalidHeader, 5},
+		{"time not increasing", chain[:1], []coin.SignedBlockHeader{stale}, 0, ErrInvalidHeader, 6},
+		{"forged header following head", nil, forged, 0, ErrInvalidHeader, 5},
+		{"forged header after pending", chain[:1], forged[1:], 0, nil, 6},
+		{"forged header ends the batch", nil, forgedTail, 2, nil, 7},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			hs := NewHeaderSync()
+			if tc.pending != nil {
+				n, err := hs.AddHeaders(head, tc.pending, verify)
+				require.NoError(t, err)
+				require.Equal(t, len(tc.pending), n)
+			}
+
+			n, err := hs.AddHeaders(head, tc.headers, verify)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.added, n)
+			require.Equal(t, tc.tip, hs.Tip(head))
+		})
+	}
+}
+
+func TestHeaderSyncBodies(t *testing.T) {
+	_, seckey := cipher.GenerateKeyPair()
+	verify := func(h coin.SignedBlockHeader) error { return nil }
+
+	head := coin.BlockHeader{Time: 100, BkSeq: 5}
+	blocks := makeHeaderChain(t, head, 5, seckey)
+	hashes := make([]cipher.SHA256, len(blocks))
+	for i, b := range blocks {
+		hashes[i] = b.HashHeader()
+	}
+
+	hs := NewHeaderSync()
+	n, err := hs.AddHeaders(head, signedHeaders(blocks), verify)
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+
+	now := time.Now()
+	// each peer gets its own batch, up to its height
+	require.Equal(t, hashes[:2], hs.NextBodiesRequest("a", 100, 2, now))
+	require.Nil(t, hs.NextBodiesRequest("a", 100, 2, now))
+	require.Equal(t, hashes[2:3], hs.NextBodiesRequest("b", 8, 2, now))
+	require.Equal(t, hashes[3:5], hs.NextBodiesRequest("c", 100, 2, now))
+
+	// a body that doesn't match its header is rejected, the headers are requested again
+	require.Equal(t, ErrInvalidBody, hs.AddBodies("b", []coin.BlockBody{blocks[0].Body}))
+	require.Equal(t, hashes[2:3], hs.NextBodiesRequest("d", 100, 2, now))
+	// more bodies than requested
+	require.Equal(t, ErrInvalidBody, hs.AddBodies("d", []coin.BlockBody{blocks[2].Body, blocks[3].Body}))
+
+	// the second body is missing, it is requested again
+	require.NoError(t, hs.AddBodies("a", []coin.BlockBody{blocks[0].Body}))
+	require.Equal(t, hashes[1:3], hs.NextBodiesRequest("a", 100, 2, now))
+	require.Equal(t, blocks[:1], hs.Ready(head))
+	require.Equal(t, 4, hs.Len())
+
+	// unrequested bodies are ignored
+	require.NoError(t, hs.AddBodies("e", []coin.BlockBody{blocks[1].Body}))
+
+	// the request of a disconnected peer is released
+	hs.RemovePeer("c")
+	require.Equal(t, hashes[3:5], hs.NextBodiesRequest("b", 100, 2, now))
+
+	// the requests are released once they expire
+	require.Empty(t, hs.Expire(now.Add(time.Second), time.Minute))
+	expired := hs.Expire(now.Add(time.Minute), time.Minute)
+	sort.Strings(expired)
+	require.Equal(t, []string{"a", "b"}, expired)
+	require.Equal(t, hashes[1:5], hs.NextBodiesRequest("c", 100, 4, now))
+
+	require.NoError(t, hs.AddBodies("c", []coin.BlockBody{blocks[1].Body, blocks[2].Body, blocks[3].Body, blocks[4].Body}))
+	require.Equal(t, blocks[1:], hs.Ready(blocks[0].Head))
+	require.Equal(t, 0, hs.Len())
+}
+
+func TestHeaderSyncPrune(t *testing.T) {
+	_, seckey := cipher.GenerateKeyPair()
+	verify := func(h coin.SignedBlockHeader) error { return nil }
+
+	head := coin.BlockHeader{Time: 100, BkSeq: 5}
+	blocks := makeHeaderChain(t, head, 3, seckey)
+
+	hs := NewHeaderSync()
+	_, err := hs.AddHeaders(head, signedHeaders(blocks), verify)
+	require.NoError(t, err)
+
+	// the first block was executed meanwhile
+	require.Empty(t, hs.Ready(blocks[0].Head))
+	require.Equal(t, 2, hs.Len())
+
+	// another block was executed at the same seq
+	other := makeHeaderChain(t, blocks[0].Head, 1, seckey)
+	require.Empty(t, hs.Ready(other[0].Head))
+	require.Equal(t, 0, hs.Len())
+
+	// a failing verification of the header following head
+	_, err = hs.AddHeaders(head, signedHeaders(blocks), func(coin.SignedBlockHeader) error {
+		return errors.New("not trust public key")
+	})
+	require.Equal(t, ErrInvalidHeader, err)
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
+	"reflect"
 	"strings"
 
+	"github.com/samoslab/samos/src/cipher/encoder"
 	"github.com/samoslab/samos/src/daemon/gnet"
 	"github.com/samoslab/samos/src/daemon/pex"
 	"github.com/samoslab/samos/src/util/iputil"
@@ -29,6 +31,8 @@ import (
 type MessageConfig struct {
 	Prefix  gnet.MessagePrefix
 	Message interface{}
+	// Limits how often each peer may send the message, zero is unlimited
+	RateLimit gnet.RateLimit
 }
 
 // NewMessageConfig creates message config
@@ -39,6 +43,14 @@ func NewMessageConfig(prefix string, m interface{}) MessageConfig {
 	}
 }
 
+// NewRateLimitedMessageConfig creates message config for a message each peer may send
+// at most rate times a second after the first burst messages
+func NewRateLimitedMessageConfig(prefix string, m interface{}, rate float64, burst int) MessageConfig {
+	mc := NewMessageConfig(prefix, m)
+	mc.RateLimit = gnet.RateLimit{Rate: rate, Burst: burst}
+	return mc
+}
+
 // Creates and populates the message configs
 func getMessageConfigs() []MessageConfig {
 	return []MessageConfig{
@@ -47,13 +59,13 @@ func getMessageConfigs() []MessageConfig {
 		NewMessageConfig("GIVP", GivePeersMessage{}),
 		NewMessageConfig("PING", PingMessage{}),
 		NewMessageConfig("PONG", PongMessage{}),
-		NewMessageConfig("GETB", GetBlocksMessage{}),
+		NewRateLimitedMessageConfig("GETB", GetBlocksMessage{}, 2, 20),
 		NewMessageConfig("GIVB", GiveBlocksMessage{}),
 		NewMessageConfig("ANNB", AnnounceBlocksMessage{}),
 		NewMessageConfig("GIPB", GivePendingBlockMessage{}),
-		NewMessageConfig("GETT", GetTxnsMessage{}),
+		NewRateLimitedMessageConfig("GETT", GetTxnsMessage{}, 5, 50),
 		NewMessageConfig("GIVT", GiveTxnsMessage{}),
-		NewMessageConfig("ANNT", AnnounceTxnsMessage{}),
+		NewRateLimitedMessageConfig("ANNT", AnnounceTxnsMessage{}, 10, 100),
 		NewMessageConfig("GETM", GetTrustMessage{}),
 		NewMessageConfig("GIVM", GiveTrustMessage{}),
 		NewMessageConfig("ANNM", AnnounceTrustMessage{}),
@@ -62,6 +74,18 @@ func getMessageConfigs() []MessageConfig {
 		NewMessageConfig("ANNC", AnnouncePrepareMessage{}),
 		NewMessageConfig("GETA", GetAgreeNumMessage{}),
 		NewMessageConfig("GIVA", GiveAgreeNumMessage{}),
+		NewMessageConfig("COMT", CommitMessage{}),
+		NewMessageConfig("VCHG", ViewChangeMessage{}),
+		NewMessageConfig("NVEW", NewViewMessage{}),
+		NewMessageConfig("EVID", EvidenceMessage{}),
+		NewMessageConfig("CERT", CertificateMessage{}),
+		NewMessageConfig("GETH", GetHeadersMessage{}),
+		NewMessageConfig("GIVH", GiveHeadersMessage{}),
+		NewMessageConfig("GETD", GetBodiesMessage{}),
+		NewMessageConfig("GIVD", GiveBodiesMessage{}),
+		NewMessageConfig("CMPB", CompactBlockMessage{}),
+		NewMessageConfig("GETX", GetBlockTxnsMessage{}),
+		NewMessageConfig("GIVX", GiveBlockTxnsMessage{}),
 	}
 }
 
@@ -69,18 +93,40 @@ func getMessageConfigs() []MessageConfig {
 type MessagesConfig struct {
 	// Message ID prefices
 	Messages []MessageConfig
+	// Limits how many messages of any kind each peer may send, zero is unlimited
+	ConnectionRateLimit gnet.RateLimit
+	// Messages over the rate limits are dropped, the peer is disconnected
+	// once more than MaxRateViolations of its messages are dropped
+	MaxRateViolations int
 }
 
 // NewMessagesConfig creates messages config
 func NewMessagesConfig() MessagesConfig {
 	return MessagesConfig{
-		Messages: getMessageConfigs(),
+		Messages:            getMessageConfigs(),
+		ConnectionRateLimit: gnet.RateLimit{Rate: 200, Burst: 1000},
+		MaxRateViolations:   20,
+	}
+}
+
+// RateLimits returns the rate limits of the messages by prefix
+func (msc *MessagesConfig) RateLimits() map[gnet.MessagePrefix]gnet.RateLimit {
+	limits := make(map[gnet.MessagePrefix]gnet.RateLimit)
+	for _, mc := range msc.Messages {
+		if !mc.RateLimit.Unlimited() {
+			limits[mc.Prefix] = mc.RateLimit
+		}
 	}
+	return limits
 }
 
-// Register registers our Messages with gnet
+// Register registers our Messages with gnet, messages already registered by
+// another daemon in the same process are skipped
 func (msc *MessagesConfig) Register() {
 	for _, mc := range msc.Messages {
+		if t, ok := gnet.MessageIDReverseMap[mc.Prefix]; ok && t == reflect.TypeOf(mc.Message) {
+			continue
+		}
 		gnet.RegisterMessage(mc.Prefix, mc.Message)
 	}
 	gnet.VerifyMessages()
@@ -237,21 +283,51 @@ type IntroductionMessage struct {
 	Port uint16
 	// Our client version
 	Version int32
+	// Extra carries the IntroductionExtra fields added after version 2, a version 2
+	// client sends none. Later versions append their fields after them
+	Extra []byte `enc:",omitempty"`
 
 	c *gnet.MessageContext `enc:"-"`
 	// We validate the message in Handle() and cache the result for Process()
 	valid bool `enc:"-"` // skip it during encoding
+	// The decoded Extra, cached in Handle() for Process()
+	extra IntroductionExtra `enc:"-"`
+}
+
+// IntroductionExtra is the part of the introduction added after version 2
+type IntroductionExtra struct {
+	// AgreeNum overridden on a testnet, 0 if the quorum is derived from the validators
+	AgreeNum int32
+	// Capabilities the optional messages our client handles
+	Capabilities uint64
 }
 
 // NewIntroductionMessage creates introduction message
-func NewIntroductionMessage(mirror uint32, version int32, port uint16) *IntroductionMessage {
+func NewIntroductionMessage(mirror uint32, version int32, port uint16, agreeNum int32, caps Capabilities) *IntroductionMessage {
 	return &IntroductionMessage{
 		Mirror:  mirror,
 		Version: version,
 		Port:    port,
+		Extra: encoder.Serialize(IntroductionExtra{
+			AgreeNum:     agreeNum,
+			Capabilities: uint64(caps),
+		}),
 	}
 }
 
+// Extension decodes the fields added after version 2, the fields of later versions are
+// ignored. Returns false if the peer sent none
+func (intro *IntroductionMessage) Extension() (IntroductionExtra, bool, error) {
+	var extra IntroductionExtra
+	if len(intro.Extra) == 0 {
+		return extra, false, nil
+	}
+	if _, err := encoder.DeserializeRawToValue(intro.Extra, reflect.ValueOf(&extra)); err != nil {
+		return extra, false, err
+	}
+	return extra, true, nil
+}
+
 // Handle Responds to an gnet.Pool event. We implement Handle() here because we
 // need to control the DisconnectReason sent back to gnet.  We still implement
 // Process(), where we do modifications that are not threadsafe
@@ -267,14 +343,32 @@ func (intro *IntroductionMessage) Handle(mc *gnet.MessageContext, daemon interfa
 
 		}
 
-		// Disconnect if not running the same version
-		if intro.Version != d.Config.Version {
-			logger.Infof("%s has different version %d. Disconnecting.",
+		// Disconnect if running a version older than we support, newer
+		// versions only send us the messages we advertise
+		if intro.Version < d.Config.MinVersion {
+			logger.Infof("%s has unsupported version %d. Disconnecting.",
 				mc.Addr, intro.Version)
 			d.Pool.Pool.Disconnect(mc.Addr, ErrDisconnectInvalidVersion)
 			return ErrDisconnectInvalidVersion
 		}
 
+		extra, ok, err := intro.Extension()
+		if err != nil {
+			logger.Infof("%s sent a malformed introduction: %v. Disconnecting.", mc.Addr, err)
+			d.Pool.Pool.Disconnect(mc.Addr, gnet.ErrDisconnectMalformedMessage)
+			return gnet.ErrDisconnectMalformedMessage
+		}
+		intro.extra = extra
+
+		// Disconnect if the peer counts a different quorum, it would never agree on our blocks.
+		// A version 2 peer predates the agree num override and advertises no capabilities
+		if ok && int(extra.AgreeNum) != d.Visor.Config.Config.AgreeNum {
+			logger.Infof("%s has different agree num %d. Disconnecting.",
+				mc.Addr, extra.AgreeNum)
+			d.Pool.Pool.Disconnect(mc.Addr, ErrDisconnectInvalidAgreeNum)
+			return ErrDisconnectInvalidAgreeNum
+		}
+
 		logger.Infof("%s verified for version %d", mc.Addr, intro.Version)
 
 		// only solicited connection can be added to exchange peer list, cause accepted
@@ -292,7 +386,8 @@ func (intro *IntroductionMessage) Handle(mc *gnet.MessageContext, daemon interfa
 			if err := d.Pex.SetHasIncomingPort(mc.Addr, true); err != nil {
 				logger.Errorf("Failed to set peer has incoming port status, %v", err)
 			}
-		} else {
+		} else if !d.Pex.Config.Disabled {
+			// the listening address of the peer is only learned with pex enabled
 			if err := d.Pex.AddPeer(fmt.Sprintf("%s:%d", ip, intro.Port)); err != nil {
 				logger.Errorf("Failed to add peer: %v", err)
 			}
@@ -341,9 +436,14 @@ func (intro *IntroductionMessage) Process(d *Daemon) {
 		return
 	}
 
+	caps := Capabilities(intro.extra.Capabilities)
+	d.peerCapabilities.Add(a, caps)
+	logger.Debugf("%s version %d supports [%s]", a, intro.Version, caps)
+
 	// Request blocks immediately after they're confirmed
-	err = d.Visor.RequestBlocksFromAddr(d.Pool, intro.c.Addr)
-	if err == nil {
+	if d.headersFirst(a) {
+		d.requestHeaders(a)
+	} else if err := d.Visor.RequestBlocksFromAddr(d.Pool, intro.c.Addr); err == nil {
 		logger.Debugf("Successfully requested blocks from %s", intro.c.Addr)
 	} else {
 		logger.Warning(err)
@@ -374,16 +474,23 @@ func (ping *PingMessage) Process(d *Daemon) {
 	}
 }
 
-// PongMessage Sent in reply to a PingMessage.  No action is taken when this is received.
+// PongMessage Sent in reply to a PingMessage.  The round-trip time of the ping is
+// recorded when this is received.
 type PongMessage struct {
 }
 
 // Handle handles message
 func (pong *PongMessage) Handle(mc *gnet.MessageContext, daemon interface{}) error {
-	// There is nothing to do; gnet updates Connection.LastMessage internally
-	// when this is received
-	if daemon.(*Daemon).Config.LogPings {
-		logger.Debugf("Received pong from %s", mc.Addr)
+	// gnet updates Connection.LastMessage internally when this is received,
+	// the round trip is measured here to leave out the message queue
+	d := daemon.(*Daemon)
+	rtt, ok := d.pingTimes.Pong(mc.Addr, d.Visor.v.Now())
+	if d.Config.LogPings {
+		if ok {
+			logger.Debugf("Received pong from %s in %v", mc.Addr, rtt)
+		} else {
+			logger.Debugf("Received pong from %s", mc.Addr)
+		}
 	}
 	return nil
 }
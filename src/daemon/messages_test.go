@@ -1 +1,26 @@
 package daemon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/samoslab/samos/src/daemon/gnet"
+)
+
+func TestMessagesConfigRateLimits(t *testing.T) {
+	cfg := NewMessagesConfig()
+	limits := cfg.RateLimits()
+	require.Len(t, limits, 3)
+	for _, prefix := range []string{"GETB", "GETT", "ANNT"} {
+		require.False(t, limits[gnet.MessagePrefixFromString(prefix)].Unlimited())
+	}
+
+	cfg.Messages = []MessageConfig{
+		NewMessageConfig("PING", PingMessage{}),
+		NewRateLimitedMessageConfig("GETB", GetBlocksMessage{}, 1, 5),
+	}
+	require.Equal(t, map[gnet.MessagePrefix]gnet.RateLimit{
+		gnet.MessagePrefixFromString("GETB"): {Rate: 1, Burst: 5},
+	}, cfg.RateLimits())
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/samoslab/samos/src/util/file"
+	"github.com/samoslab/samos/src/util/iputil"
 	"github.com/samoslab/samos/src/util/utc"
 )
 
@@ -27,11 +28,15 @@ func (ps Peers) ToAddrs() []string {
 // peerlist is a map of addresses to *PeerStates
 type peerlist struct {
 	peers map[string]*Peer
+	// peers not in the list, e.g. incoming connections, tracked only for their
+	// misbehaviour score and ban. They are never exchanged nor tried
+	outsiders map[string]*Peer
 }
 
 func newPeerlist() peerlist {
 	return peerlist{
-		peers: make(map[string]*Peer),
+		peers:     make(map[string]*Peer),
+		outsiders: make(map[string]*Peer),
 	}
 }
 
@@ -159,6 +164,10 @@ func hasIncomingPort(p Peer) bool {
 	return p.HasIncomingPort
 }
 
+func isBanned(p Peer) bool {
+	return p.IsBanned()
+}
+
 func canTry(p Peer) bool {
 	return p.CanTry()
 }
@@ -220,15 +229,22 @@ func (pl *peerlist) getPeerByAddr(addr string) (Peer, bool) {
 	return Peer{}, false
 }
 
-// ClearOld removes public peers that haven't been seen in timeAgo seconds
+// ClearOld removes public peers that haven't been seen in timeAgo seconds,
+// banned peers are kept until their ban expires
 func (pl *peerlist) clearOld(timeAgo time.Duration) {
 	t := utc.Now()
 	for addr, peer := range pl.peers {
 		lastSeen := time.Unix(peer.LastSeen, 0)
-		if !peer.Private && t.Sub(lastSeen) > timeAgo {
+		if !peer.Private && !peer.IsBanned() && t.Sub(lastSeen) > timeAgo {
 			delete(pl.peers, addr)
 		}
 	}
+
+	for addr, peer := range pl.outsiders {
+		if peer.BannedUntil != 0 && !peer.IsBanned() {
+			delete(pl.outsiders, addr)
+		}
+	}
 }
 
 // Returns n random peers, or all of the peers, whichever is lower.
@@ -291,6 +307,95 @@ func (pl *peerlist) resetAllRetryTimes() {
 	}
 }
 
+// tracked returns the peer of addr, a peer not in the list is tracked as an outsider
+func (pl *peerlist) tracked(addr string) *Peer {
+	if p, ok := pl.peers[addr]; ok {
+		return p
+	}
+	p, ok := pl.outsiders[addr]
+	if !ok {
+		p = NewPeer(addr)
+		pl.outsiders[addr] = p
+	}
+	return p
+}
+
+// misbehave adds score to the peer's misbehaviour score, the score of a peer not
+// in the list is kept without adding it to the list.
+// Returns true if the score reached banScore and the peer was banned.
+func (pl *peerlist) misbehave(addr string, score, banScore int, banDuration time.Duration) bool {
+	p := pl.tracked(addr)
+	p.Score += score
+	logger.Debugf("Misbehaviour score of %v: %v", addr, p.Score)
+	if banScore <= 0 || p.Score < banScore {
+		return false
+	}
+
+	if p.Trusted {
+		logger.Warningf("Trusted peer %v reached misbehaviour score %v, not banning", addr, p.Score)
+		p.Score = 0
+		return false
+	}
+
+	pl.ban(addr, banDuration)
+	return true
+}
+
+// ban bans the peer for the duration, a peer not in the list is banned without adding it
+func (pl *peerlist) ban(addr string, duration time.Duration) {
+	p := pl.tracked(addr)
+	p.Score = 0
+	p.BannedUntil = utc.Now().Add(duration).Unix()
+	logger.Infof("Banned %v until %v", addr, time.Unix(p.BannedUntil, 0).UTC())
+}
+
+// unban lifts the ban of the peer
+func (pl *peerlist) unban(addr string) error {
+	p, ok := pl.peers[addr]
+	if !ok {
+		p, ok = pl.outsiders[addr]
+	}
+	if !ok || !p.IsBanned() {
+		return ErrPeerNotBanned
+	}
+
+	p.Score = 0
+	p.BannedUntil = 0
+	logger.Infof("Lifted the ban of %v", addr)
+	return nil
+}
+
+// banned returns the banned peers, in the list or not
+func (pl *peerlist) banned() Peers {
+	ps := pl.getPeers(isBanned)
+	for _, p := range pl.outsiders {
+		if p.IsBanned() {
+			ps = append(ps, *p)
+		}
+	}
+	return ps
+}
+
+// isIPBanned returns whether any peer with the ip of addr is banned
+func (pl *peerlist) isIPBanned(addr string) bool {
+	ip, _, err := iputil.SplitAddr(addr)
+	if err != nil {
+		return false
+	}
+
+	for _, peers := range []map[string]*Peer{pl.peers, pl.outsiders} {
+		for _, p := range peers {
+			if !p.IsBanned() {
+				continue
+			}
+			if pip, _, err := iputil.SplitAddr(p.Addr); err == nil && pip == ip {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // PeerJSON is for saving and loading peers to disk. Some fields are strange,
 // to be backwards compatible due to variable name changes
 type PeerJSON struct {
@@ -302,6 +407,7 @@ type PeerJSON struct {
 	Trusted         bool  // Whether this peer is trusted
 	HasIncomePort   *bool `json:"HasIncomePort,omitempty"` // Whether this peer has incoming port [DEPRECATED]
 	HasIncomingPort *bool // Whether this peer has incoming port
+	BannedUntil     int64 `json:",omitempty"` // Unix timestamp until which this peer is banned
 }
 
 // newPeerJSON returns a PeerJSON from a Peer
@@ -312,6 +418,7 @@ func newPeerJSON(p Peer) PeerJSON {
 		Private:         p.Private,
 		Trusted:         p.Trusted,
 		HasIncomingPort: &p.HasIncomingPort,
+		BannedUntil:     p.BannedUntil,
 	}
 }
 
@@ -355,5 +462,6 @@ func newPeerFromJSON(p PeerJSON) (*Peer, error) {
 		Private:         p.Private,
 		Trusted:         p.Trusted,
 		HasIncomingPort: hasIncomingPort,
+		BannedUntil:     p.BannedUntil,
 	}, nil
 }
@@ -418,6 +418,15 @@ func TestPeerlistSave(t *testing.T) {
 				testPeers[1]: {Addr: testPeers[1]},
 			},
 		},
+		{
+			"save ban",
+			[]Peer{
+				{Addr: testPeers[0], BannedUntil: 1600000000, Score: 10},
+			},
+			map[string]Peer{
+				testPeers[0]: {Addr: testPeers[0], BannedUntil: 1600000000},
+			},
+		},
 	}
 
 	for _, tc := range tt {
@@ -462,6 +471,20 @@ func TestPeerCanTry(t *testing.T) {
 	}
 }
 
+func TestPeerlistIsIPBanned(t *testing.T) {
+	pl := newPeerlist()
+	pl.setPeers([]Peer{
+		{Addr: testPeers[0], BannedUntil: utc.Now().Add(time.Hour).Unix()},
+		{Addr: testPeers[1], BannedUntil: utc.Now().Add(-time.Hour).Unix()},
+	})
+
+	require.True(t, pl.isIPBanned(testPeers[0]))
+	require.True(t, pl.isIPBanned("112.32.32.14:51234"))
+	require.False(t, pl.isIPBanned(testPeers[1]))
+	require.False(t, pl.isIPBanned(testPeers[2]))
+	require.False(t, pl.isIPBanned("invalid"))
+}
+
 func TestPeerJSONParsing(t *testing.T) {
 	// The serialized peer json format changed,
 	// this tests that the old format can still parse.
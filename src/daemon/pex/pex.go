@@ -50,6 +50,8 @@ var (
 	ErrPortTooLow = errors.New("Port must be >= 1024")
 	// ErrBlacklistedAddress returned when attempting to add a blacklisted peer
 	ErrBlacklistedAddress = errors.New("Blacklisted address")
+	// ErrPeerNotBanned is returned when lifting the ban of a peer that is not banned
+	ErrPeerNotBanned = errors.New("Peer is not banned")
 
 	// Logging. See http://godoc.org/github.com/op/go-logging for
 	// instructions on how to include this log's output
@@ -99,6 +101,8 @@ type Peer struct {
 	Trusted         bool   // Whether this peer is trusted
 	HasIncomingPort bool   // Whether this peer has accessable public port
 	RetryTimes      int    `json:"-"` // records the retry times
+	Score           int    `json:"-"` // misbehaviour score, the peer is banned once it reaches Config.BanScore
+	BannedUntil     int64  // Unix timestamp until which the peer is banned, 0 if it is not banned
 }
 
 // NewPeer returns a *Peer initialised by an address string of the form ip:port
@@ -129,8 +133,17 @@ func (peer *Peer) ResetRetryTimes() {
 	logger.Debugf("Reset retry times of %v", peer.Addr)
 }
 
+// IsBanned returns whether the peer is banned
+func (peer *Peer) IsBanned() bool {
+	return peer.BannedUntil > utc.UnixNow()
+}
+
 // CanTry returns whether this peer is tryable base on the exponential backoff algorithm
 func (peer *Peer) CanTry() bool {
+	if peer.IsBanned() {
+		return false
+	}
+
 	// Exponential backoff
 	mod := (math.Exp2(float64(peer.RetryTimes)) - 1) * 5
 	if mod == 0 {
@@ -176,6 +189,10 @@ type Config struct {
 	DownloadPeerList bool
 	// Download peers list from this URL
 	PeerListURL string
+	// Misbehaviour score at which a peer is banned
+	BanScore int
+	// How long a misbehaving peer is banned for
+	BanDuration time.Duration
 }
 
 // NewConfig creates default pex config.
@@ -194,6 +211,8 @@ func NewConfig() Config {
 		NetworkDisabled:     false,
 		DownloadPeerList:    false,
 		PeerListURL:         DefaultPeerListURL,
+		BanScore:            100,
+		BanDuration:         time.Hour * 24,
 	}
 }
 
@@ -520,6 +539,59 @@ func (px *Pex) ResetAllRetryTimes() {
 	px.peerlist.resetAllRetryTimes()
 }
 
+// Misbehave adds score to the misbehaviour score of the peer, and bans the peer
+// for Config.BanDuration once the score reaches Config.BanScore. Trusted peers
+// are never banned. Returns true if the peer was banned.
+func (px *Pex) Misbehave(addr string, score int) bool {
+	px.Lock()
+	defer px.Unlock()
+
+	cleanAddr, err := validateAddress(addr, px.Config.AllowLocalhost)
+	if err != nil {
+		logger.Errorf("Invalid address %s: %v", addr, err)
+		return false
+	}
+
+	return px.peerlist.misbehave(cleanAddr, score, px.Config.BanScore, px.Config.BanDuration)
+}
+
+// Ban bans the peer for the duration
+func (px *Pex) Ban(addr string, duration time.Duration) error {
+	px.Lock()
+	defer px.Unlock()
+
+	cleanAddr, err := validateAddress(addr, px.Config.AllowLocalhost)
+	if err != nil {
+		logger.Errorf("Invalid address %s: %v", addr, err)
+		return ErrInvalidAddress
+	}
+
+	px.peerlist.ban(cleanAddr, duration)
+	return nil
+}
+
+// Unban lifts the ban of the peer and resets its misbehaviour score
+func (px *Pex) Unban(addr string) error {
+	px.Lock()
+	defer px.Unlock()
+	return px.peerlist.unban(addr)
+}
+
+// IsBanned returns whether the ip of the address is banned. The ban applies to
+// every port of the ip, so a banned peer can't reconnect from another port.
+func (px *Pex) IsBanned(addr string) bool {
+	px.RLock()
+	defer px.RUnlock()
+	return px.peerlist.isIPBanned(addr)
+}
+
+// Banned returns the banned peers
+func (px *Pex) Banned() Peers {
+	px.RLock()
+	defer px.RUnlock()
+	return px.peerlist.banned()
+}
+
 // IsFull returns whether the peer list is full
 func (px *Pex) IsFull() bool {
 	px.RLock()
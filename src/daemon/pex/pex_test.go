@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -1211,6 +1212,120 @@ func TestPexSetTrusted(t *testing.T) {
 	}
 }
 
+func TestPexMisbehave(t *testing.T) {
+	tt := []struct {
+		name      string
+		initPeers []Peer
+		peer      string
+		scores    []int
+		banned    bool
+		score     int
+	}{
+		{
+			"below ban score",
+			[]Peer{*NewPeer(testPeers[0])},
+			testPeers[0],
+			[]int{50, 40},
+			false,
+			90,
+		},
+		{
+			"reach ban score",
+			[]Peer{*NewPeer(testPeers[0])},
+			testPeers[0],
+			[]int{50, 50},
+			true,
+			0,
+		},
+		{
+			"unknown peer below ban score",
+			nil,
+			testPeers[0],
+			[]int{50},
+			false,
+			0,
+		},
+		{
+			"unknown peer",
+			nil,
+			testPeers[0],
+			[]int{50, 50},
+			true,
+			0,
+		},
+		{
+			"trusted peer",
+			[]Peer{{Addr: testPeers[0], Trusted: true}},
+			testPeers[0],
+			[]int{100},
+			false,
+			0,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := NewConfig()
+			pex := &Pex{
+				Config:   cfg,
+				peerlist: newPeerlist(),
+			}
+			pex.peerlist.setPeers(tc.initPeers)
+
+			var banned bool
+			for _, s := range tc.scores {
+				banned = pex.Misbehave(tc.peer, s)
+			}
+			require.Equal(t, tc.banned, banned)
+
+			if tc.initPeers == nil {
+				// the score of a peer not in the list is kept without adding it
+				_, ok := pex.GetPeerByAddr(tc.peer)
+				require.False(t, ok)
+				require.Equal(t, tc.banned, pex.IsBanned(tc.peer))
+				if tc.banned {
+					require.Equal(t, []string{tc.peer}, pex.Banned().ToAddrs())
+					require.NoError(t, pex.Unban(tc.peer))
+					require.False(t, pex.IsBanned(tc.peer))
+				}
+				return
+			}
+
+			p, ok := pex.GetPeerByAddr(tc.peer)
+			require.True(t, ok)
+			require.Equal(t, tc.banned, p.IsBanned())
+			require.Equal(t, tc.banned, pex.IsBanned(tc.peer))
+			require.Equal(t, tc.score, p.Score)
+			require.Equal(t, !tc.banned, p.CanTry())
+
+			if tc.banned {
+				require.WithinDuration(t, utc.Now().Add(cfg.BanDuration), time.Unix(p.BannedUntil, 0), time.Second)
+				require.Equal(t, []string{tc.peer}, pex.Banned().ToAddrs())
+			} else {
+				require.Empty(t, pex.Banned())
+			}
+		})
+	}
+}
+
+func TestPexUnban(t *testing.T) {
+	pex := &Pex{
+		Config:   NewConfig(),
+		peerlist: newPeerlist(),
+	}
+	pex.peerlist.setPeers([]Peer{*NewPeer(testPeers[0])})
+
+	require.Equal(t, ErrPeerNotBanned, pex.Unban(testPeers[0]))
+	require.Equal(t, ErrPeerNotBanned, pex.Unban(testPeers[1]))
+
+	require.NoError(t, pex.Ban(testPeers[0], time.Hour))
+	require.True(t, pex.IsBanned(testPeers[0]))
+
+	require.NoError(t, pex.Unban(testPeers[0]))
+	require.False(t, pex.IsBanned(testPeers[0]))
+	require.Empty(t, pex.Banned())
+}
+
 func TestPexSetHasIncomingPort(t *testing.T) {
 	tt := []struct {
 		name            string
@@ -3,6 +3,7 @@ package daemon
 import (
 	"time"
 
+	"github.com/samoslab/samos/src/cipher"
 	//"github.com/samoslab/samos/src/daemon/gnet"
 	"github.com/samoslab/samos/src/daemon/gnet"
 )
@@ -23,9 +24,19 @@ type PoolConfig struct {
 	ClearStaleRate time.Duration
 	// Buffer size for gnet.ConnectionPool's network Read events
 	EventChannelSize int
+	// Encrypt and authenticate connections, all peers must enable it
+	Encrypt bool
+	// Trust keys the peers at these addresses must prove on encrypted connections
+	PinnedKeys map[string]cipher.PubKey
 	// These should be assigned by the controlling daemon
 	address string
 	port    int
+	// identity key proved on encrypted connections
+	identityKey cipher.SecKey
+	// rate limits of the messages received from each connection
+	connectionRateLimit gnet.RateLimit
+	messageRateLimits   map[gnet.MessagePrefix]gnet.RateLimit
+	maxRateViolations   int
 }
 
 // NewPoolConfig creates pool config
@@ -41,6 +52,8 @@ func NewPoolConfig() PoolConfig {
 		IdleCheckRate:       1 * time.Second,
 		ClearStaleRate:      1 * time.Second,
 		EventChannelSize:    4096,
+		Encrypt:             false,
+		PinnedKeys:          make(map[string]cipher.PubKey),
 	}
 }
 
@@ -48,13 +61,16 @@ func NewPoolConfig() PoolConfig {
 type Pool struct {
 	Config PoolConfig
 	Pool   *gnet.ConnectionPool
+
+	capabilities *PeerCapabilities
 }
 
 // NewPool creates pool
 func NewPool(c PoolConfig, d *Daemon) *Pool {
 	pool := &Pool{
-		Config: c,
-		Pool:   nil,
+		Config:       c,
+		Pool:         nil,
+		capabilities: d.peerCapabilities,
 	}
 
 	cfg := gnet.NewConfig()
@@ -63,6 +79,14 @@ func NewPool(c PoolConfig, d *Daemon) *Pool {
 	cfg.Address = pool.Config.address
 	cfg.ConnectCallback = d.onGnetConnect
 	cfg.DisconnectCallback = d.onGnetDisconnect
+	cfg.Encrypt = pool.Config.Encrypt
+	cfg.IdentityKey = pool.Config.identityKey
+	cfg.PinnedKeys = pool.Config.PinnedKeys
+	cfg.ConnectionRateLimit = pool.Config.connectionRateLimit
+	if pool.Config.messageRateLimits != nil {
+		cfg.MessageRateLimits = pool.Config.messageRateLimits
+	}
+	cfg.MaxRateViolations = pool.Config.maxRateViolations
 
 	pool.Pool = gnet.NewConnectionPool(cfg, d)
 
@@ -96,3 +120,23 @@ func (pool *Pool) sendPings() {
 func (pool *Pool) clearStaleConnections() {
 	pool.Pool.ClearStaleConnections(pool.Config.IdleLimit, ErrDisconnectIdle)
 }
+
+// BroadcastMessage sends msg to the connections whose peer advertised the
+// capabilities it requires
+func (pool *Pool) BroadcastMessage(msg gnet.Message) error {
+	required := requiredCapabilities(msg)
+	if required == 0 {
+		return pool.Pool.BroadcastMessage(msg)
+	}
+	return pool.Pool.BroadcastMessageTo(msg, func(addr string) bool {
+		return pool.capabilities.Has(addr, required)
+	})
+}
+
+// BroadcastMessageWithout sends the message to the peers lacking any of the
+// capabilities cs, to send them the older message a newer capability replaces
+func (pool *Pool) BroadcastMessageWithout(msg gnet.Message, cs Capabilities) error {
+	return pool.Pool.BroadcastMessageTo(msg, func(addr string) bool {
+		return !pool.capabilities.Has(addr, cs)
+	})
+}
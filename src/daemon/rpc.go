@@ -3,8 +3,11 @@ package daemon
 import (
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/daemon/gnet"
+	"github.com/samoslab/samos/src/util/utc"
 )
 
 // Connection a connection's state within the daemon
@@ -20,6 +23,41 @@ type Connection struct {
 	Introduced bool   `json:"introduced"`
 	Mirror     uint32 `json:"mirror"`
 	ListenPort uint16 `json:"listen_port"`
+	// Whether the traffic is encrypted
+	Encrypted bool `json:"encrypted"`
+	// Trust key the peer proved on the encrypted connection
+	TrustKey string `json:"trust_key,omitempty"`
+	// Optional messages the peer advertised in its introduction
+	Capabilities []string `json:"capabilities"`
+	// Messages of the peer dropped by the rate limits
+	RateViolations int `json:"rate_violations"`
+	// Unix timestamp of the connection and its age in seconds
+	ConnectedAt int64 `json:"connected_at"`
+	Duration    int64 `json:"duration"`
+	// Round-trip time of the last ping in milliseconds, 0 until a ping is answered
+	RTT int64 `json:"rtt"`
+	// Blockchain height the peer last advertised
+	Height uint64 `json:"height"`
+	// Messages sent to and received from the peer by prefix
+	Sent     map[string]MessageStats `json:"sent"`
+	Received map[string]MessageStats `json:"received"`
+}
+
+// MessageStats counts the messages of a prefix and their bytes
+type MessageStats struct {
+	Messages uint64 `json:"messages"`
+	Bytes    uint64 `json:"bytes"`
+}
+
+func newMessageStats(stats map[gnet.MessagePrefix]gnet.MessageStats) map[string]MessageStats {
+	ms := make(map[string]MessageStats, len(stats))
+	for prefix, s := range stats {
+		ms[strings.TrimRight(string(prefix[:]), "\x00")] = MessageStats{
+			Messages: s.Messages,
+			Bytes:    s.Bytes,
+		}
+	}
+	return ms
 }
 
 // Connections an array of connections
@@ -28,6 +66,18 @@ type Connections struct {
 	Connections []*Connection `json:"connections"`
 }
 
+// BannedPeer a peer banned for misbehaving
+type BannedPeer struct {
+	Addr string `json:"address"`
+	// Unix timestamp until which the peer is banned
+	BannedUntil int64 `json:"banned_until"`
+}
+
+// BannedPeers an array of banned peers
+type BannedPeers struct {
+	Peers []BannedPeer `json:"peers"`
+}
+
 // BlockchainProgress current sync blockchain status
 type BlockchainProgress struct {
 	// Our current blockchain length
@@ -68,17 +118,37 @@ func (rpc RPC) GetConnection(d *Daemon, addr string) *Connection {
 	if !exist {
 		return nil
 	}
+	caps, _ := d.peerCapabilities.Get(addr)
+	rtt, _ := d.pingTimes.RTT(addr)
+	height, _ := d.Visor.GetPeerBlockchainHeight(addr)
 
 	return &Connection{
-		ID:           c.ID,
-		Addr:         addr,
-		LastSent:     c.LastSent.Unix(),
-		LastReceived: c.LastReceived.Unix(),
-		Outgoing:     !d.outgoingConnections.Get(addr),
-		Introduced:   !d.needsIntro(addr),
-		Mirror:       mirror,
-		ListenPort:   d.GetListenPort(addr),
+		ID:             c.ID,
+		Addr:           addr,
+		LastSent:       c.LastSent.Unix(),
+		LastReceived:   c.LastReceived.Unix(),
+		Outgoing:       !d.outgoingConnections.Get(addr),
+		Introduced:     !d.needsIntro(addr),
+		Mirror:         mirror,
+		ListenPort:     d.GetListenPort(addr),
+		Encrypted:      c.Encrypted,
+		TrustKey:       remoteKeyHex(c.RemoteKey),
+		Capabilities:   caps.Names(),
+		RateViolations: c.RateViolations,
+		ConnectedAt:    c.ConnectedAt.Unix(),
+		Duration:       int64(utc.Now().Sub(c.ConnectedAt) / time.Second),
+		RTT:            int64(rtt / time.Millisecond),
+		Height:         height,
+		Sent:           newMessageStats(c.Sent),
+		Received:       newMessageStats(c.Received),
+	}
+}
+
+func remoteKeyHex(pk cipher.PubKey) string {
+	if pk == (cipher.PubKey{}) {
+		return ""
 	}
+	return pk.Hex()
 }
 
 // GetConnections gets all connections
@@ -132,6 +202,29 @@ func (rpc RPC) GetAllExchgConnections(d *Daemon) []string {
 	return d.Pex.RandomExchangeable(0).ToAddrs()
 }
 
+// GetBannedPeers returns the banned peers sorted by address
+func (rpc RPC) GetBannedPeers(d *Daemon) *BannedPeers {
+	peers := d.Pex.Banned()
+	bps := make([]BannedPeer, 0, len(peers))
+	for _, p := range peers {
+		bps = append(bps, BannedPeer{
+			Addr:        p.Addr,
+			BannedUntil: p.BannedUntil,
+		})
+	}
+
+	sort.Slice(bps, func(i, j int) bool {
+		return bps[i].Addr < bps[j].Addr
+	})
+
+	return &BannedPeers{Peers: bps}
+}
+
+// UnbanPeer lifts the ban of a peer
+func (rpc RPC) UnbanPeer(d *Daemon, addr string) error {
+	return d.Pex.Unban(addr)
+}
+
 // GetBlockchainProgress gets the blockchain progress
 func (rpc RPC) GetBlockchainProgress(v *Visor) *BlockchainProgress {
 	if v.v == nil {
@@ -140,6 +140,156 @@ func (cm *ConnectionMirrors) Remove(addr string) {
 	cm.remove(addr)
 }
 
+// PeerCapabilities records the capabilities advertised by the peer of a connection
+type PeerCapabilities struct {
+	store
+}
+
+// NewPeerCapabilities create PeerCapabilities instance.
+func NewPeerCapabilities() *PeerCapabilities {
+	return &PeerCapabilities{
+		store: store{
+			value: make(map[interface{}]interface{}),
+		},
+	}
+}
+
+// Add records the capabilities of connection
+func (pc *PeerCapabilities) Add(addr string, cs Capabilities) {
+	pc.setValue(addr, cs)
+}
+
+// Get returns the capabilities of connection
+func (pc *PeerCapabilities) Get(addr string) (Capabilities, bool) {
+	v, ok := pc.getValue(addr)
+	if ok {
+		return v.(Capabilities), ok
+	}
+	return 0, false
+}
+
+// Has returns true if the peer of connection advertised all of cs
+func (pc *PeerCapabilities) Has(addr string, cs Capabilities) bool {
+	v, _ := pc.Get(addr)
+	return v.Has(cs)
+}
+
+// Remove remove connection capabilities
+func (pc *PeerCapabilities) Remove(addr string) {
+	pc.remove(addr)
+}
+
+// GetBlocksRequests counts the GetBlocksMessages of each connection that don't
+// ask for newer blocks than the previous request, to detect peers spamming them
+type GetBlocksRequests struct {
+	store
+}
+
+type getBlocksRequest struct {
+	lastBlock uint64
+	since     time.Time
+	repeats   int
+}
+
+// NewGetBlocksRequests creates GetBlocksRequests instance
+func NewGetBlocksRequests() *GetBlocksRequests {
+	return &GetBlocksRequests{
+		store: store{
+			value: make(map[interface{}]interface{}),
+		},
+	}
+}
+
+// Add records a request for the blocks after lastBlock received at t, and returns
+// the number of times the request was repeated within window of the first one
+func (gr *GetBlocksRequests) Add(addr string, lastBlock uint64, t time.Time, window time.Duration) int {
+	var repeats int
+	gr.do(func(s *store) error {
+		r, ok := s.value[addr].(getBlocksRequest)
+		if !ok || lastBlock > r.lastBlock || t.Sub(r.since) > window {
+			r = getBlocksRequest{
+				lastBlock: lastBlock,
+				since:     t,
+			}
+		} else {
+			r.repeats++
+		}
+		s.value[addr] = r
+		repeats = r.repeats
+		return nil
+	})
+	return repeats
+}
+
+// Remove removes the requests of connection
+func (gr *GetBlocksRequests) Remove(addr string) {
+	gr.remove(addr)
+}
+
+// PingTimes measures the round-trip time of the pings sent to each connection
+type PingTimes struct {
+	store
+}
+
+type pingTime struct {
+	sent time.Time
+	rtt  time.Duration
+}
+
+// NewPingTimes creates PingTimes instance
+func NewPingTimes() *PingTimes {
+	return &PingTimes{
+		store: store{
+			value: make(map[interface{}]interface{}),
+		},
+	}
+}
+
+// Sent records a ping sent at t, the round trip is measured from the
+// earliest ping not answered yet
+func (pt *PingTimes) Sent(addr string, t time.Time) {
+	pt.do(func(s *store) error {
+		p, _ := s.value[addr].(pingTime)
+		if p.sent.IsZero() {
+			p.sent = t
+		}
+		s.value[addr] = p
+		return nil
+	})
+}
+
+// Pong records a pong received at t, returns false if no ping is waiting for it
+func (pt *PingTimes) Pong(addr string, t time.Time) (time.Duration, bool) {
+	var rtt time.Duration
+	var ok bool
+	pt.do(func(s *store) error {
+		p, _ := s.value[addr].(pingTime)
+		if p.sent.IsZero() {
+			return nil
+		}
+		p.rtt = t.Sub(p.sent)
+		p.sent = time.Time{}
+		s.value[addr] = p
+		rtt, ok = p.rtt, true
+		return nil
+	})
+	return rtt, ok
+}
+
+// RTT returns the round-trip time of the last ping answered by connection
+func (pt *PingTimes) RTT(addr string) (time.Duration, bool) {
+	v, ok := pt.getValue(addr)
+	if !ok || v.(pingTime).rtt == 0 {
+		return 0, false
+	}
+	return v.(pingTime).rtt, true
+}
+
+// Remove removes the pings of connection
+func (pt *PingTimes) Remove(addr string) {
+	pt.remove(addr)
+}
+
 // OutgoingConnections records the outgoing connections
 type OutgoingConnections struct {
 	store
@@ -225,6 +225,78 @@ func TestConnMirrorsRemove(t *testing.T) {
 	assert.True(t, ok)
 }
 
+func TestPeerCapabilities(t *testing.T) {
+	pc := NewPeerCapabilities()
+	_, ok := pc.Get("a")
+	assert.False(t, ok)
+	assert.False(t, pc.Has("a", CapCommit))
+	assert.True(t, pc.Has("a", 0))
+
+	pc.Add("a", CapCommit|CapEvidence)
+	cs, ok := pc.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, CapCommit|CapEvidence, cs)
+	assert.True(t, pc.Has("a", CapCommit))
+	assert.False(t, pc.Has("a", CapCommit|CapViewChange))
+
+	pc.Remove("a")
+	assert.Equal(t, 0, len(pc.value))
+	assert.False(t, pc.Has("a", CapCommit))
+}
+
+func TestGetBlocksRequests(t *testing.T) {
+	gr := NewGetBlocksRequests()
+	now := time.Now()
+
+	assert.Equal(t, 0, gr.Add("a", 10, now, time.Minute))
+	assert.Equal(t, 1, gr.Add("a", 10, now.Add(time.Second), time.Minute))
+	assert.Equal(t, 2, gr.Add("a", 9, now.Add(2*time.Second), time.Minute))
+	assert.Equal(t, 0, gr.Add("b", 10, now, time.Minute))
+
+	// asking for newer blocks is not a repeat
+	assert.Equal(t, 0, gr.Add("a", 11, now.Add(3*time.Second), time.Minute))
+	assert.Equal(t, 1, gr.Add("a", 11, now.Add(4*time.Second), time.Minute))
+
+	// the count restarts once the window has passed
+	assert.Equal(t, 0, gr.Add("a", 11, now.Add(2*time.Minute), time.Minute))
+
+	gr.Remove("a")
+	gr.Remove("b")
+	assert.Equal(t, 0, len(gr.value))
+}
+
+func TestPingTimes(t *testing.T) {
+	pt := NewPingTimes()
+	now := time.Now()
+
+	_, ok := pt.Pong("a", now)
+	assert.False(t, ok)
+	_, ok = pt.RTT("a")
+	assert.False(t, ok)
+
+	pt.Sent("a", now)
+	// measured from the earliest ping not answered
+	pt.Sent("a", now.Add(time.Second))
+	rtt, ok := pt.Pong("a", now.Add(1500*time.Millisecond))
+	assert.True(t, ok)
+	assert.Equal(t, 1500*time.Millisecond, rtt)
+
+	// a pong without a ping keeps the last round trip
+	_, ok = pt.Pong("a", now.Add(time.Minute))
+	assert.False(t, ok)
+	rtt, ok = pt.RTT("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1500*time.Millisecond, rtt)
+
+	pt.Sent("a", now.Add(2*time.Minute))
+	rtt, ok = pt.RTT("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1500*time.Millisecond, rtt)
+
+	pt.Remove("a")
+	assert.Equal(t, 0, len(pt.value))
+}
+
 func TestNewOutgoingConnections(t *testing.T) {
 	oc := NewOutgoingConnections(3)
 	assert.NotNil(t, oc)
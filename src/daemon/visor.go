@@ -10,16 +10,13 @@ import (
 
 	"github.com/samoslab/samos/src/cipher"
 	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/consensus/pbft"
 	"github.com/samoslab/samos/src/daemon/gnet"
 	"github.com/samoslab/samos/src/daemon/strand"
-	"github.com/samoslab/samos/src/util/utc"
 	"github.com/samoslab/samos/src/visor"
+	"github.com/samoslab/samos/src/visor/blockdb"
 )
 
-//TODO
-//- download block headers
-//- request blocks individually across multiple peers
-
 //TODO
 //- use CXO for blocksync
 
@@ -37,8 +34,17 @@ type VisorConfig struct {
 	BlocksRequestRate time.Duration
 	// How often to announce our blocks to peers
 	BlocksAnnounceRate time.Duration
-	// How many blocks to respond with to a GetBlocksMessage
+	// How many blocks to respond with to a GetBlocksMessage, and bodies to
+	// request and respond with in a GetBodiesMessage
 	BlocksResponseCount uint64
+	// How many headers to respond with to a GetHeadersMessage
+	HeadersResponseCount uint64
+	// Maximum number of synced headers waiting for their bodies
+	MaxPendingHeaders int
+	// How often to request the bodies of the synced headers
+	BodiesRequestRate time.Duration
+	// How long to wait for the bodies before requesting them from another peer
+	BodiesRequestTimeout time.Duration
 	// How long between saving copies of the blockchain
 	BlockchainBackupRate time.Duration
 	// Max announce txns hash number
@@ -64,6 +70,10 @@ func NewVisorConfig() VisorConfig {
 		BlocksRequestRate:     time.Second * 60,
 		BlocksAnnounceRate:    time.Second * 60,
 		BlocksResponseCount:   20,
+		HeadersResponseCount:  500,
+		MaxPendingHeaders:     5000,
+		BodiesRequestRate:     time.Second,
+		BodiesRequestTimeout:  time.Second * 10,
 		BlockchainBackupRate:  time.Second * 30,
 		MaxTxnAnnounceNum:     16,
 		TxnsAnnounceRate:      time.Minute,
@@ -189,6 +199,34 @@ func (vs *Visor) RequestBlocks(pool *Pool) error {
 	return err
 }
 
+// RequestBackfill sends a GetBlocksMessage for the blocks below the lowest block
+// of a chain started from a snapshot to all connections
+func (vs *Visor) RequestBackfill(pool *Pool) error {
+	if vs.Config.DisableNetworking {
+		return nil
+	}
+
+	err := vs.strand("RequestBackfill", func() error {
+		cp := vs.v.Blockchain.Checkpoint()
+		if cp == nil {
+			return nil
+		}
+
+		from := uint64(1)
+		if cp.Lowest > vs.Config.BlocksResponseCount+1 {
+			from = cp.Lowest - vs.Config.BlocksResponseCount
+		}
+		m := NewGetBlocksMessage(from-1, cp.Lowest-from)
+		return pool.Pool.BroadcastMessage(m)
+	})
+
+	if err != nil {
+		logger.Debugf("Broadcast GetBlocksMessage for back-fill failed: %v", err)
+	}
+
+	return err
+}
+
 // AnnounceBlocks sends an AnnounceBlocksMessage to all connections
 func (vs *Visor) AnnounceBlocks(pool *Pool) error {
 	if vs.Config.DisableNetworking {
@@ -346,7 +384,7 @@ func (vs *Visor) RequestBlocksFromAddr(pool *Pool, addr string) error {
 // SetTxnsAnnounced sets all txns as announced
 func (vs *Visor) SetTxnsAnnounced(txns []cipher.SHA256) {
 	vs.strand("SetTxnsAnnounced", func() error {
-		now := utc.Now()
+		now := vs.v.Now()
 		for _, h := range txns {
 			if err := vs.v.Unconfirmed.SetAnnounced(h, now); err != nil {
 				logger.Error("Failed to set unconfirmed txn announce time: ", err)
@@ -372,6 +410,24 @@ func (vs *Visor) InjectBroadcastTransaction(txn coin.Transaction, pool *Pool) er
 	})
 }
 
+// CommitEvidence injects and broadcasts a transaction committing the evidence, so the
+// double signing trust node is excluded once a block executes it
+func (vs *Visor) CommitEvidence(e pbft.Evidence, pool *Pool) error {
+	return vs.strand("CommitEvidence", func() error {
+		txn, err := vs.v.CreateEvidenceTransaction(e)
+		if err != nil || txn == nil {
+			return err
+		}
+
+		if _, err := vs.v.InjectTransactionStrict(*txn); err != nil {
+			return err
+		}
+
+		logger.Infof("Committing evidence against %s in transaction %s", e.PubKey.Hex(), txn.TxIDHex())
+		return vs.broadcastTransaction(*txn, pool)
+	})
+}
+
 // InjectTransaction adds a transaction to the unconfirmed txn pool if it does not violate hard constraints.
 // The transaction is added to the pool if it only violates soft constraints.
 // If a soft constraint is violated, the specific error is returned separately.
@@ -386,17 +442,6 @@ func (vs *Visor) InjectTransaction(tx coin.Transaction) (bool, *visor.ErrTxnViol
 	return known, softErr, err
 }
 
-// Sends a signed block to all connections.
-// TODO: deprecate, should only send to clients that request by hash
-func (vs *Visor) broadcastBlock(sb coin.SignedBlock, pool *Pool) error {
-	if vs.Config.DisableNetworking {
-		return nil
-	}
-
-	m := NewGiveBlocksMessage([]coin.SignedBlock{sb})
-	return pool.Pool.BroadcastMessage(m)
-}
-
 // Sends a signed block to all connections.
 // TODO: deprecate, should only send to clients that request by hash
 func (vs *Visor) broadcastPendingBlock(sb coin.PendingSignedBlock, pool *Pool) error {
@@ -425,15 +470,15 @@ func (vs *Visor) BroadcastMessage(pool *Pool) error {
 	}
 
 	if vs.v.IsGenesisNode() {
-		// Locate all txns from the unconfirmed pool
-		trustNodes := vs.TrustNodes()
+		// Distribute the genesis trust nodes, validator changes are replayed from the chain
+		trustNodes := vs.v.GenesisTrustNodes()
 		m := NewGiveTrustMessage(trustNodes, vs.v.Config.BlockchainSeckey)
 		if err := pool.Pool.BroadcastMessage(m); err != nil {
 			logger.Errorf("Broadcast GiveTrustMessage failed: %v", err)
 			return err
 		}
-		num := vs.v.GetAgreeNodeNum()
-		if num > 0 && num <= len(trustNodes) {
+		num := vs.v.Config.AgreeNum
+		if num > 0 {
 			m := NewGiveAgreeNumMessage(num, vs.v.Config.BlockchainSeckey)
 			return pool.Pool.BroadcastMessage(m)
 		}
@@ -573,6 +618,17 @@ func (vs *Visor) EstimateBlockchainHeight() uint64 {
 	return maxLen
 }
 
+// GetPeerBlockchainHeight returns the blockchain height reported by the peer of addr
+func (vs *Visor) GetPeerBlockchainHeight(addr string) (uint64, bool) {
+	var height uint64
+	var ok bool
+	vs.strand("GetPeerBlockchainHeight", func() error {
+		height, ok = vs.blockchainHeights[addr]
+		return nil
+	})
+	return height, ok
+}
+
 // PeerBlockchainHeight is a peer's IP address with their reported blockchain height
 type PeerBlockchainHeight struct {
 	Address string
@@ -618,6 +674,66 @@ func (vs *Visor) ExecuteSignedBlock(b coin.SignedBlock) error {
 	})
 }
 
+// BackfillBlocks stores the blocks below the lowest block of a chain started
+// from a snapshot, returns the number of blocks stored
+func (vs *Visor) BackfillBlocks(blocks []coin.SignedBlock) (int, error) {
+	var n int
+	err := vs.strand("BackfillBlocks", func() error {
+		var err error
+		n, err = vs.v.BackfillBlocks(blocks)
+		return err
+	})
+	return n, err
+}
+
+// HeadBlockHeader returns the header of the head block, false if the blockchain is empty
+func (vs *Visor) HeadBlockHeader() (coin.BlockHeader, bool) {
+	var head coin.BlockHeader
+	var ok bool
+	vs.strand("HeadBlockHeader", func() error {
+		if vs.v.Blockchain.Len() == 0 {
+			return nil
+		}
+
+		b, err := vs.v.GetHeadBlock()
+		if err != nil {
+			return err
+		}
+		head = b.Head
+		ok = true
+		return nil
+	})
+	return head, ok
+}
+
+// VerifyBlockHeader verifies the header is signed by a trust node
+func (vs *Visor) VerifyBlockHeader(h coin.SignedBlockHeader) error {
+	return vs.strand("VerifyBlockHeader", func() error {
+		return vs.v.VerifyBlockHeader(h)
+	})
+}
+
+// VerifyBlockIntegrity returns an error if the block is invalid whatever the state of the
+// chain it is executed on: the header is not signed by a trust node or the body does not
+// hash to the body hash of the header
+func (vs *Visor) VerifyBlockIntegrity(b coin.SignedBlock) error {
+	if b.Block.Head.BodyHash != b.Block.Body.Hash() {
+		return errors.New("block body does not match the body hash of the header")
+	}
+	return vs.VerifyBlockHeader(b.SignedHeader())
+}
+
+// GetSignedBlockByHash returns the signed block of hash, nil if not found
+func (vs *Visor) GetSignedBlockByHash(hash cipher.SHA256) (*coin.SignedBlock, error) {
+	var sb *coin.SignedBlock
+	err := vs.strand("GetSignedBlockByHash", func() error {
+		var err error
+		sb, err = vs.v.GetBlockByHash(hash)
+		return err
+	})
+	return sb, err
+}
+
 // GetSignedBlock returns a copy of signed block at seq.
 // Returns error if seq is greater than blockhain height.
 func (vs *Visor) GetSignedBlock(seq uint64) (*coin.SignedBlock, error) {
@@ -651,6 +767,16 @@ func (vs *Visor) UnConfirmFilterKnown(txns []cipher.SHA256) []cipher.SHA256 {
 	return ts
 }
 
+// UnconfirmedTxns returns the transactions of the unconfirmed pool
+func (vs *Visor) UnconfirmedTxns() coin.Transactions {
+	var txns coin.Transactions
+	vs.strand("UnconfirmedTxns", func() error {
+		txns = vs.v.Unconfirmed.RawTxns()
+		return nil
+	})
+	return txns
+}
+
 // UnConfirmKnow returns all know tansactions
 func (vs *Visor) UnConfirmKnow(hashes []cipher.SHA256) coin.Transactions {
 	var txns coin.Transactions
@@ -671,16 +797,48 @@ func (vs *Visor) AddPendingBlock(block coin.SignedBlock) error {
 	return vs.v.AddPendingBlock(block)
 }
 
-func CanMakeBlock(dm *Daemon, hash cipher.SHA256) error {
-	creatorNum := len(dm.Visor.V.TrustNodes())
+// CanCommitBlock commits the block once AgreeNum validators prepared it,
+// the commit of this node is recorded and broadcast
+func CanCommitBlock(dm *Daemon, hash cipher.SHA256) error {
+	if !dm.Visor.V.Config.IsMaster {
+		return nil
+	}
+	pubkey := dm.Visor.V.Config.BlockchainTrustPubkey
+	if dm.Visor.V.CheckCommitExists(hash, pubkey) {
+		return nil
+	}
 	currentNum, err := dm.Visor.V.GetValidatorNumber(hash)
+	if err != nil {
+		return err
+	}
+	agreeNum, err := dm.Visor.V.BlockAgreeNum(hash)
+	if err != nil {
+		return err
+	}
+	if currentNum < agreeNum {
+		return nil
+	}
+	view, err := dm.Visor.V.GetBlockView(hash)
+	if err != nil {
+		return err
+	}
+	m := NewCommitMessage(hash, view, dm.Visor.V.Config.BlockchainTrustSeckey)
+	if err := dm.Visor.V.AddCommit(hash, pubkey, pbft.CommitSig{View: view, Sig: m.Sig}); err != nil {
+		return err
+	}
+	return dm.Pool.BroadcastMessage(m)
+}
+
+// CanMakeBlock executes the block once AgreeNum validators committed it
+func CanMakeBlock(dm *Daemon, hash cipher.SHA256) error {
+	currentNum, err := dm.Visor.V.GetCommitNumber(hash)
 	if err != nil {
 		logger.Errorf("Get Validator Number failed: %v", err)
 		return err
 	}
-	agreeNum := dm.Visor.V.GetAgreeNodeNum()
-	if agreeNum <= 0 || agreeNum > creatorNum {
-		agreeNum = creatorNum
+	agreeNum, err := dm.Visor.V.BlockAgreeNum(hash)
+	if err != nil {
+		return err
 	}
 	if currentNum >= agreeNum {
 		err := dm.Visor.V.StartExecuteSignedBlock(hash)
@@ -692,6 +850,34 @@ func CanMakeBlock(dm *Daemon, hash cipher.SHA256) error {
 	return nil
 }
 
+// executeCommittedBlock executes the committed block and broadcasts it
+func executeCommittedBlock(d *Daemon, hash cipher.SHA256) {
+	if err := CanMakeBlock(d, hash); err != nil {
+		return
+	}
+	if !d.Visor.v.CheckHashExistsInChain(hash) {
+		return
+	}
+
+	sb, err := d.Visor.v.GetBlockByHash(hash)
+	if err != nil || sb == nil {
+		logger.Errorf("get block by hash %s failed", hash.Hex())
+		return
+	}
+	if err := d.broadcastBlock(*sb); err != nil {
+		logger.Errorf("broadcast block %s failed", sb.HashHeader())
+	}
+
+	// nodes that do not take part in pbft finalize the block with the certificate
+	cert, ok, err := d.Visor.v.GetCertificate(hash)
+	if err != nil || !ok {
+		return
+	}
+	if err := d.Pool.BroadcastMessage(NewCertificateMessage(*cert)); err != nil {
+		logger.Errorf("broadcast certificate of block %s failed", hash.Hex())
+	}
+}
+
 // Communication layer for the coin pkg
 
 // GetBlocksMessage sent to request blocks since LastBlock
@@ -724,6 +910,17 @@ func (gbm *GetBlocksMessage) Process(d *Daemon) {
 	if d.Visor.Config.DisableNetworking {
 		return
 	}
+	// A syncing peer asks for newer blocks each time, only a few repeats of
+	// the same request are expected from the periodic requests
+	n := d.getBlocksRequests.Add(gbm.c.Addr, gbm.LastBlock, d.Visor.v.Now(), d.Config.GetBlocksRepeatWindow)
+	if d.Config.GetBlocksRepeatMax > 0 && n > d.Config.GetBlocksRepeatMax {
+		logger.Infof("%s repeated GetBlocksMessage %d times, disconnecting", gbm.c.Addr, n)
+		if err := d.Pool.Pool.Disconnect(gbm.c.Addr, ErrDisconnectGetBlocksSpam); err != nil {
+			logger.Errorf("Disconnect %s failed: %v", gbm.c.Addr, err)
+		}
+		return
+	}
+
 	// Record this as this peer's highest block
 	d.Visor.RecordBlockchainHeight(gbm.c.Addr, gbm.LastBlock)
 	// Fetch and return signed blocks since LastBlock
@@ -772,6 +969,22 @@ func (gbm *GiveBlocksMessage) Process(d *Daemon) {
 		return
 	}
 
+	// blocks below the lowest block of a chain started from a snapshot
+	n, err := d.Visor.BackfillBlocks(gbm.Blocks)
+	if err != nil {
+		logger.Errorf("Failed to back-fill blocks from %s: %v", gbm.c.Addr, err)
+		if err == blockdb.ErrBackfillMismatch {
+			if err := d.Pool.Pool.Disconnect(gbm.c.Addr, ErrDisconnectInvalidBlock); err != nil {
+				logger.Errorf("Disconnect %s failed: %v", gbm.c.Addr, err)
+			}
+		}
+		return
+	}
+	if n > 0 {
+		logger.Infof("Back-filled %d blocks from %s", n, gbm.c.Addr)
+		d.Visor.RequestBackfill(d.Pool)
+	}
+
 	processed := 0
 	maxSeq := d.Visor.HeadBkSeq()
 	for _, b := range gbm.Blocks {
@@ -782,7 +995,11 @@ func (gbm *GiveBlocksMessage) Process(d *Daemon) {
 		// the reply with 15 was received first, we would toss the one with 20
 		// even though we could process it at the time.
 		if b.Seq() <= maxSeq {
-			continue
+			// An unknown block above the finalized block competes with our
+			// chain, the visor decides whether its branch is preferred
+			if b.Seq() <= d.Visor.v.FinalizedSeq() || d.Visor.v.CheckHashExistsInChain(b.HashHeader()) {
+				continue
+			}
 		}
 
 		err := d.Visor.ExecuteSignedBlock(b)
@@ -791,6 +1008,14 @@ func (gbm *GiveBlocksMessage) Process(d *Daemon) {
 			processed++
 		} else {
 			logger.Critical().Errorf("Failed to execute received block %d: %v", b.Block.Head.BkSeq, err)
+			// The peer is only punished for a block no chain accepts, a block failing on
+			// our chain may be valid on a branch we don't follow
+			if err := d.Visor.VerifyBlockIntegrity(b); err != nil {
+				logger.Errorf("Received invalid block %d from %s: %v", b.Seq(), gbm.c.Addr, err)
+				if err := d.Pool.Pool.Disconnect(gbm.c.Addr, ErrDisconnectInvalidBlock); err != nil {
+					logger.Errorf("Disconnect %s failed: %v", gbm.c.Addr, err)
+				}
+			}
 			// Blocks must be received in order, so if one fails its assumed
 			// the rest are failing
 			break
@@ -805,8 +1030,7 @@ func (gbm *GiveBlocksMessage) Process(d *Daemon) {
 	m1 := NewAnnounceBlocksMessage(headBkSeq)
 	d.Pool.Pool.BroadcastMessage(m1)
 	//request more blocks.
-	m2 := NewGetBlocksMessage(headBkSeq, d.Visor.Config.BlocksResponseCount)
-	d.Pool.Pool.BroadcastMessage(m2)
+	d.requestBlocks()
 }
 
 // AnnounceBlocksMessage tells a peer our highest known BkSeq. The receiving peer can choose
@@ -841,6 +1065,12 @@ func (abm *AnnounceBlocksMessage) Process(d *Daemon) {
 		return
 	}
 
+	d.Visor.RecordBlockchainHeight(abm.c.Addr, abm.MaxBkSeq)
+	if d.headersFirst(abm.c.Addr) {
+		d.requestHeaders(abm.c.Addr)
+		return
+	}
+
 	// TODO: Should this be block get request for current sequence?
 	// If client is not caught up, won't attempt to get block
 	m := NewGetBlocksMessage(headBkSeq, d.Visor.Config.BlocksResponseCount)
@@ -1016,8 +1246,8 @@ func (gtm *GetTrustMessage) Process(d *Daemon) {
 
 	// Reply to sender with GiveTrustMessage
 	if d.Visor.v.IsGenesisNode() {
-		// Locate all txns from the unconfirmed pool
-		trustNodes := d.Visor.TrustNodes()
+		// Distribute the genesis trust nodes, validator changes are replayed from the chain
+		trustNodes := d.Visor.v.GenesisTrustNodes()
 		m := NewGiveTrustMessage(trustNodes, d.Visor.v.Config.BlockchainSeckey)
 		if err := d.Pool.Pool.SendMessage(gtm.c.Addr, m); err != nil {
 			logger.Errorf("Send GiveTrustMessage to %s failed: %v", gtm.c.Addr, err)
@@ -1198,6 +1428,9 @@ func (gpm *GivePrepareMessage) Process(d *Daemon) {
 			logger.Errorf("Get block %s validator failed, waiting pending block added", gpm.Hash.Hex())
 			return
 		}
+		if _, err := d.Visor.v.RecordPrepare(gpm.Hash, pubkeyRec, gpm.Sig); err != nil {
+			logger.Errorf("Record prepare of %s failed: %v", pubkeyRec.Hex(), err)
+		}
 		err = d.Visor.v.AddValidator(gpm.Hash, pubkeyRec)
 		if err != nil {
 			logger.Errorf("AddValidator %s for hash failed: %v", pubkeyRec.Hex(), err)
@@ -1205,21 +1438,11 @@ func (gpm *GivePrepareMessage) Process(d *Daemon) {
 		for _, v := range pubkeys {
 			logger.Debugf("pubkey %s", v.Hex())
 		}
-		err = CanMakeBlock(d, gpm.Hash)
-		if err != nil {
-			return
-		}
-
-		sb, err := d.Visor.v.GetBlockByHash(gpm.Hash)
-		if err != nil {
-			logger.Errorf("get block by hash %s failed", gpm.Hash.Hex())
-			return
-		}
-		err = d.Visor.broadcastBlock(*sb, d.Pool)
-		if err != nil {
-			logger.Errorf("broadcast block %s failed", sb.HashHeader())
+		if err := CanCommitBlock(d, gpm.Hash); err != nil {
+			logger.Errorf("Commit block %s failed: %v", gpm.Hash.Hex(), err)
 			return
 		}
+		executeCommittedBlock(d, gpm.Hash)
 	}
 
 	if d.Visor.v.Config.IsMaster {
@@ -1274,6 +1497,267 @@ func (apm *AnnouncePrepareMessage) Process(d *Daemon) {
 	}
 }
 
+// CommitMessage tells that the validator committed the prepared block hash in view
+type CommitMessage struct {
+	Hash cipher.SHA256
+	View uint64
+	Sig  cipher.Sig
+	c    *gnet.MessageContext `enc:"-"`
+}
+
+// NewCommitMessage creates CommitMessage
+func NewCommitMessage(hash cipher.SHA256, view uint64, secKey cipher.SecKey) *CommitMessage {
+	sig := cipher.SignHash(pbft.CommitHash(hash, view), secKey)
+	return &CommitMessage{
+		Hash: hash,
+		View: view,
+		Sig:  sig,
+	}
+}
+
+// Handle handle message
+func (cm *CommitMessage) Handle(mc *gnet.MessageContext,
+	daemon interface{}) error {
+	cm.c = mc
+	return daemon.(*Daemon).recordMessageEvent(cm, mc)
+}
+
+// Process process message
+func (cm *CommitMessage) Process(d *Daemon) {
+	if d.Visor.Config.DisableNetworking {
+		return
+	}
+
+	if !d.Visor.v.Config.IsMaster {
+		return
+	}
+
+	pubkeyRec, err := d.Visor.v.VerifyCommit(cm.Hash, cm.View, cm.Sig)
+	if err != nil {
+		logger.Debugf("Ignore commit of block %s: %v", cm.Hash.Hex(), err)
+		return
+	}
+	if err := d.Visor.v.AddCommit(cm.Hash, pubkeyRec, pbft.CommitSig{View: cm.View, Sig: cm.Sig}); err != nil {
+		return
+	}
+
+	// Relay the commit, validators may not be connected to each other
+	m := &CommitMessage{
+		Hash: cm.Hash,
+		View: cm.View,
+		Sig:  cm.Sig,
+	}
+	d.Pool.Pool.BroadcastMessage(m)
+
+	executeCommittedBlock(d, cm.Hash)
+}
+
+// ViewChangeMessage votes to abandon the view of the pending block seq
+type ViewChangeMessage struct {
+	Seq      uint64
+	View     uint64
+	Prepared cipher.SHA256
+	Sig      cipher.Sig
+	c        *gnet.MessageContext `enc:"-"`
+}
+
+// NewViewChangeMessage creates ViewChangeMessage
+func NewViewChangeMessage(vc pbft.ViewChange) *ViewChangeMessage {
+	return &ViewChangeMessage{
+		Seq:      vc.Seq,
+		View:     vc.View,
+		Prepared: vc.Prepared,
+		Sig:      vc.Sig,
+	}
+}
+
+// ViewChange returns the view change vote
+func (vcm *ViewChangeMessage) ViewChange() pbft.ViewChange {
+	return pbft.ViewChange{
+		Seq:      vcm.Seq,
+		View:     vcm.View,
+		Prepared: vcm.Prepared,
+		Sig:      vcm.Sig,
+	}
+}
+
+// Handle handle message
+func (vcm *ViewChangeMessage) Handle(mc *gnet.MessageContext,
+	daemon interface{}) error {
+	vcm.c = mc
+	return daemon.(*Daemon).recordMessageEvent(vcm, mc)
+}
+
+// Process process message
+func (vcm *ViewChangeMessage) Process(d *Daemon) {
+	if d.Visor.Config.DisableNetworking {
+		return
+	}
+
+	if !d.Visor.v.Config.IsMaster {
+		return
+	}
+
+	changed, err := d.Visor.v.AddViewChange(vcm.ViewChange())
+	if err != nil {
+		logger.Debugf("Ignore view change to %d: %v", vcm.View, err)
+		return
+	}
+
+	// Relay the vote, validators may not be connected to each other
+	d.Pool.BroadcastMessage(NewViewChangeMessage(vcm.ViewChange()))
+
+	if changed {
+		m := NewNewViewMessage(vcm.View, d.Visor.v.ViewChanges(vcm.View))
+		d.Pool.BroadcastMessage(m)
+	}
+}
+
+// NewViewMessage proves the view change with the votes of AgreeNum validators
+type NewViewMessage struct {
+	View  uint64
+	Votes []pbft.ViewChange
+	c     *gnet.MessageContext `enc:"-"`
+}
+
+// NewNewViewMessage creates NewViewMessage
+func NewNewViewMessage(view uint64, votes []pbft.ViewChange) *NewViewMessage {
+	return &NewViewMessage{
+		View:  view,
+		Votes: votes,
+	}
+}
+
+// Handle handle message
+func (nvm *NewViewMessage) Handle(mc *gnet.MessageContext,
+	daemon interface{}) error {
+	nvm.c = mc
+	return daemon.(*Daemon).recordMessageEvent(nvm, mc)
+}
+
+// Process process message
+func (nvm *NewViewMessage) Process(d *Daemon) {
+	if d.Visor.Config.DisableNetworking {
+		return
+	}
+
+	if !d.Visor.v.Config.IsMaster {
+		return
+	}
+
+	if err := d.Visor.v.ApplyNewView(nvm.View, nvm.Votes); err != nil {
+		logger.Debugf("Ignore new view %d: %v", nvm.View, err)
+		return
+	}
+
+	d.Pool.BroadcastMessage(NewNewViewMessage(nvm.View, nvm.Votes))
+}
+
+// EvidenceMessage relays the proof that a trust node double signed
+type EvidenceMessage struct {
+	Evidence pbft.Evidence
+	c        *gnet.MessageContext `enc:"-"`
+}
+
+// NewEvidenceMessage creates EvidenceMessage
+func NewEvidenceMessage(e pbft.Evidence) *EvidenceMessage {
+	return &EvidenceMessage{
+		Evidence: e,
+	}
+}
+
+// Handle handle message
+func (em *EvidenceMessage) Handle(mc *gnet.MessageContext,
+	daemon interface{}) error {
+	em.c = mc
+	return daemon.(*Daemon).recordMessageEvent(em, mc)
+}
+
+// Process process message
+func (em *EvidenceMessage) Process(d *Daemon) {
+	if d.Visor.Config.DisableNetworking {
+		return
+	}
+
+	added, err := d.Visor.v.AddEvidence(em.Evidence)
+	if err != nil {
+		logger.Debugf("Ignore evidence against %s: %v", em.Evidence.PubKey.Hex(), err)
+		return
+	}
+	if added {
+		d.Pool.BroadcastMessage(NewEvidenceMessage(em.Evidence))
+	}
+}
+
+// CertificateMessage relays the commits that made an executed block final
+type CertificateMessage struct {
+	Certificate pbft.Certificate
+	c           *gnet.MessageContext `enc:"-"`
+}
+
+// NewCertificateMessage creates CertificateMessage
+func NewCertificateMessage(cert pbft.Certificate) *CertificateMessage {
+	return &CertificateMessage{
+		Certificate: cert,
+	}
+}
+
+// Handle handle message
+func (cm *CertificateMessage) Handle(mc *gnet.MessageContext,
+	daemon interface{}) error {
+	cm.c = mc
+	return daemon.(*Daemon).recordMessageEvent(cm, mc)
+}
+
+// Process process message
+func (cm *CertificateMessage) Process(d *Daemon) {
+	if d.Visor.Config.DisableNetworking {
+		return
+	}
+
+	added, err := d.Visor.v.AddCertificate(cm.Certificate)
+	if err != nil {
+		logger.Debugf("Ignore certificate of block %s: %v", cm.Certificate.Hash.Hex(), err)
+		return
+	}
+	if added {
+		d.Pool.BroadcastMessage(NewCertificateMessage(cm.Certificate))
+	}
+}
+
+// RequestViewChange votes to change the view if the pending block is not committed in time
+func (vs *Visor) RequestViewChange(pool *Pool) error {
+	if vs.Config.DisableNetworking {
+		return nil
+	}
+
+	err := vs.strand("RequestViewChange", func() error {
+		vc, ok := vs.v.NeedViewChange()
+		if !ok {
+			return nil
+		}
+
+		logger.Infof("Vote view change to %d for block seq %d", vc.View, vc.Seq)
+		changed, err := vs.v.AddViewChange(vc)
+		if err != nil {
+			return err
+		}
+		if err := pool.BroadcastMessage(NewViewChangeMessage(vc)); err != nil {
+			return err
+		}
+		if changed {
+			return pool.BroadcastMessage(NewNewViewMessage(vc.View, vs.v.ViewChanges(vc.View)))
+		}
+		return nil
+	})
+
+	if err != nil {
+		logger.Debugf("Broadcast ViewChangeMessage failed: %v", err)
+	}
+
+	return err
+}
+
 // RequestPrepare Sends a GetPrepareMessage to all connections
 func (vs *Visor) RequestPrepare(pool *Pool) error {
 	if vs.Config.DisableNetworking {
@@ -1287,6 +1771,18 @@ func (vs *Visor) RequestPrepare(pool *Pool) error {
 			if err != nil {
 				return err
 			}
+
+			// Resend our commit, it may be lost before the block was pending at peers
+			if vs.v.Config.IsMaster && vs.v.CheckCommitExists(hash, vs.v.Config.BlockchainTrustPubkey) {
+				view, err := vs.v.GetBlockView(hash)
+				if err != nil {
+					continue
+				}
+				cm := NewCommitMessage(hash, view, vs.v.Config.BlockchainTrustSeckey)
+				if err := pool.BroadcastMessage(cm); err != nil {
+					return err
+				}
+			}
 		}
 		return nil
 	})
@@ -1341,7 +1837,7 @@ func (gtm *GetAgreeNumMessage) Process(d *Daemon) {
 	// Reply to sender with GiveAgreeNumMessage
 	if d.Visor.v.IsGenesisNode() {
 		// Locate all txns from the unconfirmed pool
-		agreeNumNodes := d.Visor.v.GetAgreeNodeNum()
+		agreeNumNodes := d.Visor.v.Config.AgreeNum
 		m := NewGiveAgreeNumMessage(agreeNumNodes, d.Visor.v.Config.BlockchainSeckey)
 		if err := d.Pool.Pool.SendMessage(gtm.c.Addr, m); err != nil {
 			logger.Errorf("Send GiveAgreeNumMessage to %s failed: %v", gtm.c.Addr, err)
@@ -1393,6 +1889,13 @@ func (gtm *GiveAgreeNumMessage) Process(d *Daemon) {
 		if err != nil {
 			return
 		}
+		// the quorum is derived from the validators unless both sides override it on a testnet
+		if num != d.Visor.Config.Config.AgreeNum {
+			logger.Warningf("%s advertises agree num %d, ours is %d. Disconnecting.",
+				gtm.c.Addr, num, d.Visor.Config.Config.AgreeNum)
+			d.Pool.Pool.Disconnect(gtm.c.Addr, ErrDisconnectInvalidAgreeNum)
+			return
+		}
 		if err := d.Visor.v.InsertAgreeNodeNum(num); err != nil {
 			return
 		}
@@ -1432,6 +1935,16 @@ func (gbm *GivePendingBlockMessage) Process(d *Daemon) {
 
 	maxSeq := d.Visor.HeadBkSeq()
 	for _, b := range gbm.PendingBlock {
+		e, err := d.Visor.v.DetectBlockEquivocation(b.ToSignedBlock())
+		if err != nil {
+			logger.Debugf("Detect equivocation of block %d failed: %v", b.Block.Head.BkSeq, err)
+		} else if e != nil {
+			d.Pool.BroadcastMessage(NewEvidenceMessage(*e))
+			if err := d.Visor.CommitEvidence(*e, d.Pool); err != nil {
+				logger.Warningf("Commit evidence against %s failed: %v", e.PubKey.Hex(), err)
+			}
+		}
+
 		if b.Seq() <= maxSeq {
 			continue
 		}
@@ -1447,6 +1960,9 @@ func (gbm *GivePendingBlockMessage) Process(d *Daemon) {
 					}
 					m := NewGivePrepareMessage(b.HashHeader(), d.Visor.v.Config.BlockchainTrustSeckey)
 					d.Pool.Pool.BroadcastMessage(m)
+					if err := CanCommitBlock(d, b.HashHeader()); err != nil {
+						logger.Errorf("Commit block %s failed: %v", b.HashHeader().Hex(), err)
+					}
 				}
 			} else {
 				logger.Critical().Errorf("Failed to add pending block %d: %v", b.Block.Head.BkSeq, err)
@@ -0,0 +1,123 @@
+package gui
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	wh "github.com/samoslab/samos/src/util/http"
+)
+
+// Returns the evidence of trust nodes signing two conflicting blocks
+// URI: /consensus/evidence
+// Method: GET
+func evidenceHandler(gateway Gatewayer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			wh.Error405(w)
+			return
+		}
+
+		evidences, err := gateway.GetEvidence()
+		if err != nil {
+			logger.WithError(err).Error("gateway.GetEvidence failed")
+			wh.Error500Msg(w, err.Error())
+			return
+		}
+
+		wh.SendJSONOr500(logger, w, evidences)
+	}
+}
+
+// Returns the trust nodes scheduled to produce the next slots of the current epoch
+// URI: /consensus/schedule
+// Method: GET
+// Args:
+//    count: number of slots to return, defaults to 10
+func scheduleHandler(gateway Gatewayer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			wh.Error405(w)
+			return
+		}
+
+		count := 10
+		if c := r.FormValue("count"); c != "" {
+			n, err := strconv.Atoi(c)
+			if err != nil || n <= 0 {
+				wh.Error400(w, fmt.Sprintf("Invalid count value \"%s\"", c))
+				return
+			}
+			count = n
+		}
+
+		schedule, err := gateway.GetProducerSchedule(count)
+		if err != nil {
+			logger.WithError(err).Error("gateway.GetProducerSchedule failed")
+			wh.Error500Msg(w, err.Error())
+			return
+		}
+
+		wh.SendJSONOr500(logger, w, schedule)
+	}
+}
+
+// Returns the slots the trust nodes produced and missed in an epoch
+// URI: /consensus/validators
+// Method: GET
+// Args:
+//    epoch: a unix time in the epoch, defaults to the epoch of the head block
+func validatorsHandler(gateway Gatewayer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			wh.Error405(w)
+			return
+		}
+
+		var epoch int64
+		if e := r.FormValue("epoch"); e != "" {
+			n, err := strconv.ParseInt(e, 10, 64)
+			if err != nil || n <= 0 {
+				wh.Error400(w, fmt.Sprintf("Invalid epoch value \"%s\"", e))
+				return
+			}
+			epoch = n
+		}
+
+		stats, err := gateway.GetValidatorStats(epoch)
+		if err != nil {
+			logger.WithError(err).Error("gateway.GetValidatorStats failed")
+			wh.Error500Msg(w, err.Error())
+			return
+		}
+
+		wh.SendJSONOr500(logger, w, stats)
+	}
+}
+
+// Returns the unspent outputs and the consensus state at the finalized block,
+// signed by the trust node. A new node started with the snapshot back-fills the
+// blocks below it
+// URI: /consensus/snapshot
+// Method: GET
+func snapshotHandler(gateway Gatewayer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			wh.Error405(w)
+			return
+		}
+
+		s, err := gateway.ExportSnapshot()
+		if err != nil {
+			logger.WithError(err).Error("gateway.ExportSnapshot failed")
+			wh.Error500Msg(w, err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=snapshot-%d.bin", s.Block.Seq()))
+		if _, err := w.Write(s.Serialize()); err != nil {
+			logger.WithError(err).Error("write snapshot failed")
+		}
+	}
+}
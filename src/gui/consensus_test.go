@@ -0,0 +1,320 @@
+package gui
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/samoslab/samos/src/visor"
+)
+
+func TestEvidenceHandler(t *testing.T) {
+	evidences := []visor.ReadableEvidence{
+		{
+			Type:   "slot",
+			PubKey: "025d8360bc9439aa94044df96605f7693f50bb35386b37ae5003787d840a98bf43",
+			Blocks: []visor.ReadableSignedHeader{
+				{
+					Head: visor.ReadableBlockHeader{BkSeq: 10, Time: 1523168680},
+					Sig:  "aa",
+				},
+				{
+					Head: visor.ReadableBlockHeader{BkSeq: 10, Time: 1523168685},
+					Sig:  "bb",
+				},
+			},
+		},
+	}
+
+	cases := []struct {
+		name     string
+		method   string
+		status   int
+		err      string
+		gwResult []visor.ReadableEvidence
+		gwErr    error
+	}{
+		{
+			name:   "405",
+			method: http.MethodPost,
+			status: http.StatusMethodNotAllowed,
+			err:    "405 Method Not Allowed",
+		},
+		{
+			name:   "500 - gateway.GetEvidence error",
+			method: http.MethodGet,
+			status: http.StatusInternalServerError,
+			err:    "500 Internal Server Error - GetEvidence failed",
+			gwErr:  errors.New("GetEvidence failed"),
+		},
+		{
+			name:     "200",
+			method:   http.MethodGet,
+			status:   http.StatusOK,
+			gwResult: evidences,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			gateway := NewGatewayerMock()
+			gateway.On("GetEvidence").Return(tc.gwResult, tc.gwErr)
+
+			req, err := http.NewRequest(tc.method, "/consensus/evidence", nil)
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			handler := newServerMux(muxConfig{host: configuredHost, appLoc: "."}, gateway, &CSRFStore{})
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.status, rr.Code)
+			if tc.status != http.StatusOK {
+				require.Equal(t, tc.err, strings.TrimSpace(rr.Body.String()))
+				return
+			}
+
+			var got []visor.ReadableEvidence
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+			require.Equal(t, tc.gwResult, got)
+		})
+	}
+}
+
+func TestScheduleHandler(t *testing.T) {
+	schedule := []visor.ReadableSlotProducer{
+		{Slot: 1523168680, Producer: "025d8360bc9439aa94044df96605f7693f50bb35386b37ae5003787d840a98bf43"},
+		{Slot: 1523168690, Producer: "03e9c0d4c4b5f3b0e2e2d52e06f55d5a1d8d2ab2b6c9f7d4e7b3e0e1bfc9b3b5e1"},
+	}
+
+	cases := []struct {
+		name     string
+		method   string
+		query    string
+		status   int
+		err      string
+		count    int
+		gwResult []visor.ReadableSlotProducer
+		gwErr    error
+	}{
+		{
+			name:   "405",
+			method: http.MethodPost,
+			status: http.StatusMethodNotAllowed,
+			err:    "405 Method Not Allowed",
+		},
+		{
+			name:   "400 - invalid count",
+			method: http.MethodGet,
+			query:  "?count=foo",
+			status: http.StatusBadRequest,
+			err:    "400 Bad Request - Invalid count value \"foo\"",
+		},
+		{
+			name:   "400 - zero count",
+			method: http.MethodGet,
+			query:  "?count=0",
+			status: http.StatusBadRequest,
+			err:    "400 Bad Request - Invalid count value \"0\"",
+		},
+		{
+			name:   "500 - gateway.GetProducerSchedule error",
+			method: http.MethodGet,
+			status: http.StatusInternalServerError,
+			err:    "500 Internal Server Error - unknown seed",
+			count:  10,
+			gwErr:  errors.New("unknown seed"),
+		},
+		{
+			name:     "200 - default count",
+			method:   http.MethodGet,
+			status:   http.StatusOK,
+			count:    10,
+			gwResult: schedule,
+		},
+		{
+			name:     "200",
+			method:   http.MethodGet,
+			query:    "?count=2",
+			status:   http.StatusOK,
+			count:    2,
+			gwResult: schedule,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			gateway := NewGatewayerMock()
+			gateway.On("GetProducerSchedule", tc.count).Return(tc.gwResult, tc.gwErr)
+
+			req, err := http.NewRequest(tc.method, "/consensus/schedule"+tc.query, nil)
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			handler := newServerMux(muxConfig{host: configuredHost, appLoc: "."}, gateway, &CSRFStore{})
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.status, rr.Code)
+			if tc.status != http.StatusOK {
+				require.Equal(t, tc.err, strings.TrimSpace(rr.Body.String()))
+				return
+			}
+
+			var got []visor.ReadableSlotProducer
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+			require.Equal(t, tc.gwResult, got)
+		})
+	}
+}
+
+func TestValidatorsHandler(t *testing.T) {
+	stats := &visor.ReadableValidatorStats{
+		Epoch: 1523145600,
+		Validators: []visor.ReadableSlotStats{
+			{PubKey: "025d8360bc9439aa94044df96605f7693f50bb35386b37ae5003787d840a98bf43", Produced: 2150, Missed: 7},
+			{PubKey: "03e9c0d4c4b5f3b0e2e2d52e06f55d5a1d8d2ab2b6c9f7d4e7b3e0e1bfc9b3b5e1", Produced: 2157},
+		},
+	}
+
+	cases := []struct {
+		name     string
+		method   string
+		query    string
+		status   int
+		err      string
+		epoch    int64
+		gwResult *visor.ReadableValidatorStats
+		gwErr    error
+	}{
+		{
+			name:   "405",
+			method: http.MethodPost,
+			status: http.StatusMethodNotAllowed,
+			err:    "405 Method Not Allowed",
+		},
+		{
+			name:   "400 - invalid epoch",
+			method: http.MethodGet,
+			query:  "?epoch=foo",
+			status: http.StatusBadRequest,
+			err:    "400 Bad Request - Invalid epoch value \"foo\"",
+		},
+		{
+			name:   "400 - negative epoch",
+			method: http.MethodGet,
+			query:  "?epoch=-1",
+			status: http.StatusBadRequest,
+			err:    "400 Bad Request - Invalid epoch value \"-1\"",
+		},
+		{
+			name:   "500 - gateway.GetValidatorStats error",
+			method: http.MethodGet,
+			status: http.StatusInternalServerError,
+			err:    "500 Internal Server Error - GetValidatorStats failed",
+			gwErr:  errors.New("GetValidatorStats failed"),
+		},
+		{
+			name:     "200 - head epoch",
+			method:   http.MethodGet,
+			status:   http.StatusOK,
+			gwResult: stats,
+		},
+		{
+			name:     "200",
+			method:   http.MethodGet,
+			query:    "?epoch=1523168680",
+			status:   http.StatusOK,
+			epoch:    1523168680,
+			gwResult: stats,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			gateway := NewGatewayerMock()
+			gateway.On("GetValidatorStats", tc.epoch).Return(tc.gwResult, tc.gwErr)
+
+			req, err := http.NewRequest(tc.method, "/consensus/validators"+tc.query, nil)
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			handler := newServerMux(muxConfig{host: configuredHost, appLoc: "."}, gateway, &CSRFStore{})
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.status, rr.Code)
+			if tc.status != http.StatusOK {
+				require.Equal(t, tc.err, strings.TrimSpace(rr.Body.String()))
+				return
+			}
+
+			var got *visor.ReadableValidatorStats
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+			require.Equal(t, tc.gwResult, got)
+		})
+	}
+}
+
+func TestSnapshotHandler(t *testing.T) {
+	s := &visor.Snapshot{
+		Epoch: 1523145600,
+	}
+	s.Block.Head.BkSeq = 12
+
+	cases := []struct {
+		name     string
+		method   string
+		status   int
+		err      string
+		gwResult *visor.Snapshot
+		gwErr    error
+	}{
+		{
+			name:   "405",
+			method: http.MethodPost,
+			status: http.StatusMethodNotAllowed,
+			err:    "405 Method Not Allowed",
+		},
+		{
+			name:   "500 - gateway.ExportSnapshot error",
+			method: http.MethodGet,
+			status: http.StatusInternalServerError,
+			err:    "500 Internal Server Error - only a trust node can export a snapshot",
+			gwErr:  errors.New("only a trust node can export a snapshot"),
+		},
+		{
+			name:     "200",
+			method:   http.MethodGet,
+			status:   http.StatusOK,
+			gwResult: s,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			gateway := NewGatewayerMock()
+			gateway.On("ExportSnapshot").Return(tc.gwResult, tc.gwErr)
+
+			req, err := http.NewRequest(tc.method, "/consensus/snapshot", nil)
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			handler := newServerMux(muxConfig{host: configuredHost, appLoc: "."}, gateway, &CSRFStore{})
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.status, rr.Code)
+			if tc.status != http.StatusOK {
+				require.Equal(t, tc.err, strings.TrimSpace(rr.Body.String()))
+				return
+			}
+
+			require.Equal(t, "application/octet-stream", rr.Header().Get("Content-Type"))
+			got, err := visor.DeserializeSnapshot(rr.Body.Bytes())
+			require.NoError(t, err)
+			require.Equal(t, s.Hash(), got.Hash())
+		})
+	}
+}
@@ -254,17 +254,13 @@ func getRichlist(gateway Gatewayer) http.HandlerFunc {
 			}
 		}
 
-		richlist, err := gateway.GetRichlist(includeDistribution)
+		richlist, err := gateway.GetRichlist(topn, includeDistribution)
 		if err != nil {
 			logger.Error(err)
 			wh.Error500(w)
 			return
 		}
 
-		if topn > 0 && topn < len(richlist) {
-			richlist = richlist[:topn]
-		}
-
 		wh.SendJSONOr500(logger, w, Richlist{
 			Richlist: richlist,
 		})
@@ -409,6 +409,7 @@ func TestGetRichlist(t *testing.T) {
 		status                   int
 		err                      string
 		httpParams               *httpParams
+		topn                     int
 		includeDistribution      bool
 		gatewayGetRichlistResult visor.Richlist
 		gatewayGetRichlistErr    error
@@ -449,6 +450,7 @@ func TestGetRichlist(t *testing.T) {
 				topn:                "1",
 				includeDistribution: "false",
 			},
+			topn:                  1,
 			gatewayGetRichlistErr: errors.New("gatewayGetRichlistErr"),
 		},
 		{
@@ -459,6 +461,7 @@ func TestGetRichlist(t *testing.T) {
 				topn:                "3",
 				includeDistribution: "false",
 			},
+			topn: 3,
 			gatewayGetRichlistResult: visor.Richlist{
 				{
 					Address: "2fGC7kwAM9yZyEF1QqBqp8uo9RUsF6ENGJF",
@@ -475,16 +478,6 @@ func TestGetRichlist(t *testing.T) {
 					Coins:   "500000.000000",
 					Locked:  false,
 				},
-				{
-					Address: "2TmvdBWJgxMwGs84R4drS9p5fYkva4dGdfs",
-					Coins:   "244458.000000",
-					Locked:  false,
-				},
-				{
-					Address: "24gvUHXHtSg5drKiFsMw7iMgoN2PbLub53C",
-					Coins:   "195503.000000",
-					Locked:  false,
-				},
 			},
 			result: Richlist{
 				Richlist: visor.Richlist{
@@ -577,7 +570,7 @@ func TestGetRichlist(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			endpoint := "/richlist"
 			gateway := NewGatewayerMock()
-			gateway.On("GetRichlist", tc.includeDistribution).Return(tc.gatewayGetRichlistResult, tc.gatewayGetRichlistErr)
+			gateway.On("GetRichlist", tc.topn, tc.includeDistribution).Return(tc.gatewayGetRichlistResult, tc.gatewayGetRichlistErr)
 
 			v := url.Values{}
 			if tc.httpParams != nil {
@@ -42,7 +42,9 @@ type Gatewayer interface {
 	GetDefaultConnections() []string
 	GetTrustConnections() []string
 	GetExchgConnection() []string
-	GetAllUnconfirmedTxns() []visor.UnconfirmedTxn
+	GetBannedPeers() *daemon.BannedPeers
+	UnbanPeer(addr string) error
+	GetAllUnconfirmedTxns() ([]visor.UnconfirmedTxnFee, error)
 	GetTransaction(txid cipher.SHA256) (*visor.Transaction, error)
 	GetTransactions(flts ...visor.TxFilter) ([]visor.Transaction, error)
 	InjectBroadcastTransaction(txn coin.Transaction) error
@@ -50,8 +52,12 @@ type Gatewayer interface {
 	GetUxOutByID(id cipher.SHA256) (*historydb.UxOut, error)
 	GetAddrUxOuts(addr []cipher.Address) ([]*historydb.UxOut, error)
 	GetAddressTxns(a cipher.Address) (*visor.TransactionResults, error)
-	GetRichlist(includeDistribution bool) (visor.Richlist, error)
+	GetRichlist(topn int, includeDistribution bool) (visor.Richlist, error)
 	GetAddressCount() (uint64, error)
 	GetHealth() (*daemon.Health, error)
+	GetEvidence() ([]visor.ReadableEvidence, error)
+	GetProducerSchedule(n int) ([]visor.ReadableSlotProducer, error)
+	GetValidatorStats(ts int64) (*visor.ReadableValidatorStats, error)
+	ExportSnapshot() (*visor.Snapshot, error)
 	UnloadWallet(id string) error
 }
@@ -144,6 +144,33 @@ func (m *GatewayerMock) EncryptWallet(p0 string, p1 []byte) (*wallet.Wallet, err
 
 }
 
+// ExportSnapshot mocked method
+func (m *GatewayerMock) ExportSnapshot() (*visor.Snapshot, error) {
+
+	ret := m.Called()
+
+	var r0 *visor.Snapshot
+	switch res := ret.Get(0).(type) {
+	case nil:
+	case *visor.Snapshot:
+		r0 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	var r1 error
+	switch res := ret.Get(1).(type) {
+	case nil:
+	case error:
+		r1 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	return r0, r1
+
+}
+
 // GetAddrUxOuts mocked method
 func (m *GatewayerMock) GetAddrUxOuts(p0 []cipher.Address) ([]*historydb.UxOut, error) {
 
@@ -226,20 +253,29 @@ func (m *GatewayerMock) GetAddressTxns(p0 cipher.Address) (*visor.TransactionRes
 }
 
 // GetAllUnconfirmedTxns mocked method
-func (m *GatewayerMock) GetAllUnconfirmedTxns() []visor.UnconfirmedTxn {
+func (m *GatewayerMock) GetAllUnconfirmedTxns() ([]visor.UnconfirmedTxnFee, error) {
 
 	ret := m.Called()
 
-	var r0 []visor.UnconfirmedTxn
+	var r0 []visor.UnconfirmedTxnFee
 	switch res := ret.Get(0).(type) {
 	case nil:
-	case []visor.UnconfirmedTxn:
+	case []visor.UnconfirmedTxnFee:
 		r0 = res
 	default:
 		panic(fmt.Sprintf("unexpected type: %v", res))
 	}
 
-	return r0
+	var r1 error
+	switch res := ret.Get(1).(type) {
+	case nil:
+	case error:
+		r1 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	return r0, r1
 
 }
 
@@ -270,6 +306,24 @@ func (m *GatewayerMock) GetBalanceOfAddrs(p0 []cipher.Address) ([]wallet.Balance
 
 }
 
+// GetBannedPeers mocked method
+func (m *GatewayerMock) GetBannedPeers() *daemon.BannedPeers {
+
+	ret := m.Called()
+
+	var r0 *daemon.BannedPeers
+	switch res := ret.Get(0).(type) {
+	case nil:
+	case *daemon.BannedPeers:
+		r0 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	return r0
+
+}
+
 // GetBlockByHash mocked method
 func (m *GatewayerMock) GetBlockByHash(p0 cipher.SHA256) (coin.SignedBlock, bool) {
 
@@ -468,6 +522,33 @@ func (m *GatewayerMock) GetDefaultConnections() []string {
 
 }
 
+// GetEvidence mocked method
+func (m *GatewayerMock) GetEvidence() ([]visor.ReadableEvidence, error) {
+
+	ret := m.Called()
+
+	var r0 []visor.ReadableEvidence
+	switch res := ret.Get(0).(type) {
+	case nil:
+	case []visor.ReadableEvidence:
+		r0 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	var r1 error
+	switch res := ret.Get(1).(type) {
+	case nil:
+	case error:
+		r1 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	return r0, r1
+
+}
+
 // GetExchgConnection mocked method
 func (m *GatewayerMock) GetExchgConnection() []string {
 
@@ -540,11 +621,38 @@ func (m *GatewayerMock) GetLastBlocks(p0 uint64) (*visor.ReadableBlocks, error)
 
 }
 
-// GetRichlist mocked method
-func (m *GatewayerMock) GetRichlist(p0 bool) (visor.Richlist, error) {
+// GetProducerSchedule mocked method
+func (m *GatewayerMock) GetProducerSchedule(p0 int) ([]visor.ReadableSlotProducer, error) {
 
 	ret := m.Called(p0)
 
+	var r0 []visor.ReadableSlotProducer
+	switch res := ret.Get(0).(type) {
+	case nil:
+	case []visor.ReadableSlotProducer:
+		r0 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	var r1 error
+	switch res := ret.Get(1).(type) {
+	case nil:
+	case error:
+		r1 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	return r0, r1
+
+}
+
+// GetRichlist mocked method
+func (m *GatewayerMock) GetRichlist(p0 int, p1 bool) (visor.Richlist, error) {
+
+	ret := m.Called(p0, p1)
+
 	var r0 visor.Richlist
 	switch res := ret.Get(0).(type) {
 	case nil:
@@ -693,6 +801,33 @@ func (m *GatewayerMock) GetUxOutByID(p0 cipher.SHA256) (*historydb.UxOut, error)
 
 }
 
+// GetValidatorStats mocked method
+func (m *GatewayerMock) GetValidatorStats(p0 int64) (*visor.ReadableValidatorStats, error) {
+
+	ret := m.Called(p0)
+
+	var r0 *visor.ReadableValidatorStats
+	switch res := ret.Get(0).(type) {
+	case nil:
+	case *visor.ReadableValidatorStats:
+		r0 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	var r1 error
+	switch res := ret.Get(1).(type) {
+	case nil:
+	case error:
+		r1 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	return r0, r1
+
+}
+
 // GetWallet mocked method
 func (m *GatewayerMock) GetWallet(p0 string) (*wallet.Wallet, error) {
 
@@ -963,6 +1098,24 @@ func (m *GatewayerMock) Spend(p0 string, p1 []byte, p2 uint64, p3 cipher.Address
 
 }
 
+// UnbanPeer mocked method
+func (m *GatewayerMock) UnbanPeer(p0 string) error {
+
+	ret := m.Called(p0)
+
+	var r0 error
+	switch res := ret.Get(0).(type) {
+	case nil:
+	case error:
+		r0 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	return r0
+
+}
+
 // UnloadWallet mocked method
 func (m *GatewayerMock) UnloadWallet(p0 string) error {
 
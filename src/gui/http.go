@@ -313,6 +313,8 @@ func newServerMux(c muxConfig, gateway Gatewayer, csrfStore *CSRFStore) *http.Se
 	webHandler("/network/defaultConnections", defaultConnectionsHandler(gateway))
 	webHandler("/network/connections/trust", trustConnectionsHandler(gateway))
 	webHandler("/network/connections/exchange", exchgConnectionsHandler(gateway))
+	webHandler("/network/bans", bannedPeersHandler(gateway))
+	webHandler("/network/unban", unbanPeerHandler(gateway))
 
 	// Transaction handler
 
@@ -324,6 +326,12 @@ func newServerMux(c muxConfig, gateway Gatewayer, csrfStore *CSRFStore) *http.Se
 	// Health check handler
 	webHandler("/health", healthCheck(gateway))
 
+	// Returns double signing evidence of trust nodes
+	webHandler("/consensus/evidence", evidenceHandler(gateway))
+	webHandler("/consensus/schedule", scheduleHandler(gateway))
+	webHandler("/consensus/validators", validatorsHandler(gateway))
+	webHandler("/consensus/snapshot", snapshotHandler(gateway))
+
 	// Returns transactions that match the filters.
 	// Method: GET
 	// Args:
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"sort"
 
+	"github.com/samoslab/samos/src/daemon/pex"
 	wh "github.com/samoslab/samos/src/util/http" //http,json helpers
 )
 
@@ -83,3 +84,38 @@ func exchgConnectionsHandler(gateway Gatewayer) http.HandlerFunc {
 		wh.SendJSONOr500(logger, w, conns)
 	}
 }
+
+func bannedPeersHandler(gateway Gatewayer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			wh.Error405(w)
+			return
+		}
+
+		wh.SendJSONOr500(logger, w, gateway.GetBannedPeers())
+	}
+}
+
+func unbanPeerHandler(gateway Gatewayer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			wh.Error405(w)
+			return
+		}
+
+		addr := r.FormValue("addr")
+		if addr == "" {
+			wh.Error400(w, "addr is required")
+			return
+		}
+
+		if err := gateway.UnbanPeer(addr); err != nil {
+			switch err {
+			case pex.ErrPeerNotBanned:
+				wh.Error404(w)
+			default:
+				wh.Error500(w)
+			}
+		}
+	}
+}
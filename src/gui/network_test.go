@@ -11,6 +11,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/samoslab/samos/src/daemon"
+	"github.com/samoslab/samos/src/daemon/pex"
 )
 
 func TestConnection(t *testing.T) {
@@ -334,3 +335,130 @@ func TestGetExchgConnection(t *testing.T) {
 		})
 	}
 }
+
+func TestBannedPeers(t *testing.T) {
+	tt := []struct {
+		name                        string
+		method                      string
+		status                      int
+		err                         string
+		gatewayGetBannedPeersResult *daemon.BannedPeers
+		result                      *daemon.BannedPeers
+	}{
+		{
+			name:   "405",
+			method: http.MethodPost,
+			status: http.StatusMethodNotAllowed,
+			err:    "405 Method Not Allowed",
+		},
+		{
+			name:   "200",
+			method: http.MethodGet,
+			status: http.StatusOK,
+			err:    "",
+			gatewayGetBannedPeersResult: &daemon.BannedPeers{
+				Peers: []daemon.BannedPeer{
+					{Addr: "44.33.22.11:6677", BannedUntil: 1600000000},
+				},
+			},
+			result: &daemon.BannedPeers{
+				Peers: []daemon.BannedPeer{
+					{Addr: "44.33.22.11:6677", BannedUntil: 1600000000},
+				},
+			},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			endpoint := "/network/bans"
+			gateway := NewGatewayerMock()
+			gateway.On("GetBannedPeers").Return(tc.gatewayGetBannedPeersResult)
+			req, err := http.NewRequest(tc.method, endpoint, nil)
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			handler := newServerMux(muxConfig{host: configuredHost, appLoc: "."}, gateway, &CSRFStore{})
+			handler.ServeHTTP(rr, req)
+
+			status := rr.Code
+			require.Equal(t, tc.status, status, "case: %s, handler returned wrong status code: got `%v` want `%v`", tc.name, status, tc.status)
+
+			if status != http.StatusOK {
+				require.Equal(t, tc.err, strings.TrimSpace(rr.Body.String()), "case: %s, handler returned wrong error message: got `%v`| %d, want `%v`",
+					tc.name, strings.TrimSpace(rr.Body.String()), status, tc.err)
+			} else {
+				var msg *daemon.BannedPeers
+				err = json.Unmarshal(rr.Body.Bytes(), &msg)
+				require.NoError(t, err)
+				require.Equal(t, tc.result, msg)
+			}
+		})
+	}
+}
+
+func TestUnbanPeer(t *testing.T) {
+	tt := []struct {
+		name         string
+		method       string
+		status       int
+		err          string
+		addr         string
+		unbanPeerErr error
+	}{
+		{
+			name:   "405",
+			method: http.MethodGet,
+			status: http.StatusMethodNotAllowed,
+			err:    "405 Method Not Allowed",
+			addr:   "44.33.22.11:6677",
+		},
+		{
+			name:   "400 - empty addr",
+			method: http.MethodPost,
+			status: http.StatusBadRequest,
+			err:    "400 Bad Request - addr is required",
+		},
+		{
+			name:         "404 - not banned",
+			method:       http.MethodPost,
+			status:       http.StatusNotFound,
+			err:          "404 Not Found",
+			addr:         "44.33.22.11:6677",
+			unbanPeerErr: pex.ErrPeerNotBanned,
+		},
+		{
+			name:   "200",
+			method: http.MethodPost,
+			status: http.StatusOK,
+			addr:   "44.33.22.11:6677",
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			endpoint := "/network/unban"
+			gateway := NewGatewayerMock()
+			gateway.On("UnbanPeer", tc.addr).Return(tc.unbanPeerErr)
+
+			v := url.Values{}
+			if tc.addr != "" {
+				v.Add("addr", tc.addr)
+			}
+
+			req, err := http.NewRequest(tc.method, endpoint, strings.NewReader(v.Encode()))
+			require.NoError(t, err)
+			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+			rr := httptest.NewRecorder()
+			handler := newServerMux(muxConfig{host: configuredHost, appLoc: "."}, gateway, &CSRFStore{})
+			handler.ServeHTTP(rr, req)
+
+			status := rr.Code
+			require.Equal(t, tc.status, status, "case: %s, handler returned wrong status code: got `%v` want `%v`", tc.name, status, tc.status)
+
+			if status != http.StatusOK {
+				require.Equal(t, tc.err, strings.TrimSpace(rr.Body.String()), "case: %s, handler returned wrong error message: got `%v`| %d, want `%v`",
+					tc.name, strings.TrimSpace(rr.Body.String()), status, tc.err)
+			}
+		})
+	}
+}
@@ -244,6 +244,8 @@ type createTransactionRequest struct {
 	Wallet         createTransactionRequestWallet `json:"wallet"`
 	ChangeAddress  *wh.Address                    `json:"change_address"`
 	To             []receiver                     `json:"to"`
+	Replace        *wh.SHA256                     `json:"replace,omitempty"`
+	MinFee         *wh.Hours                      `json:"min_fee,omitempty"`
 }
 
 // createTransactionRequestWallet defines a wallet to spend from and optionally which addresses in the wallet
@@ -376,6 +378,10 @@ func (r createTransactionRequest) Validate() error {
 		return errors.New("to contains duplicate values")
 	}
 
+	if r.Replace != nil && r.Replace.SHA256 == (cipher.SHA256{}) {
+		return errors.New("replace is an empty txid")
+	}
+
 	return nil
 }
 
@@ -411,6 +417,16 @@ func (r createTransactionRequest) ToWalletParams() wallet.CreateTransactionParam
 		changeAddress = r.ChangeAddress.Address
 	}
 
+	var replace cipher.SHA256
+	if r.Replace != nil {
+		replace = r.Replace.SHA256
+	}
+
+	var minFee uint64
+	if r.MinFee != nil {
+		minFee = r.MinFee.Value()
+	}
+
 	return wallet.CreateTransactionParams{
 		HoursSelection: wallet.HoursSelection{
 			Type:        r.HoursSelection.Type,
@@ -420,6 +436,8 @@ func (r createTransactionRequest) ToWalletParams() wallet.CreateTransactionParam
 		Wallet:        walletParams,
 		ChangeAddress: changeAddress,
 		To:            to,
+		Replace:       replace,
+		MinFee:        minFee,
 	}
 }
 
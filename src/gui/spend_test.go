@@ -45,6 +45,8 @@ func TestCreateTransaction(t *testing.T) {
 		ChangeAddress  string            `json:"change_address,omitempty"`
 		To             []rawReceiver     `json:"to"`
 		Password       string            `json:"password"`
+		Replace        string            `json:"replace,omitempty"`
+		MinFee         string            `json:"min_fee,omitempty"`
 	}
 
 	changeAddress := testutil.MakeAddress()
@@ -620,6 +622,54 @@ func TestCreateTransaction(t *testing.T) {
 			err:    "400 Bad Request - to contains duplicate values",
 		},
 
+		{
+			name:   "400 - invalid replace",
+			method: http.MethodPost,
+			body: &rawRequest{
+				HoursSelection: rawHoursSelection{
+					Type: wallet.HoursSelectionTypeManual,
+				},
+				To: []rawReceiver{
+					{
+						Address: destinationAddress.String(),
+						Coins:   "100",
+						Hours:   "10",
+					},
+				},
+				ChangeAddress: changeAddress.String(),
+				Wallet: rawRequestWallet{
+					ID: "foo.wlt",
+				},
+				Replace: "xxx",
+			},
+			status: http.StatusBadRequest,
+			err:    "400 Bad Request - invalid sha256: encoding/hex: invalid byte: U+0078 'x'",
+		},
+
+		{
+			name:   "400 - empty replace",
+			method: http.MethodPost,
+			body: &rawRequest{
+				HoursSelection: rawHoursSelection{
+					Type: wallet.HoursSelectionTypeManual,
+				},
+				To: []rawReceiver{
+					{
+						Address: destinationAddress.String(),
+						Coins:   "100",
+						Hours:   "10",
+					},
+				},
+				ChangeAddress: changeAddress.String(),
+				Wallet: rawRequestWallet{
+					ID: "foo.wlt",
+				},
+				Replace: cipher.SHA256{}.Hex(),
+			},
+			status: http.StatusBadRequest,
+			err:    "400 Bad Request - replace is an empty txid",
+		},
+
 		{
 			name:   "200 - auto type split even",
 			method: http.MethodPost,
@@ -696,6 +746,33 @@ func TestCreateTransaction(t *testing.T) {
 			createTransactionResponse:      createTxnResponse,
 		},
 
+		{
+			name:   "200 - replace with min fee",
+			method: http.MethodPost,
+			body: &rawRequest{
+				HoursSelection: rawHoursSelection{
+					Type: wallet.HoursSelectionTypeManual,
+				},
+				To: []rawReceiver{
+					{
+						Address: destinationAddress.String(),
+						Coins:   "100",
+						Hours:   "10",
+					},
+				},
+				ChangeAddress: changeAddress.String(),
+				Wallet: rawRequestWallet{
+					ID: "foo.wlt",
+				},
+				Replace: testutil.RandSHA256(t).Hex(),
+				MinFee:  "50",
+			},
+			status: http.StatusOK,
+			gatewayCreateTransactionResult: txn,
+			gatewayCreateTransactionInputs: inputs,
+			createTransactionResponse:      createTxnResponse,
+		},
+
 		{
 			name:   "200 - manual type nonzero hours - csrf disabled",
 			method: http.MethodPost,
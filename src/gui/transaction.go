@@ -22,10 +22,15 @@ func getPendingTxs(gateway Gatewayer) http.HandlerFunc {
 			return
 		}
 
-		txns := gateway.GetAllUnconfirmedTxns()
-		ret := make([]*visor.ReadableUnconfirmedTxn, 0, len(txns))
+		txns, err := gateway.GetAllUnconfirmedTxns()
+		if err != nil {
+			wh.Error500Msg(w, err.Error())
+			return
+		}
+
+		ret := make([]*visor.ReadableUnconfirmedTxnFee, 0, len(txns))
 		for _, unconfirmedTxn := range txns {
-			readable, err := visor.NewReadableUnconfirmedTxn(&unconfirmedTxn)
+			readable, err := visor.NewReadableUnconfirmedTxnFee(&unconfirmedTxn)
 			if err != nil {
 				logger.Error(err)
 				wh.Error500(w)
@@ -101,37 +101,72 @@ func TestGetPendingTxs(t *testing.T) {
 		Coins: math.MaxInt64 + 1,
 	})
 
+	validTxn := visor.UnconfirmedTxnFee{
+		UnconfirmedTxn:  createUnconfirmedTxn(t),
+		Fee:             10,
+		Size:            100,
+		FeePerKB:        102,
+		PackageFeePerKB: 204,
+		Descendants:     []cipher.SHA256{testutil.RandSHA256(t)},
+	}
+	readableTxn, err := visor.NewReadableUnconfirmedTxnFee(&validTxn)
+	require.NoError(t, err)
+	// decode it as the response is, the times lose their location
+	b, err := json.Marshal(readableTxn)
+	require.NoError(t, err)
+	readableTxn = &visor.ReadableUnconfirmedTxnFee{}
+	require.NoError(t, json.Unmarshal(b, readableTxn))
+	require.Equal(t, uint64(102), readableTxn.FeePerKB)
+	require.Equal(t, uint64(204), readableTxn.PackageFeePerKB)
+	require.Equal(t, []string{}, readableTxn.Ancestors)
+	require.Equal(t, []string{validTxn.Descendants[0].Hex()}, readableTxn.Descendants)
+
 	tt := []struct {
 		name                          string
 		method                        string
 		url                           string
 		status                        int
 		err                           string
-		getAllUnconfirmedTxnsResponse []visor.UnconfirmedTxn
-		httpResponse                  []*visor.ReadableUnconfirmedTxn
+		getAllUnconfirmedTxnsResponse []visor.UnconfirmedTxnFee
+		getAllUnconfirmedTxnsErr      error
+		httpResponse                  []*visor.ReadableUnconfirmedTxnFee
 	}{
 		{
 			name:   "405",
 			method: http.MethodPost,
 			status: http.StatusMethodNotAllowed,
 			err:    "405 Method Not Allowed",
-			getAllUnconfirmedTxnsResponse: []visor.UnconfirmedTxn{},
+			getAllUnconfirmedTxnsResponse: []visor.UnconfirmedTxnFee{},
 		},
 		{
 			name:   "500 - bad unconfirmedTxn",
 			method: http.MethodGet,
 			status: http.StatusInternalServerError,
 			err:    "500 Internal Server Error",
-			getAllUnconfirmedTxnsResponse: []visor.UnconfirmedTxn{
-				invalidTxn,
+			getAllUnconfirmedTxnsResponse: []visor.UnconfirmedTxnFee{
+				{UnconfirmedTxn: invalidTxn},
 			},
 		},
+		{
+			name:   "500 - gateway error",
+			method: http.MethodGet,
+			status: http.StatusInternalServerError,
+			err:    "500 Internal Server Error - get fee failed",
+			getAllUnconfirmedTxnsErr: errors.New("get fee failed"),
+		},
 		{
 			name:   "200",
 			method: http.MethodGet,
 			status: http.StatusOK,
-			getAllUnconfirmedTxnsResponse: []visor.UnconfirmedTxn{},
-			httpResponse:                  []*visor.ReadableUnconfirmedTxn{},
+			getAllUnconfirmedTxnsResponse: []visor.UnconfirmedTxnFee{},
+			httpResponse:                  []*visor.ReadableUnconfirmedTxnFee{},
+		},
+		{
+			name:   "200 - with fee",
+			method: http.MethodGet,
+			status: http.StatusOK,
+			getAllUnconfirmedTxnsResponse: []visor.UnconfirmedTxnFee{validTxn},
+			httpResponse:                  []*visor.ReadableUnconfirmedTxnFee{readableTxn},
 		},
 	}
 
@@ -139,7 +174,7 @@ func TestGetPendingTxs(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			endpoint := "/pendingTxs"
 			gateway := NewGatewayerMock()
-			gateway.On("GetAllUnconfirmedTxns").Return(tc.getAllUnconfirmedTxnsResponse)
+			gateway.On("GetAllUnconfirmedTxns").Return(tc.getAllUnconfirmedTxnsResponse, tc.getAllUnconfirmedTxnsErr)
 
 			req, err := http.NewRequest(tc.method, endpoint, nil)
 			require.NoError(t, err)
@@ -161,7 +196,7 @@ func TestGetPendingTxs(t *testing.T) {
 				require.Equal(t, tc.err, strings.TrimSpace(rr.Body.String()), "case: %s, handler returned wrong error message: got `%v`| %s, want `%v`",
 					tc.name, strings.TrimSpace(rr.Body.String()), status, tc.err)
 			} else {
-				var msg []*visor.ReadableUnconfirmedTxn
+				var msg []*visor.ReadableUnconfirmedTxnFee
 				err = json.Unmarshal(rr.Body.Bytes(), &msg)
 				require.NoError(t, err)
 				require.Equal(t, tc.httpResponse, msg, tc.name)
@@ -94,6 +94,34 @@ func (a Address) MarshalJSON() ([]byte, error) {
 	return []byte(`"` + a.Address.String() + `"`), nil
 }
 
+// SHA256 is a wrapper around cipher.SHA256 which implements json.Unmarshaler and json.Marshaler.
+// It marshals and unmarshals the hash as a hex string
+type SHA256 struct {
+	cipher.SHA256
+}
+
+// UnmarshalJSON unmarshals a hex string to a cipher.SHA256
+func (h *SHA256) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	tmp, err := cipher.SHA256FromHex(s)
+	if err != nil {
+		return fmt.Errorf("invalid sha256: %v", err)
+	}
+
+	h.SHA256 = tmp
+
+	return nil
+}
+
+// MarshalJSON marshals a cipher.SHA256 as a hex string
+func (h SHA256) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + h.SHA256.Hex() + `"`), nil
+}
+
 // Coins is a wrapper around uint64 which implements json.Unmarshaler and json.Marshaler.
 // It unmarshals a fixed-point decimal string to droplets and vice versa
 type Coins uint64
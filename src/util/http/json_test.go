@@ -125,6 +125,30 @@ func TestAddressUnmarshalJSON(t *testing.T) {
 	testutil.RequireError(t, err, "invalid character 'i' looking for beginning of value")
 }
 
+func TestSHA256MarshalJSON(t *testing.T) {
+	h := SHA256{cipher.SumSHA256([]byte("foo"))}
+
+	data, err := h.MarshalJSON()
+	require.NoError(t, err)
+	require.Equal(t, `"`+h.Hex()+`"`, string(data))
+}
+
+func TestSHA256UnmarshalJSON(t *testing.T) {
+	hash := cipher.SumSHA256([]byte("foo"))
+
+	var h SHA256
+	err := h.UnmarshalJSON([]byte(`"` + hash.Hex() + `"`))
+	require.NoError(t, err)
+	require.Equal(t, hash, h.SHA256)
+
+	err = h.UnmarshalJSON([]byte(`"xxx"`))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid sha256")
+
+	err = h.UnmarshalJSON([]byte("invalidjson"))
+	testutil.RequireError(t, err, "invalid character 'i' looking for beginning of value")
+}
+
 func TestCoinsMarshalJSON(t *testing.T) {
 	c := Coins(111)
 
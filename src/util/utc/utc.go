@@ -2,15 +2,61 @@
 package utc
 
 import (
+	"sync"
 	"time"
 )
 
+// Clock provides the current time
+type Clock interface {
+	Now() time.Time
+}
+
 // Now returns the current UTC time
 func Now() time.Time {
 	return time.Now().UTC()
 }
 
+// NowFrom returns the current UTC time of c, or Now if c is nil
+func NowFrom(c Clock) time.Time {
+	if c == nil {
+		return Now()
+	}
+	return c.Now().UTC()
+}
+
 // UnixNow returns the current UTC time as unix timestamp
 func UnixNow() int64 {
 	return Now().Unix()
 }
+
+// MockClock is a Clock that only moves when it is set or advanced
+type MockClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+// NewMockClock creates a MockClock starting at now
+func NewMockClock(now time.Time) *MockClock {
+	return &MockClock{now: now}
+}
+
+// Now returns the time of the clock
+func (mc *MockClock) Now() time.Time {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	return mc.now
+}
+
+// Set sets the time of the clock
+func (mc *MockClock) Set(now time.Time) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	mc.now = now
+}
+
+// Advance moves the clock forward by d
+func (mc *MockClock) Advance(d time.Duration) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	mc.now = mc.now.Add(d)
+}
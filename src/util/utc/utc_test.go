@@ -20,3 +20,22 @@ func TestUnixNow(t *testing.T) {
 	unow := UnixNow()
 	require.True(t, now.Unix() == unow || now.Unix() == unow-1)
 }
+
+func TestMockClock(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	mc := NewMockClock(start)
+	require.Equal(t, start, mc.Now())
+
+	mc.Advance(10 * time.Second)
+	require.Equal(t, int64(1500000010), mc.Now().Unix())
+
+	mc.Set(start)
+	require.Equal(t, start, mc.Now())
+}
+
+func TestNowFrom(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	mc := NewMockClock(start)
+	require.Equal(t, start.UTC(), NowFrom(mc))
+	require.True(t, NowFrom(nil).Unix() > 1500000000)
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/samoslab/samos/src/cipher"
 	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/consensus/pbft"
 	"github.com/samoslab/samos/src/util/fee"
 	"github.com/samoslab/samos/src/visor/blockdb"
 )
@@ -23,6 +24,13 @@ const (
 	SigVerifyTheadNum = 4
 )
 
+var (
+	// ErrFinalizedReorg the block would replace a final block
+	ErrFinalizedReorg = errors.New("block is at or below the finalized height")
+	// ErrBranchProducer a block of the branch is not made by the producer of its slot
+	ErrBranchProducer = errors.New("block of the branch is not made by the producer of its slot")
+)
+
 //Warning: 10e6 is 10 million, 1e6 is 1 million
 
 // Note: DebugLevel1 adds additional checks for hash collisions that
@@ -51,6 +59,15 @@ type chainStore interface {
 	GetBlockBySeq(seq uint64) (*coin.SignedBlock, error)
 	UnspentPool() blockdb.UnspentPool
 	GetGenesisBlock() *coin.SignedBlock
+	FinalizedSeq() uint64
+	AddCertificateWithTx(tx *bolt.Tx, seq uint64, cert pbft.Certificate) error
+	GetCertificate(hash cipher.SHA256) (*pbft.Certificate, bool, error)
+	AddCheckpointWithTx(tx *bolt.Tx, sb *coin.SignedBlock, uxs coin.UxArray, cp blockdb.Checkpoint) error
+	AddBackfillBlockWithTx(tx *bolt.Tx, sb *coin.SignedBlock) error
+	Checkpoint() *blockdb.Checkpoint
+	AddSideBlockWithTx(tx *bolt.Tx, sb *coin.SignedBlock) error
+	RevertHeadWithTx(tx *bolt.Tx) error
+	Reload() error
 }
 
 // BlockListener notify the register when new block is appended to the chain
@@ -147,6 +164,76 @@ func (bc *Blockchain) processBlock(b coin.SignedBlock) (coin.SignedBlock, error)
 	return b, nil
 }
 
+// FinalizedSeq returns the seq of the highest final block, the chain is never reorganized below it
+func (bc *Blockchain) FinalizedSeq() uint64 {
+	return bc.store.FinalizedSeq()
+}
+
+// AddCertificateWithTx saves the commit certificate of the block of seq with *bolt.Tx
+func (bc *Blockchain) AddCertificateWithTx(tx *bolt.Tx, seq uint64, cert pbft.Certificate) error {
+	return bc.store.AddCertificateWithTx(tx, seq, cert)
+}
+
+// GetCertificate returns the commit certificate of the block of given hash
+func (bc *Blockchain) GetCertificate(hash cipher.SHA256) (*pbft.Certificate, bool, error) {
+	return bc.store.GetCertificate(hash)
+}
+
+// AddCheckpointWithTx starts the chain from a snapshot with *bolt.Tx, the block
+// follows the genesis block and uxs are the unspent outputs after it
+func (bc *Blockchain) AddCheckpointWithTx(tx *bolt.Tx, sb *coin.SignedBlock, uxs coin.UxArray, cp blockdb.Checkpoint) error {
+	return bc.store.AddCheckpointWithTx(tx, sb, uxs, cp)
+}
+
+// AddBackfillBlockWithTx stores the parent of the lowest block of a chain started from a snapshot with *bolt.Tx
+func (bc *Blockchain) AddBackfillBlockWithTx(tx *bolt.Tx, sb *coin.SignedBlock) error {
+	return bc.store.AddBackfillBlockWithTx(tx, sb)
+}
+
+// AddSideBlockWithTx stores the block on a side branch with *bolt.Tx, the chain is not
+// changed. The block must follow a stored block and be above the finalized block
+func (bc *Blockchain) AddSideBlockWithTx(tx *bolt.Tx, sb *coin.SignedBlock) error {
+	if sb.Seq() <= bc.FinalizedSeq() {
+		return ErrFinalizedReorg
+	}
+
+	parent, err := bc.GetBlockByHash(sb.Head.PrevHash)
+	if err != nil {
+		return err
+	}
+	if parent == nil {
+		return errors.New("PrevHash does not match a stored block")
+	}
+
+	if sb.Seq() != parent.Seq()+1 {
+		return errors.New("BkSeq invalid")
+	}
+	if sb.Time() <= parent.Time() {
+		return errors.New("Block time must be > parent time")
+	}
+	if sb.HashBody() != sb.Head.BodyHash {
+		return errors.New("Computed body hash does not match")
+	}
+
+	return bc.store.AddSideBlockWithTx(tx, sb)
+}
+
+// RevertHeadWithTx reverts the head block with *bolt.Tx, its parent becomes the head
+func (bc *Blockchain) RevertHeadWithTx(tx *bolt.Tx) error {
+	return bc.store.RevertHeadWithTx(tx)
+}
+
+// Reload reloads the head and the unspent outputs from the db, after a db
+// transaction which changed them is rolled back
+func (bc *Blockchain) Reload() error {
+	return bc.store.Reload()
+}
+
+// Checkpoint returns the checkpoint of a chain started from a snapshot, nil once it is back-filled
+func (bc *Blockchain) Checkpoint() *blockdb.Checkpoint {
+	return bc.store.Checkpoint()
+}
+
 // Unspent returns the unspent outputs pool
 func (bc *Blockchain) Unspent() blockdb.UnspentPool {
 	return bc.store.UnspentPool()
@@ -594,7 +681,16 @@ func (bc *Blockchain) verifySigs() error {
 
 	shutdown, errC := bc.sigVerifier(seqC)
 
+	// blocks below the checkpoint are not back-filled yet
+	var lowest uint64
+	if cp := bc.Checkpoint(); cp != nil {
+		lowest = cp.Lowest
+	}
+
 	for i := uint64(0); i <= head.Seq(); i++ {
+		if i > 0 && i < lowest {
+			continue
+		}
 		seqC <- i
 	}
 
@@ -656,6 +752,9 @@ func (bc Blockchain) verifyBlockHeader(b coin.Block) error {
 		return err
 	}
 
+	if b.Head.BkSeq <= bc.FinalizedSeq() {
+		return ErrFinalizedReorg
+	}
 	if b.Head.BkSeq != head.Head.BkSeq+1 {
 		return errors.New("BkSeq invalid")
 	}
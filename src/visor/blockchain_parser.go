@@ -14,6 +14,7 @@ type ParserOption func(*BlockchainParser)
 type BlockchainParser struct {
 	historyDB historyer
 	blkC      chan coin.Block
+	syncC     chan struct{}
 	quit      chan struct{}
 	done      chan struct{}
 	bc        Blockchainer
@@ -29,6 +30,7 @@ func NewBlockchainParser(hisDB *historydb.HistoryDB, bc *Blockchain, ops ...Pars
 		quit:      make(chan struct{}),
 		done:      make(chan struct{}),
 		blkC:      make(chan coin.Block, 10),
+		syncC:     make(chan struct{}, 1),
 	}
 
 	for _, op := range ops {
@@ -43,6 +45,15 @@ func (bcp *BlockchainParser) FeedBlock(b coin.Block) {
 	bcp.blkC <- b
 }
 
+// Sync makes the parser parse to the blockchain head, once the blocks
+// below the checkpoint of a chain started from a snapshot are back-filled
+func (bcp *BlockchainParser) Sync() {
+	select {
+	case bcp.syncC <- struct{}{}:
+	default:
+	}
+}
+
 // Run starts blockchain parser
 func (bcp *BlockchainParser) Run() error {
 	logger.Info("Blockchain parser start")
@@ -64,7 +75,22 @@ func (bcp *BlockchainParser) Run() error {
 		case <-bcp.quit:
 			return nil
 		case b := <-bcp.blkC:
-			if err := bcp.historyDB.ParseBlock(&b); err != nil {
+			// the blocks are parsed in order, a chain started from a
+			// snapshot is parsed once the blocks below it are back-filled
+			parsedHeight := bcp.historyDB.ParsedHeight()
+			switch {
+			case int64(b.Seq()) <= parsedHeight:
+			case int64(b.Seq()) == parsedHeight+1:
+				if err := bcp.parseBlock(&b); err != nil {
+					return err
+				}
+			default:
+				if err := bcp.parseTo(b.Seq()); err != nil {
+					return err
+				}
+			}
+		case <-bcp.syncC:
+			if err := bcp.parseTo(bcp.bc.HeadSeq()); err != nil {
 				return err
 			}
 		}
@@ -87,13 +113,27 @@ func (bcp *BlockchainParser) parseTo(bcHeight uint64) error {
 		}
 
 		if b == nil {
+			// not back-filled yet
+			if bcp.bc.Checkpoint() != nil {
+				return nil
+			}
 			return fmt.Errorf("no block exist in depth:%d", parsedHeight+i+1)
 		}
 
-		if err := bcp.historyDB.ParseBlock(&b.Block); err != nil {
+		if err := bcp.parseBlock(&b.Block); err != nil {
 			return err
 		}
 	}
 
 	return nil
 }
+
+// parseBlock parses the block, a block reverted from the chain since it was read is skipped
+func (bcp *BlockchainParser) parseBlock(b *coin.Block) error {
+	err := bcp.historyDB.ParseBlock(b)
+	if err == historydb.ErrParsedBlockMismatch {
+		logger.Warningf("Skip parsing block %d %s, it is not on the chain", b.Seq(), b.HashHeader().Hex())
+		return nil
+	}
+	return err
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/samoslab/samos/src/cipher"
 	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/consensus/pbft"
 	"github.com/samoslab/samos/src/testutil"
 	"github.com/samoslab/samos/src/util/utc"
 	"github.com/samoslab/samos/src/visor/blockdb"
@@ -84,9 +85,10 @@ func makeSpendTx(t *testing.T, uxs coin.UxArray, keys []cipher.SecKey, toAddr ci
 
 /* Helpers */
 type fakeChainStore struct {
-	len    uint64
-	blocks []coin.SignedBlock
-	up     blockdb.UnspentPool
+	len       uint64
+	blocks    []coin.SignedBlock
+	up        blockdb.UnspentPool
+	finalized uint64
 }
 
 func (fcs fakeChainStore) Head() (*coin.SignedBlock, error) {
@@ -138,6 +140,42 @@ func (fcs fakeChainStore) GetGenesisBlock() *coin.SignedBlock {
 	return nil
 }
 
+func (fcs fakeChainStore) FinalizedSeq() uint64 {
+	return fcs.finalized
+}
+
+func (fcs fakeChainStore) AddCertificateWithTx(tx *bolt.Tx, seq uint64, cert pbft.Certificate) error {
+	return nil
+}
+
+func (fcs fakeChainStore) GetCertificate(hash cipher.SHA256) (*pbft.Certificate, bool, error) {
+	return nil, false, nil
+}
+
+func (fcs fakeChainStore) AddCheckpointWithTx(tx *bolt.Tx, sb *coin.SignedBlock, uxs coin.UxArray, cp blockdb.Checkpoint) error {
+	return nil
+}
+
+func (fcs fakeChainStore) AddBackfillBlockWithTx(tx *bolt.Tx, sb *coin.SignedBlock) error {
+	return nil
+}
+
+func (fcs fakeChainStore) Checkpoint() *blockdb.Checkpoint {
+	return nil
+}
+
+func (fcs fakeChainStore) AddSideBlockWithTx(tx *bolt.Tx, sb *coin.SignedBlock) error {
+	return nil
+}
+
+func (fcs fakeChainStore) RevertHeadWithTx(tx *bolt.Tx) error {
+	return nil
+}
+
+func (fcs fakeChainStore) Reload() error {
+	return nil
+}
+
 func makeBlock(t *testing.T, preBlock coin.Block, tm uint64) *coin.Block {
 	uxHash := testutil.RandSHA256(t)
 	tx := coin.Transaction{}
@@ -264,6 +302,15 @@ func TestVerifyBlockHeader(t *testing.T) {
 			bs[2].Block,
 			errors.New("BkSeq invalid"),
 		},
+		{
+			"below finalized",
+			&fakeChainStore{
+				blocks:    bs[:3],
+				finalized: 1,
+			},
+			bs[1].Block,
+			ErrFinalizedReorg,
+		},
 		{
 			"invalid time",
 			&fakeChainStore{
@@ -14,6 +14,7 @@ import (
 
 	cipher "github.com/samoslab/samos/src/cipher"
 	coin "github.com/samoslab/samos/src/coin"
+	pbft "github.com/samoslab/samos/src/consensus/pbft"
 	blockdb "github.com/samoslab/samos/src/visor/blockdb"
 )
 
@@ -26,6 +27,78 @@ func NewBlockchainerMock() *BlockchainerMock {
 	return &BlockchainerMock{}
 }
 
+// AddBackfillBlockWithTx mocked method
+func (m *BlockchainerMock) AddBackfillBlockWithTx(p0 *bolt.Tx, p1 *coin.SignedBlock) error {
+
+	ret := m.Called(p0, p1)
+
+	var r0 error
+	switch res := ret.Get(0).(type) {
+	case nil:
+	case error:
+		r0 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	return r0
+
+}
+
+// AddCertificateWithTx mocked method
+func (m *BlockchainerMock) AddCertificateWithTx(p0 *bolt.Tx, p1 uint64, p2 pbft.Certificate) error {
+
+	ret := m.Called(p0, p1, p2)
+
+	var r0 error
+	switch res := ret.Get(0).(type) {
+	case nil:
+	case error:
+		r0 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	return r0
+
+}
+
+// AddCheckpointWithTx mocked method
+func (m *BlockchainerMock) AddCheckpointWithTx(p0 *bolt.Tx, p1 *coin.SignedBlock, p2 coin.UxArray, p3 blockdb.Checkpoint) error {
+
+	ret := m.Called(p0, p1, p2, p3)
+
+	var r0 error
+	switch res := ret.Get(0).(type) {
+	case nil:
+	case error:
+		r0 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	return r0
+
+}
+
+// AddSideBlockWithTx mocked method
+func (m *BlockchainerMock) AddSideBlockWithTx(p0 *bolt.Tx, p1 *coin.SignedBlock) error {
+
+	ret := m.Called(p0, p1)
+
+	var r0 error
+	switch res := ret.Get(0).(type) {
+	case nil:
+	case error:
+		r0 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	return r0
+
+}
+
 // BindListener mocked method
 func (m *BlockchainerMock) BindListener(p0 BlockListener) {
 
@@ -33,6 +106,24 @@ func (m *BlockchainerMock) BindListener(p0 BlockListener) {
 
 }
 
+// Checkpoint mocked method
+func (m *BlockchainerMock) Checkpoint() *blockdb.Checkpoint {
+
+	ret := m.Called()
+
+	var r0 *blockdb.Checkpoint
+	switch res := ret.Get(0).(type) {
+	case nil:
+	case *blockdb.Checkpoint:
+		r0 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	return r0
+
+}
+
 // ExecuteBlockWithTx mocked method
 func (m *BlockchainerMock) ExecuteBlockWithTx(p0 *bolt.Tx, p1 *coin.SignedBlock) error {
 
@@ -51,6 +142,24 @@ func (m *BlockchainerMock) ExecuteBlockWithTx(p0 *bolt.Tx, p1 *coin.SignedBlock)
 
 }
 
+// FinalizedSeq mocked method
+func (m *BlockchainerMock) FinalizedSeq() uint64 {
+
+	ret := m.Called()
+
+	var r0 uint64
+	switch res := ret.Get(0).(type) {
+	case nil:
+	case uint64:
+		r0 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	return r0
+
+}
+
 // GetBlockByHash mocked method
 func (m *BlockchainerMock) GetBlockByHash(p0 cipher.SHA256) (*coin.SignedBlock, error) {
 
@@ -123,6 +232,42 @@ func (m *BlockchainerMock) GetBlocks(p0 uint64, p1 uint64) []coin.SignedBlock {
 
 }
 
+// GetCertificate mocked method
+func (m *BlockchainerMock) GetCertificate(p0 cipher.SHA256) (*pbft.Certificate, bool, error) {
+
+	ret := m.Called(p0)
+
+	var r0 *pbft.Certificate
+	switch res := ret.Get(0).(type) {
+	case nil:
+	case *pbft.Certificate:
+		r0 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	var r1 bool
+	switch res := ret.Get(1).(type) {
+	case nil:
+	case bool:
+		r1 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	var r2 error
+	switch res := ret.Get(2).(type) {
+	case nil:
+	case error:
+		r2 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	return r0, r1, r2
+
+}
+
 // GetGenesisBlock mocked method
 func (m *BlockchainerMock) GetGenesisBlock() *coin.SignedBlock {
 
@@ -256,6 +401,42 @@ func (m *BlockchainerMock) Notify(p0 coin.Block) {
 
 }
 
+// Reload mocked method
+func (m *BlockchainerMock) Reload() error {
+
+	ret := m.Called()
+
+	var r0 error
+	switch res := ret.Get(0).(type) {
+	case nil:
+	case error:
+		r0 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	return r0
+
+}
+
+// RevertHeadWithTx mocked method
+func (m *BlockchainerMock) RevertHeadWithTx(p0 *bolt.Tx) error {
+
+	ret := m.Called(p0)
+
+	var r0 error
+	switch res := ret.Get(0).(type) {
+	case nil:
+	case error:
+		r0 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	return r0
+
+}
+
 // Time mocked method
 func (m *BlockchainerMock) Time() uint64 {
 
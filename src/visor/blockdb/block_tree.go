@@ -13,11 +13,12 @@ import (
 )
 
 var (
-	emptyHash      cipher.SHA256
-	errBlockExist  = errors.New("block already exist")
-	errNoParent    = errors.New("block is not genesis and have no parent")
-	errWrongParent = errors.New("wrong parent")
-	errHasChild    = errors.New("remove block failed, it has children")
+	emptyHash        cipher.SHA256
+	errBlockExist    = errors.New("block already exist")
+	errBlockNotExist = errors.New("block does not exist")
+	errNoParent      = errors.New("block is not genesis and have no parent")
+	errWrongParent   = errors.New("wrong parent")
+	errHasChild      = errors.New("remove block failed, it has children")
 )
 
 // blockTree use the blockdb store all blocks and maintains the block tree struct.
@@ -54,8 +55,48 @@ func (bt *blockTree) AddBlock(b *coin.Block) error {
 	})
 }
 
-// AddBlockWithTx adds block with *bolt.Tx
+// AddBlockWithTx adds block with *bolt.Tx, the block extends the active chain.
+// The first hash pair of each depth is the block of the active chain.
 func (bt *blockTree) AddBlockWithTx(tx *bolt.Tx, b *coin.Block) error {
+	return bt.addBlockWithTx(tx, b, true, true)
+}
+
+// AddSideBlockWithTx adds block on a side branch with *bolt.Tx, the block
+// is stored after the blocks of the depth
+func (bt *blockTree) AddSideBlockWithTx(tx *bolt.Tx, b *coin.Block) error {
+	return bt.addBlockWithTx(tx, b, true, false)
+}
+
+// AddCheckpointBlockWithTx adds a block whose parent is not stored, the
+// checkpoint block of a snapshot or a block back-filled below it. The
+// caller verifies it is linked to the chain by its hash.
+func (bt *blockTree) AddCheckpointBlockWithTx(tx *bolt.Tx, b *coin.Block) error {
+	return bt.addBlockWithTx(tx, b, false, true)
+}
+
+// SetActiveWithTx moves the stored block to the front of its depth with
+// *bolt.Tx, when the branch of the block becomes the active chain
+func (bt *blockTree) SetActiveWithTx(tx *bolt.Tx, b *coin.Block) error {
+	tree := tx.Bucket(bt.tree.Name)
+	if tree == nil {
+		return fmt.Errorf("bucket %s doesn't exist", bt.tree.Name)
+	}
+
+	hashPairs, err := getHashPairInDepth(tree, b.Seq(), allPairs)
+	if err != nil {
+		return err
+	}
+
+	hp := coin.HashPair{Hash: b.HashHeader(), PreHash: b.Head.PrevHash}
+	if !containHash(hashPairs, hp) {
+		return errBlockNotExist
+	}
+
+	ps := append([]coin.HashPair{hp}, removePairs(hashPairs, hp)...)
+	return setHashPairInDepth(tree, b.Seq(), ps)
+}
+
+func (bt *blockTree) addBlockWithTx(tx *bolt.Tx, b *coin.Block, checkParent, active bool) error {
 	bkt := tx.Bucket(bt.blocks.Name)
 	if bkt == nil {
 		return fmt.Errorf("bucket %s doesn't eist", bt.blocks.Name)
@@ -81,7 +122,7 @@ func (bt *blockTree) AddBlockWithTx(tx *bolt.Tx, b *coin.Block) error {
 	tree := tx.Bucket(bt.tree.Name)
 
 	// the pre hash must be in depth - 1.
-	if b.Seq() > 0 {
+	if b.Seq() > 0 && checkParent {
 		preHash := b.PreHashHeader()
 		parentHashPair, err := getHashPairInDepth(tree, b.Seq()-1, func(hp coin.HashPair) bool {
 			return hp.Hash == preHash
@@ -113,7 +154,11 @@ func (bt *blockTree) AddBlockWithTx(tx *bolt.Tx, b *coin.Block) error {
 		return errBlockExist
 	}
 
-	hashPairs = append(hashPairs, hp)
+	if active {
+		hashPairs = append([]coin.HashPair{hp}, hashPairs...)
+	} else {
+		hashPairs = append(hashPairs, hp)
+	}
 	return setHashPairInDepth(tree, b.Seq(), hashPairs)
 }
 
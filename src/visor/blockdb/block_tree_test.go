@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/boltdb/bolt"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/samoslab/samos/src/cipher"
 	"github.com/samoslab/samos/src/coin"
@@ -204,3 +206,43 @@ func TestGetBlockInDepth(t *testing.T) {
 
 	assert.Equal(t, *block, blocks[2])
 }
+
+func TestSetActive(t *testing.T) {
+	db, teardown := testutil.PrepareDB(t)
+	defer teardown()
+
+	bt, err := newBlockTree(db)
+	require.NoError(t, err)
+
+	gb := coin.Block{Head: coin.BlockHeader{BkSeq: 0}}
+	b1 := coin.Block{Head: coin.BlockHeader{BkSeq: 1, Time: 1, PrevHash: gb.HashHeader()}}
+	side := coin.Block{Head: coin.BlockHeader{BkSeq: 1, Time: 2, PrevHash: gb.HashHeader()}}
+	first := func(hps []coin.HashPair) cipher.SHA256 {
+		return hps[0].Hash
+	}
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		require.NoError(t, bt.AddBlockWithTx(tx, &gb))
+		require.NoError(t, bt.AddBlockWithTx(tx, &b1))
+		return bt.AddSideBlockWithTx(tx, &side)
+	}))
+	require.Equal(t, b1, *bt.GetBlockInDepth(1, first))
+	require.Equal(t, side, *bt.GetBlock(side.HashHeader()))
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return bt.SetActiveWithTx(tx, &side)
+	}))
+	require.Equal(t, side, *bt.GetBlockInDepth(1, first))
+
+	// a block added to the active chain goes first
+	b2 := coin.Block{Head: coin.BlockHeader{BkSeq: 1, Time: 3, PrevHash: gb.HashHeader()}}
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return bt.AddBlockWithTx(tx, &b2)
+	}))
+	require.Equal(t, b2, *bt.GetBlockInDepth(1, first))
+
+	unknown := coin.Block{Head: coin.BlockHeader{BkSeq: 1, Time: 4, PrevHash: gb.HashHeader()}}
+	require.Equal(t, errBlockNotExist, db.Update(func(tx *bolt.Tx) error {
+		return bt.SetActiveWithTx(tx, &unknown)
+	}))
+}
@@ -8,7 +8,9 @@ import (
 	"github.com/boltdb/bolt"
 
 	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/cipher/encoder"
 	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/consensus/pbft"
 	"github.com/samoslab/samos/src/visor/bucket"
 )
 
@@ -17,6 +19,17 @@ var (
 	blockchainMetaBkt = []byte("blockchain_meta")
 	// blockchain head sequence number
 	headSeqKey = []byte("head_seq")
+	// sequence number of the highest block with a commit certificate
+	finalizedSeqKey = []byte("finalized_seq")
+	// checkpoint of a chain started from a snapshot
+	checkpointKey = []byte("checkpoint")
+
+	// ErrNoCheckpoint is returned when back-filling a chain that did not start from a snapshot
+	ErrNoCheckpoint = errors.New("blockchain has no checkpoint")
+	// ErrBackfillMismatch is returned if a back-filled block is not the parent of the lowest block
+	ErrBackfillMismatch = errors.New("back-filled block is not the parent of the lowest block")
+	// ErrRevertFinalized is returned when reverting a final block
+	ErrRevertFinalized = errors.New("can not revert a finalized block")
 )
 
 // ErrMissingSignature is returned if no matching signature is found for a block in the db
@@ -52,9 +65,55 @@ func (m chainMeta) setHeadSeqWithTx(tx *bolt.Tx, seq uint64) error {
 	return m.PutWithTx(tx, headSeqKey, bucket.Itob(seq))
 }
 
+func (m chainMeta) setFinalizedSeqWithTx(tx *bolt.Tx, seq uint64) error {
+	return m.PutWithTx(tx, finalizedSeqKey, bucket.Itob(seq))
+}
+
+func (m chainMeta) setCheckpointWithTx(tx *bolt.Tx, cp *Checkpoint) error {
+	if cp == nil {
+		return m.DeleteWithTx(tx, checkpointKey)
+	}
+	return m.PutWithTx(tx, checkpointKey, encoder.Serialize(*cp))
+}
+
+func (m chainMeta) getCheckpoint() (*Checkpoint, error) {
+	v := m.Get(checkpointKey)
+	if v == nil {
+		return nil, nil
+	}
+
+	var cp Checkpoint
+	if err := encoder.DeserializeRaw(v, &cp); err != nil {
+		return nil, fmt.Errorf("decode checkpoint failed: %v", err)
+	}
+	return &cp, nil
+}
+
+// Checkpoint is the first block of a chain started from a snapshot. The blocks
+// between the genesis block and the checkpoint are back-filled from the top,
+// each one is verified by the PrevHash of the block above it
+type Checkpoint struct {
+	// Seq of the checkpoint block
+	Seq uint64
+	// Lowest seq of the blocks stored above the genesis block
+	Lowest uint64
+	// Epoch of the checkpoint block, and the seed of its producer order,
+	// which is the hash of a block that may not be back-filled yet
+	Epoch     int64
+	EpochSeed cipher.SHA256
+}
+
+// Backfilled returns true if all the blocks below the checkpoint are stored
+func (cp Checkpoint) Backfilled() bool {
+	return cp.Lowest <= 1
+}
+
 // BlockTree block storage
 type BlockTree interface {
 	AddBlockWithTx(tx *bolt.Tx, b *coin.Block) error
+	AddSideBlockWithTx(tx *bolt.Tx, b *coin.Block) error
+	AddCheckpointBlockWithTx(tx *bolt.Tx, b *coin.Block) error
+	SetActiveWithTx(tx *bolt.Tx, b *coin.Block) error
 	GetBlock(hash cipher.SHA256) *coin.Block
 	GetBlockInDepth(dep uint64, filter func(hps []coin.HashPair) cipher.SHA256) *coin.Block
 }
@@ -63,6 +122,8 @@ type BlockTree interface {
 type BlockSigs interface {
 	AddWithTx(*bolt.Tx, cipher.SHA256, cipher.Sig) error
 	Get(hash cipher.SHA256) (cipher.Sig, bool, error)
+	AddCertificateWithTx(*bolt.Tx, pbft.Certificate) error
+	GetCertificate(hash cipher.SHA256) (*pbft.Certificate, bool, error)
 }
 
 // UnspentPool unspent outputs pool
@@ -73,8 +134,14 @@ type UnspentPool interface {
 	GetArray(hashes []cipher.SHA256) (coin.UxArray, error)
 	GetUxHash() cipher.SHA256
 	GetUnspentsOfAddrs(addrs []cipher.Address) coin.AddressUxOuts
+	GetTopAddressCoins(n int, skip map[cipher.Address]struct{}) (map[cipher.Address]uint64, error)
+	AddressCount() uint64
 	ProcessBlock(*coin.SignedBlock) bucket.TxHandler
+	RevertBlock(*coin.SignedBlock) bucket.TxHandler
+	PruneUndo(seq uint64) bucket.TxHandler
+	Load(coin.UxArray) bucket.TxHandler
 	Contains(cipher.SHA256) bool
+	Reload() error
 }
 
 // Walker function for go through blockchain
@@ -90,7 +157,10 @@ type Blockchain struct {
 	walker  Walker
 	cache   struct {
 		headSeq      uint64 // head block seq
+		head         *coin.SignedBlock
+		finalizedSeq uint64 // finalized block seq
 		genesisBlock *coin.SignedBlock
+		checkpoint   *Checkpoint
 	}
 	sync.RWMutex // cache lock
 }
@@ -150,8 +220,16 @@ func createBlockchain(db *bolt.DB,
 	return bc, nil
 }
 
-// AddBlockWithTx adds signed block
+// AddBlockWithTx adds signed block on top of the head, a block stored on a
+// side branch before is moved to the active chain
 func (bc *Blockchain) AddBlockWithTx(tx *bolt.Tx, sb *coin.SignedBlock) error {
+	if bc.tree.GetBlock(sb.HashHeader()) != nil {
+		if err := bc.tree.SetActiveWithTx(tx, &sb.Block); err != nil {
+			return fmt.Errorf("activate block failed: %v", err)
+		}
+		return bc.processBlockWithTx(tx, sb)
+	}
+
 	if err := bc.sigs.AddWithTx(tx, sb.HashHeader(), sb.Sig); err != nil {
 		return fmt.Errorf("save signature failed: %v", err)
 	}
@@ -168,6 +246,126 @@ func (bc *Blockchain) AddBlockWithTx(tx *bolt.Tx, sb *coin.SignedBlock) error {
 	return nil
 }
 
+// AddSideBlockWithTx stores the signed block on a side branch, the chain
+// is not changed. The parent of the block must be stored
+func (bc *Blockchain) AddSideBlockWithTx(tx *bolt.Tx, sb *coin.SignedBlock) error {
+	if err := bc.sigs.AddWithTx(tx, sb.HashHeader(), sb.Sig); err != nil {
+		return fmt.Errorf("save signature failed: %v", err)
+	}
+
+	if err := bc.tree.AddSideBlockWithTx(tx, &sb.Block); err != nil {
+		return fmt.Errorf("save block failed: %v", err)
+	}
+	return nil
+}
+
+// RevertHeadWithTx reverts the head block, its parent becomes the head. The
+// block stays in the block tree as a side branch. Final blocks can't be reverted
+func (bc *Blockchain) RevertHeadWithTx(tx *bolt.Tx) error {
+	head, err := bc.Head()
+	if err != nil {
+		return err
+	}
+
+	if head.Seq() <= bc.FinalizedSeq() {
+		return ErrRevertFinalized
+	}
+
+	parent, err := bc.GetBlockByHash(head.Head.PrevHash)
+	if err != nil {
+		return err
+	}
+	if parent == nil {
+		return fmt.Errorf("found no parent of head block: %v", head.Seq())
+	}
+
+	return bc.updateWithTx(tx, bc.unspent.RevertBlock(head), bc.updateHeadSeq(parent))
+}
+
+// AddCheckpointWithTx starts the chain from the checkpoint block of a snapshot,
+// replacing the unspent pool with uxs, the unspent outputs after the block.
+// The chain must have the genesis block only.
+func (bc *Blockchain) AddCheckpointWithTx(tx *bolt.Tx, sb *coin.SignedBlock, uxs coin.UxArray, cp Checkpoint) error {
+	if bc.Len() != 1 {
+		return errors.New("checkpoint must follow the genesis block only")
+	}
+
+	if sb.Seq() <= 1 {
+		return errors.New("checkpoint must be above the first block")
+	}
+
+	if err := bc.sigs.AddWithTx(tx, sb.HashHeader(), sb.Sig); err != nil {
+		return fmt.Errorf("save signature failed: %v", err)
+	}
+
+	if err := bc.tree.AddCheckpointBlockWithTx(tx, &sb.Block); err != nil {
+		return fmt.Errorf("save block failed: %v", err)
+	}
+
+	cp.Seq = sb.Seq()
+	cp.Lowest = sb.Seq()
+	return bc.updateWithTx(tx, bc.updateHeadSeq(sb), bc.unspent.Load(uxs),
+		bc.updateFinalizedSeq(sb.Seq()), bc.updateCheckpoint(&cp))
+}
+
+// AddBackfillBlockWithTx stores the block below the lowest block of a chain
+// started from a snapshot, it must be the parent of the lowest block. The
+// checkpoint is removed once the first block is stored.
+func (bc *Blockchain) AddBackfillBlockWithTx(tx *bolt.Tx, sb *coin.SignedBlock) error {
+	cp := bc.Checkpoint()
+	if cp == nil {
+		return ErrNoCheckpoint
+	}
+
+	lowest, err := bc.GetBlockBySeq(cp.Lowest)
+	if err != nil {
+		return err
+	}
+	if lowest == nil {
+		return fmt.Errorf("found no lowest block: %v", cp.Lowest)
+	}
+
+	if sb.Seq()+1 != cp.Lowest || sb.HashHeader() != lowest.Head.PrevHash {
+		return ErrBackfillMismatch
+	}
+
+	if sb.HashBody() != sb.Head.BodyHash {
+		return ErrBackfillMismatch
+	}
+
+	// the first block must follow our genesis block
+	if sb.Seq() == 1 && sb.Head.PrevHash != bc.GetGenesisBlock().HashHeader() {
+		return ErrBackfillMismatch
+	}
+
+	if err := bc.sigs.AddWithTx(tx, sb.HashHeader(), sb.Sig); err != nil {
+		return fmt.Errorf("save signature failed: %v", err)
+	}
+
+	if err := bc.tree.AddCheckpointBlockWithTx(tx, &sb.Block); err != nil {
+		return fmt.Errorf("save block failed: %v", err)
+	}
+
+	next := *cp
+	next.Lowest = sb.Seq()
+	if next.Backfilled() {
+		return bc.updateWithTx(tx, bc.updateCheckpoint(nil))
+	}
+	return bc.updateWithTx(tx, bc.updateCheckpoint(&next))
+}
+
+// Checkpoint returns the checkpoint of a chain started from a snapshot, nil if
+// the chain started from the genesis block or all the blocks are back-filled
+func (bc *Blockchain) Checkpoint() *Checkpoint {
+	bc.RLock()
+	defer bc.RUnlock()
+	if bc.cache.checkpoint == nil {
+		return nil
+	}
+	cp := *bc.cache.checkpoint
+	return &cp
+}
+
 // processBlockWithTx process block with *bolt.Tx
 func (bc *Blockchain) processBlockWithTx(tx *bolt.Tx, b *coin.SignedBlock) error {
 	return bc.updateWithTx(tx, bc.updateHeadSeq(b), bc.unspent.ProcessBlock(b), bc.cacheGenesisBlock(b))
@@ -175,6 +373,16 @@ func (bc *Blockchain) processBlockWithTx(tx *bolt.Tx, b *coin.SignedBlock) error
 
 // Head returns head block, returns error if no block does exist
 func (bc *Blockchain) Head() (*coin.SignedBlock, error) {
+	// the head is cached when it changes, it is read in the db transaction
+	// changing it before the transaction is committed
+	bc.RLock()
+	head := bc.cache.head
+	bc.RUnlock()
+	if head != nil {
+		b := *head
+		return &b, nil
+	}
+
 	b, err := bc.GetBlockBySeq(bc.HeadSeq())
 	if err != nil {
 		return nil, err
@@ -194,6 +402,30 @@ func (bc *Blockchain) HeadSeq() uint64 {
 	return bc.cache.headSeq
 }
 
+// FinalizedSeq returns the sequence of the highest final block, blocks up to
+// it can not be reverted. The genesis block is final
+func (bc *Blockchain) FinalizedSeq() uint64 {
+	bc.RLock()
+	defer bc.RUnlock()
+	return bc.cache.finalizedSeq
+}
+
+// AddCertificateWithTx saves the commit certificate of the block of seq and
+// finalizes the chain up to it
+func (bc *Blockchain) AddCertificateWithTx(tx *bolt.Tx, seq uint64, cert pbft.Certificate) error {
+	if err := bc.sigs.AddCertificateWithTx(tx, cert); err != nil {
+		return fmt.Errorf("save certificate failed: %v", err)
+	}
+
+	return bc.updateWithTx(tx, bc.updateFinalizedSeq(seq), bc.unspent.PruneUndo(seq))
+}
+
+// GetCertificate returns the commit certificate of the block, returns false if the
+// block was not finalized by its own certificate
+func (bc *Blockchain) GetCertificate(hash cipher.SHA256) (*pbft.Certificate, bool, error) {
+	return bc.sigs.GetCertificate(hash)
+}
+
 // UnspentPool returns the unspent pool
 func (bc *Blockchain) UnspentPool() UnspentPool {
 	return bc.unspent
@@ -234,8 +466,17 @@ func (bc *Blockchain) GetBlockByHash(hash cipher.SHA256) (*coin.SignedBlock, err
 	}, nil
 }
 
-// GetBlockBySeq returns signed block of given seq
+// GetBlockBySeq returns signed block of given seq on the active chain
 func (bc *Blockchain) GetBlockBySeq(seq uint64) (*coin.SignedBlock, error) {
+	// a side branch may be longer than the chain
+	if seq > bc.HeadSeq() {
+		return nil, nil
+	}
+
+	return bc.getBlockBySeq(seq)
+}
+
+func (bc *Blockchain) getBlockBySeq(seq uint64) (*coin.SignedBlock, error) {
 	b := bc.tree.GetBlockInDepth(seq, bc.walker)
 	if b == nil {
 		return nil, nil
@@ -270,10 +511,17 @@ func (bc *Blockchain) syncCache() error {
 	bc.Lock()
 	defer bc.Unlock()
 	bc.cache.headSeq = bc.getHeadSeqFromDB()
+	bc.cache.finalizedSeq = bc.getFinalizedSeqFromDB()
+
+	cp, err := bc.meta.getCheckpoint()
+	if err != nil {
+		return err
+	}
+	bc.cache.checkpoint = cp
 
 	// load genesis block
 	if bc.cache.genesisBlock == nil {
-		b, err := bc.GetBlockBySeq(0)
+		b, err := bc.getBlockBySeq(0)
 		if err != nil {
 			return err
 		}
@@ -283,6 +531,19 @@ func (bc *Blockchain) syncCache() error {
 	return nil
 }
 
+// Reload reloads the caches from the db, when a db transaction is rolled back
+// after the handlers updated them
+func (bc *Blockchain) Reload() error {
+	if err := bc.unspent.Reload(); err != nil {
+		return err
+	}
+
+	bc.Lock()
+	bc.cache.head = nil
+	bc.Unlock()
+	return bc.syncCache()
+}
+
 func (bc *Blockchain) getHeadSeqFromDB() uint64 {
 	if v := bc.meta.Get(headSeqKey); v != nil {
 		return bucket.Btoi(v)
@@ -291,6 +552,14 @@ func (bc *Blockchain) getHeadSeqFromDB() uint64 {
 	return 0
 }
 
+func (bc *Blockchain) getFinalizedSeqFromDB() uint64 {
+	if v := bc.meta.Get(finalizedSeqKey); v != nil {
+		return bucket.Btoi(v)
+	}
+
+	return 0
+}
+
 // dbUpdate will execute all processors in sequence, return error will rollback all
 // updates to the db
 func (bc *Blockchain) dbUpdate(ps ...bucket.TxHandler) error {
@@ -326,15 +595,62 @@ func (bc *Blockchain) updateHeadSeq(b *coin.SignedBlock) bucket.TxHandler {
 		bc.Lock()
 		// get current head seq
 		seq := bc.cache.headSeq
+		head := bc.cache.head
 
 		// update the cache head seq
 		bc.cache.headSeq = b.Seq()
+		bc.cache.head = b
 		bc.Unlock()
 
 		return func() {
 			// reset the cache head seq
 			bc.Lock()
 			bc.cache.headSeq = seq
+			bc.cache.head = head
+			bc.Unlock()
+		}, nil
+	}
+}
+
+// updateFinalizedSeq raises the finalized seq, certificates of lower blocks leave it
+func (bc *Blockchain) updateFinalizedSeq(seq uint64) bucket.TxHandler {
+	return func(tx *bolt.Tx) (bucket.Rollback, error) {
+		bc.Lock()
+		defer bc.Unlock()
+
+		finalized := bc.cache.finalizedSeq
+		if seq <= finalized {
+			return func() {}, nil
+		}
+
+		if err := bc.meta.setFinalizedSeqWithTx(tx, seq); err != nil {
+			return func() {}, err
+		}
+		bc.cache.finalizedSeq = seq
+
+		return func() {
+			bc.Lock()
+			bc.cache.finalizedSeq = finalized
+			bc.Unlock()
+		}, nil
+	}
+}
+
+// updateCheckpoint saves the checkpoint, nil removes it
+func (bc *Blockchain) updateCheckpoint(cp *Checkpoint) bucket.TxHandler {
+	return func(tx *bolt.Tx) (bucket.Rollback, error) {
+		if err := bc.meta.setCheckpointWithTx(tx, cp); err != nil {
+			return func() {}, err
+		}
+
+		bc.Lock()
+		defer bc.Unlock()
+		origin := bc.cache.checkpoint
+		bc.cache.checkpoint = cp
+
+		return func() {
+			bc.Lock()
+			bc.cache.checkpoint = origin
 			bc.Unlock()
 		}, nil
 	}
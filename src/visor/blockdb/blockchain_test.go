@@ -11,6 +11,7 @@ import (
 
 	"github.com/samoslab/samos/src/cipher"
 	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/consensus/pbft"
 	"github.com/samoslab/samos/src/testutil"
 	"github.com/samoslab/samos/src/visor/bucket"
 )
@@ -51,6 +52,18 @@ func (bt fakeBlockTree) AddBlockWithTx(tx *bolt.Tx, b *coin.Block) error {
 	return nil
 }
 
+func (bt fakeBlockTree) AddCheckpointBlockWithTx(tx *bolt.Tx, b *coin.Block) error {
+	return bt.AddBlockWithTx(tx, b)
+}
+
+func (bt fakeBlockTree) AddSideBlockWithTx(tx *bolt.Tx, b *coin.Block) error {
+	return bt.AddBlockWithTx(tx, b)
+}
+
+func (bt fakeBlockTree) SetActiveWithTx(tx *bolt.Tx, b *coin.Block) error {
+	return nil
+}
+
 func (bt fakeBlockTree) GetBlock(hash cipher.SHA256) *coin.Block {
 	if failedWhenSave {
 		return nil
@@ -65,13 +78,15 @@ func (bt fakeBlockTree) GetBlockInDepth(dep uint64, filter func(hps []coin.HashP
 type fakeSignatureStore struct {
 	db         *bolt.DB
 	sigs       map[string]cipher.Sig
+	certs      map[string]pbft.Certificate
 	saveFailed bool
 	getSigErr  error
 }
 
 func newFakeSigStore() *fakeSignatureStore {
 	return &fakeSignatureStore{
-		sigs: make(map[string]cipher.Sig),
+		sigs:  make(map[string]cipher.Sig),
+		certs: make(map[string]pbft.Certificate),
 	}
 }
 
@@ -98,6 +113,19 @@ func (ss fakeSignatureStore) Get(hash cipher.SHA256) (cipher.Sig, bool, error) {
 	return sig, ok, nil
 }
 
+func (ss fakeSignatureStore) AddCertificateWithTx(tx *bolt.Tx, cert pbft.Certificate) error {
+	ss.certs[cert.Hash.Hex()] = cert
+	return nil
+}
+
+func (ss fakeSignatureStore) GetCertificate(hash cipher.SHA256) (*pbft.Certificate, bool, error) {
+	cert, ok := ss.certs[hash.Hex()]
+	if !ok {
+		return nil, false, nil
+	}
+	return &cert, true, nil
+}
+
 type fakeUnspentPool struct {
 	outs       map[cipher.SHA256]coin.UxOut
 	uxHash     cipher.SHA256
@@ -155,6 +183,14 @@ func (fup fakeUnspentPool) GetUnspentsOfAddrs(addrs []cipher.Address) coin.Addre
 	return addrOutMap
 }
 
+func (fup fakeUnspentPool) GetTopAddressCoins(n int, skip map[cipher.Address]struct{}) (map[cipher.Address]uint64, error) {
+	return nil, nil
+}
+
+func (fup fakeUnspentPool) AddressCount() uint64 {
+	return 0
+}
+
 func (fup fakeUnspentPool) ProcessBlock(b *coin.SignedBlock) bucket.TxHandler {
 	return func(tx *bolt.Tx) (bucket.Rollback, error) {
 		if fup.saveFailed {
@@ -165,6 +201,28 @@ func (fup fakeUnspentPool) ProcessBlock(b *coin.SignedBlock) bucket.TxHandler {
 	}
 }
 
+func (fup fakeUnspentPool) RevertBlock(b *coin.SignedBlock) bucket.TxHandler {
+	return func(tx *bolt.Tx) (bucket.Rollback, error) {
+		return func() {}, nil
+	}
+}
+
+func (fup fakeUnspentPool) PruneUndo(seq uint64) bucket.TxHandler {
+	return func(tx *bolt.Tx) (bucket.Rollback, error) {
+		return func() {}, nil
+	}
+}
+
+func (fup fakeUnspentPool) Load(uxs coin.UxArray) bucket.TxHandler {
+	return func(tx *bolt.Tx) (bucket.Rollback, error) {
+		return func() {}, nil
+	}
+}
+
+func (fup fakeUnspentPool) Reload() error {
+	return nil
+}
+
 func (fup fakeUnspentPool) Contains(h cipher.SHA256) bool {
 	_, ok := fup.outs[h]
 	return ok
@@ -477,3 +535,245 @@ func TestBlockchainGetBlockByHash(t *testing.T) {
 		})
 	}
 }
+
+func TestBlockchainAddCertificateWithTx(t *testing.T) {
+	db, closeDB := testutil.PrepareDB(t)
+	defer closeDB()
+
+	bc, err := NewBlockchain(db, DefaultWalker)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), bc.FinalizedSeq())
+
+	hash := testutil.RandSHA256(t)
+	_, ok, err := bc.GetCertificate(hash)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	_, s := cipher.GenerateKeyPair()
+	cert := pbft.Certificate{
+		Hash:    hash,
+		Commits: []pbft.CommitSig{{View: 1, Sig: cipher.SignHash(pbft.CommitHash(hash, 1), s)}},
+	}
+	lower := pbft.Certificate{Hash: testutil.RandSHA256(t)}
+
+	for _, c := range []struct {
+		seq  uint64
+		cert pbft.Certificate
+	}{{5, cert}, {3, lower}} {
+		err = db.Update(func(tx *bolt.Tx) error {
+			return bc.AddCertificateWithTx(tx, c.seq, c.cert)
+		})
+		require.NoError(t, err)
+	}
+
+	// a lower certificate is saved and leaves the finalized seq
+	require.Equal(t, uint64(5), bc.FinalizedSeq())
+	got, ok, err := bc.GetCertificate(hash)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, cert, *got)
+	_, ok, err = bc.GetCertificate(lower.Hash)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// the finalized seq is loaded on restart
+	bc, err = NewBlockchain(db, DefaultWalker)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), bc.FinalizedSeq())
+}
+
+func makeBlockChain(t *testing.T, gb coin.SignedBlock, n int) []coin.SignedBlock {
+	blocks := []coin.SignedBlock{gb}
+	for i := 1; i <= n; i++ {
+		txn := coin.Transaction{}
+		txn.PushInput(testutil.RandSHA256(t))
+		txn.PushOutput(genAddress, 1e6, 100)
+		b, err := coin.NewBlock(blocks[i-1].Block, blocks[i-1].Time()+10, testutil.RandSHA256(t), coin.Transactions{txn}, _feeCalc)
+		require.NoError(t, err)
+		blocks = append(blocks, coin.SignedBlock{
+			Block: *b,
+			Sig:   cipher.SignHash(b.HashHeader(), genSecret),
+		})
+	}
+	return blocks
+}
+
+func TestBlockchainCheckpoint(t *testing.T) {
+	db, closeDB := testutil.PrepareDB(t)
+	defer closeDB()
+
+	bc, err := NewBlockchain(db, DefaultWalker)
+	require.NoError(t, err)
+
+	blocks := makeBlockChain(t, makeGenesisBlock(t), 4)
+	uxs := coin.UxArray{makeUxOut(t), makeUxOut(t)}
+
+	// the chain must have the genesis block
+	err = db.Update(func(tx *bolt.Tx) error {
+		return bc.AddCheckpointWithTx(tx, &blocks[4], uxs, Checkpoint{})
+	})
+	require.Error(t, err)
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return bc.AddBlockWithTx(tx, &blocks[0])
+	}))
+	require.Nil(t, bc.Checkpoint())
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return bc.AddCheckpointWithTx(tx, &blocks[4], uxs, Checkpoint{Epoch: 3, EpochSeed: blocks[2].HashHeader()})
+	}))
+
+	cp := Checkpoint{Seq: 4, Lowest: 4, Epoch: 3, EpochSeed: blocks[2].HashHeader()}
+	require.Equal(t, &cp, bc.Checkpoint())
+	require.Equal(t, uint64(4), bc.HeadSeq())
+	require.Equal(t, uint64(4), bc.FinalizedSeq())
+	require.Equal(t, uint64(2), bc.UnspentPool().Len())
+	require.Equal(t, UxHash(uxs), bc.UnspentPool().GetUxHash())
+	b, err := bc.GetBlockBySeq(3)
+	require.NoError(t, err)
+	require.Nil(t, b)
+
+	// not the parent of the lowest block
+	for _, b := range []coin.SignedBlock{blocks[2], makeBlockChain(t, blocks[2], 1)[1]} {
+		err = db.Update(func(tx *bolt.Tx) error {
+			return bc.AddBackfillBlockWithTx(tx, &b)
+		})
+		require.Equal(t, ErrBackfillMismatch, err)
+	}
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return bc.AddBackfillBlockWithTx(tx, &blocks[3])
+	}))
+	cp.Lowest = 3
+	require.Equal(t, &cp, bc.Checkpoint())
+
+	// the checkpoint is loaded on restart
+	bc, err = NewBlockchain(db, DefaultWalker)
+	require.NoError(t, err)
+	require.Equal(t, &cp, bc.Checkpoint())
+
+	for _, i := range []int{2, 1} {
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+			return bc.AddBackfillBlockWithTx(tx, &blocks[i])
+		}))
+	}
+
+	// removed once back-filled
+	require.Nil(t, bc.Checkpoint())
+	for i := range blocks {
+		b, err := bc.GetBlockBySeq(uint64(i))
+		require.NoError(t, err)
+		require.Equal(t, blocks[i], *b)
+	}
+	require.Equal(t, uint64(4), bc.HeadSeq())
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		return bc.AddBackfillBlockWithTx(tx, &blocks[1])
+	})
+	require.Equal(t, ErrNoCheckpoint, err)
+}
+
+func makeSpendBlock(t *testing.T, parent coin.SignedBlock, uxHash cipher.SHA256, ux coin.UxOut, dt uint64) coin.SignedBlock {
+	txn := coin.Transaction{}
+	txn.PushInput(ux.Hash())
+	txn.PushOutput(genAddress, ux.Body.Coins, ux.Body.Hours/2)
+	txn.SignInputs([]cipher.SecKey{genSecret})
+	txn.UpdateHeader()
+
+	b, err := coin.NewBlock(parent.Block, parent.Time()+dt, uxHash, coin.Transactions{txn}, _feeCalc)
+	require.NoError(t, err)
+	return coin.SignedBlock{
+		Block: *b,
+		Sig:   cipher.SignHash(b.HashHeader(), genSecret),
+	}
+}
+
+func TestBlockchainRevertHeadWithTx(t *testing.T) {
+	db, closeDB := testutil.PrepareDB(t)
+	defer closeDB()
+
+	bc, err := NewBlockchain(db, DefaultWalker)
+	require.NoError(t, err)
+
+	addBlock := func(b coin.SignedBlock) {
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+			return bc.AddBlockWithTx(tx, &b)
+		}))
+	}
+	revertHead := func() error {
+		return db.Update(func(tx *bolt.Tx) error {
+			return bc.RevertHeadWithTx(tx)
+		})
+	}
+
+	gb := makeGenesisBlock(t)
+	addBlock(gb)
+	require.Equal(t, ErrRevertFinalized, revertHead())
+
+	b1 := makeSpendBlock(t, gb, bc.UnspentPool().GetUxHash(), coin.CreateUnspents(gb.Head, gb.Body.Transactions[0])[0], 10)
+	addBlock(b1)
+	uxHash := bc.UnspentPool().GetUxHash()
+	uxs, err := bc.UnspentPool().GetAll()
+	require.NoError(t, err)
+
+	ux := coin.CreateUnspents(b1.Head, b1.Body.Transactions[0])[0]
+	b2 := makeSpendBlock(t, b1, uxHash, ux, 10)
+	side := makeSpendBlock(t, b1, uxHash, ux, 20)
+	addBlock(b2)
+
+	// a side block does not change the chain
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return bc.AddSideBlockWithTx(tx, &side)
+	}))
+	require.Equal(t, uint64(2), bc.HeadSeq())
+	head, err := bc.Head()
+	require.NoError(t, err)
+	require.Equal(t, b2, *head)
+	b, err := bc.GetBlockByHash(side.HashHeader())
+	require.NoError(t, err)
+	require.Equal(t, side, *b)
+
+	// the outputs spent by the head are restored
+	require.NoError(t, revertHead())
+	require.Equal(t, uint64(1), bc.HeadSeq())
+	require.Equal(t, uxHash, bc.UnspentPool().GetUxHash())
+	got, err := bc.UnspentPool().GetAll()
+	require.NoError(t, err)
+	require.Equal(t, uxs, got)
+	b, err = bc.GetBlockBySeq(2)
+	require.NoError(t, err)
+	require.Nil(t, b)
+
+	// the side block becomes the head
+	addBlock(side)
+	b, err = bc.GetBlockBySeq(2)
+	require.NoError(t, err)
+	require.Equal(t, side, *b)
+	require.True(t, bc.UnspentPool().Contains(coin.CreateUnspents(side.Head, side.Body.Transactions[0])[0].Hash()))
+
+	// the reverted block is kept on a side branch
+	b, err = bc.GetBlockByHash(b2.HashHeader())
+	require.NoError(t, err)
+	require.Equal(t, b2, *b)
+
+	// the caches are reloaded after the db transaction is rolled back
+	require.Error(t, db.Update(func(tx *bolt.Tx) error {
+		require.NoError(t, bc.RevertHeadWithTx(tx))
+		return errors.New("rollback")
+	}))
+	require.Equal(t, uint64(1), bc.HeadSeq())
+	require.NoError(t, bc.Reload())
+	require.Equal(t, uint64(2), bc.HeadSeq())
+	head, err = bc.Head()
+	require.NoError(t, err)
+	require.Equal(t, side, *head)
+	require.Equal(t, uint64(1), bc.UnspentPool().Len())
+	require.True(t, bc.UnspentPool().Contains(coin.CreateUnspents(side.Head, side.Body.Transactions[0])[0].Hash()))
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return bc.AddCertificateWithTx(tx, 1, pbft.Certificate{Hash: b1.HashHeader()})
+	}))
+	require.NoError(t, revertHead())
+	require.Equal(t, ErrRevertFinalized, revertHead())
+	require.Equal(t, uint64(1), bc.HeadSeq())
+}
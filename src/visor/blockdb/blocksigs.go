@@ -5,6 +5,7 @@ import (
 
 	"github.com/samoslab/samos/src/cipher"
 	"github.com/samoslab/samos/src/cipher/encoder"
+	"github.com/samoslab/samos/src/consensus/pbft"
 	"github.com/samoslab/samos/src/visor/bucket"
 )
 
@@ -18,12 +19,15 @@ import (
 // blockSigs per BkSeq, or use hashes as keys.  For now, this is not a
 // problem assuming the signed blocks created from master are valid blocks,
 // because we can check the signature independently of the blockchain.
+// The commit certificates of final blocks are kept along with the producer signatures.
 type blockSigs struct {
-	Sigs *bucket.Bucket
+	Sigs  *bucket.Bucket
+	Certs *bucket.Bucket
 }
 
 var (
-	blockSigsBkt = []byte("block_sigs")
+	blockSigsBkt  = []byte("block_sigs")
+	blockCertsBkt = []byte("block_certificates")
 )
 
 // newBlockSigs create block signature buckets
@@ -33,8 +37,14 @@ func newBlockSigs(db *bolt.DB) (*blockSigs, error) {
 		return nil, err
 	}
 
+	certs, err := bucket.New(blockCertsBkt, db)
+	if err != nil {
+		return nil, err
+	}
+
 	return &blockSigs{
-		Sigs: sigs,
+		Sigs:  sigs,
+		Certs: certs,
 	}, nil
 }
 
@@ -55,3 +65,21 @@ func (bs blockSigs) Get(hash cipher.SHA256) (cipher.Sig, bool, error) {
 func (bs *blockSigs) AddWithTx(tx *bolt.Tx, hash cipher.SHA256, sig cipher.Sig) error {
 	return bs.Sigs.PutWithTx(tx, hash[:], encoder.Serialize(sig))
 }
+
+// GetCertificate returns the commit certificate of specific block
+func (bs blockSigs) GetCertificate(hash cipher.SHA256) (*pbft.Certificate, bool, error) {
+	bin := bs.Certs.Get(hash[:])
+	if bin == nil {
+		return nil, false, nil
+	}
+	var cert pbft.Certificate
+	if err := encoder.DeserializeRaw(bin, &cert); err != nil {
+		return nil, false, err
+	}
+	return &cert, true, nil
+}
+
+// AddCertificateWithTx add commit certificate of block with bolt.Tx
+func (bs *blockSigs) AddCertificateWithTx(tx *bolt.Tx, cert pbft.Certificate) error {
+	return bs.Certs.PutWithTx(tx, cert.Hash[:], encoder.Serialize(cert))
+}
@@ -0,0 +1,54 @@
+package blockdb
+
+import (
+	"github.com/boltdb/bolt"
+
+	"github.com/samoslab/samos/src/cipher/encoder"
+	"github.com/samoslab/samos/src/consensus/pbft"
+	"github.com/samoslab/samos/src/visor/bucket"
+)
+
+// Evidences stores the double signing evidence of trust nodes
+type Evidences struct {
+	evidence *bucket.Bucket
+}
+
+// NewEvidences create the evidence bucket if it does not exist
+func NewEvidences(db *bolt.DB) (*Evidences, error) {
+	evidence, err := bucket.New([]byte("consensus_evidence"), db)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Evidences{
+		evidence: evidence,
+	}, nil
+}
+
+// Add writes the evidence, returns false if it is known already
+func (es *Evidences) Add(e pbft.Evidence) (bool, error) {
+	hash := e.Hash()
+	if es.evidence.IsExist(hash[:]) {
+		return false, nil
+	}
+	if err := es.evidence.Put(hash[:], encoder.Serialize(e)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetAll returns all evidence
+func (es *Evidences) GetAll() ([]pbft.Evidence, error) {
+	evidences := []pbft.Evidence{}
+	if err := es.evidence.ForEach(func(k, v []byte) error {
+		var e pbft.Evidence
+		if err := encoder.DeserializeRaw(v, &e); err != nil {
+			return err
+		}
+		evidences = append(evidences, e)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return evidences, nil
+}
@@ -0,0 +1,41 @@
+package blockdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/consensus/pbft"
+	"github.com/samoslab/samos/src/testutil"
+)
+
+func TestEvidences(t *testing.T) {
+	db, close := testutil.PrepareDB(t)
+	defer close()
+
+	evidences, err := NewEvidences(db)
+	require.NoError(t, err)
+
+	es, err := evidences.GetAll()
+	require.NoError(t, err)
+	require.Empty(t, es)
+
+	pk, sk := cipher.GenerateKeyPair()
+	a := coin.BlockHeader{BkSeq: 2, Time: 100}
+	b := coin.BlockHeader{BkSeq: 2, Time: 105}
+	e := pbft.NewEvidence(pbft.EvidenceSlot, pk, 0, a, cipher.SignHash(a.Hash(), sk), b, cipher.SignHash(b.Hash(), sk))
+
+	added, err := evidences.Add(e)
+	require.NoError(t, err)
+	require.True(t, added)
+
+	added, err = evidences.Add(e)
+	require.NoError(t, err)
+	require.False(t, added)
+
+	es, err = evidences.GetAll()
+	require.NoError(t, err)
+	require.Equal(t, []pbft.Evidence{e}, es)
+}
@@ -0,0 +1,54 @@
+package blockdb
+
+import (
+	"github.com/boltdb/bolt"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/cipher/encoder"
+	"github.com/samoslab/samos/src/consensus/pbft"
+	"github.com/samoslab/samos/src/visor/bucket"
+)
+
+// PbftJournal stores the pbft pending block state, so that a restarted
+// trust node can continue the round it was in
+type PbftJournal struct {
+	pending *bucket.Bucket
+}
+
+// NewPbftJournal create the pbft journal bucket if it does not exist
+func NewPbftJournal(db *bolt.DB) (*PbftJournal, error) {
+	pending, err := bucket.New([]byte("pbft_pending"), db)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PbftJournal{
+		pending: pending,
+	}, nil
+}
+
+// Put writes the pending state of block hash
+func (pj *PbftJournal) Put(hash cipher.SHA256, state pbft.PendingState) error {
+	return pj.pending.Put(hash[:], encoder.Serialize(state))
+}
+
+// Delete removes the pending state of block hash
+func (pj *PbftJournal) Delete(hash cipher.SHA256) error {
+	return pj.pending.Delete(hash[:])
+}
+
+// GetAll returns all journaled pending states
+func (pj *PbftJournal) GetAll() ([]pbft.PendingState, error) {
+	var states []pbft.PendingState
+	if err := pj.pending.ForEach(func(k, v []byte) error {
+		var state pbft.PendingState
+		if err := encoder.DeserializeRaw(v, &state); err != nil {
+			return err
+		}
+		states = append(states, state)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
@@ -0,0 +1,49 @@
+package blockdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/consensus/pbft"
+	"github.com/samoslab/samos/src/testutil"
+)
+
+func TestPbftJournal(t *testing.T) {
+	db, close := testutil.PrepareDB(t)
+	defer close()
+
+	journal, err := NewPbftJournal(db)
+	require.NoError(t, err)
+
+	states, err := journal.GetAll()
+	require.NoError(t, err)
+	require.Empty(t, states)
+
+	pk, sk := cipher.GenerateKeyPair()
+	b := coin.Block{Head: coin.BlockHeader{BkSeq: 3, Time: 100}}
+	st := pbft.PendingState{
+		Block:     coin.SignedBlock{Block: b, Sig: cipher.SignHash(b.HashHeader(), sk)},
+		Prepared:  []cipher.PubKey{pk},
+		CreatedAt: 100,
+	}
+	hash := b.HashHeader()
+	require.NoError(t, journal.Put(hash, st))
+
+	st.Prepared = append(st.Prepared, cipher.MustPubKeyFromHex("02d15bf28c4ed2c39b35b2be2f8bcde1318e2b3b65fe2a676db39b520bee9bfe86"))
+	require.NoError(t, journal.Put(hash, st))
+
+	// reopen, as a restarted node would
+	journal, err = NewPbftJournal(db)
+	require.NoError(t, err)
+	states, err = journal.GetAll()
+	require.NoError(t, err)
+	require.Equal(t, []pbft.PendingState{st}, states)
+
+	require.NoError(t, journal.Delete(hash))
+	states, err = journal.GetAll()
+	require.NoError(t, err)
+	require.Empty(t, states)
+}
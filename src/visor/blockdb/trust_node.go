@@ -1,6 +1,7 @@
 package blockdb
 
 import (
+	"bytes"
 	"fmt"
 	"sort"
 	"strconv"
@@ -9,13 +10,20 @@ import (
 	"github.com/boltdb/bolt"
 
 	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/cipher/encoder"
+	"github.com/samoslab/samos/src/consensus/dpos"
 	"github.com/samoslab/samos/src/visor/bucket"
 )
 
 // TrustNode use the trustnode store all trust node info
 type TrustNode struct {
-	db   *bolt.DB
-	node *bucket.Bucket
+	db        *bolt.DB
+	node      *bucket.Bucket
+	changes   *bucket.Bucket
+	votes     *bucket.Bucket
+	elections *bucket.Bucket
+	stats     *bucket.Bucket
+	excluded  *bucket.Bucket
 }
 
 // NewBlockTree create buckets in blockdb if does not exist.
@@ -25,9 +33,39 @@ func NewTrustNode(db *bolt.DB) (*TrustNode, error) {
 		return nil, err
 	}
 
+	changes, err := bucket.New([]byte("validator_changes"), db)
+	if err != nil {
+		return nil, err
+	}
+
+	votes, err := bucket.New([]byte("producer_votes"), db)
+	if err != nil {
+		return nil, err
+	}
+
+	elections, err := bucket.New([]byte("producer_elections"), db)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := bucket.New([]byte("validator_stats"), db)
+	if err != nil {
+		return nil, err
+	}
+
+	excluded, err := bucket.New([]byte("validator_exclusions"), db)
+	if err != nil {
+		return nil, err
+	}
+
 	return &TrustNode{
-		node: node,
-		db:   db,
+		node:      node,
+		changes:   changes,
+		votes:     votes,
+		elections: elections,
+		stats:     stats,
+		excluded:  excluded,
+		db:        db,
 	}, nil
 }
 
@@ -142,3 +180,199 @@ func (tn *TrustNode) GetAgreeNodeNum() int {
 	}
 	return num
 }
+
+// AddValidatorChangeWithTx records an executed validator change with *bolt.Tx
+func (tn *TrustNode) AddValidatorChangeWithTx(tx *bolt.Tx, change dpos.ValidatorChange) error {
+	return tn.changes.PutWithTx(tx, change.TxID[:], encoder.Serialize(change))
+}
+
+// DeleteValidatorChangeWithTx removes the validator change of a reverted transaction with *bolt.Tx
+func (tn *TrustNode) DeleteValidatorChangeWithTx(tx *bolt.Tx, txid cipher.SHA256) error {
+	return tn.changes.DeleteWithTx(tx, txid[:])
+}
+
+// GetValidatorChanges returns all recorded validator changes ordered by activation time
+func (tn *TrustNode) GetValidatorChanges() ([]dpos.ValidatorChange, error) {
+	var changes []dpos.ValidatorChange
+	if err := tn.changes.ForEach(func(k, v []byte) error {
+		var change dpos.ValidatorChange
+		if err := encoder.DeserializeRaw(v, &change); err != nil {
+			return err
+		}
+		changes = append(changes, change)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].ActivateAt < changes[j].ActivateAt
+	})
+	return changes, nil
+}
+
+// AddVoteWithTx records an executed vote with *bolt.Tx
+func (tn *TrustNode) AddVoteWithTx(tx *bolt.Tx, vote dpos.Vote) error {
+	return tn.votes.PutWithTx(tx, vote.TxID[:], encoder.Serialize(vote))
+}
+
+// DeleteVoteWithTx removes the vote of a reverted transaction with *bolt.Tx
+func (tn *TrustNode) DeleteVoteWithTx(tx *bolt.Tx, txid cipher.SHA256) error {
+	return tn.votes.DeleteWithTx(tx, txid[:])
+}
+
+// GetVotes returns all recorded votes ordered by transaction id
+func (tn *TrustNode) GetVotes() ([]dpos.Vote, error) {
+	var votes []dpos.Vote
+	if err := tn.db.View(func(tx *bolt.Tx) error {
+		var err error
+		votes, err = tn.GetVotesWithTx(tx)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return votes, nil
+}
+
+// GetVotesWithTx returns all recorded votes ordered by transaction id with *bolt.Tx
+func (tn *TrustNode) GetVotesWithTx(tx *bolt.Tx) ([]dpos.Vote, error) {
+	var votes []dpos.Vote
+	if err := tn.votes.ForEachWithTx(tx, func(k, v []byte) error {
+		var vote dpos.Vote
+		if err := encoder.DeserializeRaw(v, &vote); err != nil {
+			return err
+		}
+		votes = append(votes, vote)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return votes, nil
+}
+
+// AddElectionWithTx records the producers elected for an epoch with *bolt.Tx
+func (tn *TrustNode) AddElectionWithTx(tx *bolt.Tx, election dpos.Election) error {
+	return tn.elections.PutWithTx(tx, bucket.Itob(uint64(election.Epoch)), encoder.Serialize(election))
+}
+
+// DeleteElectionWithTx removes the election of the epoch, when the block holding it is reverted, with *bolt.Tx
+func (tn *TrustNode) DeleteElectionWithTx(tx *bolt.Tx, epoch int64) error {
+	return tn.elections.DeleteWithTx(tx, bucket.Itob(uint64(epoch)))
+}
+
+// GetElections returns all recorded elections ordered by epoch
+func (tn *TrustNode) GetElections() ([]dpos.Election, error) {
+	var elections []dpos.Election
+	if err := tn.elections.ForEach(func(k, v []byte) error {
+		var election dpos.Election
+		if err := encoder.DeserializeRaw(v, &election); err != nil {
+			return err
+		}
+		elections = append(elections, election)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return elections, nil
+}
+
+// AddExclusionWithTx records the exclusion of a validator for executed evidence with *bolt.Tx
+func (tn *TrustNode) AddExclusionWithTx(tx *bolt.Tx, exclusion dpos.Exclusion) error {
+	return tn.excluded.PutWithTx(tx, exclusion.Evidence[:], encoder.Serialize(exclusion))
+}
+
+// DeleteExclusionWithTx removes the exclusion for the evidence of a reverted transaction with *bolt.Tx
+func (tn *TrustNode) DeleteExclusionWithTx(tx *bolt.Tx, evidence cipher.SHA256) error {
+	return tn.excluded.DeleteWithTx(tx, evidence[:])
+}
+
+// HasExclusion returns true if the evidence of the hash is executed already
+func (tn *TrustNode) HasExclusion(evidence cipher.SHA256) bool {
+	return tn.excluded.IsExist(evidence[:])
+}
+
+// GetExclusions returns all recorded exclusions ordered by evidence hash
+func (tn *TrustNode) GetExclusions() ([]dpos.Exclusion, error) {
+	var exclusions []dpos.Exclusion
+	if err := tn.excluded.ForEach(func(k, v []byte) error {
+		var exclusion dpos.Exclusion
+		if err := encoder.DeserializeRaw(v, &exclusion); err != nil {
+			return err
+		}
+		exclusions = append(exclusions, exclusion)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return exclusions, nil
+}
+
+func slotStatsKey(epoch int64, validator cipher.PubKey) []byte {
+	return append(bucket.Itob(uint64(epoch)), validator[:]...)
+}
+
+// AddSlotStatsWithTx adds the slots settled by a block to the recorded stats with *bolt.Tx
+func (tn *TrustNode) AddSlotStatsWithTx(tx *bolt.Tx, stats []dpos.SlotStats) error {
+	for _, s := range stats {
+		key := slotStatsKey(s.Epoch, s.Validator)
+		if v := tn.stats.GetWithTx(tx, key); v != nil {
+			var recorded dpos.SlotStats
+			if err := encoder.DeserializeRaw(v, &recorded); err != nil {
+				return err
+			}
+			s.Add(recorded)
+		}
+		if err := tn.stats.PutWithTx(tx, key, encoder.Serialize(s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SubSlotStatsWithTx subtracts the slots settled by a reverted block from the recorded stats with *bolt.Tx
+func (tn *TrustNode) SubSlotStatsWithTx(tx *bolt.Tx, stats []dpos.SlotStats) error {
+	for _, s := range stats {
+		key := slotStatsKey(s.Epoch, s.Validator)
+		v := tn.stats.GetWithTx(tx, key)
+		if v == nil {
+			continue
+		}
+
+		var recorded dpos.SlotStats
+		if err := encoder.DeserializeRaw(v, &recorded); err != nil {
+			return err
+		}
+		recorded.Sub(s)
+
+		if recorded.Produced == 0 && recorded.Missed == 0 {
+			if err := tn.stats.DeleteWithTx(tx, key); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := tn.stats.PutWithTx(tx, key, encoder.Serialize(recorded)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetSlotStats returns the slot stats of the validators in the epoch ordered by validator
+func (tn *TrustNode) GetSlotStats(epoch int64) ([]dpos.SlotStats, error) {
+	prefix := bucket.Itob(uint64(epoch))
+	stats := []dpos.SlotStats{}
+	if err := tn.stats.ForEach(func(k, v []byte) error {
+		if !bytes.HasPrefix(k, prefix) {
+			return nil
+		}
+		var s dpos.SlotStats
+		if err := encoder.DeserializeRaw(v, &s); err != nil {
+			return err
+		}
+		stats = append(stats, s)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
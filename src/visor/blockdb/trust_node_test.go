@@ -4,7 +4,11 @@ import (
 	"sort"
 	"testing"
 
+	"github.com/boltdb/bolt"
+
 	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/consensus/dpos"
 	"github.com/samoslab/samos/src/testutil"
 	"github.com/stretchr/testify/assert"
 )
@@ -49,3 +53,208 @@ func TestAddPubkey(t *testing.T) {
 	trustPks := trustNode.GetPubkeys()
 	assert.Equal(t, pks, trustPks)
 }
+
+func TestValidatorChanges(t *testing.T) {
+	db, close := testutil.PrepareDB(t)
+	defer close()
+	trustNode, err := NewTrustNode(db)
+	assert.Nil(t, err)
+
+	changes, err := trustNode.GetValidatorChanges()
+	assert.Nil(t, err)
+	assert.Empty(t, changes)
+
+	pk := cipher.MustPubKeyFromHex("02d15bf28c4ed2c39b35b2be2f8bcde1318e2b3b65fe2a676db39b520bee9bfe86")
+	remove := dpos.ValidatorChange{
+		TxID:       cipher.SumSHA256([]byte("remove")),
+		Type:       coin.TxnTypeRemoveValidator,
+		PubKey:     pk,
+		ActivateAt: 86400 * 2,
+	}
+	add := dpos.ValidatorChange{
+		TxID:       cipher.SumSHA256([]byte("add")),
+		Type:       coin.TxnTypeAddValidator,
+		PubKey:     pk,
+		ActivateAt: 86400,
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if err := trustNode.AddValidatorChangeWithTx(tx, remove); err != nil {
+			return err
+		}
+		return trustNode.AddValidatorChangeWithTx(tx, add)
+	})
+	assert.Nil(t, err)
+
+	changes, err = trustNode.GetValidatorChanges()
+	assert.Nil(t, err)
+	assert.Equal(t, []dpos.ValidatorChange{add, remove}, changes)
+
+	// the change of a reverted block is removed
+	err = db.Update(func(tx *bolt.Tx) error {
+		return trustNode.DeleteValidatorChangeWithTx(tx, add.TxID)
+	})
+	assert.Nil(t, err)
+
+	changes, err = trustNode.GetValidatorChanges()
+	assert.Nil(t, err)
+	assert.Equal(t, []dpos.ValidatorChange{remove}, changes)
+}
+
+func TestVotesAndElections(t *testing.T) {
+	db, close := testutil.PrepareDB(t)
+	defer close()
+	trustNode, err := NewTrustNode(db)
+	assert.Nil(t, err)
+
+	votes, err := trustNode.GetVotes()
+	assert.Nil(t, err)
+	assert.Empty(t, votes)
+
+	pk := cipher.MustPubKeyFromHex("02d15bf28c4ed2c39b35b2be2f8bcde1318e2b3b65fe2a676db39b520bee9bfe86")
+	vote := dpos.Vote{
+		TxID:      cipher.SumSHA256([]byte("vote")),
+		Candidate: pk,
+		Voter:     cipher.MustDecodeBase58Address("EX8omhDyjKtc8zHGp1KZwn7usCndaoJxSe"),
+		Outputs:   []cipher.SHA256{cipher.SumSHA256([]byte("ux"))},
+	}
+	second := dpos.Election{Epoch: 86400 * 2, Producers: []cipher.PubKey{pk}}
+	first := dpos.Election{Epoch: 86400, Producers: []cipher.PubKey{pk}}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if err := trustNode.AddVoteWithTx(tx, vote); err != nil {
+			return err
+		}
+		if err := trustNode.AddElectionWithTx(tx, second); err != nil {
+			return err
+		}
+		return trustNode.AddElectionWithTx(tx, first)
+	})
+	assert.Nil(t, err)
+
+	votes, err = trustNode.GetVotes()
+	assert.Nil(t, err)
+	assert.Equal(t, []dpos.Vote{vote}, votes)
+
+	elections, err := trustNode.GetElections()
+	assert.Nil(t, err)
+	assert.Equal(t, []dpos.Election{first, second}, elections)
+
+	// the vote and the election of reverted blocks are removed
+	err = db.Update(func(tx *bolt.Tx) error {
+		if err := trustNode.DeleteVoteWithTx(tx, vote.TxID); err != nil {
+			return err
+		}
+		return trustNode.DeleteElectionWithTx(tx, second.Epoch)
+	})
+	assert.Nil(t, err)
+
+	votes, err = trustNode.GetVotes()
+	assert.Nil(t, err)
+	assert.Empty(t, votes)
+
+	elections, err = trustNode.GetElections()
+	assert.Nil(t, err)
+	assert.Equal(t, []dpos.Election{first}, elections)
+}
+
+func TestExclusions(t *testing.T) {
+	db, close := testutil.PrepareDB(t)
+	defer close()
+	trustNode, err := NewTrustNode(db)
+	assert.Nil(t, err)
+
+	exclusions, err := trustNode.GetExclusions()
+	assert.Nil(t, err)
+	assert.Empty(t, exclusions)
+
+	exclusion := dpos.Exclusion{
+		Evidence: cipher.SumSHA256([]byte("evidence")),
+		PubKey:   cipher.MustPubKeyFromHex("02d15bf28c4ed2c39b35b2be2f8bcde1318e2b3b65fe2a676db39b520bee9bfe86"),
+		From:     86400,
+		To:       86400 * 2,
+	}
+	assert.False(t, trustNode.HasExclusion(exclusion.Evidence))
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		return trustNode.AddExclusionWithTx(tx, exclusion)
+	})
+	assert.Nil(t, err)
+
+	assert.True(t, trustNode.HasExclusion(exclusion.Evidence))
+	exclusions, err = trustNode.GetExclusions()
+	assert.Nil(t, err)
+	assert.Equal(t, []dpos.Exclusion{exclusion}, exclusions)
+
+	// the exclusion of a reverted block is removed
+	err = db.Update(func(tx *bolt.Tx) error {
+		return trustNode.DeleteExclusionWithTx(tx, exclusion.Evidence)
+	})
+	assert.Nil(t, err)
+
+	assert.False(t, trustNode.HasExclusion(exclusion.Evidence))
+	exclusions, err = trustNode.GetExclusions()
+	assert.Nil(t, err)
+	assert.Empty(t, exclusions)
+}
+
+func TestSlotStats(t *testing.T) {
+	db, close := testutil.PrepareDB(t)
+	defer close()
+	trustNode, err := NewTrustNode(db)
+	assert.Nil(t, err)
+
+	stats, err := trustNode.GetSlotStats(86400)
+	assert.Nil(t, err)
+	assert.Empty(t, stats)
+
+	pk := cipher.MustPubKeyFromHex("02d15bf28c4ed2c39b35b2be2f8bcde1318e2b3b65fe2a676db39b520bee9bfe86")
+	pk2 := cipher.MustPubKeyFromHex("03e5a3e2a96b0f04e1dd8c2e0fc3aa8be5b34d7f0a3e3f3c1e2d1d8c0c7f3b5a11")
+
+	for _, s := range [][]dpos.SlotStats{
+		{
+			{Epoch: 86400, Validator: pk, Missed: 2},
+			{Epoch: 86400, Validator: pk2, Produced: 1},
+		},
+		{
+			{Epoch: 86400, Validator: pk, Produced: 1},
+			{Epoch: 86400 * 2, Validator: pk, Missed: 1},
+		},
+	} {
+		err = db.Update(func(tx *bolt.Tx) error {
+			return trustNode.AddSlotStatsWithTx(tx, s)
+		})
+		assert.Nil(t, err)
+	}
+
+	stats, err = trustNode.GetSlotStats(86400)
+	assert.Nil(t, err)
+	assert.Equal(t, []dpos.SlotStats{
+		{Epoch: 86400, Validator: pk, Produced: 1, Missed: 2},
+		{Epoch: 86400, Validator: pk2, Produced: 1},
+	}, stats)
+
+	stats, err = trustNode.GetSlotStats(86400 * 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []dpos.SlotStats{{Epoch: 86400 * 2, Validator: pk, Missed: 1}}, stats)
+
+	// the slots of a reverted block are subtracted
+	err = db.Update(func(tx *bolt.Tx) error {
+		return trustNode.SubSlotStatsWithTx(tx, []dpos.SlotStats{
+			{Epoch: 86400, Validator: pk, Produced: 1},
+			{Epoch: 86400 * 2, Validator: pk, Missed: 1},
+		})
+	})
+	assert.Nil(t, err)
+
+	stats, err = trustNode.GetSlotStats(86400)
+	assert.Nil(t, err)
+	assert.Equal(t, []dpos.SlotStats{
+		{Epoch: 86400, Validator: pk, Missed: 2},
+		{Epoch: 86400, Validator: pk2, Produced: 1},
+	}, stats)
+
+	stats, err = trustNode.GetSlotStats(86400 * 2)
+	assert.Nil(t, err)
+	assert.Empty(t, stats)
+}
@@ -1,6 +1,8 @@
 package blockdb
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"sync"
 
@@ -19,6 +21,17 @@ var (
 	unspentPoolBkt = []byte("unspent_pool")
 	// bucket for unspent meta info
 	unspentMetaBkt = []byte("unspent_meta")
+	// bucket for the outputs spent by the executed blocks, to revert them
+	unspentUndoBkt = []byte("unspent_undo")
+	// bucket for the unspent outputs of each address
+	unspentAddrBkt = []byte("unspent_addr")
+	// bucket for the coins of each address
+	unspentAddrCoinsBkt = []byte("unspent_addr_coins")
+	// bucket for the addresses ordered by their coins
+	unspentAddrRankBkt = []byte("unspent_addr_rank")
+
+	// ErrNoUndo is returned when reverting a block whose spent outputs are not recorded
+	ErrNoUndo = errors.New("spent outputs of the block are not recorded")
 )
 
 // ErrUnspentNotExist is returned if an unspent is not found in the pool
@@ -45,13 +58,32 @@ type UnspentGetter interface {
 	Get(cipher.SHA256) (coin.UxOut, bool)
 }
 
+// addressOutputs is the coins and the unspent outputs of an address
+type addressOutputs struct {
+	coins uint64
+	uxids map[cipher.SHA256]struct{}
+}
+
+// addressOf returns the cached outputs of the address, adding them if missing
+func addressOf(addrs map[cipher.Address]*addressOutputs, addr cipher.Address) *addressOutputs {
+	ao, ok := addrs[addr]
+	if !ok {
+		ao = &addressOutputs{uxids: make(map[cipher.SHA256]struct{})}
+		addrs[addr] = ao
+	}
+	return ao
+}
+
 // Unspents unspent outputs pool
 type Unspents struct {
 	db    *bolt.DB
 	pool  *pool
 	meta  *unspentMeta
+	undo  *undo
+	addrs *addrIndex
 	cache struct {
 		pool   map[string]coin.UxOut
+		addrs  map[cipher.Address]*addressOutputs
 		uxhash cipher.SHA256
 	}
 	sync.Mutex
@@ -121,10 +153,264 @@ func (pl *pool) deleteWithTx(tx *bolt.Tx, hash cipher.SHA256) error {
 	return pl.DeleteWithTx(tx, hash[:])
 }
 
+// undo bucket stores the outputs spent by each block, keyed by block seq and
+// hash, so the blocks above the finalized block can be reverted
+type undo struct {
+	bucket.Bucket
+}
+
+func newUndo(db *bolt.DB) (*undo, error) {
+	bkt, err := bucket.New(unspentUndoBkt, db)
+	if err != nil {
+		return nil, err
+	}
+
+	return &undo{
+		Bucket: *bkt,
+	}, nil
+}
+
+func undoKey(b *coin.SignedBlock) []byte {
+	hash := b.HashHeader()
+	return append(bucket.Itob(b.Seq()), hash[:]...)
+}
+
+func (ud undo) getWithTx(tx *bolt.Tx, b *coin.SignedBlock) (coin.UxArray, bool, error) {
+	v := ud.GetWithTx(tx, undoKey(b))
+	if v == nil {
+		return nil, false, nil
+	}
+
+	var uxs coin.UxArray
+	if err := encoder.DeserializeRaw(v, &uxs); err != nil {
+		return nil, false, err
+	}
+	return uxs, true, nil
+}
+
+func (ud undo) setWithTx(tx *bolt.Tx, b *coin.SignedBlock, uxs coin.UxArray) error {
+	return ud.PutWithTx(tx, undoKey(b), encoder.Serialize(uxs))
+}
+
+func (ud undo) deleteWithTx(tx *bolt.Tx, b *coin.SignedBlock) error {
+	return ud.DeleteWithTx(tx, undoKey(b))
+}
+
+// pruneWithTx deletes the spent outputs of the blocks up to seq
+func (ud undo) pruneWithTx(tx *bolt.Tx, seq uint64) error {
+	bkt := tx.Bucket(ud.Name)
+	if bkt == nil {
+		return fmt.Errorf("bucket %s doesn't exist", ud.Name)
+	}
+
+	var keys [][]byte
+	c := bkt.Cursor()
+	for k, _ := c.First(); k != nil && bucket.Btoi(k[:8]) <= seq; k, _ = c.Next() {
+		keys = append(keys, k)
+	}
+
+	for _, k := range keys {
+		if err := bkt.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addrIndex stores the unspent outputs of each address keyed by the address
+// and the output hash, the coins of each address keyed by the address, and
+// the addresses keyed by their coins and the address to read them by balance
+type addrIndex struct {
+	uxs   *bucket.Bucket
+	coins *bucket.Bucket
+	rank  *bucket.Bucket
+}
+
+// addrKeyLen is the length of the address in the index keys, without the checksum
+const addrKeyLen = 21
+
+func addrKey(addr cipher.Address) []byte {
+	return append(addr.Key[:], addr.Version)
+}
+
+func addrUxKey(addr cipher.Address, h cipher.SHA256) []byte {
+	return append(addrKey(addr), h[:]...)
+}
+
+// rankKey orders the addresses by coins, the big endian coins lead the key
+func rankKey(addr cipher.Address, coins uint64) []byte {
+	return append(bucket.Itob(coins), addrKey(addr)...)
+}
+
+func keyAddr(k []byte) cipher.Address {
+	var addr cipher.Address
+	copy(addr.Key[:], k[:20])
+	addr.Version = k[20]
+	return addr
+}
+
+func newAddrIndex(db *bolt.DB) (*addrIndex, error) {
+	uxs, err := bucket.New(unspentAddrBkt, db)
+	if err != nil {
+		return nil, err
+	}
+
+	coins, err := bucket.New(unspentAddrCoinsBkt, db)
+	if err != nil {
+		return nil, err
+	}
+
+	rank, err := bucket.New(unspentAddrRankBkt, db)
+	if err != nil {
+		return nil, err
+	}
+
+	return &addrIndex{
+		uxs:   uxs,
+		coins: coins,
+		rank:  rank,
+	}, nil
+}
+
+func (ai addrIndex) getCoinsWithTx(tx *bolt.Tx, addr cipher.Address) uint64 {
+	if v := ai.coins.GetWithTx(tx, addrKey(addr)); v != nil {
+		return bucket.Btoi(v)
+	}
+	return 0
+}
+
+// hasOutputsWithTx checks if the address owns an indexed output
+func (ai addrIndex) hasOutputsWithTx(tx *bolt.Tx, addr cipher.Address) (bool, error) {
+	bkt := tx.Bucket(ai.uxs.Name)
+	if bkt == nil {
+		return false, fmt.Errorf("bucket %s doesn't exist", ai.uxs.Name)
+	}
+
+	prefix := addrKey(addr)
+	k, _ := bkt.Cursor().Seek(prefix)
+	return k != nil && bytes.HasPrefix(k, prefix), nil
+}
+
+// setCoinsWithTx updates the coins of the address and its place in the balance order,
+// the address is removed if it owns no output
+func (ai addrIndex) setCoinsWithTx(tx *bolt.Tx, addr cipher.Address, coins uint64, owns bool) error {
+	if v := ai.coins.GetWithTx(tx, addrKey(addr)); v != nil {
+		if err := ai.rank.DeleteWithTx(tx, rankKey(addr, bucket.Btoi(v))); err != nil {
+			return err
+		}
+	}
+
+	if !owns {
+		return ai.coins.DeleteWithTx(tx, addrKey(addr))
+	}
+
+	if err := ai.rank.PutWithTx(tx, rankKey(addr, coins), []byte{}); err != nil {
+		return err
+	}
+	return ai.coins.PutWithTx(tx, addrKey(addr), bucket.Itob(coins))
+}
+
+func (ai addrIndex) addWithTx(tx *bolt.Tx, ux coin.UxOut) error {
+	addr := ux.Body.Address
+	coins, err := coin.AddUint64(ai.getCoinsWithTx(tx, addr), ux.Body.Coins)
+	if err != nil {
+		return err
+	}
+
+	if err := ai.uxs.PutWithTx(tx, addrUxKey(addr, ux.Hash()), bucket.Itob(ux.Body.Coins)); err != nil {
+		return err
+	}
+	return ai.setCoinsWithTx(tx, addr, coins, true)
+}
+
+func (ai addrIndex) removeWithTx(tx *bolt.Tx, ux coin.UxOut) error {
+	addr := ux.Body.Address
+	key := addrUxKey(addr, ux.Hash())
+	if ai.uxs.GetWithTx(tx, key) == nil {
+		return nil
+	}
+
+	if err := ai.uxs.DeleteWithTx(tx, key); err != nil {
+		return err
+	}
+
+	ok, err := ai.hasOutputsWithTx(tx, addr)
+	if err != nil {
+		return err
+	}
+
+	return ai.setCoinsWithTx(tx, addr, ai.getCoinsWithTx(tx, addr)-ux.Body.Coins, ok)
+}
+
+// topWithTx returns the coins of the n richest addresses not in skip. The addresses having
+// as many coins as the last one are added, so the caller can order the ties. All the
+// addresses are returned if n <= 0
+func (ai addrIndex) topWithTx(tx *bolt.Tx, n int, skip map[cipher.Address]struct{}) (map[cipher.Address]uint64, error) {
+	bkt := tx.Bucket(ai.rank.Name)
+	if bkt == nil {
+		return nil, fmt.Errorf("bucket %s doesn't exist", ai.rank.Name)
+	}
+
+	top := make(map[cipher.Address]uint64)
+	var last uint64
+	c := bkt.Cursor()
+	for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+		coins := bucket.Btoi(k[:8])
+		if n > 0 && len(top) >= n && coins < last {
+			break
+		}
+
+		addr := keyAddr(k[8:])
+		if _, ok := skip[addr]; ok {
+			continue
+		}
+		top[addr] = coins
+		last = coins
+	}
+	return top, nil
+}
+
+// resetWithTx replaces the index with the outputs of uxs
+func (ai addrIndex) resetWithTx(tx *bolt.Tx, uxs coin.UxArray) (map[cipher.Address]*addressOutputs, error) {
+	for _, b := range []*bucket.Bucket{ai.uxs, ai.coins, ai.rank} {
+		bkt := tx.Bucket(b.Name)
+		if bkt == nil {
+			return nil, fmt.Errorf("bucket %s doesn't exist", b.Name)
+		}
+
+		var keys [][]byte
+		if err := bkt.ForEach(func(k, v []byte) error {
+			keys = append(keys, k)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+
+		for _, k := range keys {
+			if err := bkt.Delete(k); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	addrs := make(map[cipher.Address]*addressOutputs)
+	for _, ux := range uxs {
+		if err := ai.addWithTx(tx, ux); err != nil {
+			return nil, err
+		}
+
+		ao := addressOf(addrs, ux.Body.Address)
+		ao.coins += ux.Body.Coins
+		ao.uxids[ux.Hash()] = struct{}{}
+	}
+	return addrs, nil
+}
+
 // NewUnspentPool creates new unspent pool instance
 func NewUnspentPool(db *bolt.DB) (*Unspents, error) {
 	up := &Unspents{db: db}
 	up.cache.pool = make(map[string]coin.UxOut)
+	up.cache.addrs = make(map[cipher.Address]*addressOutputs)
 
 	pool, err := newPool(db)
 	if err != nil {
@@ -138,6 +424,25 @@ func NewUnspentPool(db *bolt.DB) (*Unspents, error) {
 	}
 	up.meta = meta
 
+	undo, err := newUndo(db)
+	if err != nil {
+		return nil, err
+	}
+	up.undo = undo
+
+	addrs, err := newAddrIndex(db)
+	if err != nil {
+		return nil, err
+	}
+	up.addrs = addrs
+
+	// the pool is created before the address index or its balance order, build them from the pool
+	if (addrs.uxs.IsEmpty() || addrs.rank.IsEmpty()) && !pool.IsEmpty() {
+		if err := up.buildAddrIndex(); err != nil {
+			return nil, fmt.Errorf("build address index of unspent outputs failed: %v", err)
+		}
+	}
+
 	// load from db
 	if err := up.syncCache(); err != nil {
 		return nil, err
@@ -163,6 +468,23 @@ func (up *Unspents) syncCache() error {
 		return err
 	}
 
+	// load the coins and the unspent outputs of each address
+	if err := up.addrs.coins.ForEach(func(k, v []byte) error {
+		addressOf(up.cache.addrs, keyAddr(k)).coins = bucket.Btoi(v)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := up.addrs.uxs.ForEach(func(k, v []byte) error {
+		var h cipher.SHA256
+		copy(h[:], k[addrKeyLen:])
+		addressOf(up.cache.addrs, keyAddr(k)).uxids[h] = struct{}{}
+		return nil
+	}); err != nil {
+		return err
+	}
+
 	// load uxhash
 	uxhash, err := up.getUxHashFromDB()
 	if err != nil {
@@ -173,6 +495,36 @@ func (up *Unspents) syncCache() error {
 	return nil
 }
 
+// Reload reloads the unspent outputs from the db
+func (up *Unspents) Reload() error {
+	up.Lock()
+	defer up.Unlock()
+	up.cache.pool = make(map[string]coin.UxOut)
+	up.cache.addrs = make(map[cipher.Address]*addressOutputs)
+	return up.syncCache()
+}
+
+// buildAddrIndex indexes the outputs of the pool by address
+func (up *Unspents) buildAddrIndex() error {
+	var uxs coin.UxArray
+	if err := up.pool.ForEach(func(k, v []byte) error {
+		var ux coin.UxOut
+		if err := encoder.DeserializeRaw(v, &ux); err != nil {
+			return err
+		}
+
+		uxs = append(uxs, ux)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	return up.db.Update(func(tx *bolt.Tx) error {
+		_, err := up.addrs.resetWithTx(tx, uxs)
+		return err
+	})
+}
+
 // ProcessBlock updates the unspent pool based upon the published block
 func (up *Unspents) ProcessBlock(b *coin.SignedBlock) bucket.TxHandler {
 	return func(tx *bolt.Tx) (bucket.Rollback, error) {
@@ -208,6 +560,11 @@ func (up *Unspents) ProcessBlock(b *coin.SignedBlock) bucket.TxHandler {
 			}
 		}
 
+		// record the spent outputs to revert the block
+		if err := up.undo.setWithTx(tx, b, delUxs); err != nil {
+			return func() {}, err
+		}
+
 		// update caches
 		up.Lock()
 		up.deleteUxFromCache(delUxs)
@@ -226,6 +583,147 @@ func (up *Unspents) ProcessBlock(b *coin.SignedBlock) bucket.TxHandler {
 	}
 }
 
+// RevertBlock reverts the head block, removing the outputs it created and
+// restoring the outputs it spent
+func (up *Unspents) RevertBlock(b *coin.SignedBlock) bucket.TxHandler {
+	return func(tx *bolt.Tx) (bucket.Rollback, error) {
+		spentUxs, ok, err := up.undo.getWithTx(tx, b)
+		if err != nil {
+			return func() {}, err
+		}
+		if !ok {
+			return func() {}, ErrNoUndo
+		}
+
+		var createdUxs coin.UxArray
+		for _, txn := range b.Body.Transactions {
+			createdUxs = append(createdUxs, coin.CreateUnspents(b.Head, txn)...)
+		}
+
+		// the outputs must not be spent by a later block
+		hashes := createdUxs.Hashes()
+		if _, err := up.GetArray(hashes); err != nil {
+			return func() {}, err
+		}
+
+		if _, err := up.deleteWithTx(tx, hashes); err != nil {
+			return func() {}, err
+		}
+
+		for i := range spentUxs {
+			if _, err := up.addWithTx(tx, spentUxs[i]); err != nil {
+				return func() {}, err
+			}
+		}
+
+		if err := up.undo.deleteWithTx(tx, b); err != nil {
+			return func() {}, err
+		}
+
+		uxHash, err := up.meta.getXorHashWithTx(tx)
+		if err != nil {
+			return func() {}, err
+		}
+
+		up.Lock()
+		oldUxHash := up.cache.uxhash
+		up.deleteUxFromCache(createdUxs)
+		up.addUxToCache(spentUxs)
+		up.updateUxHashInCache(uxHash)
+		up.Unlock()
+
+		return func() {
+			up.Lock()
+			up.deleteUxFromCache(spentUxs)
+			up.addUxToCache(createdUxs)
+			up.updateUxHashInCache(oldUxHash)
+			up.Unlock()
+		}, nil
+	}
+}
+
+// PruneUndo deletes the spent outputs recorded for the blocks up to seq,
+// they are final and never reverted
+func (up *Unspents) PruneUndo(seq uint64) bucket.TxHandler {
+	return func(tx *bolt.Tx) (bucket.Rollback, error) {
+		return func() {}, up.undo.pruneWithTx(tx, seq)
+	}
+}
+
+// Load replaces the unspent outputs with uxs, when the chain starts from a snapshot
+func (up *Unspents) Load(uxs coin.UxArray) bucket.TxHandler {
+	return func(tx *bolt.Tx) (bucket.Rollback, error) {
+		bkt := tx.Bucket(up.pool.Name)
+		if bkt == nil {
+			return func() {}, fmt.Errorf("bucket %s doesn't exist", up.pool.Name)
+		}
+
+		var keys [][]byte
+		if err := bkt.ForEach(func(k, v []byte) error {
+			keys = append(keys, k)
+			return nil
+		}); err != nil {
+			return func() {}, err
+		}
+
+		for _, k := range keys {
+			if err := bkt.Delete(k); err != nil {
+				return func() {}, err
+			}
+		}
+
+		pool := make(map[string]coin.UxOut, len(uxs))
+		for _, ux := range uxs {
+			h := ux.Hash()
+			if _, ok := pool[h.Hex()]; ok {
+				return func() {}, fmt.Errorf("attemps to insert uxout:%v twice into the unspent pool", h.Hex())
+			}
+			pool[h.Hex()] = ux
+
+			if err := up.pool.setWithTx(tx, h, ux); err != nil {
+				return func() {}, err
+			}
+		}
+
+		addrs, err := up.addrs.resetWithTx(tx, uxs)
+		if err != nil {
+			return func() {}, err
+		}
+
+		uxHash := UxHash(uxs)
+		if err := up.meta.setXorHashWithTx(tx, uxHash); err != nil {
+			return func() {}, err
+		}
+
+		up.Lock()
+		oldPool := up.cache.pool
+		oldAddrs := up.cache.addrs
+		oldUxHash := up.cache.uxhash
+		up.cache.pool = pool
+		up.cache.addrs = addrs
+		up.cache.uxhash = uxHash
+		up.Unlock()
+
+		return func() {
+			up.Lock()
+			up.cache.pool = oldPool
+			up.cache.addrs = oldAddrs
+			up.cache.uxhash = oldUxHash
+			up.Unlock()
+		}, nil
+	}
+}
+
+// UxHash returns the checksum of the unspent outputs, the UxHash of the block
+// following the block they are the unspent outputs after
+func UxHash(uxs coin.UxArray) cipher.SHA256 {
+	var h cipher.SHA256
+	for _, ux := range uxs {
+		h = h.Xor(ux.SnapshotHash())
+	}
+	return h
+}
+
 func (up *Unspents) addWithTx(tx *bolt.Tx, ux coin.UxOut) (uxhash cipher.SHA256, err error) {
 	// will rollback all updates if return is not nil
 	// in case of unexpected panic, we must catch it and return error
@@ -256,18 +754,46 @@ func (up *Unspents) addWithTx(tx *bolt.Tx, ux coin.UxOut) (uxhash cipher.SHA256,
 		return cipher.SHA256{}, err
 	}
 
+	if err := up.addrs.addWithTx(tx, ux); err != nil {
+		return cipher.SHA256{}, err
+	}
+
 	return xorhash, nil
 }
 
 func (up *Unspents) deleteUxFromCache(uxs []coin.UxOut) {
 	for _, ux := range uxs {
-		delete(up.cache.pool, ux.Hash().Hex())
+		h := ux.Hash()
+		delete(up.cache.pool, h.Hex())
+
+		ao, ok := up.cache.addrs[ux.Body.Address]
+		if !ok {
+			continue
+		}
+
+		if _, ok := ao.uxids[h]; !ok {
+			continue
+		}
+
+		delete(ao.uxids, h)
+		ao.coins -= ux.Body.Coins
+		if len(ao.uxids) == 0 {
+			delete(up.cache.addrs, ux.Body.Address)
+		}
 	}
 }
 
 func (up *Unspents) addUxToCache(uxs []coin.UxOut) {
 	for i, ux := range uxs {
-		up.cache.pool[ux.Hash().Hex()] = uxs[i]
+		h := ux.Hash()
+		up.cache.pool[h.Hex()] = uxs[i]
+
+		// the coins are checked for overflow when added to the db
+		ao := addressOf(up.cache.addrs, ux.Body.Address)
+		if _, ok := ao.uxids[h]; !ok {
+			ao.uxids[h] = struct{}{}
+			ao.coins += ux.Body.Coins
+		}
 	}
 }
 
@@ -346,6 +872,10 @@ func (up *Unspents) deleteWithTx(tx *bolt.Tx, hashes []cipher.SHA256) (cipher.SH
 		if err := up.pool.deleteWithTx(tx, hash); err != nil {
 			return cipher.SHA256{}, err
 		}
+
+		if err := up.addrs.removeWithTx(tx, *ux); err != nil {
+			return cipher.SHA256{}, err
+		}
 	}
 
 	return uxHash, nil
@@ -376,15 +906,42 @@ func (up *Unspents) GetUnspentsOfAddrs(addrs []cipher.Address) coin.AddressUxOut
 	}
 
 	addrUxs := coin.AddressUxOuts{}
-	for _, ux := range up.cache.pool {
-		if _, ok := addrm[ux.Body.Address]; ok {
-			addrUxs[ux.Body.Address] = append(addrUxs[ux.Body.Address], ux)
+	for addr := range addrm {
+		ao, ok := up.cache.addrs[addr]
+		if !ok {
+			continue
+		}
+
+		for h := range ao.uxids {
+			if ux, ok := up.cache.pool[h.Hex()]; ok {
+				addrUxs[addr] = append(addrUxs[addr], ux)
+			}
 		}
 	}
 	up.Unlock()
 	return addrUxs
 }
 
+// GetTopAddressCoins returns the coins of the n richest addresses not in skip, read from
+// the addresses ordered by coins. The addresses having as many coins as the last one are
+// included. All the addresses are returned if n <= 0
+func (up *Unspents) GetTopAddressCoins(n int, skip map[cipher.Address]struct{}) (map[cipher.Address]uint64, error) {
+	var top map[cipher.Address]uint64
+	err := up.db.View(func(tx *bolt.Tx) error {
+		var err error
+		top, err = up.addrs.topWithTx(tx, n, skip)
+		return err
+	})
+	return top, err
+}
+
+// AddressCount returns the number of addresses owning unspent outputs
+func (up *Unspents) AddressCount() uint64 {
+	up.Lock()
+	defer up.Unlock()
+	return uint64(len(up.cache.addrs))
+}
+
 // GetUxHash returns unspent output checksum for the Block.
 // Must be called after Block is fully initialized,
 // and before its outputs are added to the unspent pool
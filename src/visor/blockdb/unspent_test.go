@@ -16,6 +16,7 @@ import (
 	"github.com/samoslab/samos/src/cipher"
 	"github.com/samoslab/samos/src/coin"
 	"github.com/samoslab/samos/src/testutil"
+	"github.com/samoslab/samos/src/visor/bucket"
 )
 
 type spending struct {
@@ -107,6 +108,44 @@ func TestUnspentPoolSyncCache(t *testing.T) {
 	}
 }
 
+func TestUnspentPoolLoad(t *testing.T) {
+	db, closedb := testutil.PrepareDB(t)
+	defer closedb()
+
+	up, err := NewUnspentPool(db)
+	require.NoError(t, err)
+
+	old := makeUxOut(t)
+	require.NoError(t, addUxOut(up, old))
+
+	uxs := coin.UxArray{makeUxOut(t), makeUxOut(t), makeUxOut(t)}
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := up.Load(uxs)(tx)
+		return err
+	}))
+
+	require.False(t, up.Contains(old.Hash()))
+	require.Equal(t, uint64(3), up.Len())
+	var uxHash cipher.SHA256
+	for _, ux := range uxs {
+		uxHash = uxHash.Xor(ux.SnapshotHash())
+	}
+	require.Equal(t, uxHash, up.GetUxHash())
+
+	// loaded from db
+	up2, err := NewUnspentPool(db)
+	require.NoError(t, err)
+	require.Equal(t, up.cache.pool, up2.cache.pool)
+	require.Equal(t, up.GetUxHash(), up2.GetUxHash())
+
+	// duplicates
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := up.Load(coin.UxArray{old, old})(tx)
+		return err
+	})
+	require.Error(t, err)
+}
+
 func TestUnspentPoolRemoveUxFromCache(t *testing.T) {
 	var uxs coin.UxArray
 	for i := 0; i < 5; i++ {
@@ -642,3 +681,165 @@ func TestUnspentProcessBlock(t *testing.T) {
 	}
 
 }
+
+func TestUnspentRevertBlock(t *testing.T) {
+	db, closedb := testutil.PrepareDB(t)
+	defer closedb()
+
+	up, err := NewUnspentPool(db)
+	require.NoError(t, err)
+
+	ux, s := makeUxOutWithSecret(t)
+	require.NoError(t, addUxOut(up, ux))
+	uxHash := up.GetUxHash()
+
+	txn := coin.Transaction{}
+	txn.PushInput(ux.Hash())
+	txn.PushOutput(testutil.MakeAddress(), ux.Body.Coins, ux.Body.Hours/2)
+	txn.SignInputs([]cipher.SecKey{s})
+	txn.UpdateHeader()
+	block, err := coin.NewBlock(coin.Block{}, uint64(time.Now().Unix()), uxHash, coin.Transactions{txn}, _feeCalc)
+	require.NoError(t, err)
+	sb := &coin.SignedBlock{Block: *block}
+
+	update := func(h bucket.TxHandler) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			_, err := h(tx)
+			return err
+		})
+	}
+
+	require.NoError(t, update(up.ProcessBlock(sb)))
+	out := coin.CreateUnspents(block.Head, txn)[0]
+	require.True(t, up.Contains(out.Hash()))
+
+	require.NoError(t, update(up.RevertBlock(sb)))
+	require.False(t, up.Contains(out.Hash()))
+	require.True(t, up.Contains(ux.Hash()))
+	require.Equal(t, uxHash, up.GetUxHash())
+
+	// the spent outputs are loaded on restart
+	up, err = NewUnspentPool(db)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), up.Len())
+	require.Equal(t, uxHash, up.GetUxHash())
+
+	// reverted once only
+	require.Equal(t, ErrNoUndo, update(up.RevertBlock(sb)))
+
+	// pruned blocks can't be reverted
+	require.NoError(t, update(up.ProcessBlock(sb)))
+	require.NoError(t, update(up.PruneUndo(sb.Seq())))
+	require.Equal(t, ErrNoUndo, update(up.RevertBlock(sb)))
+}
+
+func TestUnspentAddressIndex(t *testing.T) {
+	db, closedb := testutil.PrepareDB(t)
+	defer closedb()
+
+	up, err := NewUnspentPool(db)
+	require.NoError(t, err)
+
+	ux, s := makeUxOutWithSecret(t)
+	ux2 := makeUxOut(t)
+	ux2.Body.Address = ux.Body.Address
+	ux2.Body.Coins = 2e6
+	require.NoError(t, addUxOut(up, ux))
+	require.NoError(t, addUxOut(up, ux2))
+
+	top := func(up *Unspents, n int, skip ...cipher.Address) map[cipher.Address]uint64 {
+		skipped := make(map[cipher.Address]struct{})
+		for _, addr := range skip {
+			skipped[addr] = struct{}{}
+		}
+		coins, err := up.GetTopAddressCoins(n, skipped)
+		require.NoError(t, err)
+		return coins
+	}
+
+	require.Equal(t, map[cipher.Address]uint64{ux.Body.Address: 3e6}, top(up, 0))
+	require.Equal(t, uint64(1), up.AddressCount())
+
+	// spend one output to another address
+	toAddr := testutil.MakeAddress()
+	txn := coin.Transaction{}
+	txn.PushInput(ux.Hash())
+	txn.PushOutput(toAddr, ux.Body.Coins, ux.Body.Hours/2)
+	txn.SignInputs([]cipher.SecKey{s})
+	txn.UpdateHeader()
+	block, err := coin.NewBlock(coin.Block{}, uint64(time.Now().Unix()), up.GetUxHash(), coin.Transactions{txn}, _feeCalc)
+	require.NoError(t, err)
+	sb := &coin.SignedBlock{Block: *block}
+
+	update := func(h bucket.TxHandler) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			_, err := h(tx)
+			return err
+		})
+	}
+
+	require.NoError(t, update(up.ProcessBlock(sb)))
+	require.Equal(t, map[cipher.Address]uint64{
+		ux.Body.Address: 2e6,
+		toAddr:          1e6,
+	}, top(up, 0))
+	require.Equal(t, uint64(2), up.AddressCount())
+
+	// the richest addresses are read in the balance order
+	require.Equal(t, map[cipher.Address]uint64{ux.Body.Address: 2e6}, top(up, 1))
+	require.Equal(t, map[cipher.Address]uint64{toAddr: 1e6}, top(up, 1, ux.Body.Address))
+	require.Equal(t, 2, up.addrs.rank.Len())
+
+	auxs := up.GetUnspentsOfAddrs([]cipher.Address{ux.Body.Address, toAddr})
+	require.Equal(t, coin.UxArray{ux2}, auxs[ux.Body.Address])
+	require.Equal(t, coin.CreateUnspents(block.Head, txn), auxs[toAddr])
+	require.Nil(t, up.addrs.uxs.Get(addrUxKey(ux.Body.Address, ux.Hash())))
+	require.NotNil(t, up.addrs.uxs.Get(addrUxKey(ux.Body.Address, ux2.Hash())))
+
+	// the index is loaded on restart
+	up2, err := NewUnspentPool(db)
+	require.NoError(t, err)
+	require.Equal(t, up.cache.addrs, up2.cache.addrs)
+
+	// the index is rebuilt if missing
+	require.NoError(t, up.addrs.uxs.Reset())
+	require.NoError(t, up.addrs.coins.Reset())
+	require.NoError(t, up.addrs.rank.Reset())
+	up2, err = NewUnspentPool(db)
+	require.NoError(t, err)
+	require.Equal(t, up.cache.addrs, up2.cache.addrs)
+	require.Equal(t, 2, up2.addrs.uxs.Len())
+	require.Equal(t, 2, up2.addrs.coins.Len())
+	require.Equal(t, top(up, 0), top(up2, 0))
+
+	require.NoError(t, update(up.RevertBlock(sb)))
+	require.Equal(t, map[cipher.Address]uint64{ux.Body.Address: 3e6}, top(up, 0))
+	up2, err = NewUnspentPool(db)
+	require.NoError(t, err)
+	require.Equal(t, top(up, 0), top(up2, 0))
+
+	// a rolled back update restores the cache
+	require.Error(t, db.Update(func(tx *bolt.Tx) error {
+		rb, err := up.ProcessBlock(sb)(tx)
+		require.NoError(t, err)
+		rb()
+		return errors.New("rollback")
+	}))
+	require.Equal(t, map[cipher.Address]uint64{ux.Body.Address: 3e6}, top(up, 0))
+
+	// loading a snapshot replaces the index
+	require.NoError(t, update(up.Load(coin.UxArray{ux2})))
+	require.Equal(t, map[cipher.Address]uint64{ux.Body.Address: 2e6}, top(up, 0))
+	up2, err = NewUnspentPool(db)
+	require.NoError(t, err)
+	require.Equal(t, top(up, 0), top(up2, 0))
+
+	// the addresses tied with the last one are read too
+	ux3 := makeUxOut(t)
+	ux3.Body.Coins = ux2.Body.Coins
+	require.NoError(t, addUxOut(up, ux3))
+	require.Equal(t, map[cipher.Address]uint64{
+		ux.Body.Address:  2e6,
+		ux3.Body.Address: 2e6,
+	}, top(up, 1))
+}
@@ -208,6 +208,11 @@ func (b *Bucket) ForEach(f func(k, v []byte) error) error {
 	})
 }
 
+// ForEachWithTx iterate the whole bucket with *bolt.Tx
+func (b *Bucket) ForEachWithTx(tx *bolt.Tx, f func(k, v []byte) error) error {
+	return tx.Bucket(b.Name).ForEach(f)
+}
+
 // Len returns the number of key value pairs
 func (b *Bucket) Len() (len int) {
 	b.db.View(func(tx *bolt.Tx) error {
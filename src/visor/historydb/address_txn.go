@@ -75,3 +75,28 @@ func setAddressTxns(bkt *bolt.Bucket, addr cipher.Address, hash cipher.SHA256) e
 	bin := encoder.Serialize(hashes)
 	return bkt.Put(addrBytes, bin)
 }
+
+func removeAddressTxns(bkt *bolt.Bucket, addr cipher.Address, hash cipher.SHA256) error {
+	addrBytes := addr.Bytes()
+	v := bkt.Get(addrBytes)
+	if v == nil {
+		return nil
+	}
+
+	var hashes []cipher.SHA256
+	if err := encoder.DeserializeRaw(v, &hashes); err != nil {
+		return err
+	}
+
+	left := hashes[:0]
+	for _, u := range hashes {
+		if u != hash {
+			left = append(left, u)
+		}
+	}
+
+	if len(left) == 0 {
+		return bkt.Delete(addrBytes)
+	}
+	return bkt.Put(addrBytes, encoder.Serialize(left))
+}
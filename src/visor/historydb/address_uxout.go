@@ -90,3 +90,27 @@ func setAddressUx(bkt *bolt.Bucket, addr cipher.Address, uxHash cipher.SHA256) e
 	uxHashes = append(uxHashes, uxHash)
 	return bkt.Put(addr.Bytes(), encoder.Serialize(uxHashes))
 }
+
+func removeAddressUx(bkt *bolt.Bucket, addr cipher.Address, uxHash cipher.SHA256) error {
+	bin := bkt.Get(addr.Bytes())
+	if bin == nil {
+		return nil
+	}
+
+	uxHashes := []cipher.SHA256{}
+	if err := encoder.DeserializeRaw(bin, &uxHashes); err != nil {
+		return err
+	}
+
+	left := uxHashes[:0]
+	for _, u := range uxHashes {
+		if u != uxHash {
+			left = append(left, u)
+		}
+	}
+
+	if len(left) == 0 {
+		return bkt.Delete(addr.Bytes())
+	}
+	return bkt.Put(addr.Bytes(), encoder.Serialize(left))
+}
@@ -5,12 +5,14 @@ import (
 
 	"github.com/boltdb/bolt"
 
+	"github.com/samoslab/samos/src/cipher"
 	"github.com/samoslab/samos/src/visor/bucket"
 )
 
 var (
 	historyMetaBkt  = []byte("history_meta")
 	parsedHeightKey = []byte("parsed_height")
+	parsedHashKey   = []byte("parsed_hash")
 )
 
 // historyMeta bucket for storing block history meta info
@@ -34,6 +36,14 @@ func (hm *historyMeta) ParsedHeight() int64 {
 	return -1
 }
 
+// parsedHeightWithTx returns history parsed height with *bolt.Tx, if no block was parsed, return -1.
+func (hm *historyMeta) parsedHeightWithTx(tx *bolt.Tx) int64 {
+	if v := hm.v.GetWithTx(tx, parsedHeightKey); v != nil {
+		return int64(bucket.Btoi(v))
+	}
+	return -1
+}
+
 // SetParsedHeight updates history parsed height
 func (hm *historyMeta) SetParsedHeight(h uint64) error {
 	return hm.v.Put(parsedHeightKey, bucket.Itob(h))
@@ -49,6 +59,27 @@ func (hm *historyMeta) SetParsedHeightWithTx(tx *bolt.Tx, h uint64) error {
 	return bkt.Put(parsedHeightKey, bucket.Itob(h))
 }
 
+// parsedHashWithTx returns the hash of the last parsed block with *bolt.Tx,
+// returns false if it is not recorded
+func (hm *historyMeta) parsedHashWithTx(tx *bolt.Tx) (cipher.SHA256, bool) {
+	v := hm.v.GetWithTx(tx, parsedHashKey)
+	if v == nil {
+		return cipher.SHA256{}, false
+	}
+
+	var hash cipher.SHA256
+	copy(hash[:], v)
+	return hash, true
+}
+
+// setParsedBlockWithTx updates history parsed height and the hash of the block with *bolt.Tx
+func (hm *historyMeta) setParsedBlockWithTx(tx *bolt.Tx, h uint64, hash cipher.SHA256) error {
+	if err := hm.SetParsedHeightWithTx(tx, h); err != nil {
+		return err
+	}
+	return hm.v.PutWithTx(tx, parsedHashKey, hash[:])
+}
+
 // IsEmpty checks if history meta bucket is empty
 func (hm *historyMeta) IsEmpty() bool {
 	return hm.v.IsEmpty()
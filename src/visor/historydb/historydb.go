@@ -4,6 +4,7 @@ package historydb
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/boltdb/bolt"
 
@@ -15,6 +16,12 @@ import (
 
 var logger = logging.MustGetLogger("historydb")
 
+var (
+	// ErrParsedBlockMismatch is returned if the block does not follow the last parsed block
+	// or, when reverting, is not the last parsed block
+	ErrParsedBlockMismatch = errors.New("block does not match the parsed blocks")
+)
+
 // Blockchainer interface for isolating the detail of blockchain.
 type Blockchainer interface {
 	Head() *coin.Block
@@ -122,6 +129,11 @@ func (hd *HistoryDB) ParseBlock(b *coin.Block) error {
 
 	// index the transactions
 	return hd.db.Update(func(tx *bolt.Tx) error {
+		// the chain may be reorganized since the block was read
+		if hash, ok := hd.parsedHashWithTx(tx); ok && b.Seq() > 0 && hash != b.Head.PrevHash {
+			return ErrParsedBlockMismatch
+		}
+
 		// all updates will rollback if return error is not nil
 		for _, t := range b.Body.Transactions {
 			txn := Transaction{
@@ -179,10 +191,81 @@ func (hd *HistoryDB) ParseBlock(b *coin.Block) error {
 			}
 		}
 
-		return hd.SetParsedHeightWithTx(tx, b.Seq())
+		return hd.setParsedBlockWithTx(tx, b.Seq(), b.HashHeader())
 	})
 }
 
+// RevertBlockWithTx removes the index of the last parsed block with *bolt.Tx, when
+// the block is reverted from the chain. A block which is not parsed yet is skipped
+func (hd *HistoryDB) RevertBlockWithTx(tx *bolt.Tx, b *coin.Block) error {
+	height := hd.parsedHeightWithTx(tx)
+	if int64(b.Seq()) > height {
+		return nil
+	}
+
+	if b.Seq() == 0 || int64(b.Seq()) != height {
+		return ErrParsedBlockMismatch
+	}
+
+	if hash, ok := hd.parsedHashWithTx(tx); ok && hash != b.HashHeader() {
+		return ErrParsedBlockMismatch
+	}
+
+	txnsBkt := tx.Bucket(hd.txns.bkt.Name)
+	outputsBkt := tx.Bucket(hd.outputs.bkt.Name)
+	addrUxBkt := tx.Bucket(hd.addrUx.bkt.Name)
+	addrTxnsBkt := tx.Bucket(hd.addrTxns.bkt.Name)
+
+	txns := b.Body.Transactions
+	for i := len(txns) - 1; i >= 0; i-- {
+		t := txns[i]
+		txnHash := t.Hash()
+
+		// remove the tx out
+		for _, ux := range coin.CreateUnspents(b.Head, t) {
+			uxHash := ux.Hash()
+			if err := outputsBkt.Delete(uxHash[:]); err != nil {
+				return err
+			}
+
+			if err := removeAddressUx(addrUxBkt, ux.Body.Address, uxHash); err != nil {
+				return err
+			}
+
+			if err := removeAddressTxns(addrTxnsBkt, ux.Body.Address, txnHash); err != nil {
+				return err
+			}
+		}
+
+		// the tx in are unspent again
+		for _, in := range t.In {
+			o, err := getOutput(outputsBkt, in)
+			if err != nil {
+				return err
+			}
+			if o == nil {
+				return fmt.Errorf("output %s of block %d is not parsed", in.Hex(), b.Seq())
+			}
+
+			o.SpentBlockSeq = 0
+			o.SpentTxID = cipher.SHA256{}
+			if err := setOutput(outputsBkt, *o); err != nil {
+				return err
+			}
+
+			if err := removeAddressTxns(addrTxnsBkt, o.Out.Body.Address, txnHash); err != nil {
+				return err
+			}
+		}
+
+		if err := txnsBkt.Delete(txnHash[:]); err != nil {
+			return err
+		}
+	}
+
+	return hd.setParsedBlockWithTx(tx, b.Seq()-1, b.Head.PrevHash)
+}
+
 // GetTransaction get transaction by hash.
 func (hd HistoryDB) GetTransaction(hash cipher.SHA256) (*Transaction, error) {
 	return hd.txns.Get(hash)
@@ -456,3 +456,89 @@ func newBlockHeader(prev coin.BlockHeader, uxHash cipher.SHA256, currentTime, fe
 		UxHash:   uxHash,
 	}
 }
+
+func TestRevertBlockWithTx(t *testing.T) {
+	db, teardown := testutil.PrepareDB(t)
+	defer teardown()
+	bc := newBlockchain(db)
+	gb := bc.CreateGenesisBlock(genAddress, _genCoins, _genTime)
+
+	hisDB, err := New(db)
+	require.NoError(t, err)
+	require.NoError(t, hisDB.ParseBlock(&gb))
+
+	addr := "222uMeCeL1PbkJGZJDgAz5sib2uisv9hYUm"
+	tds := []testData{
+		{
+			PreBlockHash: gb.HashHeader(),
+			Vin: txIn{
+				SigKey: genSecret.Hex(),
+				Addr:   genAddress.String(),
+				TxID:   gb.Body.Transactions[0].Hash(),
+			},
+			Vouts: []txOut{
+				{ToAddr: addr, Coins: _genCoins, Hours: 400},
+			},
+			AddrInNum: map[string]int{addr: 1},
+		},
+		{
+			Vin: txIn{
+				Addr:     addr,
+				SigKey:   "62f4d675d991c41a2819d908a4fcf4ba44ff0c31564039e80508c9d68197f90c",
+				BlockSeq: 1,
+			},
+			Vouts: []txOut{
+				{ToAddr: "2RxP5N26GhDqHrP6SK45ZzEMSmSpeUeWxsS", Coins: _genCoins, Hours: 100},
+			},
+			AddrInNum: map[string]int{"2RxP5N26GhDqHrP6SK45ZzEMSmSpeUeWxsS": 1},
+		},
+	}
+	testEngine(t, tds, bc, hisDB, db)
+
+	b1, b2 := bc.blocks[1], bc.blocks[2]
+	spentUx := coin.CreateUnspents(b1.Head, b1.Body.Transactions[0])[0]
+	revert := func(b coin.Block) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			return hisDB.RevertBlockWithTx(tx, &b)
+		})
+	}
+
+	// only the last parsed block can be reverted
+	require.Equal(t, ErrParsedBlockMismatch, revert(b1))
+
+	require.NoError(t, revert(b2))
+	require.Equal(t, int64(1), hisDB.ParsedHeight())
+
+	txn, err := hisDB.GetTransaction(b2.Body.Transactions[0].Hash())
+	require.NoError(t, err)
+	require.Nil(t, txn)
+
+	ux, err := hisDB.GetUxout(spentUx.Hash())
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), ux.SpentBlockSeq)
+	require.Equal(t, cipher.SHA256{}, ux.SpentTxID)
+
+	txns, err := hisDB.GetAddrTxns(cipher.MustDecodeBase58Address(addr))
+	require.NoError(t, err)
+	require.Len(t, txns, 1)
+	require.Equal(t, b1.Body.Transactions[0].Hash(), txns[0].Hash())
+
+	uxs, err := hisDB.GetAddrUxOuts(cipher.MustDecodeBase58Address("2RxP5N26GhDqHrP6SK45ZzEMSmSpeUeWxsS"))
+	require.NoError(t, err)
+	require.Empty(t, uxs)
+
+	// a block not parsed yet is skipped
+	require.NoError(t, revert(b2))
+	require.Equal(t, int64(1), hisDB.ParsedHeight())
+
+	// the blocks must follow the last parsed block
+	b3 := b2
+	b3.Head.PrevHash = b2.HashHeader()
+	require.Equal(t, ErrParsedBlockMismatch, hisDB.ParseBlock(&b3))
+
+	require.NoError(t, hisDB.ParseBlock(&b2))
+	require.Equal(t, int64(2), hisDB.ParsedHeight())
+	ux, err = hisDB.GetUxout(spentUx.Hash())
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), ux.SpentBlockSeq)
+}
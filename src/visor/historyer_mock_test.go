@@ -8,6 +8,7 @@ package visor
 import (
 	"fmt"
 
+	bolt "github.com/boltdb/bolt"
 	mock "github.com/stretchr/testify/mock"
 
 	cipher "github.com/samoslab/samos/src/cipher"
@@ -186,6 +187,24 @@ func (m *historyerMock) ParsedHeight() int64 {
 
 }
 
+// RevertBlockWithTx mocked method
+func (m *historyerMock) RevertBlockWithTx(p0 *bolt.Tx, p1 *coin.Block) error {
+
+	ret := m.Called(p0, p1)
+
+	var r0 error
+	switch res := ret.Get(0).(type) {
+	case nil:
+	case error:
+		r0 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	return r0
+
+}
+
 // ResetIfNeed mocked method
 func (m *historyerMock) ResetIfNeed() error {
 
@@ -10,6 +10,8 @@ import (
 
 	"github.com/samoslab/samos/src/cipher"
 	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/consensus/dpos"
+	"github.com/samoslab/samos/src/consensus/pbft"
 	"github.com/samoslab/samos/src/util/droplet"
 	"github.com/samoslab/samos/src/wallet"
 )
@@ -22,6 +24,8 @@ type BlockchainMetadata struct {
 	Unspents uint64 `json:"unspents"`
 	// Number of known unconfirmed txns
 	Unconfirmed uint64 `json:"unconfirmed"`
+	// Seq of the highest block final by the commits of the trust nodes
+	FinalizedSeq uint64 `json:"finalized_seq"`
 }
 
 // NewBlockchainMetadata creates blockchain meta data
@@ -32,9 +36,10 @@ func NewBlockchainMetadata(v *Visor) (*BlockchainMetadata, error) {
 	}
 
 	return &BlockchainMetadata{
-		Head:        NewReadableBlockHeader(&head.Head),
-		Unspents:    v.Blockchain.Unspent().Len(),
-		Unconfirmed: uint64(v.Unconfirmed.Len()),
+		Head:         NewReadableBlockHeader(&head.Head),
+		Unspents:     v.Blockchain.Unspent().Len(),
+		Unconfirmed:  uint64(v.Unconfirmed.Len()),
+		FinalizedSeq: v.Blockchain.FinalizedSeq(),
 	}, nil
 }
 
@@ -403,6 +408,45 @@ func NewReadableUnconfirmedTxn(unconfirmed *UnconfirmedTxn) (*ReadableUnconfirme
 	}, nil
 }
 
+// ReadableUnconfirmedTxnFee represents readable unconfirmed transaction with the fee it pays
+type ReadableUnconfirmedTxnFee struct {
+	ReadableUnconfirmedTxn
+	Fee             uint64   `json:"fee"`
+	Size            int      `json:"size"`
+	FeePerKB        uint64   `json:"fee_per_kb"`
+	PackageFeePerKB uint64   `json:"package_fee_per_kb"`
+	Ancestors       []string `json:"ancestors"`
+	Descendants     []string `json:"descendants"`
+}
+
+// NewReadableUnconfirmedTxnFee creates readable unconfirmed transaction with its fee
+func NewReadableUnconfirmedTxnFee(unconfirmed *UnconfirmedTxnFee) (*ReadableUnconfirmedTxnFee, error) {
+	tx, err := NewReadableUnconfirmedTxn(&unconfirmed.UnconfirmedTxn)
+	if err != nil {
+		return nil, err
+	}
+
+	ancestors := make([]string, len(unconfirmed.Ancestors))
+	for i, h := range unconfirmed.Ancestors {
+		ancestors[i] = h.Hex()
+	}
+
+	descendants := make([]string, len(unconfirmed.Descendants))
+	for i, h := range unconfirmed.Descendants {
+		descendants[i] = h.Hex()
+	}
+
+	return &ReadableUnconfirmedTxnFee{
+		ReadableUnconfirmedTxn: *tx,
+		Fee:                    unconfirmed.Fee,
+		Size:                   unconfirmed.Size,
+		FeePerKB:               unconfirmed.FeePerKB,
+		PackageFeePerKB:        unconfirmed.PackageFeePerKB,
+		Ancestors:              ancestors,
+		Descendants:            descendants,
+	}, nil
+}
+
 // NewReadableUnconfirmedTxns converts []UnconfirmedTxn to []ReadableUnconfirmedTxn
 func NewReadableUnconfirmedTxns(txs []UnconfirmedTxn) ([]ReadableUnconfirmedTxn, error) {
 	rut := make([]ReadableUnconfirmedTxn, len(txs))
@@ -581,6 +625,95 @@ func NewReadableBlocks(blocks []coin.SignedBlock) (*ReadableBlocks, error) {
 	}, nil
 }
 
+// ReadableSignedHeader represents a block header with a signature of a trust node
+type ReadableSignedHeader struct {
+	Head ReadableBlockHeader `json:"header"`
+	Sig  string              `json:"signature"`
+}
+
+// ReadableEvidence represents the evidence of a trust node double signing
+type ReadableEvidence struct {
+	Type   string                 `json:"type"`
+	PubKey string                 `json:"pubkey"`
+	View   uint64                 `json:"view"`
+	Blocks []ReadableSignedHeader `json:"blocks"`
+}
+
+// NewReadableEvidence creates readable evidence
+func NewReadableEvidence(e pbft.Evidence) ReadableEvidence {
+	var typ string
+	switch e.Type {
+	case pbft.EvidenceSlot:
+		typ = "slot"
+	case pbft.EvidenceSeq:
+		typ = "seq"
+	case pbft.EvidencePrepare:
+		typ = "prepare"
+	default:
+		typ = "unknown"
+	}
+
+	return ReadableEvidence{
+		Type:   typ,
+		PubKey: e.PubKey.Hex(),
+		View:   e.View,
+		Blocks: []ReadableSignedHeader{
+			{
+				Head: NewReadableBlockHeader(&e.HeaderA),
+				Sig:  e.SigA.Hex(),
+			},
+			{
+				Head: NewReadableBlockHeader(&e.HeaderB),
+				Sig:  e.SigB.Hex(),
+			},
+		},
+	}
+}
+
+// ReadableSlotProducer represents the trust node scheduled to produce the block of a slot
+type ReadableSlotProducer struct {
+	Slot     int64  `json:"slot"`
+	Producer string `json:"producer"`
+}
+
+// NewReadableSlotProducer creates a readable slot producer
+func NewReadableSlotProducer(sp dpos.SlotProducer) ReadableSlotProducer {
+	return ReadableSlotProducer{
+		Slot:     sp.Slot,
+		Producer: sp.Producer.Hex(),
+	}
+}
+
+// ReadableSlotStats represents the slots a trust node produced and missed in an epoch
+type ReadableSlotStats struct {
+	PubKey   string `json:"pubkey"`
+	Produced uint64 `json:"produced"`
+	Missed   uint64 `json:"missed"`
+}
+
+// ReadableValidatorStats represents the slot stats of the validators in an epoch
+type ReadableValidatorStats struct {
+	Epoch      int64               `json:"epoch"`
+	Validators []ReadableSlotStats `json:"validators"`
+}
+
+// NewReadableValidatorStats creates readable validator stats of the epoch
+func NewReadableValidatorStats(epoch int64, stats []dpos.SlotStats) ReadableValidatorStats {
+	validators := make([]ReadableSlotStats, 0, len(stats))
+	for _, s := range stats {
+		validators = append(validators, ReadableSlotStats{
+			PubKey:   s.Validator.Hex(),
+			Produced: s.Produced,
+			Missed:   s.Missed,
+		})
+	}
+
+	return ReadableValidatorStats{
+		Epoch:      epoch,
+		Validators: validators,
+	}
+}
+
 /*
 	Transactions to and from JSON
 */
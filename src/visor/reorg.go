@@ -0,0 +1,413 @@
+package visor
+
+import (
+	"fmt"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/consensus/dpos"
+	"github.com/samoslab/samos/src/consensus/pbft"
+)
+
+// isSideBlock returns true if the block follows a stored block other than the head
+func (vs *Visor) isSideBlock(b coin.SignedBlock) (bool, error) {
+	if vs.Blockchain.Len() == 0 {
+		return false, nil
+	}
+
+	head, err := vs.Blockchain.Head()
+	if err != nil {
+		return false, err
+	}
+	if b.Head.PrevHash == head.HashHeader() {
+		return false, nil
+	}
+
+	parent, err := vs.Blockchain.GetBlockByHash(b.Head.PrevHash)
+	if err != nil {
+		return false, err
+	}
+	return parent != nil, nil
+}
+
+// inChain returns true if the block is on the active chain
+func (vs *Visor) inChain(b coin.SignedBlock) (bool, error) {
+	cb, err := vs.Blockchain.GetBlockBySeq(b.Seq())
+	if err != nil {
+		return false, err
+	}
+	return cb != nil && cb.HashHeader() == b.HashHeader(), nil
+}
+
+// executeSideBlock stores the block following a block other than the head, the
+// chain is reorganized if the fork choice rule prefers the branch of the block
+func (vs *Visor) executeSideBlock(b coin.SignedBlock, cert *pbft.Certificate) error {
+	if b.Seq() <= vs.Blockchain.FinalizedSeq() {
+		return ErrFinalizedReorg
+	}
+
+	known, err := vs.Blockchain.GetBlockByHash(b.HashHeader())
+	if err != nil {
+		return err
+	}
+
+	if known == nil {
+		if err := vs.db.Update(func(tx *bolt.Tx) error {
+			return vs.Blockchain.AddSideBlockWithTx(tx, &b)
+		}); err != nil {
+			return err
+		}
+		logger.Infof("Stored block %d %s on a side branch", b.Seq(), b.HashHeader().Hex())
+	}
+
+	return vs.chooseBranch(b, cert)
+}
+
+// branch returns the blocks from the fork point on the active chain up to tip,
+// the fork point is the first one
+func (vs *Visor) branch(tip coin.SignedBlock) ([]coin.SignedBlock, error) {
+	blocks := []coin.SignedBlock{tip}
+	for {
+		parent, err := vs.Blockchain.GetBlockByHash(blocks[0].Head.PrevHash)
+		if err != nil {
+			return nil, err
+		}
+		if parent == nil {
+			return nil, fmt.Errorf("found no parent of block %s", blocks[0].HashHeader().Hex())
+		}
+
+		blocks = append([]coin.SignedBlock{*parent}, blocks...)
+
+		ok, err := vs.inChain(*parent)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return blocks, nil
+		}
+	}
+}
+
+// preferBranch is the fork choice rule, it returns true if the branch blocks following fork
+// replace the reverted blocks of the active chain above it:
+//   - a branch with a committed block wins, the certificate finalizes the block
+//   - otherwise the branch signed by more trust nodes wins, the active chain is kept on a tie
+//
+// Only the branch blocks made by the producer of their slot count, so a trust node can't
+// outweigh the others by signing blocks out of its slots. The chain is never reorganized
+// below the finalized block.
+func (vs *Visor) preferBranch(fork coin.SignedBlock, reverted, blocks []coin.SignedBlock, certified bool) (bool, error) {
+	if certified {
+		return true, nil
+	}
+	signers, err := vs.branchSigners(fork, blocks)
+	if err != nil {
+		return false, err
+	}
+	return signers > trustSigners(reverted), nil
+}
+
+// branchSigners returns the number of trust nodes which signed the blocks following fork in
+// the slots they are scheduled for. The slots are looked up in the consensus state of the active
+// chain with the epoch seeds of the branch, reorganize checks the blocks again in the state of
+// the branch. The epoch seed of the head is restored
+func (vs *Visor) branchSigners(fork coin.SignedBlock, blocks []coin.SignedBlock) (int, error) {
+	seeds, err := vs.epochSeeds(fork, blocks)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := vs.loadEpochSeed(); err != nil {
+			logger.Errorf("Reload epoch seed failed: %v", err)
+		}
+	}()
+
+	var scheduled []coin.SignedBlock
+	parent := fork
+	for i, b := range blocks {
+		vs.setEpochSeed(b, seeds[i+1])
+		if err := vs.checkProducer(&parent, b); err != nil {
+			logger.Infof("Block %d %s of the branch is not counted: %v", b.Seq(), b.HashHeader().Hex(), err)
+		} else {
+			scheduled = append(scheduled, b)
+		}
+		parent = b
+	}
+	return trustSigners(scheduled), nil
+}
+
+// trustSigners returns the number of trust nodes which signed the blocks,
+// the signatures are verified when the blocks are received
+func trustSigners(blocks []coin.SignedBlock) int {
+	signers := make(map[cipher.PubKey]struct{})
+	for _, b := range blocks {
+		pubkey, err := cipher.PubKeyFromSig(b.Sig, b.HashHeader())
+		if err != nil {
+			continue
+		}
+		signers[pubkey] = struct{}{}
+	}
+	return len(signers)
+}
+
+// chooseBranch reorganizes the chain to the branch ending at tip if the fork choice
+// rule prefers it, the certificate of tip is saved with the branch
+func (vs *Visor) chooseBranch(tip coin.SignedBlock, cert *pbft.Certificate) error {
+	blocks, err := vs.branch(tip)
+	if err != nil {
+		return err
+	}
+
+	if blocks[0].Seq() < vs.Blockchain.FinalizedSeq() {
+		return ErrFinalizedReorg
+	}
+
+	reverted, err := vs.blocksAbove(blocks[0])
+	if err != nil {
+		return err
+	}
+
+	ok, err := vs.preferBranch(blocks[0], reverted, blocks[1:], cert != nil)
+	if err != nil || !ok {
+		return err
+	}
+
+	return vs.reorganize(blocks[0], reverted, blocks[1:], cert)
+}
+
+// blocksAbove returns the blocks of the active chain above the block, from low to high
+func (vs *Visor) blocksAbove(b coin.SignedBlock) ([]coin.SignedBlock, error) {
+	var blocks []coin.SignedBlock
+	for seq := b.Seq() + 1; seq <= vs.HeadBkSeq(); seq++ {
+		cb, err := vs.Blockchain.GetBlockBySeq(seq)
+		if err != nil {
+			return nil, err
+		}
+		if cb == nil {
+			return nil, fmt.Errorf("found no block %d", seq)
+		}
+		blocks = append(blocks, *cb)
+	}
+	return blocks, nil
+}
+
+// epochSeeds returns the seeds of the producer order of the epochs of fork and of each of
+// the blocks following it, a block starting an epoch seeds it with the hash of its parent
+func (vs *Visor) epochSeeds(fork coin.SignedBlock, blocks []coin.SignedBlock) ([]cipher.SHA256, error) {
+	_, seed, err := vs.epochSeedOf(&fork)
+	if err != nil {
+		return nil, err
+	}
+
+	seeds := []cipher.SHA256{seed}
+	parent := fork
+	for _, b := range blocks {
+		if vs.startsEpoch(&parent, b) {
+			seed = parent.HashHeader()
+		}
+		seeds = append(seeds, seed)
+		parent = b
+	}
+	return seeds, nil
+}
+
+// setEpochSeed seeds the producer order of the block's epoch
+func (vs *Visor) setEpochSeed(b coin.SignedBlock, seed cipher.SHA256) {
+	iv := vs.dpos.Intervals()
+	vs.dpos.SetEpochSeed(iv.EpochStart(iv.PrevSlot(int64(b.Time()))), seed)
+}
+
+// checkProducer returns ErrBranchProducer if the block built on parent is not signed by the producer of its slot
+func (vs *Visor) checkProducer(parent *coin.SignedBlock, b coin.SignedBlock) error {
+	signer, err := cipher.PubKeyFromSig(b.Sig, b.HashHeader())
+	if err != nil {
+		return err
+	}
+	producer, err := vs.dpos.GetValidator(parent, int64(b.Time()))
+	if err != nil {
+		return err
+	}
+	if signer != producer {
+		return ErrBranchProducer
+	}
+	return nil
+}
+
+// revertBlockWithTx reverts the head block b built on parent with *bolt.Tx. The block is removed
+// from the unspent outputs, the history and the validator stats, and its validator changes, votes,
+// exclusions and election are removed from the db and the consensus state
+func (vs *Visor) revertBlockWithTx(tx *bolt.Tx, parent *coin.SignedBlock, b coin.SignedBlock) error {
+	// the stats are counted as they were when the block was executed
+	stats := vs.blockSlotStats(parent, b)
+
+	if err := vs.Blockchain.RevertHeadWithTx(tx); err != nil {
+		return fmt.Errorf("revert block %d failed: %v", b.Seq(), err)
+	}
+
+	if err := vs.history.RevertBlockWithTx(tx, &b.Block); err != nil {
+		return fmt.Errorf("revert history of block %d failed: %v", b.Seq(), err)
+	}
+
+	if err := vs.trustNode.SubSlotStatsWithTx(tx, stats); err != nil {
+		return err
+	}
+
+	iv := vs.dpos.Intervals()
+	for _, txn := range b.Body.Transactions {
+		txid := txn.Hash()
+		switch {
+		case dpos.IsValidatorChange(txn):
+			if err := vs.trustNode.DeleteValidatorChangeWithTx(tx, txid); err != nil {
+				return err
+			}
+			vs.dpos.RemoveValidatorChange(txid)
+		case dpos.IsVote(txn):
+			if err := vs.trustNode.DeleteVoteWithTx(tx, txid); err != nil {
+				return err
+			}
+		case pbft.IsEvidence(txn):
+			e, err := pbft.ParseEvidence(txn, iv)
+			if err != nil {
+				return fmt.Errorf("revert evidence %s failed: %v", txid.Hex(), err)
+			}
+			if err := vs.trustNode.DeleteExclusionWithTx(tx, e.Hash()); err != nil {
+				return err
+			}
+			vs.dpos.RemoveExclusion(e.Hash())
+		}
+	}
+
+	if vs.startsEpoch(parent, b) {
+		epoch := iv.NextEpoch(iv.PrevSlot(int64(b.Time())))
+		if err := vs.trustNode.DeleteElectionWithTx(tx, epoch); err != nil {
+			return err
+		}
+		vs.dpos.RemoveElection(epoch)
+	}
+
+	return nil
+}
+
+// reorganize switches the active chain from the reverted blocks to the blocks following fork
+// in one db transaction. The reverted blocks are reverted from the head down, then the blocks
+// of the branch are executed as head blocks are, each must be made by the producer of its slot.
+// The consensus state follows the blocks in the transaction and is reloaded if it rolls back.
+// The transactions of the reverted blocks are returned to the unconfirmed pool
+func (vs *Visor) reorganize(fork coin.SignedBlock, reverted, blocks []coin.SignedBlock, cert *pbft.Certificate) error {
+	seeds, err := vs.epochSeeds(fork, reverted)
+	if err != nil {
+		return err
+	}
+
+	if err := vs.db.Update(func(tx *bolt.Tx) error {
+		for i := len(reverted) - 1; i >= 0; i-- {
+			parent := fork
+			if i > 0 {
+				parent = reverted[i-1]
+			}
+
+			vs.setEpochSeed(parent, seeds[i])
+			if err := vs.revertBlockWithTx(tx, &parent, reverted[i]); err != nil {
+				return err
+			}
+		}
+		vs.setEpochSeed(fork, seeds[0])
+
+		parent := fork
+		for i := range blocks {
+			b := blocks[i]
+			if err := vs.checkProducer(&parent, b); err != nil {
+				logger.Warningf("Block %d %s of the branch is refused: %v", b.Seq(), b.HashHeader().Hex(), err)
+				return err
+			}
+
+			executed, err := vs.executeBlockWithTx(tx, &parent, b)
+			if err != nil {
+				return fmt.Errorf("execute block %d failed: %v", b.Seq(), err)
+			}
+
+			// the next blocks of the branch are checked against the state of this one
+			vs.applyExecutedBlock(&parent, b, executed)
+			parent = b
+		}
+
+		if cert != nil {
+			tip := blocks[len(blocks)-1]
+			if err := vs.Blockchain.AddCertificateWithTx(tx, tip.Seq(), *cert); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}); err != nil {
+		// the caches and the consensus state were changed by the rolled back transaction
+		if err := vs.Blockchain.Reload(); err != nil {
+			logger.Errorf("Reload blockchain failed: %v", err)
+		}
+		if err := loadConsensusState(vs.dpos, vs.trustNode); err != nil {
+			logger.Errorf("Reload consensus state failed: %v", err)
+		}
+		if err := vs.loadEpochSeed(); err != nil {
+			logger.Errorf("Reload epoch seed failed: %v", err)
+		}
+		return err
+	}
+
+	logger.Warningf("Reorganized the chain at block %d, reverted %d blocks and executed %d blocks",
+		fork.Seq(), len(reverted), len(blocks))
+
+	vs.reinjectTxns(reverted, blocks)
+
+	for _, b := range blocks {
+		vs.Blockchain.Notify(b.Block)
+	}
+
+	if err := vs.pbft.NewHeight(vs.HeadBkSeq(), vs.Now().Unix()); err != nil {
+		logger.Errorf("Reset pbft view failed: %v", err)
+	}
+	return nil
+}
+
+// reinjectTxns returns the transactions of the reverted blocks which are not in the
+// executed blocks to the unconfirmed pool, and removes the pooled transactions
+// conflicting with the executed blocks
+func (vs *Visor) reinjectTxns(reverted, executed []coin.SignedBlock) {
+	confirmed := make(map[cipher.SHA256]struct{})
+	for _, b := range executed {
+		for _, h := range txnHashes(b.Body.Transactions) {
+			confirmed[h] = struct{}{}
+		}
+	}
+
+	// parents are injected before their children
+	for _, b := range reverted {
+		for _, txn := range b.Body.Transactions {
+			if _, ok := confirmed[txn.Hash()]; ok {
+				continue
+			}
+
+			if _, _, err := vs.InjectTransaction(txn); err != nil {
+				logger.Infof("Drop txn %s of reverted block %d: %v", txn.Hash().Hex(), b.Seq(), err)
+			}
+		}
+	}
+
+	removed, err := vs.RemoveInvalidUnconfirmed()
+	if err != nil {
+		logger.Errorf("Remove invalid unconfirmed txns failed: %v", err)
+		return
+	}
+	if len(removed) > 0 {
+		logger.Infof("Removed %d txns conflicting with the new branch from pool", len(removed))
+	}
+}
+
+func txnHashes(txns coin.Transactions) []cipher.SHA256 {
+	hashes := make([]cipher.SHA256, 0, len(txns))
+	for _, txn := range txns {
+		hashes = append(hashes, txn.Hash())
+	}
+	return hashes
+}
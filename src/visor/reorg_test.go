@@ -0,0 +1,242 @@
+package visor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/consensus/dpos"
+	"github.com/samoslab/samos/src/testutil"
+	"github.com/samoslab/samos/src/util/utc"
+	"github.com/samoslab/samos/src/visor/historydb"
+)
+
+// makeSideBlock creates a block following parent, dt seconds after it, signed by key
+func makeSideBlock(t *testing.T, parent coin.SignedBlock, uxHash cipher.SHA256, dt uint64, key cipher.SecKey, txns ...coin.Transaction) coin.SignedBlock {
+	b, err := coin.NewBlock(parent.Block, parent.Time()+dt, uxHash, coin.Transactions(txns), feeCalc)
+	require.NoError(t, err)
+	return coin.SignedBlock{
+		Block: *b,
+		Sig:   cipher.SignHash(b.HashHeader(), key),
+	}
+}
+
+// nextUxHash returns the unspent outputs hash after the txn is executed in the block
+func nextUxHash(uxHash cipher.SHA256, head coin.BlockHeader, spent coin.UxArray, txn coin.Transaction) cipher.SHA256 {
+	for _, ux := range spent {
+		uxHash = uxHash.Xor(ux.SnapshotHash())
+	}
+	for _, ux := range coin.CreateUnspents(head, txn) {
+		uxHash = uxHash.Xor(ux.SnapshotHash())
+	}
+	return uxHash
+}
+
+func TestReorganize(t *testing.T) {
+	clock := utc.NewMockClock(time.Unix(int64(genTime)+3600, 0))
+
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+	v := newSnapshotVisor(t, db, clock)
+	v.Config.IsMaster = true
+	v.Config.BlockchainSeckey = genSecret
+	v.Config.BlockchainTrustSeckey = genSecret
+	v.Config.AgreeNum = 1
+	v.Config.ProducerNum = 1
+
+	// three trust nodes take turns to make the blocks
+	trustKeys := map[cipher.PubKey]cipher.SecKey{genPublic: genSecret}
+	validators := []cipher.PubKey{genPublic}
+	for i := 0; i < 2; i++ {
+		pk, sk := cipher.GenerateKeyPair()
+		trustKeys[pk] = sk
+		validators = append(validators, pk)
+	}
+	v.Config.TrustPubkeyList = validators
+	require.NoError(t, v.dpos.SetTrustNode(validators))
+
+	history := v.history.(*historydb.HistoryDB)
+	v.Blockchain.BindListener(func(b coin.Block) {
+		require.NoError(t, history.ParseBlock(&b))
+	})
+
+	gb := addGenesisBlock(t, v.Blockchain)
+	require.NoError(t, history.ParseBlock(&gb.Block))
+	require.NoError(t, v.loadEpochSeed())
+
+	keys := []cipher.SecKey{genSecret}
+	otherAddr := testutil.MakeAddress()
+
+	producer := func(parent coin.SignedBlock, dt uint64) cipher.PubKey {
+		pk, err := v.dpos.GetValidator(&parent, int64(parent.Time()+dt))
+		require.NoError(t, err)
+		return pk
+	}
+
+	// the blocks of the active chain are made in the slots of the master
+	executeBlock := func(txns ...coin.Transaction) coin.SignedBlock {
+		for _, txn := range txns {
+			_, _, err := v.InjectTransaction(txn)
+			require.NoError(t, err)
+		}
+		head, err := v.Blockchain.Head()
+		require.NoError(t, err)
+		clock.Advance(time.Minute)
+		for producer(*head, uint64(clock.Now().Unix())-head.Time()) != genPublic {
+			clock.Advance(10 * time.Second)
+		}
+		sb, err := v.CreateAndExecuteBlock()
+		require.NoError(t, err)
+		require.NoError(t, v.StartExecuteSignedBlock(sb.HashHeader()))
+		b, err := v.GetBlockBySeq(v.HeadBkSeq())
+		require.NoError(t, err)
+		require.Equal(t, sb.HashHeader(), b.HashHeader())
+		return *b
+	}
+
+	// block 1 splits the genesis output in two
+	txn1 := makeSpendTx(t, coin.CreateUnspents(gb.Head, gb.Body.Transactions[0]), keys, genAddress, genCoins/4)
+	b1 := executeBlock(txn1)
+	uxs1 := coin.CreateUnspents(b1.Head, txn1)
+	require.Len(t, uxs1, 2)
+	uxHash1 := v.Blockchain.Unspent().GetUxHash()
+
+	// block 2 spends both outputs
+	txn2 := makeSpendTx(t, uxs1[:1], keys, genAddress, uxs1[0].Body.Coins)
+	txn2b := makeSpendTx(t, uxs1[1:], keys, genAddress, uxs1[1].Body.Coins)
+	b2 := executeBlock(txn2, txn2b)
+	require.Len(t, b2.Body.Transactions, 2)
+	uxHash2 := v.Blockchain.Unspent().GetUxHash()
+
+	// a side block signed by as many trust nodes is stored, the active chain is kept.
+	// It votes for a trust node with the outputs to the genesis address
+	txn3 := makeSpendTx(t, uxs1[:1], keys, genAddress, uxs1[0].Body.Coins/5)
+	txn3.Sigs = nil
+	dpos.PushVote(&txn3, validators[1], genSecret)
+	txn3.SignInputs(keys)
+	txn3.UpdateHeader()
+	p2 := producer(b1, 30)
+	side2 := makeSideBlock(t, b1, uxHash1, 30, trustKeys[p2], txn3)
+	require.NoError(t, v.ExecuteSignedBlock(side2))
+	require.Equal(t, uint64(2), v.HeadBkSeq())
+	require.Equal(t, uxHash2, v.Blockchain.Unspent().GetUxHash())
+	b, err := v.GetBlockBySeq(2)
+	require.NoError(t, err)
+	require.Equal(t, b2.HashHeader(), b.HashHeader())
+	sb, err := v.Blockchain.GetBlockByHash(side2.HashHeader())
+	require.NoError(t, err)
+	require.NotNil(t, sb)
+
+	// a branch signed by more trust nodes with a block not made by the producer of its
+	// slot does not outweigh the active chain, the block is stored without a reorganization
+	uxs3 := coin.CreateUnspents(side2.Head, txn3)
+	uxHash3 := nextUxHash(uxHash1, side2.Head, uxs1[:1], txn3)
+	txn4 := makeSpendTx(t, uxs3, []cipher.SecKey{genSecret, genSecret}, otherAddr, uxs3[0].Body.Coins)
+	var other cipher.PubKey
+	for _, pk := range validators {
+		if pk != p2 && pk != producer(side2, 10) {
+			other = pk
+		}
+	}
+	unscheduled := makeSideBlock(t, side2, uxHash3, 10, trustKeys[other], txn4)
+	require.NoError(t, v.ExecuteSignedBlock(unscheduled))
+	require.Equal(t, uint64(2), v.HeadBkSeq())
+	require.Equal(t, uxHash2, v.Blockchain.Unspent().GetUxHash())
+	sb, err = v.Blockchain.GetBlockByHash(unscheduled.HashHeader())
+	require.NoError(t, err)
+	require.NotNil(t, sb)
+	votes, err := v.trustNode.GetVotes()
+	require.NoError(t, err)
+	require.Empty(t, votes)
+
+	// a branch starting a new epoch signed by more trust nodes replaces the active chain,
+	// the first block of the epoch elects the producers of the next one
+	dt := uint64(v.dpos.Intervals().Epoch)
+	for producer(side2, dt) == p2 {
+		dt += uint64(v.dpos.Intervals().Block)
+	}
+	epochBlock := makeSideBlock(t, side2, uxHash3, dt, trustKeys[producer(side2, dt)], txn4)
+	require.NoError(t, v.ExecuteSignedBlock(epochBlock))
+	require.Equal(t, uint64(3), v.HeadBkSeq())
+	for _, sb := range []coin.SignedBlock{b1, side2, epochBlock} {
+		b, err := v.GetBlockBySeq(sb.Seq())
+		require.NoError(t, err)
+		require.Equal(t, sb.HashHeader(), b.HashHeader())
+	}
+	require.Equal(t, nextUxHash(uxHash3, epochBlock.Head, uxs3, txn4), v.Blockchain.Unspent().GetUxHash())
+
+	votes, err = v.trustNode.GetVotes()
+	require.NoError(t, err)
+	require.Len(t, votes, 1)
+	require.Equal(t, txn3.Hash(), votes[0].TxID)
+	iv := v.dpos.Intervals()
+	nextEpoch := iv.NextEpoch(iv.PrevSlot(int64(epochBlock.Time())))
+	elections, err := v.trustNode.GetElections()
+	require.NoError(t, err)
+	require.Equal(t, []dpos.Election{{Epoch: nextEpoch, Producers: validators[1:2]}}, elections)
+	require.Equal(t, validators[1:2], v.dpos.ProducersAt(nextEpoch))
+
+	// the txn conflicting with the branch is dropped, the other one returns to the pool
+	_, ok := v.Unconfirmed.Get(txn2.Hash())
+	require.False(t, ok)
+	_, ok = v.Unconfirmed.Get(txn2b.Hash())
+	require.True(t, ok)
+
+	// the history follows the new branch
+	htxn, err := history.GetTransaction(txn2.Hash())
+	require.NoError(t, err)
+	require.Nil(t, htxn)
+	htxn, err = history.GetTransaction(txn3.Hash())
+	require.NoError(t, err)
+	require.NotNil(t, htxn)
+	require.Equal(t, uint64(2), htxn.BlockSeq)
+	ux, err := history.GetUxout(uxs1[0].Hash())
+	require.NoError(t, err)
+	require.Equal(t, txn3.Hash(), ux.SpentTxID)
+	ux, err = history.GetUxout(uxs1[1].Hash())
+	require.NoError(t, err)
+	require.Equal(t, cipher.SHA256{}, ux.SpentTxID)
+
+	// a certified block wins over a branch signed by more trust nodes, the vote
+	// and the election of the reverted blocks are reverted
+	ok, err = v.AddCertificate(makeCertificate(b2.HashHeader()))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, uint64(2), v.HeadBkSeq())
+	require.Equal(t, uint64(2), v.FinalizedSeq())
+	require.Equal(t, uxHash2, v.Blockchain.Unspent().GetUxHash())
+	b, err = v.GetBlockBySeq(2)
+	require.NoError(t, err)
+	require.Equal(t, b2.HashHeader(), b.HashHeader())
+	b, err = v.GetBlockBySeq(3)
+	require.NoError(t, err)
+	require.Nil(t, b)
+
+	votes, err = v.trustNode.GetVotes()
+	require.NoError(t, err)
+	require.Empty(t, votes)
+	elections, err = v.trustNode.GetElections()
+	require.NoError(t, err)
+	require.Empty(t, elections)
+	require.Equal(t, validators, v.dpos.ProducersAt(nextEpoch))
+
+	for _, txn := range []coin.Transaction{txn2b, txn3, txn4} {
+		_, ok := v.Unconfirmed.Get(txn.Hash())
+		require.False(t, ok)
+	}
+
+	htxn, err = history.GetTransaction(txn3.Hash())
+	require.NoError(t, err)
+	require.Nil(t, htxn)
+	htxn, err = history.GetTransaction(txn2.Hash())
+	require.NoError(t, err)
+	require.NotNil(t, htxn)
+
+	// the finalized block is never reverted
+	require.Equal(t, ErrFinalizedReorg, v.ExecuteSignedBlock(epochBlock))
+	require.Equal(t, ErrFinalizedReorg, v.ExecuteSignedBlock(side2))
+	require.Equal(t, uint64(2), v.HeadBkSeq())
+}
@@ -0,0 +1,411 @@
+package visor
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/cipher/encoder"
+	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/consensus/dpos"
+	"github.com/samoslab/samos/src/consensus/pbft"
+	"github.com/samoslab/samos/src/visor/blockdb"
+)
+
+var (
+	// ErrSnapshotNotTrusted is returned if the snapshot is not signed by a trust node of the config
+	ErrSnapshotNotTrusted = errors.New("snapshot is not signed by a trust node")
+	// ErrSnapshotUxHash is returned if the unspent outputs of the snapshot do not match its block
+	ErrSnapshotUxHash = errors.New("snapshot unspent outputs do not match the UxHash of the block")
+	// ErrSnapshotCheckpoint is returned if the snapshot block is not the configured checkpoint
+	ErrSnapshotCheckpoint = errors.New("snapshot block does not match the checkpoint")
+	// ErrSnapshotNoCheckpoint is returned if no checkpoint is configured to verify the snapshot,
+	// a single trust node could sign the state of any block otherwise
+	ErrSnapshotNoCheckpoint = errors.New("snapshot hash of the checkpoint block is not configured")
+	// ErrSnapshotHistoryBehind is returned when exporting before the history is parsed to the head block
+	ErrSnapshotHistoryBehind = errors.New("history is not parsed to the head block")
+)
+
+// Snapshot is the state a node starts from instead of replaying the blockchain
+// from the genesis block. The blocks below it are back-filled afterwards.
+type Snapshot struct {
+	// Parent of the block, the chain starts from it
+	Parent coin.SignedBlock
+	// Block is finalized by the certificate, it is executed on top of the parent
+	Block       coin.SignedBlock
+	Certificate pbft.Certificate
+	// Unspent outputs after the parent, checked by the UxHash of the block
+	Unspents coin.UxArray
+	// Consensus state executed up to the parent
+	ValidatorChanges []dpos.ValidatorChange
+	Votes            []dpos.Vote
+	Elections        []dpos.Election
+	Exclusions       []dpos.Exclusion
+	// Epoch of the parent and the seed of its producer order
+	Epoch     int64
+	EpochSeed cipher.SHA256
+	// Trust node exporting the snapshot
+	PubKey cipher.PubKey
+	Sig    cipher.Sig
+}
+
+// Hash returns the hash the exporter signs
+func (s Snapshot) Hash() cipher.SHA256 {
+	s.Sig = cipher.Sig{}
+	return cipher.SumSHA256(encoder.Serialize(s))
+}
+
+// Serialize encodes the snapshot
+func (s Snapshot) Serialize() []byte {
+	return encoder.Serialize(s)
+}
+
+// DeserializeSnapshot decodes a snapshot
+func DeserializeSnapshot(b []byte) (*Snapshot, error) {
+	var s Snapshot
+	if err := encoder.DeserializeRaw(b, &s); err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %v", err)
+	}
+	return &s, nil
+}
+
+// Verify checks the snapshot block is the checkpoint, the snapshot is signed by one of
+// the trust nodes, its block follows the parent and the unspent outputs match the block.
+// The signatures of the blocks depend on the validators of the snapshot and are checked
+// once they are loaded
+func (s Snapshot) Verify(trusted []cipher.PubKey, checkpoint cipher.SHA256) error {
+	if checkpoint == (cipher.SHA256{}) {
+		return ErrSnapshotNoCheckpoint
+	}
+
+	hash := s.Block.HashHeader()
+	if checkpoint != hash {
+		return ErrSnapshotCheckpoint
+	}
+
+	if s.Block.Seq() != s.Parent.Seq()+1 || s.Block.Head.PrevHash != s.Parent.HashHeader() {
+		return errors.New("snapshot block does not follow its parent")
+	}
+
+	if s.Certificate.Hash != hash {
+		return errors.New("certificate is not for the snapshot block")
+	}
+
+	if blockdb.UxHash(s.Unspents) != s.Block.Head.UxHash {
+		return ErrSnapshotUxHash
+	}
+
+	for _, pubkey := range trusted {
+		if pubkey == s.PubKey {
+			return cipher.VerifySignature(s.PubKey, s.Sig, s.Hash())
+		}
+	}
+	return ErrSnapshotNotTrusted
+}
+
+// ExportSnapshot exports the state at the finalized block signed by the trust node,
+// the blocks executed after it are reverted with the parsed history
+func (vs *Visor) ExportSnapshot() (*Snapshot, error) {
+	if !vs.Config.IsMaster {
+		return nil, errors.New("only a trust node can export a snapshot")
+	}
+
+	seq := vs.Blockchain.FinalizedSeq()
+	if seq < 3 {
+		return nil, errors.New("no finalized block to export")
+	}
+
+	headSeq := vs.Blockchain.HeadSeq()
+	if vs.history.ParsedHeight() < int64(headSeq) {
+		return nil, ErrSnapshotHistoryBehind
+	}
+
+	parent, err := vs.Blockchain.GetBlockBySeq(seq - 1)
+	if err != nil {
+		return nil, err
+	}
+	b, err := vs.Blockchain.GetBlockBySeq(seq)
+	if err != nil {
+		return nil, err
+	}
+	if parent == nil || b == nil {
+		return nil, fmt.Errorf("block %d not found", seq)
+	}
+
+	cert, ok, err := vs.Blockchain.GetCertificate(b.HashHeader())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("block %d has no certificate", seq)
+	}
+
+	uxs, txids, err := vs.revertUnspents(seq, headSeq)
+	if err != nil {
+		return nil, err
+	}
+	if blockdb.UxHash(uxs) != b.Head.UxHash {
+		return nil, ErrSnapshotUxHash
+	}
+
+	s := &Snapshot{
+		Parent:      *parent,
+		Block:       *b,
+		Certificate: *cert,
+		Unspents:    uxs,
+		PubKey:      vs.Config.BlockchainTrustPubkey,
+	}
+
+	// the consensus state of the reverted blocks is left out
+	changes, err := vs.trustNode.GetValidatorChanges()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range changes {
+		if !txids[c.TxID] {
+			s.ValidatorChanges = append(s.ValidatorChanges, c)
+		}
+	}
+
+	votes, err := vs.trustNode.GetVotes()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range votes {
+		if !txids[v.TxID] {
+			s.Votes = append(s.Votes, v)
+		}
+	}
+
+	exclusions, err := vs.trustNode.GetExclusions()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range exclusions {
+		if !txids[e.TxID] {
+			s.Exclusions = append(s.Exclusions, e)
+		}
+	}
+
+	// the block starting an epoch elects the producers of the next one
+	iv := vs.dpos.Intervals()
+	next := iv.NextEpoch(iv.PrevSlot(int64(parent.Time())))
+	elections, err := vs.trustNode.GetElections()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range elections {
+		if e.Epoch <= next {
+			s.Elections = append(s.Elections, e)
+		}
+	}
+
+	s.Epoch, s.EpochSeed, err = vs.epochSeedOf(parent)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Sig = cipher.SignHash(s.Hash(), vs.Config.BlockchainTrustSeckey)
+	return s, nil
+}
+
+// revertUnspents returns the unspent outputs before the block of seq, reverting the blocks
+// from the head down to it, and the transactions of the reverted blocks
+func (vs *Visor) revertUnspents(seq, headSeq uint64) (coin.UxArray, map[cipher.SHA256]bool, error) {
+	all, err := vs.Blockchain.Unspent().GetAll()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pool := make(map[cipher.SHA256]coin.UxOut, len(all))
+	for _, ux := range all {
+		pool[ux.Hash()] = ux
+	}
+
+	txids := make(map[cipher.SHA256]bool)
+	for i := headSeq; i >= seq; i-- {
+		b, err := vs.Blockchain.GetBlockBySeq(i)
+		if err != nil {
+			return nil, nil, err
+		}
+		if b == nil {
+			return nil, nil, fmt.Errorf("block %d not found", i)
+		}
+
+		txns := b.Body.Transactions
+		for j := len(txns) - 1; j >= 0; j-- {
+			txids[txns[j].Hash()] = true
+
+			for _, ux := range coin.CreateUnspents(b.Head, txns[j]) {
+				delete(pool, ux.Hash())
+			}
+
+			for _, in := range txns[j].In {
+				ux, err := vs.history.GetUxout(in)
+				if err != nil {
+					return nil, nil, err
+				}
+				if ux == nil {
+					return nil, nil, fmt.Errorf("spent output %s not found in history", in.Hex())
+				}
+				pool[in] = ux.Out
+			}
+		}
+	}
+
+	uxs := make(coin.UxArray, 0, len(pool))
+	for _, ux := range pool {
+		uxs = append(uxs, ux)
+	}
+	sort.Slice(uxs, func(i, j int) bool {
+		return uxs[i].Hash().Hex() < uxs[j].Hash().Hex()
+	})
+	return uxs, txids, nil
+}
+
+// maybeImportSnapshot starts the blockchain from the snapshot file of the config,
+// unless the chain has more than the genesis block
+func (vs *Visor) maybeImportSnapshot() error {
+	if vs.Config.SnapshotPath == "" {
+		return nil
+	}
+
+	if vs.Blockchain.HeadSeq() > 0 {
+		logger.Infof("Blockchain has %d blocks, ignoring snapshot %s", vs.Blockchain.Len(), vs.Config.SnapshotPath)
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(vs.Config.SnapshotPath)
+	if err != nil {
+		return err
+	}
+
+	s, err := DeserializeSnapshot(b)
+	if err != nil {
+		return err
+	}
+
+	if err := vs.ImportSnapshot(*s); err != nil {
+		return fmt.Errorf("import snapshot %s failed: %v", vs.Config.SnapshotPath, err)
+	}
+
+	logger.Infof("Started blockchain from snapshot of block %d, back-filling the blocks below it", s.Block.Seq())
+	return nil
+}
+
+// ImportSnapshot starts the blockchain from the snapshot, the chain must have the genesis
+// block only. The consensus state is loaded before the blocks are verified, the visor
+// must not be used if it fails
+func (vs *Visor) ImportSnapshot(s Snapshot) error {
+	if vs.Blockchain.Len() != 1 {
+		return errors.New("snapshot can only start a chain with the genesis block only")
+	}
+
+	if err := s.Verify(vs.Config.TrustPubkeyList, vs.Config.SnapshotHash); err != nil {
+		return err
+	}
+
+	for _, c := range s.ValidatorChanges {
+		vs.dpos.AddValidatorChange(c)
+	}
+	for _, e := range s.Elections {
+		vs.dpos.AddElection(e)
+	}
+	for _, e := range s.Exclusions {
+		vs.dpos.AddExclusion(e)
+	}
+	vs.dpos.SetEpochSeed(s.Epoch, s.EpochSeed)
+
+	if err := vs.VerifyBlockHeader(s.Parent.SignedHeader()); err != nil {
+		return err
+	}
+	if err := vs.verifyCertificate(s.Block, s.Certificate); err != nil {
+		return err
+	}
+
+	if err := vs.db.Update(func(tx *bolt.Tx) error {
+		cp := blockdb.Checkpoint{
+			Epoch:     s.Epoch,
+			EpochSeed: s.EpochSeed,
+		}
+		if err := vs.Blockchain.AddCheckpointWithTx(tx, &s.Parent, s.Unspents, cp); err != nil {
+			return err
+		}
+
+		for _, c := range s.ValidatorChanges {
+			if err := vs.trustNode.AddValidatorChangeWithTx(tx, c); err != nil {
+				return err
+			}
+		}
+
+		for _, v := range s.Votes {
+			if err := vs.trustNode.AddVoteWithTx(tx, v); err != nil {
+				return err
+			}
+		}
+
+		for _, e := range s.Elections {
+			if err := vs.trustNode.AddElectionWithTx(tx, e); err != nil {
+				return err
+			}
+		}
+
+		for _, e := range s.Exclusions {
+			if err := vs.trustNode.AddExclusionWithTx(tx, e); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	return vs.executeSignedBlock(s.Block, &s.Certificate)
+}
+
+// BackfillBlocks stores the blocks below the lowest block of a chain started from a snapshot,
+// each one must be the parent of the lowest block. Blocks not following the lowest block
+// are ignored, returns the number of blocks stored
+func (vs *Visor) BackfillBlocks(blocks []coin.SignedBlock) (int, error) {
+	cp := vs.Blockchain.Checkpoint()
+	if cp == nil {
+		return 0, nil
+	}
+
+	bs := make([]coin.SignedBlock, len(blocks))
+	copy(bs, blocks)
+	sort.Slice(bs, func(i, j int) bool {
+		return bs[i].Seq() > bs[j].Seq()
+	})
+
+	n := 0
+	lowest := cp.Lowest
+	for i := range bs {
+		b := bs[i]
+		if b.Seq() >= lowest {
+			continue
+		}
+		if b.Seq()+1 != lowest {
+			break
+		}
+
+		if err := vs.db.Update(func(tx *bolt.Tx) error {
+			return vs.Blockchain.AddBackfillBlockWithTx(tx, &b)
+		}); err != nil {
+			return n, err
+		}
+		n++
+		lowest = b.Seq()
+	}
+
+	if n > 0 && vs.Blockchain.Checkpoint() == nil {
+		logger.Info("Back-filled all the blocks below the snapshot")
+		vs.bcParser.Sync()
+	}
+	return n, nil
+}
@@ -0,0 +1,169 @@
+package visor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/consensus/dpos"
+	"github.com/samoslab/samos/src/consensus/pbft"
+	"github.com/samoslab/samos/src/testutil"
+	"github.com/samoslab/samos/src/util/utc"
+	"github.com/samoslab/samos/src/visor/blockdb"
+	"github.com/samoslab/samos/src/visor/historydb"
+)
+
+func newSnapshotVisor(t *testing.T, db *bolt.DB, clock utc.Clock) *Visor {
+	db, bc, err := loadBlockchain(db, []cipher.PubKey{genPublic}, false)
+	require.NoError(t, err)
+
+	cfg := NewVisorConfig()
+	cfg.BlockchainPubkey = genPublic
+	cfg.BlockchainTrustPubkey = genPublic
+	cfg.TrustPubkeyList = []cipher.PubKey{genPublic}
+	cfg.Clock = clock
+	d := dpos.NewDpos(genPublic)
+	require.NoError(t, d.SetTrustNode(cfg.TrustPubkeyList))
+	tn, err := blockdb.NewTrustNode(db)
+	require.NoError(t, err)
+	history, err := historydb.New(db)
+	require.NoError(t, err)
+
+	return &Visor{
+		Config:      cfg,
+		Unconfirmed: NewUnconfirmedTxnPool(db),
+		Blockchain:  bc,
+		db:          db,
+		pbft:        pbft.NewPBFT(),
+		dpos:        d,
+		trustNode:   tn,
+		history:     history,
+		bcParser:    NewBlockchainParser(history, bc),
+	}
+}
+
+func makeCertificate(hash cipher.SHA256) pbft.Certificate {
+	return pbft.Certificate{
+		Hash: hash,
+		Commits: []pbft.CommitSig{
+			{View: 0, Sig: cipher.SignHash(pbft.CommitHash(hash, 0), genSecret)},
+		},
+	}
+}
+
+func TestSnapshotExportImport(t *testing.T) {
+	clock := utc.NewMockClock(time.Unix(int64(genTime)+3600, 0))
+
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+	v := newSnapshotVisor(t, db, clock)
+	v.Config.IsMaster = true
+	v.Config.BlockchainSeckey = genSecret
+	v.Config.BlockchainTrustSeckey = genSecret
+
+	// parse the history as the blocks are executed
+	history := v.history.(*historydb.HistoryDB)
+	v.Blockchain.BindListener(func(b coin.Block) {
+		require.NoError(t, history.ParseBlock(&b))
+	})
+
+	gb := addGenesisBlock(t, v.Blockchain)
+	require.NoError(t, history.ParseBlock(&gb.Block))
+
+	_, err := v.ExportSnapshot()
+	testutil.RequireError(t, err, "no finalized block to export")
+
+	// blocks 1 to 4 are finalized, block 5 is not
+	uxs := coin.CreateUnspents(gb.Head, gb.Body.Transactions[0])
+	for i := 0; i < 5; i++ {
+		txn := makeSpendTx(t, uxs, []cipher.SecKey{genSecret}, genAddress, genCoins)
+		_, _, err := v.InjectTransaction(txn)
+		require.NoError(t, err)
+
+		clock.Advance(time.Minute)
+		sb, err := v.CreateAndExecuteBlock()
+		require.NoError(t, err)
+		require.NoError(t, v.StartExecuteSignedBlock(sb.HashHeader()))
+		if i < 4 {
+			ok, err := v.AddCertificate(makeCertificate(sb.HashHeader()))
+			require.NoError(t, err)
+			require.True(t, ok)
+		}
+		uxs = coin.CreateUnspents(sb.Head, txn)
+	}
+	require.Equal(t, uint64(5), v.HeadBkSeq())
+	require.Equal(t, uint64(4), v.FinalizedSeq())
+
+	s, err := v.ExportSnapshot()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), s.Parent.Seq())
+	require.Equal(t, uint64(4), s.Block.Seq())
+	require.Equal(t, s.Block.HashHeader(), s.Certificate.Hash)
+	require.Len(t, s.Unspents, 1)
+	require.NoError(t, s.Verify([]cipher.PubKey{genPublic}, s.Block.HashHeader()))
+
+	t.Run("verify", func(t *testing.T) {
+		require.Equal(t, ErrSnapshotCheckpoint, s.Verify([]cipher.PubKey{genPublic}, s.Parent.HashHeader()))
+
+		require.Equal(t, ErrSnapshotNoCheckpoint, s.Verify([]cipher.PubKey{genPublic}, cipher.SHA256{}))
+
+		hash := s.Block.HashHeader()
+		pubkey, _ := cipher.GenerateKeyPair()
+		require.Equal(t, ErrSnapshotNotTrusted, s.Verify([]cipher.PubKey{pubkey}, hash))
+
+		bad := *s
+		bad.Unspents = nil
+		require.Equal(t, ErrSnapshotUxHash, bad.Verify([]cipher.PubKey{genPublic}, hash))
+
+		bad = *s
+		bad.Epoch++
+		require.Error(t, bad.Verify([]cipher.PubKey{genPublic}, hash))
+	})
+
+	db2, shutdown2 := testutil.PrepareDB(t)
+	defer shutdown2()
+	v2 := newSnapshotVisor(t, db2, clock)
+	addGenesisBlock(t, v2.Blockchain)
+
+	ds, err := DeserializeSnapshot(s.Serialize())
+	require.NoError(t, err)
+	require.Equal(t, ErrSnapshotNoCheckpoint, v2.ImportSnapshot(*ds))
+	v2.Config.SnapshotHash = s.Block.HashHeader()
+	require.NoError(t, v2.ImportSnapshot(*ds))
+	require.Equal(t, uint64(4), v2.HeadBkSeq())
+	require.Equal(t, uint64(4), v2.FinalizedSeq())
+	require.Equal(t, uint64(3), v2.Blockchain.Checkpoint().Lowest)
+
+	// the block after the snapshot is executed on top of it
+	b5 := v.GetBlocks(5, 6)
+	require.Len(t, b5, 1)
+	require.NoError(t, v2.ExecuteSignedBlock(b5[0]))
+	require.Equal(t, v.Blockchain.Unspent().GetUxHash(), v2.Blockchain.Unspent().GetUxHash())
+
+	// a block which is not the parent of the lowest block is ignored
+	blocks := v.GetBlocks(1, 3)
+	n, err := v2.BackfillBlocks(blocks[:1])
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	forged := blocks[1]
+	forged.Body.Transactions = nil
+	_, err = v2.BackfillBlocks([]coin.SignedBlock{forged})
+	require.Equal(t, blockdb.ErrBackfillMismatch, err)
+
+	n, err = v2.BackfillBlocks(blocks)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Nil(t, v2.Blockchain.Checkpoint())
+
+	for i := uint64(1); i <= 5; i++ {
+		b, err := v2.GetBlockBySeq(i)
+		require.NoError(t, err)
+		require.NotNil(t, b)
+		require.Equal(t, v.GetBlocks(i, i+1)[0].HashHeader(), b.HashHeader())
+	}
+}
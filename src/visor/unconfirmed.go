@@ -1,7 +1,11 @@
 package visor
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"math"
+	"sort"
 	"time"
 
 	"github.com/boltdb/bolt"
@@ -9,11 +13,26 @@ import (
 	"github.com/samoslab/samos/src/cipher"
 	"github.com/samoslab/samos/src/cipher/encoder"
 	"github.com/samoslab/samos/src/coin"
+	"github.com/samoslab/samos/src/util/fee"
 	"github.com/samoslab/samos/src/util/utc"
 	"github.com/samoslab/samos/src/visor/blockdb"
 	"github.com/samoslab/samos/src/visor/bucket"
 )
 
+var (
+	// ErrTxnBelowMinRelayFee is returned when a transaction pays less than the minimum relay fee of the pool
+	ErrTxnBelowMinRelayFee = errors.New("Transaction fee per kB is below the minimum relay fee")
+	// ErrUnconfirmedPoolFull is returned when the pool is full of transactions that pay at least as much
+	// as the transaction being injected
+	ErrUnconfirmedPoolFull = errors.New("Unconfirmed pool is full of transactions paying a higher fee")
+	// ErrTxnConflictsUnconfirmed is returned when an invalid transaction spends an input spent by an
+	// unconfirmed transaction, only valid transactions replace others
+	ErrTxnConflictsUnconfirmed = errors.New("Transaction spends an input of an unconfirmed transaction")
+	// ErrTxnReplacementFeeTooLow is returned when a transaction does not pay a higher fee than the
+	// unconfirmed transactions it would replace, by at least the minimum relay fee for its size
+	ErrTxnReplacementFeeTooLow = errors.New("Transaction fee is not higher than the fee of the transactions it replaces")
+)
+
 // TxnUnspents maps from coin.Transaction hash to its expected unspents.  The unspents'
 // Head can be different at execution time, but the Unspent's hash is fixed.
 type TxnUnspents map[cipher.SHA256]coin.UxArray
@@ -49,6 +68,45 @@ func (ut *UnconfirmedTxn) Hash() cipher.SHA256 {
 	return ut.Txn.Hash()
 }
 
+// UnconfirmedTxnFee is an unconfirmed transaction with the fee it pays.
+// The fee is calculated against the current unspent pool and the outputs of the
+// pooled transactions, it is 0 if the inputs of the transaction are no longer unspent
+type UnconfirmedTxnFee struct {
+	UnconfirmedTxn
+	Fee      uint64
+	Size     int
+	FeePerKB uint64
+	// Ancestors are the pooled transactions the transaction spends outputs of, directly or not.
+	// The transaction can only be included in a block once they are confirmed
+	Ancestors []cipher.SHA256
+	// Descendants are the pooled transactions spending outputs of the transaction, directly or not
+	Descendants []cipher.SHA256
+	// PackageFeePerKB is the highest fee per kB of the transaction or one of its descendants,
+	// together with their ancestors. A descendant paying a high fee raises the priority of its
+	// ancestors, which must be confirmed first
+	PackageFeePerKB uint64
+}
+
+// priorityLess returns true if the transaction is picked after b for a block,
+// invalid transactions come after valid ones, then by package fee per kB descending,
+// ancestors before their descendants, by fee per kB descending and by hash
+func (ut *UnconfirmedTxnFee) priorityLess(b *UnconfirmedTxnFee) bool {
+	if ut.IsValid != b.IsValid {
+		return ut.IsValid < b.IsValid
+	}
+	if ut.PackageFeePerKB != b.PackageFeePerKB {
+		return ut.PackageFeePerKB < b.PackageFeePerKB
+	}
+	if len(ut.Ancestors) != len(b.Ancestors) {
+		return len(ut.Ancestors) > len(b.Ancestors)
+	}
+	if ut.FeePerKB != b.FeePerKB {
+		return ut.FeePerKB < b.FeePerKB
+	}
+	h, bh := ut.Hash(), b.Hash()
+	return bytes.Compare(h[:], bh[:]) > 0
+}
+
 // unconfirmed transactions bucket
 type uncfmTxnBkt struct {
 	txns *bucket.Bucket
@@ -247,6 +305,17 @@ type UnconfirmedTxnPool struct {
 	// our future balance and avoid double spending our own coins
 	// Maps from Transaction.Hash() to UxArray.
 	unspent *txUnspents
+	// clock the received and checked times are read from, the utc clock if nil
+	clock utc.Clock
+	// maximum total size of the transactions in the pool in bytes, unlimited if 0
+	maxSize int
+	// minimum fee per kB of a transaction accepted to the pool
+	minFeePerKB uint64
+	// whether a transaction paying a higher fee replaces the transactions it conflicts with
+	replaceByFee bool
+	// transactions in the order they are picked for a block, built on first use
+	// and dropped when a block is executed or the pool is refreshed
+	index *feeIndex
 }
 
 // NewUnconfirmedTxnPool creates an UnconfirmedTxnPool instance
@@ -257,16 +326,38 @@ func NewUnconfirmedTxnPool(db *bolt.DB) *UnconfirmedTxnPool {
 	}
 }
 
+// SetClock sets the clock the received and checked times are read from
+func (utp *UnconfirmedTxnPool) SetClock(clock utc.Clock) {
+	utp.clock = clock
+}
+
+// SetLimits sets the maximum total size of the transactions in the pool, 0 for unlimited,
+// and the minimum fee per kB a transaction must pay to be accepted
+func (utp *UnconfirmedTxnPool) SetLimits(maxSize int, minFeePerKB uint64) {
+	utp.maxSize = maxSize
+	utp.minFeePerKB = minFeePerKB
+}
+
+// SetReplaceByFee enables replacing the transactions spending the inputs of a new
+// transaction which pays a higher fee
+func (utp *UnconfirmedTxnPool) SetReplaceByFee(enabled bool) {
+	utp.replaceByFee = enabled
+}
+
 // SetAnnounced updates announced time of specific tx
 func (utp *UnconfirmedTxnPool) SetAnnounced(h cipher.SHA256, t time.Time) error {
-	return utp.txns.update(h, func(tx *UnconfirmedTxn) {
+	announce := func(tx *UnconfirmedTxn) {
 		tx.Announced = t.UnixNano()
-	})
+	}
+	if utp.index != nil {
+		utp.index.update(h, announce)
+	}
+	return utp.txns.update(h, announce)
 }
 
 // Creates an unconfirmed transaction
 func (utp *UnconfirmedTxnPool) createUnconfirmedTxn(t coin.Transaction) UnconfirmedTxn {
-	now := utc.Now()
+	now := utc.NowFrom(utp.clock)
 	return UnconfirmedTxn{
 		Txn:       t,
 		Received:  now.UnixNano(),
@@ -277,14 +368,25 @@ func (utp *UnconfirmedTxnPool) createUnconfirmedTxn(t coin.Transaction) Unconfir
 
 // InjectTransaction adds a coin.Transaction to the pool, or updates an existing one's timestamps
 // Returns an error if txn is invalid, and whether the transaction already
-// existed in the pool.
+// existed in the pool. The transaction can spend outputs of the pooled transactions.
 // If the transaction violates hard constraints, it is rejected.
 // Soft constraints violations mark a txn as invalid, but the txn is inserted. The soft violation is returned.
+// A new transaction paying less than the minimum relay fee is rejected. If the pool is full, the
+// transactions picked last for a block are evicted to make room, as long as they are invalid or pay
+// a lower fee per kB than the new transaction, otherwise the new transaction is rejected.
+// With replace-by-fee enabled, a new transaction spending an input of transactions in the pool replaces
+// them and their descendants if it is valid and pays a higher fee than all of them, by at least the
+// minimum relay fee for its size, otherwise it is rejected.
 func (utp *UnconfirmedTxnPool) InjectTransaction(bc Blockchainer, t coin.Transaction, maxSize int) (bool, *ErrTxnViolatesSoftConstraint, error) {
+	idx, err := utp.feeIndex(bc)
+	if err != nil {
+		return false, nil, err
+	}
+
 	var isValid int8 = 1
 	var softErr *ErrTxnViolatesSoftConstraint
-	if err := bc.VerifySingleTxnAllConstraints(t, maxSize); err != nil {
-		logger.Warningf("bc.VerifySingleTxnAllConstraints failed for txn %s: %v", t.TxIDHex(), err)
+	if err := verifyPoolTxnAllConstraints(bc, idx.outputs, t, maxSize); err != nil {
+		logger.Warningf("verifyPoolTxnAllConstraints failed for txn %s: %v", t.TxIDHex(), err)
 		switch err.(type) {
 		case ErrTxnViolatesSoftConstraint:
 			e := err.(ErrTxnViolatesSoftConstraint)
@@ -300,41 +402,168 @@ func (utp *UnconfirmedTxnPool) InjectTransaction(bc Blockchainer, t coin.Transac
 	// Update if we already have this txn
 	h := t.Hash()
 	known := false
-	utp.txns.update(h, func(tx *UnconfirmedTxn) {
+	received := func(tx *UnconfirmedTxn) {
 		known = true
-		now := utc.Now().UnixNano()
+		now := utc.NowFrom(utp.clock).UnixNano()
 		tx.Received = now
 		tx.Checked = now
 		tx.IsValid = isValid
-	})
+	}
+	utp.txns.update(h, received)
 
 	if known {
+		idx.update(h, received)
 		return true, softErr, nil
 	}
 
 	utx := utp.createUnconfirmedTxn(t)
 	utx.IsValid = isValid
 
+	fee, err := txnFee(bc, idx.outputs, &t)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if coin.FeePerKB(fee, t.Size()) < utp.minFeePerKB {
+		return false, nil, ErrTxnBelowMinRelayFee
+	}
+
+	// relate the transaction to its pooled ancestors
+	utxFee := idx.entry(utx, fee)
+
+	replaced, err := utp.replacedBy(idx, &utxFee)
+	if err != nil {
+		return false, nil, err
+	}
+
+	evicted, err := utp.evictFor(idx, replaced, &utxFee)
+	if err != nil {
+		return false, nil, err
+	}
+
+	head, err := bc.Head()
+	if err != nil {
+		return false, nil, err
+	}
+	outputs := coin.CreateUnspents(head.Head, t)
+
 	if err := bc.UpdateDB(func(tx *bolt.Tx) error {
+		utp.removeTxnsWithTx(tx, replaced)
+		utp.removeTxnsWithTx(tx, evicted)
+
 		// add txn to index
 		if err := utp.txns.putWithTx(tx, &utx); err != nil {
 			return err
 		}
 
 		// update unconfirmed unspent
-		head, err := bc.Head()
-		if err != nil {
-			return err
-		}
-
-		return utp.unspent.putWithTx(tx, h, coin.CreateUnspents(head.Head, t))
+		return utp.unspent.putWithTx(tx, h, outputs)
 	}); err != nil {
 		return false, nil, err
 	}
 
+	idx.remove(replaced)
+	idx.remove(evicted)
+	idx.add(utxFee, outputs)
+
+	if len(replaced) > 0 {
+		logger.Infof("Replaced %d transactions of the unconfirmed pool by txn %s", len(replaced), t.TxIDHex())
+	}
+	if len(evicted) > 0 {
+		logger.Infof("Evicted %d transactions from the unconfirmed pool for txn %s", len(evicted), t.TxIDHex())
+	}
+
 	return false, softErr, nil
 }
 
+// replacedBy returns the pooled transactions spending the inputs of utx and their descendants,
+// which utx replaces. It fails if utx can not replace them. Without replace-by-fee, conflicting
+// transactions are kept in the pool and the block creation picks one of them
+func (utp *UnconfirmedTxnPool) replacedBy(idx *feeIndex, utx *UnconfirmedTxnFee) ([]cipher.SHA256, error) {
+	if !utp.replaceByFee {
+		return nil, nil
+	}
+
+	// a transaction spending an output of a replaced transaction is replaced too
+	replaced := idx.conflicts(&utx.Txn)
+	if len(replaced) == 0 {
+		return nil, nil
+	}
+
+	if utx.IsValid == 0 {
+		return nil, ErrTxnConflictsUnconfirmed
+	}
+
+	var fee uint64
+	for _, h := range replaced {
+		fee = addFees(fee, idx.txns[h].Fee)
+	}
+
+	// the increase pays for relaying the replacement, like a new transaction
+	if utx.Fee <= fee || coin.FeePerKB(utx.Fee-fee, utx.Size) < utp.minFeePerKB {
+		return nil, ErrTxnReplacementFeeTooLow
+	}
+
+	return replaced, nil
+}
+
+// evictFor returns the pooled transactions to evict to make room for utx in the pool,
+// the replaced transactions are removed first
+func (utp *UnconfirmedTxnPool) evictFor(idx *feeIndex, replaced []cipher.SHA256, utx *UnconfirmedTxnFee) ([]cipher.SHA256, error) {
+	if utp.maxSize <= 0 {
+		return nil, nil
+	}
+
+	if utx.Size > utp.maxSize {
+		return nil, ErrUnconfirmedPoolFull
+	}
+
+	size := idx.size + utx.Size
+	removed := make(map[cipher.SHA256]struct{}, len(replaced))
+	for _, h := range replaced {
+		removed[h] = struct{}{}
+		size -= idx.txns[h].Size
+	}
+
+	// the ancestors of utx are kept for it
+	ancestors := make(map[cipher.SHA256]struct{}, len(utx.Ancestors))
+	for _, h := range utx.Ancestors {
+		ancestors[h] = struct{}{}
+	}
+
+	var evicted []cipher.SHA256
+	for i := len(idx.sorted) - 1; i >= 0 && size > utp.maxSize; i-- {
+		t := idx.sorted[i]
+		h := t.Hash()
+		if _, ok := removed[h]; ok {
+			continue
+		}
+		if _, ok := ancestors[h]; ok {
+			continue
+		}
+
+		if !t.priorityLess(utx) {
+			return nil, ErrUnconfirmedPoolFull
+		}
+
+		// the descendants can not be confirmed without the evicted transaction
+		for _, eh := range append([]cipher.SHA256{h}, t.Descendants...) {
+			if _, ok := removed[eh]; ok {
+				continue
+			}
+			removed[eh] = struct{}{}
+			evicted = append(evicted, eh)
+			size -= idx.txns[eh].Size
+		}
+	}
+
+	if size > utp.maxSize {
+		return nil, ErrUnconfirmedPoolFull
+	}
+
+	return evicted, nil
+}
+
 // RawTxns returns underlying coin.Transactions
 func (utp *UnconfirmedTxnPool) RawTxns() coin.Transactions {
 	utxns, err := utp.txns.getAll()
@@ -349,9 +578,267 @@ func (utp *UnconfirmedTxnPool) RawTxns() coin.Transactions {
 	return txns
 }
 
+// GetTxnsByFee returns all transactions with their fees and the pooled transactions they are related
+// to, in the order they are picked for a block
+func (utp *UnconfirmedTxnPool) GetTxnsByFee(bc Blockchainer) ([]UnconfirmedTxnFee, error) {
+	idx, err := utp.feeIndex(bc)
+	if err != nil {
+		return nil, err
+	}
+	return idx.txnsByFee(), nil
+}
+
+// feeIndex returns the fee index of the pool, it is built if the pool changed since the last use
+func (utp *UnconfirmedTxnPool) feeIndex(bc Blockchainer) (*feeIndex, error) {
+	if utp.index != nil {
+		return utp.index, nil
+	}
+
+	txns, outputs, err := utp.txnsByFee(bc)
+	if err != nil {
+		return nil, err
+	}
+	utp.index = newFeeIndex(txns, outputs)
+	return utp.index, nil
+}
+
+// txnsByFee computes the fees and the packages of all transactions and sorts them,
+// outputs are the outputs of the pooled transactions
+func (utp *UnconfirmedTxnPool) txnsByFee(bc Blockchainer) ([]UnconfirmedTxnFee, map[cipher.SHA256]coin.UxOut, error) {
+	outputs, err := utp.poolOutputs()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var txns []UnconfirmedTxnFee
+	if err := utp.txns.forEach(func(_ cipher.SHA256, tx *UnconfirmedTxn) error {
+		fee, err := txnFee(bc, outputs, &tx.Txn)
+		if err != nil {
+			// the inputs are spent, the transaction is removed as invalid later
+			fee = 0
+		}
+
+		size := tx.Txn.Size()
+		txns = append(txns, UnconfirmedTxnFee{
+			UnconfirmedTxn: *tx,
+			Fee:            fee,
+			Size:           size,
+			FeePerKB:       coin.FeePerKB(fee, size),
+		})
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	setPackages(txns, outputs)
+
+	sort.Slice(txns, func(i, j int) bool {
+		return txns[j].priorityLess(&txns[i])
+	})
+
+	return txns, outputs, nil
+}
+
+// setPackages sets the ancestors, descendants and package fee per kB of txns,
+// outputs are the outputs of txns
+func setPackages(txns []UnconfirmedTxnFee, outputs map[cipher.SHA256]coin.UxOut) {
+	index := make(map[cipher.SHA256]int, len(txns))
+	for i := range txns {
+		index[txns[i].Hash()] = i
+	}
+
+	parents := make([][]int, len(txns))
+	for i := range txns {
+		for _, in := range txns[i].Txn.In {
+			ux, ok := outputs[in]
+			if !ok {
+				continue
+			}
+			if p, ok := index[ux.Body.SrcTransaction]; ok {
+				parents[i] = append(parents[i], p)
+			}
+		}
+	}
+
+	ancestors := make([]map[int]struct{}, len(txns))
+	var ancestorsOf func(i int) map[int]struct{}
+	ancestorsOf = func(i int) map[int]struct{} {
+		if ancestors[i] == nil {
+			anc := make(map[int]struct{})
+			for _, p := range parents[i] {
+				anc[p] = struct{}{}
+				for a := range ancestorsOf(p) {
+					anc[a] = struct{}{}
+				}
+			}
+			ancestors[i] = anc
+		}
+		return ancestors[i]
+	}
+
+	// the fee per kB of each transaction together with its ancestors
+	scores := make([]uint64, len(txns))
+	descendants := make([][]int, len(txns))
+	for i := range txns {
+		fee, size := txns[i].Fee, txns[i].Size
+		for a := range ancestorsOf(i) {
+			fee = addFees(fee, txns[a].Fee)
+			size += txns[a].Size
+			descendants[a] = append(descendants[a], i)
+		}
+		scores[i] = coin.FeePerKB(fee, size)
+	}
+
+	for i := range txns {
+		txns[i].Ancestors = sortedHashes(txns, ancestorsOf(i))
+		txns[i].PackageFeePerKB = scores[i]
+		desc := make(map[int]struct{}, len(descendants[i]))
+		for _, d := range descendants[i] {
+			desc[d] = struct{}{}
+			if scores[d] > txns[i].PackageFeePerKB {
+				txns[i].PackageFeePerKB = scores[d]
+			}
+		}
+		txns[i].Descendants = sortedHashes(txns, desc)
+	}
+}
+
+// sortedHashes returns the sorted hashes of the txns of the indexes
+func sortedHashes(txns []UnconfirmedTxnFee, indexes map[int]struct{}) []cipher.SHA256 {
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	hashes := make([]cipher.SHA256, 0, len(indexes))
+	for i := range indexes {
+		hashes = append(hashes, txns[i].Hash())
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+	return hashes
+}
+
+// addFees adds two fees, saturating at math.MaxUint64
+func addFees(a, b uint64) uint64 {
+	c, err := coin.AddUint64(a, b)
+	if err != nil {
+		return math.MaxUint64
+	}
+	return c
+}
+
+// poolOutputs returns the outputs of the pooled transactions by hash
+func (utp *UnconfirmedTxnPool) poolOutputs() (map[cipher.SHA256]coin.UxOut, error) {
+	outputs := make(map[cipher.SHA256]coin.UxOut)
+	if err := utp.unspent.forEach(func(_ cipher.SHA256, uxa coin.UxArray) {
+		for _, ux := range uxa {
+			outputs[ux.Hash()] = ux
+		}
+	}); err != nil {
+		return nil, err
+	}
+	return outputs, nil
+}
+
+// spendsPoolOutput returns true if txn spends an output of a pooled transaction
+func spendsPoolOutput(txn *coin.Transaction, outputs map[cipher.SHA256]coin.UxOut) bool {
+	for _, in := range txn.In {
+		if _, ok := outputs[in]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// txnInputs returns the outputs spent by txn, from the unspent pool of the blockchain or the
+// outputs of the pooled transactions. The latter accrue no coin hours as they are not confirmed
+func txnInputs(bc Blockchainer, outputs map[cipher.SHA256]coin.UxOut, headTime uint64, txn *coin.Transaction) (coin.UxArray, error) {
+	uxIn := make(coin.UxArray, len(txn.In))
+	for i, in := range txn.In {
+		if ux, ok := outputs[in]; ok {
+			ux.Head.Time = headTime
+			uxIn[i] = ux
+			continue
+		}
+
+		ux, ok := bc.Unspent().Get(in)
+		if !ok {
+			return nil, blockdb.NewErrUnspentNotExist(in.Hex())
+		}
+		uxIn[i] = ux
+	}
+	return uxIn, nil
+}
+
+// txnFee returns the fee of txn, it can spend outputs of the pooled transactions
+func txnFee(bc Blockchainer, outputs map[cipher.SHA256]coin.UxOut, txn *coin.Transaction) (uint64, error) {
+	if !spendsPoolOutput(txn, outputs) {
+		return bc.TransactionFee(txn)
+	}
+
+	headTime := bc.Time()
+	uxIn, err := txnInputs(bc, outputs, headTime, txn)
+	if err != nil {
+		return 0, err
+	}
+
+	return fee.TransactionFee(txn, headTime, uxIn)
+}
+
+// verifyPoolTxnAllConstraints checks txn like Blockchainer.VerifySingleTxnAllConstraints,
+// it can spend outputs of the pooled transactions
+func verifyPoolTxnAllConstraints(bc Blockchainer, outputs map[cipher.SHA256]coin.UxOut, txn coin.Transaction, maxSize int) error {
+	if !spendsPoolOutput(&txn, outputs) {
+		return bc.VerifySingleTxnAllConstraints(txn, maxSize)
+	}
+
+	head, uxIn, err := headAndInputs(bc, outputs, &txn)
+	if err != nil {
+		return err
+	}
+
+	// Hard constraints must be checked before soft constraints
+	if err := VerifySingleTxnHardConstraints(txn, head, uxIn); err != nil {
+		return err
+	}
+
+	return VerifySingleTxnSoftConstraints(txn, head.Time(), uxIn, maxSize)
+}
+
+// verifyPoolTxnHardConstraints checks txn like Blockchainer.VerifySingleTxnHardConstraints,
+// it can spend outputs of the pooled transactions
+func verifyPoolTxnHardConstraints(bc Blockchainer, outputs map[cipher.SHA256]coin.UxOut, txn coin.Transaction) error {
+	if !spendsPoolOutput(&txn, outputs) {
+		return bc.VerifySingleTxnHardConstraints(txn)
+	}
+
+	head, uxIn, err := headAndInputs(bc, outputs, &txn)
+	if err != nil {
+		return err
+	}
+
+	return VerifySingleTxnHardConstraints(txn, head, uxIn)
+}
+
+func headAndInputs(bc Blockchainer, outputs map[cipher.SHA256]coin.UxOut, txn *coin.Transaction) (*coin.SignedBlock, coin.UxArray, error) {
+	head, err := bc.Head()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	uxIn, err := txnInputs(bc, outputs, head.Time(), txn)
+	if err != nil {
+		return nil, nil, NewErrTxnViolatesHardConstraint(err)
+	}
+
+	return head, uxIn, nil
+}
+
 // Remove a single txn by hash
 func (utp *UnconfirmedTxnPool) removeTxn(bc *Blockchain, txHash cipher.SHA256) {
 	// delete(utp.Txns, txHash)
+	utp.index = nil
 	utp.txns.delete(txHash)
 	utp.unspent.delete(txHash)
 }
@@ -359,6 +846,7 @@ func (utp *UnconfirmedTxnPool) removeTxn(bc *Blockchain, txHash cipher.SHA256) {
 // Removes multiple txns at once. Slightly more efficient than a series of
 // single RemoveTxns.  Hashes is an array of Transaction hashes.
 func (utp *UnconfirmedTxnPool) removeTxns(hashes []cipher.SHA256) error {
+	utp.index = nil
 	for i := range hashes {
 		if err := utp.txns.delete(hashes[i]); err != nil {
 			return err
@@ -383,23 +871,31 @@ func (utp *UnconfirmedTxnPool) RemoveTransactions(txns []cipher.SHA256) error {
 	return utp.removeTxns(txns)
 }
 
-// RemoveTransactionsWithTx remove transactions with bolt.Tx
+// RemoveTransactionsWithTx remove transactions with bolt.Tx, the transactions are confirmed
+// by a block which changes the fees of the others
 func (utp *UnconfirmedTxnPool) RemoveTransactionsWithTx(tx *bolt.Tx, txns []cipher.SHA256) {
+	utp.index = nil
 	utp.removeTxnsWithTx(tx, txns)
 }
 
-// Refresh checks all unconfirmed txns against the blockchain.
+// Refresh checks all unconfirmed txns against the blockchain and the outputs of the pooled txns.
 // If the transaction becomes invalid it is marked invalid.
 // If the transaction becomes valid it is marked valid and is returned to the caller.
 func (utp *UnconfirmedTxnPool) Refresh(bc Blockchainer, maxBlockSize int) ([]cipher.SHA256, error) {
-	now := utc.Now()
+	now := utc.NowFrom(utp.clock)
+	utp.index = nil
+
+	outputs, err := utp.poolOutputs()
+	if err != nil {
+		return nil, err
+	}
 
 	var nowValid []cipher.SHA256
 
 	if err := utp.txns.rangeUpdate(func(_ cipher.SHA256, tx *UnconfirmedTxn) error {
 		tx.Checked = now.UnixNano()
 
-		err := bc.VerifySingleTxnAllConstraints(tx.Txn, maxBlockSize)
+		err := verifyPoolTxnAllConstraints(bc, outputs, tx.Txn, maxBlockSize)
 
 		switch err.(type) {
 		case ErrTxnViolatesSoftConstraint, ErrTxnViolatesHardConstraint:
@@ -421,25 +917,45 @@ func (utp *UnconfirmedTxnPool) Refresh(bc Blockchainer, maxBlockSize int) ([]cip
 	return nowValid, nil
 }
 
-// RemoveInvalid checks all unconfirmed txns against the blockchain.
-// If a transaction violates hard constraints it is removed from the pool.
+// RemoveInvalid checks all unconfirmed txns against the blockchain and the outputs of the pooled txns.
+// If a transaction violates hard constraints it is removed from the pool, with its descendants.
 // The transactions that were removed are returned.
 func (utp *UnconfirmedTxnPool) RemoveInvalid(bc Blockchainer) ([]cipher.SHA256, error) {
+	outputs, err := utp.poolOutputs()
+	if err != nil {
+		return nil, err
+	}
+
 	var removeTxs []cipher.SHA256
+	removed := make(map[cipher.SHA256]struct{})
+
+	// the descendants of a removed transaction are checked again without its outputs
+	for found := true; found; {
+		found = false
+		if err := utp.txns.forEach(func(_ cipher.SHA256, tx *UnconfirmedTxn) error {
+			h := tx.Hash()
+			if _, ok := removed[h]; ok {
+				return nil
+			}
 
-	if err := utp.txns.forEach(func(_ cipher.SHA256, tx *UnconfirmedTxn) error {
-		err := bc.VerifySingleTxnHardConstraints(tx.Txn)
+			err := verifyPoolTxnHardConstraints(bc, outputs, tx.Txn)
 
-		switch err.(type) {
-		case ErrTxnViolatesHardConstraint:
-			removeTxs = append(removeTxs, tx.Hash())
-		default:
-			return err
-		}
+			switch err.(type) {
+			case ErrTxnViolatesHardConstraint:
+				removeTxs = append(removeTxs, h)
+				removed[h] = struct{}{}
+				for _, ux := range coin.CreateUnspents(coin.BlockHeader{}, tx.Txn) {
+					delete(outputs, ux.Hash())
+				}
+				found = true
+			default:
+				return err
+			}
 
-		return nil
-	}); err != nil {
-		return nil, err
+			return nil
+		}); err != nil {
+			return nil, err
+		}
 	}
 
 	if err := utp.RemoveTransactions(removeTxs); err != nil {
@@ -503,7 +1019,7 @@ type UnspentGetFunc func(hash cipher.SHA256) (coin.UxOut, bool)
 
 // SpendsOfAddresses returns all unconfirmed coin.UxOut spends of addresses
 // Looks at all inputs for unconfirmed txns, gets their source UxOut from the
-// blockchain's unspent pool, and returns as coin.AddressUxOuts
+// blockchain's unspent pool or the outputs of the pooled txns, and returns as coin.AddressUxOuts
 func (utp *UnconfirmedTxnPool) SpendsOfAddresses(addrs []cipher.Address,
 	unspent blockdb.UnspentGetter) (coin.AddressUxOuts, error) {
 	addrm := make(map[cipher.Address]struct{}, len(addrs))
@@ -511,10 +1027,18 @@ func (utp *UnconfirmedTxnPool) SpendsOfAddresses(addrs []cipher.Address,
 		addrm[addr] = struct{}{}
 	}
 
+	outputs, err := utp.poolOutputs()
+	if err != nil {
+		return coin.AddressUxOuts{}, fmt.Errorf("get unconfirmed spend error:%v", err)
+	}
+
 	auxs := make(coin.AddressUxOuts, len(addrs))
 	if err := utp.txns.forEach(func(_ cipher.SHA256, tx *UnconfirmedTxn) error {
 		for _, h := range tx.Txn.In {
 			ux, ok := unspent.Get(h)
+			if !ok {
+				ux, ok = outputs[h]
+			}
 			if !ok {
 				// unconfirm transaction's IN is not in the unspent pool, this should not happen
 				return fmt.Errorf("unconfirmed transaction's IN: %s is not in unspent pool", h.Hex())
@@ -531,16 +1055,28 @@ func (utp *UnconfirmedTxnPool) SpendsOfAddresses(addrs []cipher.Address,
 	return auxs, nil
 }
 
-// GetSpendingOutputs returns all spending outputs in unconfirmed tx pool.
+// GetSpendingOutputs returns all spending outputs in unconfirmed tx pool,
+// including the outputs of pooled transactions.
 func (utp *UnconfirmedTxnPool) GetSpendingOutputs(bcUnspent blockdb.UnspentPool) (coin.UxArray, error) {
+	outputs, err := utp.poolOutputs()
+	if err != nil {
+		return coin.UxArray{}, fmt.Errorf("get unconfirmed spending outputs failed: %v", err)
+	}
+
 	outs := coin.UxArray{}
-	err := utp.txns.forEach(func(_ cipher.SHA256, tx *UnconfirmedTxn) error {
-		uxs, err := bcUnspent.GetArray(tx.Txn.In)
-		if err != nil {
-			return err
-		}
+	err = utp.txns.forEach(func(_ cipher.SHA256, tx *UnconfirmedTxn) error {
+		for _, h := range tx.Txn.In {
+			if ux, ok := outputs[h]; ok {
+				outs = append(outs, ux)
+				continue
+			}
 
-		outs = append(outs, uxs...)
+			ux, ok := bcUnspent.Get(h)
+			if !ok {
+				return blockdb.NewErrUnspentNotExist(h.Hex())
+			}
+			outs = append(outs, ux)
+		}
 		return nil
 	})
 
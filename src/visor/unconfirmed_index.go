@@ -0,0 +1,275 @@
+package visor
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/samoslab/samos/src/cipher"
+	"github.com/samoslab/samos/src/coin"
+)
+
+// feeIndex keeps the unconfirmed transactions in the order they are picked for a block.
+// It is updated as transactions are added to and removed from the pool, so injecting a
+// transaction only visits the transactions it is related to. The fees depend on the head
+// time, the index is rebuilt once a block is executed or the pool is refreshed.
+type feeIndex struct {
+	txns map[cipher.SHA256]*UnconfirmedTxnFee
+	// outputs of the indexed transactions by hash
+	outputs map[cipher.SHA256]coin.UxOut
+	// the indexed transactions spending each input, conflicting transactions spend the same one
+	spenders map[cipher.SHA256][]cipher.SHA256
+	// sorted in the order the transactions are picked for a block
+	sorted []*UnconfirmedTxnFee
+	// total size of the indexed transactions
+	size int
+}
+
+// newFeeIndex indexes txns, which must be sorted and have their packages set
+func newFeeIndex(txns []UnconfirmedTxnFee, outputs map[cipher.SHA256]coin.UxOut) *feeIndex {
+	idx := &feeIndex{
+		txns:     make(map[cipher.SHA256]*UnconfirmedTxnFee, len(txns)),
+		outputs:  outputs,
+		spenders: make(map[cipher.SHA256][]cipher.SHA256),
+		sorted:   make([]*UnconfirmedTxnFee, len(txns)),
+	}
+
+	for i := range txns {
+		t := txns[i]
+		idx.txns[t.Hash()] = &t
+		idx.sorted[i] = &t
+		idx.size += t.Size
+		idx.addSpender(&t)
+	}
+	return idx
+}
+
+// txnsByFee returns a copy of the indexed transactions in the order they are picked for a block
+func (idx *feeIndex) txnsByFee() []UnconfirmedTxnFee {
+	txns := make([]UnconfirmedTxnFee, len(idx.sorted))
+	for i, t := range idx.sorted {
+		txns[i] = *t
+	}
+	return txns
+}
+
+// entry returns utx with its fee and its package in the index, without adding it
+func (idx *feeIndex) entry(utx UnconfirmedTxn, fee uint64) UnconfirmedTxnFee {
+	size := utx.Txn.Size()
+	t := UnconfirmedTxnFee{
+		UnconfirmedTxn: utx,
+		Fee:            fee,
+		Size:           size,
+		FeePerKB:       coin.FeePerKB(fee, size),
+	}
+
+	ancestors := make(map[cipher.SHA256]struct{})
+	for _, in := range utx.Txn.In {
+		ux, ok := idx.outputs[in]
+		if !ok {
+			continue
+		}
+		p, ok := idx.txns[ux.Body.SrcTransaction]
+		if !ok {
+			continue
+		}
+		ancestors[p.Hash()] = struct{}{}
+		for _, a := range p.Ancestors {
+			ancestors[a] = struct{}{}
+		}
+	}
+
+	t.Ancestors = hashesOf(ancestors)
+	t.PackageFeePerKB = idx.score(&t)
+	return t
+}
+
+// score returns the fee per kB of the transaction together with its ancestors
+func (idx *feeIndex) score(t *UnconfirmedTxnFee) uint64 {
+	fee, size := t.Fee, t.Size
+	for _, a := range t.Ancestors {
+		if at, ok := idx.txns[a]; ok {
+			fee = addFees(fee, at.Fee)
+			size += at.Size
+		}
+	}
+	return coin.FeePerKB(fee, size)
+}
+
+// add indexes the transaction returned by entry, its ancestors are raised to its package fee
+func (idx *feeIndex) add(t UnconfirmedTxnFee, outputs coin.UxArray) {
+	h := t.Hash()
+	for _, a := range t.Ancestors {
+		at := idx.txns[a]
+		idx.unsort(at)
+		at.Descendants = insertHash(at.Descendants, h)
+		if t.PackageFeePerKB > at.PackageFeePerKB {
+			at.PackageFeePerKB = t.PackageFeePerKB
+		}
+		idx.insert(at)
+	}
+
+	idx.txns[h] = &t
+	idx.insert(&t)
+	idx.size += t.Size
+	idx.addSpender(&t)
+	for _, ux := range outputs {
+		idx.outputs[ux.Hash()] = ux
+	}
+}
+
+// remove removes the transactions from the index, the descendants of a removed transaction
+// must be removed with it. The package fees of their ancestors are lowered
+func (idx *feeIndex) remove(hashes []cipher.SHA256) {
+	removed := make(map[cipher.SHA256]struct{}, len(hashes))
+	affected := make(map[cipher.SHA256]struct{})
+	for _, h := range hashes {
+		t, ok := idx.txns[h]
+		if !ok {
+			continue
+		}
+
+		removed[h] = struct{}{}
+		idx.unsort(t)
+		delete(idx.txns, h)
+		idx.size -= t.Size
+		idx.removeSpender(t)
+		for _, ux := range coin.CreateUnspents(coin.BlockHeader{}, t.Txn) {
+			delete(idx.outputs, ux.Hash())
+		}
+		for _, a := range t.Ancestors {
+			affected[a] = struct{}{}
+		}
+	}
+
+	for a := range affected {
+		at, ok := idx.txns[a]
+		if !ok {
+			continue
+		}
+
+		idx.unsort(at)
+		var descendants []cipher.SHA256
+		for _, d := range at.Descendants {
+			if _, ok := removed[d]; !ok {
+				descendants = append(descendants, d)
+			}
+		}
+		at.Descendants = descendants
+		at.PackageFeePerKB = idx.score(at)
+		for _, d := range descendants {
+			if s := idx.score(idx.txns[d]); s > at.PackageFeePerKB {
+				at.PackageFeePerKB = s
+			}
+		}
+		idx.insert(at)
+	}
+}
+
+// update applies f to the indexed transaction, keeping the order
+func (idx *feeIndex) update(h cipher.SHA256, f func(tx *UnconfirmedTxn)) {
+	t, ok := idx.txns[h]
+	if !ok {
+		return
+	}
+	idx.unsort(t)
+	f(&t.UnconfirmedTxn)
+	idx.insert(t)
+}
+
+// conflicts returns the indexed transactions spending an input of txn and their descendants
+func (idx *feeIndex) conflicts(txn *coin.Transaction) []cipher.SHA256 {
+	var hashes []cipher.SHA256
+	seen := make(map[cipher.SHA256]struct{})
+	add := func(h cipher.SHA256) {
+		if _, ok := seen[h]; !ok {
+			seen[h] = struct{}{}
+			hashes = append(hashes, h)
+		}
+	}
+
+	for _, in := range txn.In {
+		for _, s := range idx.spenders[in] {
+			add(s)
+			for _, d := range idx.txns[s].Descendants {
+				add(d)
+			}
+		}
+	}
+	return hashes
+}
+
+// position returns the index of t in sorted, or where it is inserted
+func (idx *feeIndex) position(t *UnconfirmedTxnFee) int {
+	return sort.Search(len(idx.sorted), func(i int) bool {
+		return !t.priorityLess(idx.sorted[i])
+	})
+}
+
+func (idx *feeIndex) insert(t *UnconfirmedTxnFee) {
+	i := idx.position(t)
+	idx.sorted = append(idx.sorted, nil)
+	copy(idx.sorted[i+1:], idx.sorted[i:])
+	idx.sorted[i] = t
+}
+
+func (idx *feeIndex) unsort(t *UnconfirmedTxnFee) {
+	i := idx.position(t)
+	if i < len(idx.sorted) && idx.sorted[i] == t {
+		idx.sorted = append(idx.sorted[:i], idx.sorted[i+1:]...)
+	}
+}
+
+func (idx *feeIndex) addSpender(t *UnconfirmedTxnFee) {
+	h := t.Hash()
+	for _, in := range t.Txn.In {
+		idx.spenders[in] = append(idx.spenders[in], h)
+	}
+}
+
+func (idx *feeIndex) removeSpender(t *UnconfirmedTxnFee) {
+	h := t.Hash()
+	for _, in := range t.Txn.In {
+		var spenders []cipher.SHA256
+		for _, s := range idx.spenders[in] {
+			if s != h {
+				spenders = append(spenders, s)
+			}
+		}
+		if len(spenders) == 0 {
+			delete(idx.spenders, in)
+		} else {
+			idx.spenders[in] = spenders
+		}
+	}
+}
+
+// hashesOf returns the sorted hashes of the set
+func hashesOf(set map[cipher.SHA256]struct{}) []cipher.SHA256 {
+	if len(set) == 0 {
+		return nil
+	}
+
+	hashes := make([]cipher.SHA256, 0, len(set))
+	for h := range set {
+		hashes = append(hashes, h)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+	return hashes
+}
+
+// insertHash returns a new sorted slice of hashes with h, the slice may be shared by a copy
+func insertHash(hashes []cipher.SHA256, h cipher.SHA256) []cipher.SHA256 {
+	i := sort.Search(len(hashes), func(i int) bool {
+		return bytes.Compare(hashes[i][:], h[:]) >= 0
+	})
+	if i < len(hashes) && hashes[i] == h {
+		return hashes
+	}
+
+	inserted := make([]cipher.SHA256, 0, len(hashes)+1)
+	inserted = append(inserted, hashes[:i]...)
+	inserted = append(inserted, h)
+	return append(inserted, hashes[i:]...)
+}
@@ -190,6 +190,33 @@ func (m *UnconfirmedTxnPoolerMock) GetTxns(p0 func(tx UnconfirmedTxn) bool) []Un
 
 }
 
+// GetTxnsByFee mocked method
+func (m *UnconfirmedTxnPoolerMock) GetTxnsByFee(p0 Blockchainer) ([]UnconfirmedTxnFee, error) {
+
+	ret := m.Called(p0)
+
+	var r0 []UnconfirmedTxnFee
+	switch res := ret.Get(0).(type) {
+	case nil:
+	case []UnconfirmedTxnFee:
+		r0 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	var r1 error
+	switch res := ret.Get(1).(type) {
+	case nil:
+	case error:
+		r1 = res
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", res))
+	}
+
+	return r0, r1
+
+}
+
 // GetUnspentsOfAddr mocked method
 func (m *UnconfirmedTxnPoolerMock) GetUnspentsOfAddr(p0 cipher.Address) coin.UxArray {
 
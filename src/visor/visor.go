@@ -14,6 +14,7 @@ import (
 	"github.com/samoslab/samos/src/cipher"
 	"github.com/samoslab/samos/src/coin"
 	"github.com/samoslab/samos/src/util/droplet"
+	"github.com/samoslab/samos/src/util/fee"
 	"github.com/samoslab/samos/src/util/utc"
 	"github.com/samoslab/samos/src/visor/blockdb"
 	"github.com/samoslab/samos/src/visor/historydb"
@@ -28,6 +29,13 @@ const (
 
 	//DefaultMaxBlockSize is max block size
 	DefaultMaxBlockSize int = 32 * 1024
+
+	// DefaultUnconfirmedMaxSize is the maximum total size of the unconfirmed pool, in bytes
+	DefaultUnconfirmedMaxSize int = 32 * 1024 * 1024
+
+	// DefaultUnconfirmedMinFeePerKB is the minimum fee per kB of a transaction relayed by the unconfirmed pool, in coin hours.
+	// It is 0 so that the transactions created before the relay fee was introduced are still relayed
+	DefaultUnconfirmedMinFeePerKB uint64 = 0
 )
 
 var (
@@ -101,6 +109,10 @@ type Config struct {
 
 	// How often new blocks are created by the master, in seconds
 	BlockCreationInterval uint64
+	// Length of a block slot, in seconds. Genesis parameter of the chain
+	BlockInterval int64
+	// Length of an epoch, in seconds. Genesis parameter of the chain
+	EpochInterval int64
 	// How often broadcast message by the genesis master, in seconds
 	BroadcastInterval uint64
 	// How often an unconfirmed txn is checked against the blockchain
@@ -113,6 +125,13 @@ type Config struct {
 	UnconfirmedRemoveInvalidRate time.Duration
 	// How often to rebroadcast unconfirmed transactions
 	UnconfirmedResendPeriod time.Duration
+	// Maximum total size of the unconfirmed txns, in bytes. The txns paying the lowest fee
+	// per kB are evicted when it is reached, 0 is unlimited
+	UnconfirmedMaxSize int
+	// Minimum fee per kB an unconfirmed txn must pay to be accepted, in coin hours
+	UnconfirmedMinFeePerKB uint64
+	// Replace the unconfirmed txns spending the inputs of a new txn which pays a higher fee
+	UnconfirmedReplaceByFee bool
 	// Maximum size of a block, in bytes.
 	MaxBlockSize int
 
@@ -125,7 +144,13 @@ type Config struct {
 	GenesisAddress cipher.Address
 
 	TrustPubkeyList []cipher.PubKey
-	AgreeNum        int
+	// Number of trust nodes that must agree on a block, 0 derives the byzantine quorum
+	// from the validators of each epoch. Only a testnet may override it
+	AgreeNum int
+	// Running a test network
+	Testnet bool
+	// Number of candidates elected by votes to produce the blocks of an epoch
+	ProducerNum int
 
 	// Genesis block sig
 	GenesisSignature cipher.Sig
@@ -149,6 +174,12 @@ type Config struct {
 	EnableSeedAPI bool
 	// wallet crypto type
 	WalletCryptoType wallet.CryptoType
+	// Clock the slots, views and pending block times are read from, the utc clock if nil
+	Clock utc.Clock
+	// Snapshot file a new node starts the blockchain from
+	SnapshotPath string
+	// Hash of the block the snapshot must start from, required with SnapshotPath
+	SnapshotHash cipher.SHA256
 }
 
 // NewVisorConfig put cap on block size, not on transactions/block
@@ -162,6 +193,9 @@ func NewVisorConfig() Config {
 		BlockchainSeckey: cipher.SecKey{},
 
 		BlockCreationInterval: 2,
+		BlockInterval:         dpos.DefaultBlockInterval,
+		EpochInterval:         dpos.DefaultEpochInterval,
+		ProducerNum:           dpos.DefaultProducerNum,
 		BroadcastInterval:     30,
 		//BlockCreationForceInterval: 120, //create block if no block within this many seconds
 
@@ -170,6 +204,8 @@ func NewVisorConfig() Config {
 		UnconfirmedRefreshRate:       time.Minute,
 		UnconfirmedRemoveInvalidRate: time.Minute,
 		UnconfirmedResendPeriod:      time.Minute,
+		UnconfirmedMaxSize:           DefaultUnconfirmedMaxSize,
+		UnconfirmedMinFeePerKB:       DefaultUnconfirmedMinFeePerKB,
 		MaxBlockSize:                 DefaultMaxBlockSize,
 
 		GenesisAddress:    cipher.Address{},
@@ -189,13 +225,46 @@ func (c Config) Verify() error {
 		}
 	}
 
+	if err := c.Intervals().Verify(); err != nil {
+		return err
+	}
+
+	if c.ProducerNum <= 0 {
+		return errors.New("producerNum must be positive")
+	}
+
+	if c.AgreeNum < 0 {
+		return errors.New("agreeNum can not be negative")
+	}
+	if c.AgreeNum > 0 {
+		if !c.Testnet {
+			return errors.New("agreeNum can only be overridden on a testnet")
+		}
+		if len(c.TrustPubkeyList) > 0 && c.AgreeNum > len(c.TrustPubkeyList) {
+			return fmt.Errorf("agreeNum %d is unreachable with %d trust nodes", c.AgreeNum, len(c.TrustPubkeyList))
+		}
+	}
+
+	if c.SnapshotPath != "" && c.SnapshotHash == (cipher.SHA256{}) {
+		return ErrSnapshotNoCheckpoint
+	}
+
 	return nil
 }
 
+// Intervals returns the block and epoch intervals of the chain
+func (c Config) Intervals() dpos.Intervals {
+	return dpos.Intervals{
+		Block: c.BlockInterval,
+		Epoch: c.EpochInterval,
+	}
+}
+
 // historyer is the interface that provides methods for accessing history data that are parsed from blockchain.
 type historyer interface {
 	GetUxout(uxid cipher.SHA256) (*historydb.UxOut, error)
 	ParseBlock(b *coin.Block) error
+	RevertBlockWithTx(tx *bolt.Tx, b *coin.Block) error
 	GetTransaction(hash cipher.SHA256) (*historydb.Transaction, error)
 	GetAddrUxOuts(address cipher.Address) ([]*historydb.UxOut, error)
 	GetAddrTxns(address cipher.Address) ([]historydb.Transaction, error)
@@ -225,6 +294,15 @@ type Blockchainer interface {
 	Notify(b coin.Block)
 	BindListener(bl BlockListener)
 	UpdateDB(f func(tx *bolt.Tx) error) error
+	FinalizedSeq() uint64
+	AddCertificateWithTx(tx *bolt.Tx, seq uint64, cert pbft.Certificate) error
+	GetCertificate(hash cipher.SHA256) (*pbft.Certificate, bool, error)
+	AddCheckpointWithTx(tx *bolt.Tx, sb *coin.SignedBlock, uxs coin.UxArray, cp blockdb.Checkpoint) error
+	AddBackfillBlockWithTx(tx *bolt.Tx, sb *coin.SignedBlock) error
+	Checkpoint() *blockdb.Checkpoint
+	AddSideBlockWithTx(tx *bolt.Tx, sb *coin.SignedBlock) error
+	RevertHeadWithTx(tx *bolt.Tx) error
+	Reload() error
 }
 
 // UnconfirmedTxnPooler is the interface that provides methods for
@@ -245,6 +323,7 @@ type UnconfirmedTxnPooler interface {
 	GetIncomingOutputs(bh coin.BlockHeader) coin.UxArray
 	Get(hash cipher.SHA256) (*UnconfirmedTxn, bool)
 	GetTxns(filter func(tx UnconfirmedTxn) bool) []UnconfirmedTxn
+	GetTxnsByFee(bc Blockchainer) ([]UnconfirmedTxnFee, error)
 	GetTxHashes(filter func(tx UnconfirmedTxn) bool) []cipher.SHA256
 	ForEach(f func(cipher.SHA256, *UnconfirmedTxn) error) error
 	GetUnspentsOfAddr(addr cipher.Address) coin.UxArray
@@ -266,6 +345,7 @@ type Visor struct {
 	dpos      *dpos.Dpos
 	pbft      *pbft.PBFT
 	trustNode *blockdb.TrustNode
+	evidences *blockdb.Evidences
 }
 
 // NewVisor creates a Visor for managing the blockchain database
@@ -310,30 +390,172 @@ func NewVisor(c Config, db *bolt.DB) (*Visor, error) {
 		return nil, err
 	}
 	dpos := dpos.NewDpos(c.BlockchainTrustPubkey)
-	dpos.SetTrustNode(c.TrustPubkeyList)
+	if err := dpos.SetIntervals(c.Intervals()); err != nil {
+		return nil, err
+	}
+	trustPubkeys := tn.GetPubkeys()
+	if len(trustPubkeys) == 0 {
+		trustPubkeys = c.TrustPubkeyList
+	}
+	dpos.SetTrustNode(trustPubkeys)
+	if err := loadConsensusState(dpos, tn); err != nil {
+		return nil, err
+	}
+	pb, err := loadPBFT(db, bc.HeadSeq())
+	if err != nil {
+		return nil, err
+	}
+	pb.SetClock(c.Clock)
+	evidences, err := blockdb.NewEvidences(db)
+	if err != nil {
+		return nil, err
+	}
+	unconfirmed := NewUnconfirmedTxnPool(db)
+	unconfirmed.SetClock(c.Clock)
+	unconfirmed.SetLimits(c.UnconfirmedMaxSize, c.UnconfirmedMinFeePerKB)
+	unconfirmed.SetReplaceByFee(c.UnconfirmedReplaceByFee)
+
 	v := &Visor{
 		Config:      c,
 		db:          db,
 		Blockchain:  bc,
-		Unconfirmed: NewUnconfirmedTxnPool(db),
+		Unconfirmed: unconfirmed,
 		history:     history,
 		bcParser:    bp,
 		Wallets:     wltServ,
 		StartedAt:   time.Now(),
 		dpos:        dpos,
-		pbft:        pbft.NewPBFT(),
+		pbft:        pb,
 		trustNode:   tn,
+		evidences:   evidences,
 	}
 
 	return v, nil
 }
 
+// loadConsensusState sets the executed validator changes, elections and exclusions to dpos
+func loadConsensusState(d *dpos.Dpos, tn *blockdb.TrustNode) error {
+	changes, err := tn.GetValidatorChanges()
+	if err != nil {
+		return err
+	}
+	elections, err := tn.GetElections()
+	if err != nil {
+		return err
+	}
+	exclusions, err := tn.GetExclusions()
+	if err != nil {
+		return err
+	}
+
+	d.ResetState(changes, elections, exclusions)
+	return nil
+}
+
+// loadEpochSeed sets the seed of the producer order of the head's epoch
+func (vs *Visor) loadEpochSeed() error {
+	if vs.Blockchain.Len() == 0 {
+		return nil
+	}
+	head, err := vs.Blockchain.Head()
+	if err != nil {
+		return err
+	}
+
+	epoch, seed, err := vs.epochSeedOf(head)
+	if err != nil {
+		return err
+	}
+
+	vs.dpos.SetEpochSeed(epoch, seed)
+	return nil
+}
+
+// epochSeedOf finds the seed of the producer order of the block's epoch, the hash of
+// the last block before the epoch or of the genesis block if the chain starts in the epoch.
+// A chain started from a snapshot is searched above its lowest block, the checkpoint
+// has the seed of its epoch
+func (vs *Visor) epochSeedOf(b *coin.SignedBlock) (int64, cipher.SHA256, error) {
+	iv := vs.dpos.Intervals()
+	epoch := iv.EpochStart(iv.PrevSlot(int64(b.Time())))
+
+	// block times grow with seq, search the last block slotted before the epoch
+	lo, hi := uint64(0), b.Seq()
+	seed := vs.Blockchain.GetGenesisBlock().HashHeader()
+	if cp := vs.Blockchain.Checkpoint(); cp != nil {
+		lo = cp.Lowest
+		seed = cp.EpochSeed
+	}
+	for lo <= hi {
+		mid := lo + (hi-lo)/2
+		b, err := vs.Blockchain.GetBlockBySeq(mid)
+		if err != nil {
+			return 0, cipher.SHA256{}, err
+		}
+		if b == nil {
+			return 0, cipher.SHA256{}, fmt.Errorf("block %d not found", mid)
+		}
+		if iv.PrevSlot(int64(b.Time())) >= epoch {
+			if mid == 0 {
+				break
+			}
+			hi = mid - 1
+			continue
+		}
+		seed = b.HashHeader()
+		lo = mid + 1
+	}
+
+	return epoch, seed, nil
+}
+
+// loadPBFT creates the pbft and replays the journaled pending blocks,
+// blocks that were executed before the node stopped are dropped
+func loadPBFT(db *bolt.DB, headSeq uint64) (*pbft.PBFT, error) {
+	journal, err := blockdb.NewPbftJournal(db)
+	if err != nil {
+		return nil, err
+	}
+
+	states, err := journal.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []pbft.PendingState
+	for _, st := range states {
+		if st.Block.Seq() <= headSeq {
+			if err := journal.Delete(st.Block.HashHeader()); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		pending = append(pending, st)
+	}
+	if len(pending) > 0 {
+		logger.Infof("Restored %d pending pbft blocks", len(pending))
+	}
+
+	pb := pbft.NewPBFT()
+	pb.Restore(pending)
+	pb.SetJournal(journal)
+	return pb, nil
+}
+
 // Run starts the visor
 func (vs *Visor) Run() error {
 	if err := vs.maybeCreateGenesisBlock(); err != nil {
 		return err
 	}
 
+	if err := vs.maybeImportSnapshot(); err != nil {
+		return err
+	}
+
+	if err := vs.loadEpochSeed(); err != nil {
+		return err
+	}
+
 	removed, err := vs.RemoveInvalidUnconfirmed()
 	if err != nil {
 		return err
@@ -345,11 +567,7 @@ func (vs *Visor) Run() error {
 		if err := vs.InsertTrustPubkeyList(vs.Config.TrustPubkeyList); err != nil {
 			return err
 		}
-		agreeNum := len(vs.Config.TrustPubkeyList)
-		if vs.Config.AgreeNum > 0 && vs.Config.AgreeNum <= len(vs.Config.TrustPubkeyList) {
-			agreeNum = vs.Config.AgreeNum
-		}
-		if err := vs.InsertAgreeNodeNum(agreeNum); err != nil {
+		if err := vs.InsertAgreeNodeNum(vs.Config.AgreeNum); err != nil {
 			return err
 		}
 	}
@@ -406,6 +624,11 @@ func (vs *Visor) GenesisPreconditions() {
 	}
 }
 
+// Now returns the current time of the visor clock
+func (vs *Visor) Now() time.Time {
+	return utc.NowFrom(vs.Config.Clock)
+}
+
 // IsGenesisNode genesis node return true
 func (vs *Visor) IsGenesisNode() bool {
 	if vs.Config.BlockchainSeckey != (cipher.SecKey{}) {
@@ -431,7 +654,10 @@ func (vs *Visor) RemoveInvalidUnconfirmed() ([]cipher.SHA256, error) {
 
 // InsertTrustPubkeyList insert trust pubkey into bolt db
 func (vs *Visor) InsertTrustPubkeyList(pubkeys []cipher.PubKey) error {
-	return vs.trustNode.AddNodePubkey(pubkeys)
+	if err := vs.trustNode.AddNodePubkey(pubkeys); err != nil {
+		return err
+	}
+	return vs.dpos.SetTrustNode(pubkeys)
 }
 
 // InsertAgreeNodeNum set agress node number
@@ -444,14 +670,207 @@ func (vs *Visor) GetAgreeNodeNum() int {
 	return vs.trustNode.GetAgreeNodeNum()
 }
 
-// TrustNodes get trust node pubkey list
+// TrustNodes get trust node pubkey list active now
 func (vs *Visor) TrustNodes() []cipher.PubKey {
+	return vs.TrustNodesAt(vs.Now().Unix())
+}
+
+// TrustNodesAt returns the trust node pubkey list active at the timestamp,
+// with the on-chain validator changes applied
+func (vs *Visor) TrustNodesAt(ts int64) []cipher.PubKey {
+	pubkeys := vs.dpos.ValidatorsAt(ts)
+	if len(pubkeys) == 0 {
+		return vs.trustNode.GetPubkeys()
+	}
+	return pubkeys
+}
+
+// GenesisTrustNodes returns the trust node pubkey list distributed by the genesis node,
+// before any on-chain validator change
+func (vs *Visor) GenesisTrustNodes() []cipher.PubKey {
 	return vs.trustNode.GetPubkeys()
 }
 
+// AgreeNum returns the number of trust nodes that must agree on a block or a validator change
+func (vs *Visor) AgreeNum() int {
+	return vs.AgreeNumAt(vs.Now().Unix())
+}
+
+// AgreeNumAt returns the agree num of the epoch at the timestamp
+func (vs *Visor) AgreeNumAt(ts int64) int {
+	return vs.agreeNumOf(len(vs.TrustNodesAt(ts)))
+}
+
+// agreeNumOf returns the byzantine quorum of creatorNum validators, unless a testnet overrides it
+func (vs *Visor) agreeNumOf(creatorNum int) int {
+	if vs.Config.AgreeNum > 0 && vs.Config.AgreeNum <= creatorNum {
+		return vs.Config.AgreeNum
+	}
+	return dpos.Quorum(creatorNum)
+}
+
+// GetValidatorChanges returns all executed validator changes
+func (vs *Visor) GetValidatorChanges() ([]dpos.ValidatorChange, error) {
+	return vs.trustNode.GetValidatorChanges()
+}
+
+// VerifyTxnType checks a typed transaction against the consensus state.
+// Validator changes must be approved by a quorum of the current trust nodes,
+// votes must be for a current trust node, evidence must be against a trust node
+// and not executed yet.
+func (vs *Visor) VerifyTxnType(txn coin.Transaction) error {
+	switch {
+	case dpos.IsValidatorChange(txn):
+		_, err := dpos.ParseValidatorChange(txn, vs.TrustNodes(), vs.AgreeNum())
+		return err
+	case dpos.IsVote(txn):
+		_, err := dpos.ParseVote(txn, vs.TrustNodes())
+		return err
+	case pbft.IsEvidence(txn):
+		_, err := vs.parseEvidence(txn)
+		return err
+	}
+	return nil
+}
+
+// parseEvidence parses the evidence committed by the transaction, the evidence must be
+// against a trust node active when it double signed and not executed yet
+func (vs *Visor) parseEvidence(txn coin.Transaction) (pbft.Evidence, error) {
+	e, err := pbft.ParseEvidence(txn, vs.dpos.Intervals())
+	if err != nil {
+		return pbft.Evidence{}, err
+	}
+	if !containsPubKey(vs.TrustNodesAt(e.Time()), e.PubKey) {
+		return pbft.Evidence{}, errors.New("evidence is not against trust node")
+	}
+	if vs.dpos.HasExclusion(e.Hash()) {
+		return pbft.Evidence{}, errors.New("evidence is already executed")
+	}
+	return e, nil
+}
+
+// votes returns the votes cast in the block for the trust nodes active at the block time
+func (vs *Visor) votes(b coin.SignedBlock) ([]dpos.Vote, error) {
+	var votes []dpos.Vote
+	candidates := vs.TrustNodesAt(int64(b.Time()))
+	for _, txn := range b.Body.Transactions {
+		if !dpos.IsVote(txn) {
+			continue
+		}
+
+		vote, err := dpos.ParseVote(txn, candidates)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vote %s: %v", txn.Hash().Hex(), err)
+		}
+		votes = append(votes, vote)
+	}
+	return votes, nil
+}
+
+// startsEpoch returns true if the block built on head is the first of its epoch,
+// the genesis block starts none
+func (vs *Visor) startsEpoch(head *coin.SignedBlock, b coin.SignedBlock) bool {
+	if head == nil {
+		return false
+	}
+	iv := vs.dpos.Intervals()
+	return iv.NextEpoch(iv.PrevSlot(int64(head.Time()))) <= iv.PrevSlot(int64(b.Time()))
+}
+
+// slotStats returns the slots the block settles for the validator stats. The slots before
+// the first block are not counted, the genesis time of a chain predates its launch
+func (vs *Visor) slotStats(head *coin.SignedBlock, b coin.SignedBlock) ([]dpos.SlotStats, error) {
+	if head == nil || head.Seq() == 0 {
+		return nil, nil
+	}
+	return vs.dpos.CountSlots(head, int64(b.Time()))
+}
+
+// election elects the producers of the epoch following the one the block starts with *bolt.Tx,
+// the votes executed before the block are weighed by the coins still unspent.
+// Returns nil if the block is not the first of its epoch or nobody voted
+func (vs *Visor) election(tx *bolt.Tx, head *coin.SignedBlock, b coin.SignedBlock) (*dpos.Election, error) {
+	if !vs.startsEpoch(head, b) {
+		return nil, nil
+	}
+	iv := vs.dpos.Intervals()
+	slot := iv.PrevSlot(int64(b.Time()))
+
+	votes, err := vs.trustNode.GetVotesWithTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	unspent := vs.Blockchain.Unspent()
+	tally := dpos.Tally(votes, func(uxid cipher.SHA256) (uint64, bool) {
+		ux, ok := unspent.Get(uxid)
+		return ux.Body.Coins, ok
+	})
+
+	epoch := iv.NextEpoch(slot)
+	producers := dpos.Elect(vs.TrustNodesAt(epoch), tally, vs.Config.ProducerNum)
+	if producers == nil {
+		return nil, nil
+	}
+	return &dpos.Election{
+		Epoch:     epoch,
+		Producers: producers,
+	}, nil
+}
+
+// validatorChanges returns the validator changes proposed in the block, verified against
+// the trust nodes active at the block time. They take effect at the next epoch.
+func (vs *Visor) validatorChanges(b coin.SignedBlock) ([]dpos.ValidatorChange, error) {
+	var changes []dpos.ValidatorChange
+	validators := vs.TrustNodesAt(int64(b.Time()))
+	quorum := vs.agreeNumOf(len(validators))
+	for _, txn := range b.Body.Transactions {
+		if !dpos.IsValidatorChange(txn) {
+			continue
+		}
+
+		change, err := dpos.ParseValidatorChange(txn, validators, quorum)
+		if err != nil {
+			return nil, fmt.Errorf("invalid validator change %s: %v", txn.Hash().Hex(), err)
+		}
+		change.ActivateAt = vs.dpos.NextEpoch(int64(b.Time()))
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// exclusions returns the validators excluded by the evidence committed in the block,
+// each from the epoch after the block
+func (vs *Visor) exclusions(b coin.SignedBlock) ([]dpos.Exclusion, error) {
+	var exclusions []dpos.Exclusion
+	seen := make(map[cipher.SHA256]struct{})
+	for _, txn := range b.Body.Transactions {
+		if !pbft.IsEvidence(txn) {
+			continue
+		}
+
+		e, err := vs.parseEvidence(txn)
+		if err != nil {
+			return nil, fmt.Errorf("invalid evidence %s: %v", txn.Hash().Hex(), err)
+		}
+		if _, ok := seen[e.Hash()]; ok {
+			return nil, fmt.Errorf("invalid evidence %s: evidence is committed twice", txn.Hash().Hex())
+		}
+		seen[e.Hash()] = struct{}{}
+
+		exclusion := vs.dpos.NextEpochExclusion(e.Hash(), e.PubKey, int64(b.Time()))
+		exclusion.TxID = txn.Hash()
+		exclusions = append(exclusions, exclusion)
+	}
+	return exclusions, nil
+}
+
 // IsTrustPubkey check the pubkey valid or not
 func (vs *Visor) IsTrustPubkey(pubKey cipher.PubKey) bool {
-	for _, pkey := range vs.TrustNodes() {
+	return containsPubKey(vs.TrustNodes(), pubKey)
+}
+
+func containsPubKey(pubkeys []cipher.PubKey, pubKey cipher.PubKey) bool {
+	for _, pkey := range pubkeys {
 		if pkey == pubKey {
 			return true
 		}
@@ -469,6 +888,15 @@ func (vs *Visor) GetValidatorNumber(hash cipher.SHA256) (int, error) {
 	return vs.pbft.ValidatorNumber(hash)
 }
 
+// BlockAgreeNum returns the agree num of the epoch the pending block is proposed in
+func (vs *Visor) BlockAgreeNum(hash cipher.SHA256) (int, error) {
+	sb, err := vs.pbft.GetSignedBlock(hash)
+	if err != nil {
+		return 0, err
+	}
+	return vs.AgreeNumAt(int64(sb.Time())), nil
+}
+
 // GetPendingHash returns waiting into chian block hash list
 func (vs *Visor) GetPendingHash() []cipher.SHA256 {
 	return vs.pbft.WaitingConfirmedBlockHash()
@@ -485,11 +913,11 @@ func (vs *Visor) CheckHashExists(hash cipher.SHA256) bool {
 
 // CheckHashExistsInChain check block hash exists in blockchian or not
 func (vs *Visor) CheckHashExistsInChain(hash cipher.SHA256) bool {
-	_, err := vs.GetBlockByHash(hash)
+	b, err := vs.GetBlockByHash(hash)
 	if err != nil {
 		return false
 	}
-	return true
+	return b != nil
 }
 
 // CheckPubkeyExists check pubkey is in pbft validator list or not
@@ -510,19 +938,389 @@ func (vs *Visor) DeletePbftHash(hash cipher.SHA256) error {
 	return vs.pbft.DeleteHash(hash)
 }
 
-// StartExecuteSignedBlock make block into blockchain if validtor check ok
+// StartExecuteSignedBlock make block into blockchain if validtor check ok,
+// the collected commits finalize the block
 func (vs *Visor) StartExecuteSignedBlock(hash cipher.SHA256) error {
 	block, err := vs.pbft.GetSignedBlock(hash)
 	if err != nil {
 		return err
 	}
-	err = vs.ExecuteSignedBlock(block)
+
+	var cert *pbft.Certificate
+	c, err := vs.pbft.GetCertificate(hash)
+	if err == nil {
+		err = vs.verifyCertificate(block, c)
+	}
+	if err != nil {
+		logger.Warningf("Block %s is executed without certificate: %v", hash.Hex(), err)
+	} else {
+		cert = &c
+	}
+
+	err = vs.executeSignedBlock(block, cert)
 	if err == nil {
 		vs.DeletePbftHash(hash)
 	}
 	return err
 }
 
+// AddCommit records the signed commit of a validator for block hash
+func (vs *Visor) AddCommit(hash cipher.SHA256, pubKey cipher.PubKey, cs pbft.CommitSig) error {
+	return vs.pbft.AddCommit(hash, pubKey, cs)
+}
+
+// verifyCertificate checks the certificate has the agree num of commits from the
+// trust nodes of the block
+func (vs *Visor) verifyCertificate(b coin.SignedBlock, cert pbft.Certificate) error {
+	if cert.Hash != b.HashHeader() {
+		return errors.New("certificate is not for the block")
+	}
+	ts := int64(b.Time())
+	return cert.Verify(vs.TrustNodesAt(ts), vs.AgreeNumAt(ts))
+}
+
+// AddCertificate finalizes an executed block with the certificate of its commits,
+// returns false if the block already has one
+func (vs *Visor) AddCertificate(cert pbft.Certificate) (bool, error) {
+	b, err := vs.Blockchain.GetBlockByHash(cert.Hash)
+	if err != nil {
+		return false, err
+	}
+	if b == nil {
+		return false, errors.New("block of the certificate is not executed")
+	}
+
+	if _, ok, err := vs.Blockchain.GetCertificate(cert.Hash); err != nil || ok {
+		return false, err
+	}
+
+	if err := vs.verifyCertificate(*b, cert); err != nil {
+		return false, err
+	}
+
+	// a certificate of a block on a side branch moves the chain to the branch
+	ok, err := vs.inChain(*b)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		if err := vs.chooseBranch(*b, &cert); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	if err := vs.db.Update(func(tx *bolt.Tx) error {
+		return vs.Blockchain.AddCertificateWithTx(tx, b.Seq(), cert)
+	}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetCertificate returns the commit certificate of the executed block
+func (vs *Visor) GetCertificate(hash cipher.SHA256) (*pbft.Certificate, bool, error) {
+	return vs.Blockchain.GetCertificate(hash)
+}
+
+// FinalizedSeq returns the seq of the highest final block
+func (vs *Visor) FinalizedSeq() uint64 {
+	return vs.Blockchain.FinalizedSeq()
+}
+
+// GetCommitNumber returns the number of validators committed the block hash
+func (vs *Visor) GetCommitNumber(hash cipher.SHA256) (int, error) {
+	return vs.pbft.CommitNumber(hash)
+}
+
+// CheckCommitExists check pubkey has committed the block hash
+func (vs *Visor) CheckCommitExists(hash cipher.SHA256, pubKey cipher.PubKey) bool {
+	return vs.pbft.CheckCommitExists(hash, pubKey) == nil
+}
+
+// GetBlockView returns the view the pending block hash was proposed in
+func (vs *Visor) GetBlockView(hash cipher.SHA256) (uint64, error) {
+	return vs.pbft.GetBlockView(hash)
+}
+
+// VerifyCommit returns the trust pubkey that signed the commit of block hash in view
+func (vs *Visor) VerifyCommit(hash cipher.SHA256, view uint64, sig cipher.Sig) (cipher.PubKey, error) {
+	pubkeyRec, err := cipher.PubKeyFromSig(sig, pbft.CommitHash(hash, view))
+	if err != nil {
+		return cipher.PubKey{}, err
+	}
+	if !vs.IsTrustPubkey(pubkeyRec) {
+		return cipher.PubKey{}, errors.New("commit is not signed by trust node")
+	}
+	blockView, err := vs.pbft.GetBlockView(hash)
+	if err != nil {
+		return cipher.PubKey{}, err
+	}
+	if blockView != view {
+		return cipher.PubKey{}, fmt.Errorf("commit for view %d, block is in view %d", view, blockView)
+	}
+	return pubkeyRec, nil
+}
+
+// GetView returns the pbft view of the height being agreed on
+func (vs *Visor) GetView() uint64 {
+	return vs.pbft.GetView()
+}
+
+// NeedViewChange returns the signed view change this node should vote, if the
+// pending block of the current view is not committed in time
+func (vs *Visor) NeedViewChange() (pbft.ViewChange, bool) {
+	if !vs.Config.IsMaster {
+		return pbft.ViewChange{}, false
+	}
+	view, prepared, ok := vs.pbft.NeedViewChange(vs.Now().Unix(), vs.Config.BlockchainTrustPubkey)
+	if !ok {
+		return pbft.ViewChange{}, false
+	}
+	return pbft.NewViewChange(vs.HeadBkSeq()+1, view, prepared, vs.Config.BlockchainTrustSeckey), true
+}
+
+func (vs *Visor) verifyViewChange(vc pbft.ViewChange) (cipher.PubKey, error) {
+	if vc.Seq != vs.HeadBkSeq()+1 {
+		return cipher.PubKey{}, fmt.Errorf("view change for seq %d, waiting for %d", vc.Seq, vs.HeadBkSeq()+1)
+	}
+	signer, err := vc.Signer()
+	if err != nil {
+		return cipher.PubKey{}, err
+	}
+	if !vs.IsTrustPubkey(signer) {
+		return cipher.PubKey{}, errors.New("view change is not signed by trust node")
+	}
+	return signer, nil
+}
+
+// AddViewChange records a view change vote, the view is changed once AgreeNum
+// validators voted for it. Returns whether the view is changed
+func (vs *Visor) AddViewChange(vc pbft.ViewChange) (bool, error) {
+	signer, err := vs.verifyViewChange(vc)
+	if err != nil {
+		return false, err
+	}
+	num, err := vs.pbft.AddViewChange(vc, signer)
+	if err != nil {
+		return false, err
+	}
+	if num < vs.AgreeNum() {
+		return false, nil
+	}
+	if err := vs.pbft.ChangeView(vc.View, vs.Now().Unix()); err != nil {
+		return false, err
+	}
+	logger.Infof("Changed to view %d for block seq %d", vc.View, vc.Seq)
+	return true, nil
+}
+
+// ApplyNewView moves to the view proved by the view change votes
+func (vs *Visor) ApplyNewView(view uint64, votes []pbft.ViewChange) error {
+	if view <= vs.pbft.GetView() {
+		return errors.New("new view is stale")
+	}
+	signers := make(map[cipher.PubKey]struct{})
+	for _, vc := range votes {
+		if vc.View != view {
+			return errors.New("view change vote for other view")
+		}
+		signer, err := vs.verifyViewChange(vc)
+		if err != nil {
+			return err
+		}
+		signers[signer] = struct{}{}
+		// votes known already are expected here
+		vs.pbft.AddViewChange(vc, signer)
+	}
+	if len(signers) < vs.AgreeNum() {
+		return fmt.Errorf("new view has %d votes, need %d", len(signers), vs.AgreeNum())
+	}
+	if err := vs.pbft.ChangeView(view, vs.Now().Unix()); err != nil {
+		return err
+	}
+	logger.Infof("Changed to view %d for block seq %d", view, vs.HeadBkSeq()+1)
+	return nil
+}
+
+// ViewChanges returns the view change votes for the view
+func (vs *Visor) ViewChanges(view uint64) []pbft.ViewChange {
+	return vs.pbft.ViewChanges(view)
+}
+
+// DetectBlockEquivocation checks whether the producer of the block signed another
+// block in the same slot or a block conflicting with the chain, returns the evidence if it is new
+func (vs *Visor) DetectBlockEquivocation(sb coin.SignedBlock) (*pbft.Evidence, error) {
+	e, err := vs.pbft.FindConflict(sb, vs.dpos.Intervals())
+	if err != nil {
+		return nil, err
+	}
+
+	if e == nil && sb.Seq() <= vs.HeadBkSeq() {
+		b, err := vs.GetBlock(sb.Seq())
+		if err != nil {
+			return nil, err
+		}
+		hash := sb.HashHeader()
+		producer, err := cipher.PubKeyFromSig(sb.Sig, hash)
+		if err != nil {
+			return nil, err
+		}
+		iv := vs.dpos.Intervals()
+		sameSlot := iv.PrevSlot(int64(b.Time())) == iv.PrevSlot(int64(sb.Time()))
+		if sameSlot && b.HashHeader() != hash && cipher.VerifySignature(producer, b.Sig, b.HashHeader()) == nil {
+			evidence := pbft.NewEvidence(pbft.EvidenceSeq, producer, 0, b.Block.Head, b.Sig, sb.Block.Head, sb.Sig)
+			e = &evidence
+		}
+	}
+
+	if e == nil {
+		return nil, nil
+	}
+	return vs.addEvidence(*e)
+}
+
+// RecordPrepare records the prepare signature of a validator for the pending block hash,
+// returns the evidence if the validator prepared another block of the same seq in the same view
+func (vs *Visor) RecordPrepare(hash cipher.SHA256, pubKey cipher.PubKey, sig cipher.Sig) (*pbft.Evidence, error) {
+	e, err := vs.pbft.RecordPrepare(hash, pubKey, sig)
+	if err != nil || e == nil {
+		return nil, err
+	}
+	return vs.addEvidence(*e)
+}
+
+// AddEvidence verifies and stores evidence received from a peer, returns whether it is new
+func (vs *Visor) AddEvidence(e pbft.Evidence) (bool, error) {
+	if e.Type == pbft.EvidencePrepare {
+		return false, errors.New("prepare evidence can not be verified")
+	}
+	if err := e.Verify(vs.dpos.Intervals()); err != nil {
+		return false, err
+	}
+	if !containsPubKey(vs.TrustNodesAt(e.Time()), e.PubKey) {
+		return false, errors.New("evidence is not against trust node")
+	}
+	if e.Type == pbft.EvidenceSeq && !vs.CheckHashExistsInChain(e.HeaderA.Hash()) && !vs.CheckHashExistsInChain(e.HeaderB.Hash()) {
+		return false, errors.New("evidence conflicts with unknown block")
+	}
+
+	added, err := vs.addEvidence(e)
+	return added != nil, err
+}
+
+func (vs *Visor) addEvidence(e pbft.Evidence) (*pbft.Evidence, error) {
+	added, err := vs.evidences.Add(e)
+	if err != nil || !added {
+		return nil, err
+	}
+
+	logger.Warningf("Trust node %s double signed block seq %d and %d", e.PubKey.Hex(), e.HeaderA.BkSeq, e.HeaderB.BkSeq)
+	return &e, nil
+}
+
+// GetProducerSchedule returns the producers of the next n slots of the current epoch
+func (vs *Visor) GetProducerSchedule(n int) ([]dpos.SlotProducer, error) {
+	head, err := vs.Blockchain.Head()
+	if err != nil {
+		return nil, err
+	}
+	return vs.dpos.Schedule(head, vs.Now().Unix(), n)
+}
+
+// GetValidatorStats returns the start of the epoch containing ts and the slots the validators
+// produced and missed in it, ts 0 selects the epoch of the head block
+func (vs *Visor) GetValidatorStats(ts int64) (int64, []dpos.SlotStats, error) {
+	iv := vs.dpos.Intervals()
+	if ts == 0 {
+		head, err := vs.Blockchain.Head()
+		if err != nil {
+			return 0, nil, err
+		}
+		ts = iv.PrevSlot(int64(head.Time()))
+	}
+	epoch := iv.EpochStart(ts)
+	stats, err := vs.trustNode.GetSlotStats(epoch)
+	if err != nil {
+		return 0, nil, err
+	}
+	return epoch, stats, nil
+}
+
+// GetEvidence returns all double signing evidence
+func (vs *Visor) GetEvidence() ([]pbft.Evidence, error) {
+	return vs.evidences.GetAll()
+}
+
+// CreateEvidenceTransaction creates a transaction committing the evidence, signed by the trust node.
+// It sends the unspent outputs of the trust node address back to it, burning the required fee.
+// Returns nil if an unconfirmed transaction commits the evidence already
+func (vs *Visor) CreateEvidenceTransaction(e pbft.Evidence) (*coin.Transaction, error) {
+	if vs.Config.BlockchainTrustSeckey == (cipher.SecKey{}) {
+		return nil, errors.New("node is not a trust node")
+	}
+
+	iv := vs.dpos.Intervals()
+	committed := vs.Unconfirmed.GetTxns(func(utx UnconfirmedTxn) bool {
+		ue, err := pbft.ParseEvidence(utx.Txn, iv)
+		return err == nil && ue.Hash() == e.Hash()
+	})
+	if len(committed) > 0 {
+		return nil, nil
+	}
+
+	head, err := vs.Blockchain.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	addr := cipher.AddressFromSecKey(vs.Config.BlockchainTrustSeckey)
+	unspent := vs.Blockchain.Unspent()
+	spends, err := vs.Unconfirmed.SpendsOfAddresses([]cipher.Address{addr}, unspent)
+	if err != nil {
+		return nil, err
+	}
+	spending := make(map[cipher.SHA256]struct{})
+	for _, ux := range spends[addr] {
+		spending[ux.Hash()] = struct{}{}
+	}
+
+	var txn coin.Transaction
+	var keys []cipher.SecKey
+	var coins, hours uint64
+	for _, ux := range unspent.GetUnspentsOfAddrs([]cipher.Address{addr})[addr] {
+		if _, ok := spending[ux.Hash()]; ok {
+			continue
+		}
+
+		uxHours, err := ux.CoinHours(head.Time())
+		if err != nil {
+			return nil, err
+		}
+		if coins, err = coin.AddUint64(coins, ux.Body.Coins); err != nil {
+			return nil, err
+		}
+		if hours, err = coin.AddUint64(hours, uxHours); err != nil {
+			return nil, err
+		}
+
+		txn.PushInput(ux.Hash())
+		keys = append(keys, vs.Config.BlockchainTrustSeckey)
+	}
+
+	if len(txn.In) == 0 {
+		return nil, fmt.Errorf("trust node address %s has no unspent output", addr.String())
+	}
+
+	txn.PushOutput(addr, coins, fee.RemainingHours(hours))
+	if err := pbft.PushEvidence(&txn, e); err != nil {
+		return nil, err
+	}
+	txn.SignInputs(keys)
+	txn.UpdateHeader()
+	return &txn, nil
+}
+
 // RemoveUnconfirmBlock remove pending block if it unconfirmed in 120 seconds
 func (vs *Visor) RemoveUnconfirmBlock() {
 	vs.pbft.RemoveUnconfirmBlock()
@@ -553,21 +1351,49 @@ func (vs *Visor) CreateBlock(when uint64) (coin.PendingSignedBlock, error) {
 
 	var sb coin.PendingSignedBlock
 
-	// Gather all unconfirmed transactions
-	txns := vs.Unconfirmed.RawTxns()
+	// Gather all unconfirmed transactions, sorted by the highest package fee per kilobyte
+	// so that a transaction paying a high fee raises the priority of its unconfirmed ancestors
+	utxns, err := vs.Unconfirmed.GetTxnsByFee(vs.Blockchain)
+	if err != nil {
+		return sb, err
+	}
 
-	if len(txns) == 0 {
+	if len(utxns) == 0 {
 		return sb, errors.New("No transactions")
 	}
 
-	logger.Infof("Unconfirmed pool has %d transactions pending", len(txns))
+	logger.Infof("Unconfirmed pool has %d transactions pending", len(utxns))
+
+	// A transaction spending outputs of unconfirmed transactions waits for them to be confirmed,
+	// a block can not spend the outputs it creates
+	txns := make(coin.Transactions, 0, len(utxns))
+	for _, utx := range utxns {
+		if len(utx.Ancestors) == 0 {
+			txns = append(txns, utx.Txn)
+		}
+	}
+
+	if nWaiting := len(utxns) - len(txns); nWaiting > 0 {
+		logger.Infof("CreateBlock left %d transactions waiting for their unconfirmed ancestors", nWaiting)
+	}
 
-	// Filter transactions that violate all constraints
+	// Filter transactions that violate all constraints. Trust nodes detecting the same
+	// double signing may each commit the evidence, a block commits it once
 	var filteredTxns coin.Transactions
+	evidences := make(map[cipher.SHA256]struct{})
 	for _, txn := range txns {
 		if err := vs.Blockchain.VerifySingleTxnAllConstraints(txn, vs.Config.MaxBlockSize); err != nil {
 			logger.Warningf("Transaction %s violates constraints: %v", txn.TxIDHex(), err)
+		} else if err := vs.VerifyTxnType(txn); err != nil {
+			logger.Warningf("Transaction %s violates constraints: %v", txn.TxIDHex(), err)
 		} else {
+			if e, err := pbft.ParseEvidence(txn, vs.dpos.Intervals()); err == nil {
+				if _, ok := evidences[e.Hash()]; ok {
+					logger.Infof("Transaction %s commits evidence committed by another transaction", txn.TxIDHex())
+					continue
+				}
+				evidences[e.Hash()] = struct{}{}
+			}
 			filteredTxns = append(filteredTxns, txn)
 		}
 	}
@@ -584,14 +1410,11 @@ func (vs *Visor) CreateBlock(when uint64) (coin.PendingSignedBlock, error) {
 		return sb, errors.New("No transactions after filtering for constraint violations")
 	}
 
-	// Sort them by highest fee per kilobyte
-	txns = coin.SortTransactions(txns, vs.Blockchain.TransactionFee)
-
-	// Apply block size transaction limit
-	txns = txns.TruncateBytesTo(vs.Config.MaxBlockSize)
+	// Apply block size transaction limit, skipping the txns that do not fit
+	txns = txns.FillBytesTo(vs.Config.MaxBlockSize)
 
 	if len(txns) == 0 {
-		logger.Panic("TruncateBytesTo removed all transactions")
+		logger.Panic("FillBytesTo removed all transactions")
 	}
 
 	logger.Infof("Creating new block with %d transactions, head time %d", len(txns), when)
@@ -607,7 +1430,7 @@ func (vs *Visor) CreateBlock(when uint64) (coin.PendingSignedBlock, error) {
 
 // CreateAndExecuteBlock creates a SignedBlock from pending transactions and executes it
 func (vs *Visor) CreateAndExecuteBlock() (coin.PendingSignedBlock, error) {
-	sb, err := vs.CreateBlock(uint64(utc.UnixNow()))
+	sb, err := vs.CreateBlock(uint64(vs.Now().Unix()))
 	if err != nil {
 		return sb, err
 	}
@@ -618,7 +1441,7 @@ func (vs *Visor) CreateAndExecuteBlock() (coin.PendingSignedBlock, error) {
 func (vs *Visor) CheckBlockMakerConstraint(block coin.SignedBlock) error {
 	height := block.Seq()
 	if height <= vs.HeadBkSeq() {
-		errors.New("pending block seq less than head")
+		return errors.New("pending block seq less than head")
 	}
 	if err := vs.pbft.CheckBkSeq(height); err != nil {
 		return err
@@ -627,7 +1450,11 @@ func (vs *Visor) CheckBlockMakerConstraint(block coin.SignedBlock) error {
 	if err != nil {
 		return err
 	}
-	validatorPubkey, err := vs.dpos.GetValidator(int64(block.Time()))
+	head, err := vs.Blockchain.Head()
+	if err != nil {
+		return err
+	}
+	validatorPubkey, err := vs.dpos.GetValidator(head, int64(block.Time()))
 	if err != nil {
 		return err
 	}
@@ -650,6 +1477,9 @@ func (vs *Visor) VerifyBlockTransactions(block coin.SignedBlock) error {
 
 // AddPendingBlock hold pending block, store into blockchain if validator number reach threshold
 func (vs *Visor) AddPendingBlock(block coin.SignedBlock) error {
+	if block.Seq() <= vs.Blockchain.FinalizedSeq() {
+		return ErrFinalizedReorg
+	}
 	if err := vs.CheckBlockMakerConstraint(block); err != nil {
 		return err
 	}
@@ -662,35 +1492,189 @@ func (vs *Visor) AddPendingBlock(block coin.SignedBlock) error {
 // ExecuteSignedBlock adds a block to the blockchain, or returns error.
 // Blocks must be executed in sequence, and be signed by the master server
 func (vs *Visor) ExecuteSignedBlock(b coin.SignedBlock) error {
-	trustPubkeys := vs.TrustNodes()
-	if len(vs.TrustNodes()) == 0 {
+	return vs.executeSignedBlock(b, nil)
+}
+
+// VerifyBlockHeader verifies the header is signed by a trust node of its time.
+// The trust nodes are known up to the head block only, so a header further
+// ahead may fail if the validators changed in between.
+func (vs *Visor) VerifyBlockHeader(h coin.SignedBlockHeader) error {
+	trustPubkeys := vs.TrustNodesAt(int64(h.Header.Time))
+	if len(trustPubkeys) == 0 {
 		trustPubkeys = vs.Config.TrustPubkeyList
 	}
-	if err := b.VerifySignature(trustPubkeys); err != nil {
+	return h.VerifySignature(trustPubkeys)
+}
+
+// executeSignedBlock executes the block, the block is finalized if cert is not nil.
+// A block following a stored block other than the head is stored on a side branch
+func (vs *Visor) executeSignedBlock(b coin.SignedBlock, cert *pbft.Certificate) error {
+	if err := vs.VerifyBlockHeader(b.SignedHeader()); err != nil {
 		return err
 	}
 
-	if err := vs.db.Update(func(tx *bolt.Tx) error {
-		if err := vs.Blockchain.ExecuteBlockWithTx(tx, &b); err != nil {
+	side, err := vs.isSideBlock(b)
+	if err != nil {
+		return err
+	}
+	if side {
+		return vs.executeSideBlock(b, cert)
+	}
+
+	// the genesis block has no head to build on
+	var head *coin.SignedBlock
+	if vs.Blockchain.Len() > 0 {
+		head, err = vs.Blockchain.Head()
+		if err != nil {
 			return err
 		}
+	}
 
-		// Remove the transactions in the Block from the unconfirmed pool
-		txHashes := make([]cipher.SHA256, 0, len(b.Block.Body.Transactions))
-		for _, tx := range b.Block.Body.Transactions {
-			txHashes = append(txHashes, tx.Hash())
+	var executed *executedBlock
+	if err := vs.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		executed, err = vs.executeBlockWithTx(tx, head, b)
+		if err != nil {
+			return err
 		}
-		vs.Unconfirmed.RemoveTransactionsWithTx(tx, txHashes)
 
+		if cert != nil {
+			return vs.Blockchain.AddCertificateWithTx(tx, b.Seq(), *cert)
+		}
 		return nil
 	}); err != nil {
 		return err
 	}
 
+	vs.applyExecutedBlock(head, b, executed)
+
+	if err := vs.pbft.NewHeight(b.Seq(), vs.Now().Unix()); err != nil {
+		logger.Errorf("Reset pbft view failed: %v", err)
+	}
+
 	vs.Blockchain.Notify(b.Block)
 	return nil
 }
 
+// executedBlock is what executing a block adds to the consensus state
+type executedBlock struct {
+	changes    []dpos.ValidatorChange
+	exclusions []dpos.Exclusion
+	election   *dpos.Election
+	stats      []dpos.SlotStats
+}
+
+// executeBlockWithTx executes the block built on head with *bolt.Tx and records the validator
+// changes, votes, exclusions, election and validator stats of the block. The consensus state
+// is not changed, applyExecutedBlock applies them
+func (vs *Visor) executeBlockWithTx(tx *bolt.Tx, head *coin.SignedBlock, b coin.SignedBlock) (*executedBlock, error) {
+	changes, err := vs.validatorChanges(b)
+	if err != nil {
+		return nil, err
+	}
+
+	votes, err := vs.votes(b)
+	if err != nil {
+		return nil, err
+	}
+
+	exclusions, err := vs.exclusions(b)
+	if err != nil {
+		return nil, err
+	}
+
+	// the votes are weighed by the outputs unspent before the block
+	election, err := vs.election(tx, head, b)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := vs.blockSlotStats(head, b)
+
+	if err := vs.Blockchain.ExecuteBlockWithTx(tx, &b); err != nil {
+		return nil, err
+	}
+
+	if err := vs.trustNode.AddSlotStatsWithTx(tx, stats); err != nil {
+		return nil, err
+	}
+
+	for _, change := range changes {
+		if err := vs.trustNode.AddValidatorChangeWithTx(tx, change); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, vote := range votes {
+		if err := vs.trustNode.AddVoteWithTx(tx, vote); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, exclusion := range exclusions {
+		if err := vs.trustNode.AddExclusionWithTx(tx, exclusion); err != nil {
+			return nil, err
+		}
+	}
+
+	if election != nil {
+		if err := vs.trustNode.AddElectionWithTx(tx, *election); err != nil {
+			return nil, err
+		}
+	}
+
+	// Remove the transactions in the Block from the unconfirmed pool
+	vs.Unconfirmed.RemoveTransactionsWithTx(tx, txnHashes(b.Block.Body.Transactions))
+
+	return &executedBlock{
+		changes:    changes,
+		exclusions: exclusions,
+		election:   election,
+		stats:      stats,
+	}, nil
+}
+
+// blockSlotStats returns the slots the block built on head settles, the stats are informational
+// and a block is not refused for them, they are skipped if the slots can't be counted
+func (vs *Visor) blockSlotStats(head *coin.SignedBlock, b coin.SignedBlock) []dpos.SlotStats {
+	stats, err := vs.slotStats(head, b)
+	if err != nil {
+		logger.Errorf("Count the slots of block %d failed, skip the validator stats: %v", b.Seq(), err)
+		return nil
+	}
+	return stats
+}
+
+// applyExecutedBlock applies the validator changes, exclusions and election of the block
+// executed on head to the consensus state, and seeds the epoch the block starts
+func (vs *Visor) applyExecutedBlock(head *coin.SignedBlock, b coin.SignedBlock, executed *executedBlock) {
+	for _, change := range executed.changes {
+		logger.Infof("Validator change %d for %s activates at %d", change.Type, change.PubKey.Hex(), change.ActivateAt)
+		vs.dpos.AddValidatorChange(change)
+	}
+
+	for _, exclusion := range executed.exclusions {
+		logger.Warningf("Trust node %s is excluded from %d to %d", exclusion.PubKey.Hex(), exclusion.From, exclusion.To)
+		vs.dpos.AddExclusion(exclusion)
+	}
+
+	for _, st := range executed.stats {
+		if st.Missed > 0 {
+			logger.Warningf("Validator %s missed %d slots in the epoch at %d", st.Validator.Hex(), st.Missed, st.Epoch)
+		}
+	}
+
+	if executed.election != nil {
+		logger.Infof("Elected %d producers for the epoch at %d", len(executed.election.Producers), executed.election.Epoch)
+		vs.dpos.AddElection(*executed.election)
+	}
+
+	if vs.startsEpoch(head, b) {
+		iv := vs.dpos.Intervals()
+		vs.dpos.SetEpochSeed(iv.EpochStart(iv.PrevSlot(int64(b.Time()))), head.HashHeader())
+	}
+}
+
 // SignBlock signs a block for master.  Will panic if anything is invalid
 func (vs *Visor) SignBlock(b coin.Block) coin.PendingSignedBlock {
 	if !vs.Config.IsMaster {
@@ -760,6 +1744,11 @@ func (vs *Visor) GetSignedBlocksSince(seq, ct uint64) ([]coin.SignedBlock, error
 			return nil, err
 		}
 
+		// below the checkpoint of a chain started from a snapshot
+		if b == nil {
+			break
+		}
+
 		blocks = append(blocks, *b)
 	}
 	return blocks, nil
@@ -799,6 +1788,9 @@ func (vs *Visor) GetBlocks(start, end uint64) []coin.SignedBlock {
 // If the transaction violates hard constraints, it is rejected, and error will not be nil.
 // If the transaction only violates soft constraints, it is still injected, and the soft constraint violation is returned.
 func (vs *Visor) InjectTransaction(txn coin.Transaction) (bool, *ErrTxnViolatesSoftConstraint, error) {
+	if err := vs.VerifyTxnType(txn); err != nil {
+		return false, nil, NewErrTxnViolatesHardConstraint(err)
+	}
 	return vs.Unconfirmed.InjectTransaction(vs.Blockchain, txn, vs.Config.MaxBlockSize)
 }
 
@@ -811,6 +1803,10 @@ func (vs *Visor) InjectTransactionStrict(txn coin.Transaction) (bool, error) {
 		return false, err
 	}
 
+	if err := vs.VerifyTxnType(txn); err != nil {
+		return false, NewErrTxnViolatesHardConstraint(err)
+	}
+
 	known, _, err := vs.Unconfirmed.InjectTransaction(vs.Blockchain, txn, vs.Config.MaxBlockSize)
 	return known, err
 }
@@ -1194,6 +2190,35 @@ func (vs *Visor) AddressBalance(auxs coin.AddressUxOuts) (uint64, uint64, error)
 	return coins, hours, nil
 }
 
+// GetTopAddressCoins returns the coins of the n richest addresses not in skip, with the
+// addresses having as many coins as the last one. All the addresses are returned if n <= 0
+func (vs *Visor) GetTopAddressCoins(n int, skip []string) (map[string]uint64, error) {
+	skipped := make(map[cipher.Address]struct{}, len(skip))
+	for _, a := range skip {
+		addr, err := cipher.DecodeBase58Address(a)
+		if err != nil {
+			return nil, err
+		}
+		skipped[addr] = struct{}{}
+	}
+
+	addrCoins, err := vs.Blockchain.Unspent().GetTopAddressCoins(n, skipped)
+	if err != nil {
+		return nil, err
+	}
+
+	coins := make(map[string]uint64, len(addrCoins))
+	for addr, c := range addrCoins {
+		coins[addr.String()] = c
+	}
+	return coins, nil
+}
+
+// GetAddressCount returns the number of addresses owning unspent outputs
+func (vs *Visor) GetAddressCount() uint64 {
+	return vs.Blockchain.Unspent().AddressCount()
+}
+
 // GetUnconfirmedTxns gets all confirmed transactions of specific addresses
 func (vs *Visor) GetUnconfirmedTxns(filter func(UnconfirmedTxn) bool) []UnconfirmedTxn {
 	return vs.Unconfirmed.GetTxns(filter)
@@ -1219,6 +2244,12 @@ func (vs *Visor) GetAllUnconfirmedTxns() []UnconfirmedTxn {
 	return vs.Unconfirmed.GetTxns(All)
 }
 
+// GetAllUnconfirmedTxnsByFee returns all unconfirmed transactions with their fees,
+// in the order they are picked for a block
+func (vs *Visor) GetAllUnconfirmedTxnsByFee() ([]UnconfirmedTxnFee, error) {
+	return vs.Unconfirmed.GetTxnsByFee(vs.Blockchain)
+}
+
 // GetAllValidUnconfirmedTxHashes returns all valid unconfirmed transaction hashes
 func (vs *Visor) GetAllValidUnconfirmedTxHashes() []cipher.SHA256 {
 	return vs.Unconfirmed.GetTxHashes(IsValid)
@@ -11,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/boltdb/bolt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -410,6 +411,228 @@ func TestVisorInjectTransaction(t *testing.T) {
 	require.Equal(t, 1, unconfirmed.Len())
 }
 
+// newFeeTestVisor returns a master visor whose head block splits the genesis coins into
+// 10 outputs of the genesis address, and the outputs
+func newFeeTestVisor(t *testing.T) (*Visor, *utc.MockClock, coin.PendingSignedBlock, coin.UxArray, func()) {
+	clock := utc.NewMockClock(time.Unix(int64(genTime)+3600, 0))
+
+	db, shutdown := testutil.PrepareDB(t)
+	v := newSnapshotVisor(t, db, clock)
+	v.Config.IsMaster = true
+	v.Config.BlockchainSeckey = genSecret
+	v.Config.BlockchainTrustSeckey = genSecret
+
+	gb := addGenesisBlock(t, v.Blockchain)
+	uxs := coin.CreateUnspents(gb.Head, gb.Body.Transactions[0])
+	txn := makeUnspentsTx(t, uxs, []cipher.SecKey{genSecret}, genAddress, 10, maxDropletDivisor)
+	_, _, err := v.InjectTransaction(txn)
+	require.NoError(t, err)
+
+	clock.Advance(time.Minute)
+	sb, err := v.CreateAndExecuteBlock()
+	require.NoError(t, err)
+	require.NoError(t, v.StartExecuteSignedBlock(sb.HashHeader()))
+	return v, clock, sb, coin.CreateUnspents(sb.Head, txn), shutdown
+}
+
+// requireFeeIndex checks the fee index updated by the injected txns matches the index built from scratch
+func requireFeeIndex(t *testing.T, pool *UnconfirmedTxnPool, bc Blockchainer) {
+	require.NotNil(t, pool.index)
+	txns, _, err := pool.txnsByFee(bc)
+	require.NoError(t, err)
+	require.Equal(t, txns, pool.index.txnsByFee())
+}
+
+func TestUnconfirmedTxnPoolLimits(t *testing.T) {
+	v, _, _, uxs, shutdown := newFeeTestVisor(t)
+	defer shutdown()
+	pool := v.Unconfirmed.(*UnconfirmedTxnPool)
+
+	// txns of the same size paying a fee growing with f
+	toAddr := testutil.MakeAddress()
+	makeTxn := func(i int, f uint64) coin.Transaction {
+		return makeSpendTxWithFee(t, coin.UxArray{uxs[i]}, []cipher.SecKey{genSecret}, toAddr, 1e6, f)
+	}
+	txns := coin.Transactions{makeTxn(0, 10), makeTxn(1, 30), makeTxn(2, 20)}
+	size := txns[0].Size()
+	pool.SetLimits(size*3, 0)
+
+	for _, txn := range txns {
+		known, softErr, err := v.InjectTransaction(txn)
+		require.NoError(t, err)
+		require.Nil(t, softErr)
+		require.False(t, known)
+	}
+
+	byFee, err := v.GetAllUnconfirmedTxnsByFee()
+	require.NoError(t, err)
+	require.Len(t, byFee, 3)
+	for i, h := range []cipher.SHA256{txns[1].Hash(), txns[2].Hash(), txns[0].Hash()} {
+		require.Equal(t, h, byFee[i].Hash())
+		require.Equal(t, size, byFee[i].Size)
+		require.Equal(t, coin.FeePerKB(byFee[i].Fee, size), byFee[i].FeePerKB)
+	}
+	require.True(t, byFee[0].Fee > byFee[1].Fee)
+
+	// a txn paying less than the txns of the full pool is rejected
+	_, _, err = v.InjectTransaction(makeTxn(3, 5))
+	require.Equal(t, ErrUnconfirmedPoolFull, err)
+	require.Equal(t, 3, pool.Len())
+
+	// a known txn is updated
+	known, _, err := v.InjectTransaction(txns[0])
+	require.NoError(t, err)
+	require.True(t, known)
+
+	// a txn paying more evicts the txn paying the lowest fee
+	txn := makeTxn(4, 40)
+	known, _, err = v.InjectTransaction(txn)
+	require.NoError(t, err)
+	require.False(t, known)
+	require.Equal(t, 3, pool.Len())
+	_, ok := pool.Get(txns[0].Hash())
+	require.False(t, ok)
+	_, ok = pool.Get(txn.Hash())
+	require.True(t, ok)
+	requireFeeIndex(t, pool, v.Blockchain)
+
+	// a txn bigger than the pool is rejected
+	pool.SetLimits(size-1, 0)
+	_, _, err = v.InjectTransaction(makeTxn(5, 50))
+	require.Equal(t, ErrUnconfirmedPoolFull, err)
+
+	// a txn below the minimum relay fee is rejected
+	pool.SetLimits(0, byFee[0].FeePerKB*2)
+	_, _, err = v.InjectTransaction(makeTxn(6, 50))
+	require.Equal(t, ErrTxnBelowMinRelayFee, err)
+
+	pool.SetLimits(0, 0)
+	_, _, err = v.InjectTransaction(makeTxn(6, 50))
+	require.NoError(t, err)
+	require.Equal(t, 4, pool.Len())
+}
+
+func TestUnconfirmedTxnReplaceByFee(t *testing.T) {
+	v, _, _, uxs, shutdown := newFeeTestVisor(t)
+	defer shutdown()
+	pool := v.Unconfirmed.(*UnconfirmedTxnPool)
+
+	makeTxn := func(i int, f uint64) coin.Transaction {
+		return makeSpendTxWithFee(t, coin.UxArray{uxs[i]}, []cipher.SecKey{genSecret}, testutil.MakeAddress(), 1e6, f)
+	}
+
+	// without replace-by-fee conflicting txns are kept
+	first := makeTxn(0, 10)
+	_, _, err := v.InjectTransaction(first)
+	require.NoError(t, err)
+	_, _, err = v.InjectTransaction(makeTxn(0, 5))
+	require.NoError(t, err)
+	require.Equal(t, 2, pool.Len())
+
+	pool.SetReplaceByFee(true)
+
+	txn := makeTxn(1, 10)
+	_, _, err = v.InjectTransaction(txn)
+	require.NoError(t, err)
+	require.Equal(t, 3, pool.Len())
+
+	// a conflicting txn must pay more than the txns it replaces
+	_, _, err = v.InjectTransaction(makeTxn(1, 5))
+	require.Equal(t, ErrTxnReplacementFeeTooLow, err)
+	_, _, err = v.InjectTransaction(makeTxn(1, 10))
+	require.Equal(t, ErrTxnReplacementFeeTooLow, err)
+
+	// the fee must be higher than the total fee of all the txns spending the inputs
+	_, _, err = v.InjectTransaction(makeTxn(0, 20))
+	require.Equal(t, ErrTxnReplacementFeeTooLow, err)
+	require.Equal(t, 3, pool.Len())
+
+	// the fee increase must pay the minimum relay fee for the size of the replacement
+	pool.SetLimits(0, coin.FeePerKB(2, txn.Size()))
+	_, _, err = v.InjectTransaction(makeTxn(1, 11))
+	require.Equal(t, ErrTxnReplacementFeeTooLow, err)
+	require.Equal(t, 3, pool.Len())
+
+	replacement := makeTxn(1, 12)
+	known, softErr, err := v.InjectTransaction(replacement)
+	require.NoError(t, err)
+	require.Nil(t, softErr)
+	require.False(t, known)
+	require.Equal(t, 3, pool.Len())
+	_, ok := pool.Get(txn.Hash())
+	require.False(t, ok)
+	_, ok = pool.Get(replacement.Hash())
+	require.True(t, ok)
+	requireFeeIndex(t, pool, v.Blockchain)
+}
+
+func TestUnconfirmedTxnChildPaysForParent(t *testing.T) {
+	v, clock, sb, uxs, shutdown := newFeeTestVisor(t)
+	defer shutdown()
+	pool := v.Unconfirmed.(*UnconfirmedTxnPool)
+
+	// a parent paying less than another txn of the same size
+	parent := makeSpendTxWithFee(t, coin.UxArray{uxs[0]}, []cipher.SecKey{genSecret}, genAddress, 1e6, 0)
+	other := makeSpendTxWithFee(t, coin.UxArray{uxs[1]}, []cipher.SecKey{genSecret}, testutil.MakeAddress(), 1e6, 10)
+	require.Equal(t, parent.Size(), other.Size())
+	for _, txn := range []coin.Transaction{parent, other} {
+		_, softErr, err := v.InjectTransaction(txn)
+		require.NoError(t, err)
+		require.Nil(t, softErr)
+	}
+
+	byFee, err := v.GetAllUnconfirmedTxnsByFee()
+	require.NoError(t, err)
+	require.Equal(t, other.Hash(), byFee[0].Hash())
+	require.Equal(t, parent.Hash(), byFee[1].Hash())
+
+	// a child burning all the hours of an output of the parent
+	parentOut := coin.CreateUnspents(sb.Head, parent)[0]
+	child := makeSpendTxWithHoursBurned(t, coin.UxArray{parentOut}, []cipher.SecKey{genSecret}, testutil.MakeAddress(), 1e6, parentOut.Body.Hours)
+	known, softErr, err := v.InjectTransaction(child)
+	require.NoError(t, err)
+	require.Nil(t, softErr)
+	require.False(t, known)
+	require.Equal(t, 3, pool.Len())
+	requireFeeIndex(t, pool, v.Blockchain)
+
+	byFee, err = v.GetAllUnconfirmedTxnsByFee()
+	require.NoError(t, err)
+	require.Len(t, byFee, 3)
+	require.Equal(t, parent.Hash(), byFee[0].Hash())
+	require.Equal(t, child.Hash(), byFee[1].Hash())
+	require.Equal(t, other.Hash(), byFee[2].Hash())
+
+	require.Equal(t, []cipher.SHA256{child.Hash()}, byFee[0].Descendants)
+	require.Empty(t, byFee[0].Ancestors)
+	require.Equal(t, []cipher.SHA256{parent.Hash()}, byFee[1].Ancestors)
+	require.Empty(t, byFee[1].Descendants)
+	require.Equal(t, parentOut.Body.Hours, byFee[1].Fee)
+	require.True(t, byFee[0].FeePerKB < byFee[2].FeePerKB)
+	require.True(t, byFee[0].PackageFeePerKB > byFee[2].PackageFeePerKB)
+	require.Equal(t, byFee[1].PackageFeePerKB, byFee[0].PackageFeePerKB)
+	require.Equal(t, coin.FeePerKB(byFee[0].Fee+byFee[1].Fee, byFee[0].Size+byFee[1].Size), byFee[0].PackageFeePerKB)
+
+	// the child gets the parent in a block of a single txn, it follows in the next block
+	v.Config.MaxBlockSize = parent.Size()
+
+	clock.Advance(time.Minute)
+	sb, err = v.CreateAndExecuteBlock()
+	require.NoError(t, err)
+	require.NoError(t, v.StartExecuteSignedBlock(sb.HashHeader()))
+	require.Equal(t, coin.Transactions{parent}, sb.Body.Transactions)
+
+	clock.Advance(time.Minute)
+	sb, err = v.CreateAndExecuteBlock()
+	require.NoError(t, err)
+	require.NoError(t, v.StartExecuteSignedBlock(sb.HashHeader()))
+	require.Equal(t, coin.Transactions{child}, sb.Body.Transactions)
+
+	require.Equal(t, 1, pool.Len())
+	_, ok := pool.Get(other.Hash())
+	require.True(t, ok)
+}
+
 func makeOverflowCoinsSpendTx(t *testing.T, uxs coin.UxArray, keys []cipher.SecKey, toAddr cipher.Address) coin.Transaction {
 	spendTx := coin.Transaction{}
 	var totalHours uint64
@@ -1916,3 +2139,324 @@ func (m *UnconfirmedTxnPoolerMock2) GetTxns(f func(tx UnconfirmedTxn) bool) []Un
 	}
 	return txs
 }
+
+func TestLoadPBFTRestart(t *testing.T) {
+	f, err := ioutil.TempFile("", "testdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	db, err := bolt.Open(f.Name(), 0700, nil)
+	require.NoError(t, err)
+
+	_, sk := cipher.GenerateKeyPair()
+	validator, _ := cipher.GenerateKeyPair()
+	b := coin.Block{Head: coin.BlockHeader{BkSeq: 1, Time: uint64(utc.UnixNow())}}
+	sb := coin.SignedBlock{Block: b, Sig: cipher.SignHash(b.HashHeader(), sk)}
+	hash := sb.HashHeader()
+
+	pb, err := loadPBFT(db, 0)
+	require.NoError(t, err)
+	require.NoError(t, pb.AddSignedBlock(sb))
+	require.NoError(t, pb.AddValidator(hash, validator))
+
+	// kill the node in the middle of the round
+	require.NoError(t, db.Close())
+
+	db, err = bolt.Open(f.Name(), 0700, nil)
+	require.NoError(t, err)
+
+	pb, err = loadPBFT(db, 0)
+	require.NoError(t, err)
+	require.Equal(t, []cipher.SHA256{hash}, pb.WaitingConfirmedBlockHash())
+	num, err := pb.ValidatorNumber(hash)
+	require.NoError(t, err)
+	require.Equal(t, 2, num)
+	require.NoError(t, pb.CheckPubkeyExists(hash, validator))
+	require.Error(t, pb.CheckBkSeq(1))
+
+	// the block is executed but the node is killed before the pbft state is cleared
+	require.NoError(t, db.Close())
+
+	db, err = bolt.Open(f.Name(), 0700, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	pb, err = loadPBFT(db, 1)
+	require.NoError(t, err)
+	require.Empty(t, pb.WaitingConfirmedBlockHash())
+
+	journal, err := blockdb.NewPbftJournal(db)
+	require.NoError(t, err)
+	states, err := journal.GetAll()
+	require.NoError(t, err)
+	require.Empty(t, states)
+}
+
+func TestConfigVerifyAgreeNum(t *testing.T) {
+	pubkeys := make([]cipher.PubKey, 4)
+	for i := range pubkeys {
+		pubkeys[i], _ = cipher.GenerateKeyPair()
+	}
+
+	cases := []struct {
+		name     string
+		agreeNum int
+		testnet  bool
+		err      string
+	}{
+		{"derived", 0, false, ""},
+		{"derived testnet", 0, true, ""},
+		{"negative", -1, true, "agreeNum can not be negative"},
+		{"override mainnet", 3, false, "agreeNum can only be overridden on a testnet"},
+		{"override testnet", 2, true, ""},
+		{"unreachable", 5, true, "agreeNum 5 is unreachable with 4 trust nodes"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewVisorConfig()
+			c.TrustPubkeyList = pubkeys
+			c.AgreeNum = tc.agreeNum
+			c.Testnet = tc.testnet
+			err := c.Verify()
+			if tc.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestAgreeNumOf(t *testing.T) {
+	vs := &Visor{Config: NewVisorConfig()}
+	require.Equal(t, 1, vs.agreeNumOf(1))
+	require.Equal(t, 3, vs.agreeNumOf(4))
+	require.Equal(t, 5, vs.agreeNumOf(7))
+
+	// a testnet override applies while it is reachable
+	vs.Config.Testnet = true
+	vs.Config.AgreeNum = 2
+	require.Equal(t, 2, vs.agreeNumOf(4))
+	require.Equal(t, 1, vs.agreeNumOf(1))
+}
+
+func TestConfigVerifyIntervals(t *testing.T) {
+	c := NewVisorConfig()
+	require.NoError(t, c.Verify())
+	require.Equal(t, dpos.DefaultIntervals(), c.Intervals())
+
+	c.BlockInterval = 1
+	c.EpochInterval = 6
+	require.NoError(t, c.Verify())
+
+	c.EpochInterval = 0
+	require.Equal(t, dpos.ErrInvalidEpochInterval, c.Verify())
+
+	c.BlockInterval = 0
+	require.Equal(t, dpos.ErrInvalidBlockInterval, c.Verify())
+}
+
+func TestMaybeCreateGenesisBlock(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	db, bc, err := loadBlockchain(db, []cipher.PubKey{genPublic}, false)
+	require.NoError(t, err)
+
+	cfg := NewVisorConfig()
+	cfg.IsMaster = true
+	cfg.BlockchainPubkey = genPublic
+	cfg.BlockchainSeckey = genSecret
+	cfg.BlockchainTrustPubkey = genPublic
+	cfg.BlockchainTrustSeckey = genSecret
+	cfg.TrustPubkeyList = []cipher.PubKey{genPublic}
+	cfg.GenesisAddress = genAddress
+	cfg.GenesisCoinVolume = genCoins
+	cfg.GenesisTimestamp = genTime
+	d := dpos.NewDpos(genPublic)
+	require.NoError(t, d.SetTrustNode(cfg.TrustPubkeyList))
+	tn, err := blockdb.NewTrustNode(db)
+	require.NoError(t, err)
+
+	v := &Visor{
+		Config:      cfg,
+		Unconfirmed: NewUnconfirmedTxnPool(db),
+		Blockchain:  bc,
+		db:          db,
+		pbft:        pbft.NewPBFT(),
+		dpos:        d,
+		trustNode:   tn,
+	}
+
+	// the genesis block is executed on an empty chain
+	require.NoError(t, v.maybeCreateGenesisBlock())
+	require.Equal(t, uint64(1), bc.Len())
+	require.NotNil(t, bc.GetGenesisBlock())
+
+	require.NoError(t, v.maybeCreateGenesisBlock())
+	require.Equal(t, uint64(1), bc.Len())
+}
+
+func TestLoadEpochSeed(t *testing.T) {
+	iv := dpos.Intervals{Block: 10, Epoch: 300}
+	gb, err := coin.NewGenesisBlock(genAddress, genCoins, genTime)
+	require.NoError(t, err)
+	epoch := iv.NextEpoch(int64(genTime)) + 3*iv.Epoch
+
+	// the block at the epoch start belongs to the last slot of the previous epoch
+	blocks := []coin.SignedBlock{{Block: *gb}}
+	for _, tm := range []int64{epoch - 50, epoch - 10, epoch, epoch + 5, epoch + 40} {
+		b := makeBlock(t, blocks[len(blocks)-1].Block, uint64(tm))
+		blocks = append(blocks, coin.SignedBlock{Block: *b})
+	}
+
+	validators := []cipher.PubKey{}
+	for i := 0; i < 4; i++ {
+		pk, _ := cipher.GenerateKeyPair()
+		validators = append(validators, pk)
+	}
+
+	load := func(blocks []coin.SignedBlock) *dpos.Dpos {
+		d := dpos.NewDpos(validators[0])
+		require.NoError(t, d.SetIntervals(iv))
+		require.NoError(t, d.SetTrustNode(validators))
+		v := &Visor{
+			Blockchain: &Blockchain{store: &fakeChainStore{blocks: blocks}},
+			dpos:       d,
+		}
+		require.NoError(t, v.loadEpochSeed())
+		return d
+	}
+
+	requireSeed := func(d *dpos.Dpos, head coin.SignedBlock, seed cipher.SHA256) {
+		order := append([]cipher.PubKey{}, validators...)
+		dpos.Shuffle(order, seed)
+		now := int64(head.Time()) + iv.Block
+		slot := iv.PrevSlot(now)
+		schedule, err := d.Schedule(&head, now, 1)
+		require.NoError(t, err)
+		require.Equal(t, order[(slot%iv.Epoch/iv.Block)%4], schedule[0].Producer)
+	}
+
+	requireSeed(load(blocks), blocks[len(blocks)-1], blocks[3].HashHeader())
+
+	// the chain started in the epoch of the head
+	requireSeed(load(blocks[:1]), blocks[0], blocks[0].HashHeader())
+}
+
+func TestGetValidatorStats(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+	trustNode, err := blockdb.NewTrustNode(db)
+	require.NoError(t, err)
+
+	iv := dpos.Intervals{Block: 10, Epoch: 300}
+	gb, err := coin.NewGenesisBlock(genAddress, genCoins, genTime)
+	require.NoError(t, err)
+	epoch := iv.NextEpoch(int64(genTime)) + 3*iv.Epoch
+
+	blocks := []coin.SignedBlock{{Block: *gb}}
+	for _, tm := range []int64{epoch + 5, epoch + 35} {
+		b := makeBlock(t, blocks[len(blocks)-1].Block, uint64(tm))
+		blocks = append(blocks, coin.SignedBlock{Block: *b})
+	}
+
+	validators := []cipher.PubKey{}
+	for i := 0; i < 4; i++ {
+		pk, _ := cipher.GenerateKeyPair()
+		validators = append(validators, pk)
+	}
+	d := dpos.NewDpos(validators[0])
+	require.NoError(t, d.SetIntervals(iv))
+	require.NoError(t, d.SetTrustNode(validators))
+	d.SetEpochSeed(epoch, blocks[0].HashHeader())
+
+	v := &Visor{
+		Blockchain: &Blockchain{store: &fakeChainStore{blocks: blocks}},
+		dpos:       d,
+		trustNode:  trustNode,
+	}
+
+	// the slots before the first block are not counted
+	stats, err := v.slotStats(&blocks[0], blocks[1])
+	require.NoError(t, err)
+	require.Empty(t, stats)
+
+	stats, err = v.slotStats(&blocks[1], blocks[2])
+	require.NoError(t, err)
+	var produced, missed uint64
+	for _, s := range stats {
+		produced += s.Produced
+		missed += s.Missed
+	}
+	require.Equal(t, uint64(1), produced)
+	require.Equal(t, uint64(2), missed)
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		return trustNode.AddSlotStatsWithTx(tx, stats)
+	})
+	require.NoError(t, err)
+
+	start, got, err := v.GetValidatorStats(0)
+	require.NoError(t, err)
+	require.Equal(t, epoch, start)
+	require.Equal(t, stats, got)
+
+	start, got, err = v.GetValidatorStats(epoch - 1)
+	require.NoError(t, err)
+	require.Equal(t, epoch-iv.Epoch, start)
+	require.Empty(t, got)
+}
+
+func TestVisorClock(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	db, bc, err := loadBlockchain(db, []cipher.PubKey{genPublic}, false)
+	require.NoError(t, err)
+
+	clock := utc.NewMockClock(time.Unix(int64(genTime)+3600, 0))
+	cfg := NewVisorConfig()
+	cfg.IsMaster = true
+	cfg.BlockchainPubkey = genPublic
+	cfg.BlockchainSeckey = genSecret
+	cfg.BlockchainTrustPubkey = genPublic
+	cfg.BlockchainTrustSeckey = genSecret
+	cfg.TrustPubkeyList = []cipher.PubKey{genPublic}
+	cfg.Clock = clock
+	d := dpos.NewDpos(genPublic)
+	require.NoError(t, d.SetTrustNode(cfg.TrustPubkeyList))
+	tn, err := blockdb.NewTrustNode(db)
+	require.NoError(t, err)
+	unconfirmed := NewUnconfirmedTxnPool(db)
+	unconfirmed.SetClock(clock)
+	pb := pbft.NewPBFT()
+	pb.SetClock(clock)
+
+	v := &Visor{
+		Config:      cfg,
+		Unconfirmed: unconfirmed,
+		Blockchain:  bc,
+		db:          db,
+		pbft:        pb,
+		dpos:        d,
+		trustNode:   tn,
+	}
+	require.Equal(t, clock.Now().UTC(), v.Now())
+
+	gb := addGenesisBlock(t, v.Blockchain)
+	uxs := coin.CreateUnspents(gb.Head, gb.Body.Transactions[0])
+	txn := makeSpendTx(t, uxs, []cipher.SecKey{genSecret}, genAddress, 1e6)
+	_, _, err = unconfirmed.InjectTransaction(bc, txn, v.Config.MaxBlockSize)
+	require.NoError(t, err)
+	utx, ok := unconfirmed.Get(txn.Hash())
+	require.True(t, ok)
+	require.Equal(t, clock.Now().UnixNano(), utx.Received)
+
+	// the block is created at the time of the visor clock
+	clock.Advance(time.Minute)
+	sb, err := v.CreateAndExecuteBlock()
+	require.NoError(t, err)
+	require.Equal(t, uint64(clock.Now().Unix()), sb.Time())
+}
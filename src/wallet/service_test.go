@@ -966,6 +966,29 @@ func TestServiceCreateAndSignTransactionAdvanced(t *testing.T) {
 	validParamsWithPassword := validParams
 	validParamsWithPassword.Wallet.Password = []byte("password")
 
+	// An unconfirmed transaction paying a fee of 50 hours, to be replaced
+	replacedTxn := coin.Transaction{}
+	replacedTxn.PushInput(originalUxouts[0].Hash())
+	replacedTxn.PushOutput(addrs[0], 1e6, 40)
+	replacedTxn.PushOutput(changeAddress, 1e6, 10)
+	replacedTxn.UpdateHeader()
+
+	replaceParams := validParams
+	replaceParams.Replace = replacedTxn.Hash()
+
+	replaceVld := &dummyValidator{
+		ok: true,
+		uncfm: map[cipher.SHA256]coin.Transaction{
+			replacedTxn.Hash(): replacedTxn,
+		},
+	}
+
+	replaceParamsWithMinFee := replaceParams
+	replaceParamsWithMinFee.MinFee = 60
+
+	replaceParamsWithHighMinFee := replaceParams
+	replaceParamsWithHighMinFee.MinFee = 95
+
 	newShareFactor := func(a string) *decimal.Decimal {
 		d, err := decimal.NewFromString(a)
 		require.NoError(t, err)
@@ -1533,6 +1556,55 @@ func TestServiceCreateAndSignTransactionAdvanced(t *testing.T) {
 			},
 			err: fee.ErrTxnNoFee,
 		},
+
+		{
+			name:     "replaced transaction not found",
+			params:   replaceParams,
+			unspents: uxouts,
+			err:      ErrReplacedTxnNotFound,
+		},
+
+		{
+			name:     "replaced transaction spends unknown input",
+			params:   replaceParams,
+			unspents: uxouts[:0],
+			vld:      replaceVld,
+			err:      ErrReplacedTxnUnknownInput,
+		},
+
+		{
+			name:           "replace transaction",
+			params:         replaceParams,
+			unspents:       uxouts,
+			vld:            replaceVld,
+			chosenUnspents: []coin.UxOut{originalUxouts[0]},
+			changeOutput: &coin.TransactionOutput{
+				Address: changeAddress,
+				Hours:   39,
+				Coins:   1e6,
+			},
+		},
+
+		{
+			name:           "replace transaction with min fee",
+			params:         replaceParamsWithMinFee,
+			unspents:       uxouts,
+			vld:            replaceVld,
+			chosenUnspents: []coin.UxOut{originalUxouts[0]},
+			changeOutput: &coin.TransactionOutput{
+				Address: changeAddress,
+				Hours:   30,
+				Coins:   1e6,
+			},
+		},
+
+		{
+			name:     "replace transaction min fee leaves insufficient hours",
+			params:   replaceParamsWithHighMinFee,
+			unspents: uxouts,
+			vld:      replaceVld,
+			err:      ErrInsufficientHours,
+		},
 	}
 
 	var cryptoTypes []CryptoType
@@ -2438,14 +2510,23 @@ func TestGetWalletSeed(t *testing.T) {
 }
 
 type dummyValidator struct {
-	ok  bool
-	err error
+	ok    bool
+	err   error
+	uncfm map[cipher.SHA256]coin.Transaction
 }
 
 func (dvld dummyValidator) HasUnconfirmedSpendTx(addr []cipher.Address) (bool, error) {
 	return dvld.ok, dvld.err
 }
 
+func (dvld dummyValidator) GetUnconfirmedTxn(hash cipher.SHA256) (*coin.Transaction, bool) {
+	txn, ok := dvld.uncfm[hash]
+	if !ok {
+		return nil, false
+	}
+	return &txn, true
+}
+
 type dummyUnspentGetter struct {
 	addrUnspents coin.AddressUxOuts
 	unspents     map[cipher.SHA256]coin.UxOut
@@ -52,6 +52,10 @@ var (
 	ErrZeroSpend = NewError(errors.New("zero spend amount"))
 	// ErrSpendingUnconfirmed is returned if caller attempts to spend unconfirmed outputs
 	ErrSpendingUnconfirmed = NewError(errors.New("please spend after your pending transaction is confirmed"))
+	// ErrReplacedTxnNotFound is returned if the transaction to replace is not an unconfirmed transaction
+	ErrReplacedTxnNotFound = NewError(errors.New("transaction to replace is not an unconfirmed transaction"))
+	// ErrReplacedTxnUnknownInput is returned if the transaction to replace spends an output not owned by the wallet addresses
+	ErrReplacedTxnUnknownInput = NewError(errors.New("transaction to replace spends an output not owned by the wallet addresses"))
 	// ErrInvalidEncryptedField is returned if a wallet's Meta.encrypted value is invalid.
 	ErrInvalidEncryptedField = NewError(errors.New(`encrypted field value is not valid, must be "true", "false" or ""`))
 	// ErrWalletEncrypted is returned when trying to generate addresses or sign tx in encrypted wallet
@@ -162,6 +166,11 @@ type CreateTransactionParams struct {
 	Wallet         CreateTransactionWalletParams
 	ChangeAddress  cipher.Address
 	To             []coin.TransactionOutput
+	// Replace is the hash of an unconfirmed transaction to replace. The created transaction
+	// spends all of its inputs and pays a higher fee, the outputs can be changed
+	Replace cipher.SHA256
+	// MinFee is the minimum coin hour fee to pay, the burn fee is paid if it is higher
+	MinFee uint64
 }
 
 // Validate validates CreateTransactionParams
@@ -936,6 +945,8 @@ func (w *Wallet) clone() *Wallet {
 type Validator interface {
 	// checks if any of the given addresses has unconfirmed spending transactions
 	HasUnconfirmedSpendTx(addr []cipher.Address) (bool, error)
+	// returns the unconfirmed transaction of the hash
+	GetUnconfirmedTxn(hash cipher.SHA256) (*coin.Transaction, bool)
 }
 
 // CreateAndSignTransaction Creates a Transaction
@@ -1055,15 +1066,25 @@ func (w *Wallet) CreateAndSignTransactionAdvanced(params CreateTransactionParams
 		}
 	}
 
-	ok, err := vld.HasUnconfirmedSpendTx(addrList)
-	if err != nil {
-		// The error from HasUnconfirmedSpendTx isn't wrapped with wallet.Error because
-		// it is from outside the wallet package and is likely some database or other
-		// unexpected failure
-		return nil, nil, fmt.Errorf("checking unconfirmed spending failed: %v", err)
-	}
-	if ok {
-		return nil, nil, ErrSpendingUnconfirmed
+	// A replacement spends the outputs of the unconfirmed transaction it replaces
+	var replaced *coin.Transaction
+	if params.Replace.Null() {
+		ok, err := vld.HasUnconfirmedSpendTx(addrList)
+		if err != nil {
+			// The error from HasUnconfirmedSpendTx isn't wrapped with wallet.Error because
+			// it is from outside the wallet package and is likely some database or other
+			// unexpected failure
+			return nil, nil, fmt.Errorf("checking unconfirmed spending failed: %v", err)
+		}
+		if ok {
+			return nil, nil, ErrSpendingUnconfirmed
+		}
+	} else {
+		var ok bool
+		replaced, ok = vld.GetUnconfirmedTxn(params.Replace)
+		if !ok {
+			return nil, nil, ErrReplacedTxnNotFound
+		}
 	}
 
 	txn := &coin.Transaction{}
@@ -1101,13 +1122,27 @@ func (w *Wallet) CreateAndSignTransactionAdvanced(params CreateTransactionParams
 		}
 	}
 
-	// Use the MinimizeUxOuts strategy, to use least possible uxouts
-	// this will allow more frequent spending
-	// we don't need to check whether we have sufficient balance beforehand as ChooseSpends already checks that
+	minFee := params.MinFee
+	var spends []UxBalance
+	if replaced != nil {
+		// Spend the inputs of the replaced transaction and pay more than its fee
+		var replacedFee uint64
+		spends, replacedFee, err = replacementSpends(uxbMap, replaced)
+		if err != nil {
+			return nil, nil, err
+		}
 
-	spends, err := ChooseSpendsMinimizeUxOuts(uxb, totalOutCoins, requestedHours)
-	if err != nil {
-		return nil, nil, err
+		if minFee <= replacedFee {
+			minFee = replacedFee + 1
+		}
+	} else {
+		// Use the MinimizeUxOuts strategy, to use least possible uxouts
+		// this will allow more frequent spending
+		// we don't need to check whether we have sufficient balance beforehand as ChooseSpends already checks that
+		spends, err = ChooseSpendsMinimizeUxOuts(uxb, totalOutCoins, requestedHours)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
 	// calculate total coins and hours in spends
@@ -1129,10 +1164,17 @@ func (w *Wallet) CreateAndSignTransactionAdvanced(params CreateTransactionParams
 		txn.PushInput(spend.Hash)
 	}
 
-	feeHours := fee.RequiredFee(totalInputHours)
+	if replaced != nil && totalOutCoins > totalInputCoins {
+		return nil, nil, ErrInsufficientBalance
+	}
+
+	feeHours := requiredFee(totalInputHours, minFee)
 	if feeHours == 0 {
 		return nil, nil, fee.ErrTxnNoFee
 	}
+	if feeHours > totalInputHours {
+		return nil, nil, fee.ErrTxnInsufficientCoinHours
+	}
 	remainingHours := totalInputHours - feeHours
 
 	switch params.HoursSelection.Type {
@@ -1186,6 +1228,11 @@ func (w *Wallet) CreateAndSignTransactionAdvanced(params CreateTransactionParams
 	}
 
 	// Make sure we have enough coinhours
+	// ChooseSpends does not account for the minimum fee or choose the inputs of a replacement
+	if totalOutHours > remainingHours && (replaced != nil || feeHours > fee.RequiredFee(totalInputHours)) {
+		return nil, nil, ErrInsufficientHours
+	}
+
 	// If we don't at this point, then ChooseSpends has a bug, it should have returned this error already
 	if totalOutHours > remainingHours {
 		logger.WithError(fee.ErrTxnInsufficientCoinHours).Error("Insufficient hours after choosing spends or distributing hours, this should not occur")
@@ -1206,7 +1253,8 @@ func (w *Wallet) CreateAndSignTransactionAdvanced(params CreateTransactionParams
 	// This chooses an available input with the least number of coin hours;
 	// if the extra coin hour fee incurred by this additional input is less than
 	// the remaining coin hours, the input is added.
-	if changeCoins == 0 && changeHours > 0 {
+	// A replacement does not add inputs, they could be spent by other unconfirmed transactions.
+	if changeCoins == 0 && changeHours > 0 && replaced == nil {
 		// Find the output with the least coin hours
 		// If size of the fee for this output is less than the changeHours, add it
 		// Update changeCoins and changeHours
@@ -1222,7 +1270,7 @@ func (w *Wallet) CreateAndSignTransactionAdvanced(params CreateTransactionParams
 			}
 
 			// Calculate the new fee for this new amount of hours
-			newFee := fee.RequiredFee(newTotalHours)
+			newFee := requiredFee(newTotalHours, minFee)
 			if newFee < feeHours {
 				err := errors.New("updated fee after adding extra input for change is unexpectedly less than it was initially")
 				logger.WithError(err).Error()
@@ -1372,9 +1420,53 @@ func verifyCreatedTransactionInvariants(params CreateTransactionParams, txn *coi
 		return errors.New("Transaction will not satisy required fee")
 	}
 
+	if inputHours-outputHours < params.MinFee {
+		return errors.New("Transaction will not satisfy the minimum fee")
+	}
+
 	return nil
 }
 
+// requiredFee returns the coin hour fee to pay for the input hours, at least minFee
+func requiredFee(inputHours, minFee uint64) uint64 {
+	feeHours := fee.RequiredFee(inputHours)
+	if feeHours < minFee {
+		return minFee
+	}
+	return feeHours
+}
+
+// replacementSpends returns the inputs of the replaced transaction and the fee it pays.
+// All of them must be unspent outputs of the wallet addresses
+func replacementSpends(uxbMap map[cipher.SHA256]UxBalance, replaced *coin.Transaction) ([]UxBalance, uint64, error) {
+	spends := make([]UxBalance, 0, len(replaced.In))
+	var inputHours uint64
+	for _, h := range replaced.In {
+		uxb, ok := uxbMap[h]
+		if !ok {
+			return nil, 0, ErrReplacedTxnUnknownInput
+		}
+
+		var err error
+		inputHours, err = coin.AddUint64(inputHours, uxb.Hours)
+		if err != nil {
+			return nil, 0, err
+		}
+		spends = append(spends, uxb)
+	}
+
+	outputHours, err := replaced.OutputHours()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if inputHours < outputHours {
+		return nil, 0, fee.ErrTxnInsufficientCoinHours
+	}
+
+	return spends, inputHours - outputHours, nil
+}
+
 // DistributeSpendHours calculates how many coin hours to transfer to the change address and how
 // many to transfer to each of the other destination addresses.
 // Input hours are split by BurnFactor (rounded down) to meet the fee requirement.